@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"os"
+	"time"
 )
 
 const (
@@ -16,10 +17,31 @@ type LogConfig struct {
 	Level string `yaml:"level" json:"level"`
 }
 
-type MysqlConfig struct {
+const (
+	DBDriverMySQL    = "mysql"    // the default driver
+	DBDriverPostgres = "postgres" // postgresql, the dsn is either a url or key=value pairs
+	DBDriverSQLite   = "sqlite"   // an embedded database file, for local development and test
+)
+
+type DatabaseConfig struct {
+	Driver string `yaml:"driver" json:"driver"`
+	DSN    string `yaml:"dsn" json:"dsn"`
+	// AutoMigrate apply the pending migrations on startup, only works in local mode,
+	// run the migrate command to migrate in other modes
+	AutoMigrate bool `yaml:"auto_migrate" json:"auto_migrate"`
+}
+
+// LegacyMysqlConfig the database config under the former mysql key, before other drivers were supported
+type LegacyMysqlConfig struct {
 	DSN string `yaml:"dsn" json:"dsn"`
 }
 
+const (
+	MailSinkSMTP   = "smtp"   // mails are sent through the smtp server
+	MailSinkFile   = "file"   // mails are written into the sink dir as .eml files
+	MailSinkMemory = "memory" // mails are kept in memory and dropped
+)
+
 type EmailServiceConfig struct {
 	Sender        string `yaml:"sender" json:"sender"`
 	Host          string `yaml:"host" json:"host"`
@@ -29,18 +51,134 @@ type EmailServiceConfig struct {
 	ActivateParam string `yaml:"activate_param" json:"activate_param"`
 	BindURI       string `yaml:"bind_uri" json:"bind_uri"`
 	BindParam     string `yaml:"bind_param" json:"bind_param"`
+	// BindCancelURI the link sent to the old email to cancel an email change
+	BindCancelURI   string `yaml:"bind_cancel_uri" json:"bind_cancel_uri"`
+	BindCancelParam string `yaml:"bind_cancel_param" json:"bind_cancel_param"`
+	ResetURI        string `yaml:"reset_uri" json:"reset_uri"`
+	ResetParam      string `yaml:"reset_param" json:"reset_param"`
+	LoginURI        string `yaml:"login_uri" json:"login_uri"`
+	LoginParam      string `yaml:"login_param" json:"login_param"`
+	// TemplateDir the dir to load the email templates from, the embedded ones are used if empty
+	TemplateDir string `yaml:"template_dir" json:"template_dir"`
+	// DefaultLanguage the locale of the emails sent to users without language preference, default is en
+	DefaultLanguage string `yaml:"default_language" json:"default_language"`
+	// Sink where the mails go, smtp, file or memory, default is smtp
+	Sink    string `yaml:"sink" json:"sink"`
+	SinkDir string `yaml:"sink_dir" json:"sink_dir"`
+	// Queue the options of the worker delivering the queued mails
+	Queue MailQueueConfig `yaml:"queue" json:"queue"`
+}
+
+// MailQueueConfig the options of the mail queue worker, zero values mean the defaults
+type MailQueueConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval" json:"poll_interval"`
+	MaxAttempts  uint32        `yaml:"max_attempts" json:"max_attempts"`
+	BaseDelay    time.Duration `yaml:"base_delay" json:"base_delay"`
+	MaxDelay     time.Duration `yaml:"max_delay" json:"max_delay"`
 }
 
 type JWTConfig struct {
 	Cypher string `yaml:"cypher" json:"cypher"`
 }
 
+type WechatConfig struct {
+	AppID     string `yaml:"app_id" json:"app_id"`
+	AppSecret string `yaml:"app_secret" json:"app_secret"`
+	// Code2SessionURL the endpoint to exchange mini-program login code for openid and session key
+	Code2SessionURL string `yaml:"code2session_url" json:"code2session_url"`
+}
+
+// OAuthProviderConfig the config of an OAuth2 / OpenID Connect login provider, like Google, Apple or GitHub
+type OAuthProviderConfig struct {
+	ClientID     string `yaml:"client_id" json:"client_id"`
+	ClientSecret string `yaml:"client_secret" json:"client_secret"`
+	AuthURL      string `yaml:"auth_url" json:"auth_url"`
+	TokenURL     string `yaml:"token_url" json:"token_url"`
+	// JWKSURL and Issuer are required for an OpenID Connect provider to verify the id token
+	JWKSURL string `yaml:"jwks_url" json:"jwks_url"`
+	Issuer  string `yaml:"issuer" json:"issuer"`
+	// UserInfoURL is required for a plain OAuth2 provider without id token, like GitHub
+	UserInfoURL  string   `yaml:"user_info_url" json:"user_info_url"`
+	SubjectField string   `yaml:"subject_field" json:"subject_field"`
+	RedirectURI  string   `yaml:"redirect_uri" json:"redirect_uri"`
+	Scopes       []string `yaml:"scopes" json:"scopes"`
+}
+
+type AccountConfig struct {
+	// DeleteGracePeriod how long a deleted account can still be restored before all its data is erased,
+	// zero means erase immediately
+	DeleteGracePeriod time.Duration `yaml:"delete_grace_period" json:"delete_grace_period"`
+}
+
+const (
+	RateLimitStoreMemory = "memory" // counters kept per server instance
+	RateLimitStoreDB     = "db"     // counters shared by all server instances through the database
+)
+
+type RateLimitConfig struct {
+	// Store where the rate limit counters are kept, memory or db, default is memory
+	Store string `yaml:"store" json:"store"`
+}
+
+const (
+	IdempotencyStoreMemory = "memory" // keys kept per server instance
+	IdempotencyStoreDB     = "db"     // keys shared by all server instances through the database
+)
+
+type IdempotencyConfig struct {
+	// Store where the idempotency keys and the responses are kept, memory or db, default is memory
+	Store string `yaml:"store" json:"store"`
+	// TTL how long a response is replayed to the retries with the same idempotency key, default is 24 hours
+	TTL time.Duration `yaml:"ttl" json:"ttl"`
+}
+
+const (
+	RealtimeBrokerMemory = "memory" // events pushed to the members connected to the same server instance
+)
+
+type RealtimeConfig struct {
+	// Broker how the habit events are passed between the server instances, default is memory,
+	// a deployment of multiple instances plugs in a realtime.Broker over a shared message bus
+	Broker string `yaml:"broker" json:"broker"`
+}
+
+type EmailValidationConfig struct {
+	// Policy how strict the emails of users are validated, syntax, disposable or mx, default is disposable,
+	// only mx needs network access
+	Policy string `yaml:"policy" json:"policy"`
+	// MXCacheTTL how long the mx lookup results are cached, default is an hour
+	MXCacheTTL time.Duration `yaml:"mx_cache_ttl" json:"mx_cache_ttl"`
+}
+
+type ServerConfig struct {
+	// RequestTimeout the deadline of handling a request, the db queries and outbound calls of the request
+	// are canceled when it passes, zero means no deadline
+	RequestTimeout time.Duration `yaml:"request_timeout" json:"request_timeout"`
+	// RouteTimeouts the deadlines of specific routes overriding RequestTimeout, keyed by the method and the path
+	// as registered, like "POST /api/v1/habit/log/:id"
+	RouteTimeouts map[string]time.Duration `yaml:"route_timeouts" json:"route_timeouts"`
+	// TrustedProxies the ips or cidrs of the reverse proxies in front of the server, the client ip is taken from
+	// the X-Forwarded-For and X-Real-IP headers only if the connection comes from one of them
+	TrustedProxies []string `yaml:"trusted_proxies" json:"trusted_proxies"`
+}
+
 type RuntimeConfig struct {
-	RunMode      string             `yaml:"-" json:"-"`
-	Log          LogConfig          `yaml:"log" json:"log"`
-	Mysql        MysqlConfig        `yaml:"mysql" json:"mysql"`
-	EmailService EmailServiceConfig `yaml:"email_service" json:"email_service"`
-	JWT          JWTConfig          `yaml:"jwt" json:"jwt"`
+	RunMode         string                `yaml:"-" json:"-"`
+	Log             LogConfig             `yaml:"log" json:"log"`
+	Server          ServerConfig          `yaml:"server" json:"server"`
+	Database        DatabaseConfig        `yaml:"database" json:"database"`
+	EmailService    EmailServiceConfig    `yaml:"email_service" json:"email_service"`
+	EmailValidation EmailValidationConfig `yaml:"email_validation" json:"email_validation"`
+	JWT             JWTConfig             `yaml:"jwt" json:"jwt"`
+	Account         AccountConfig         `yaml:"account" json:"account"`
+	Wechat          WechatConfig          `yaml:"wechat" json:"wechat"`
+	RateLimit       RateLimitConfig       `yaml:"rate_limit" json:"rate_limit"`
+	Idempotency     IdempotencyConfig     `yaml:"idempotency" json:"idempotency"`
+	Realtime        RealtimeConfig        `yaml:"realtime" json:"realtime"`
+	// OAuthProviders the social login providers by name, the name is used in api path and as identity provider
+	OAuthProviders map[string]*OAuthProviderConfig `yaml:"oauth_providers" json:"oauth_providers"`
+	// LegacyMysql read as the mysql database if database is absent, to keep the former config files working
+	LegacyMysql *LegacyMysqlConfig `yaml:"mysql" json:"-"`
 }
 
 var GlobalConfig *RuntimeConfig
@@ -63,11 +201,19 @@ func InitConfig(filePath string) error {
 		return fmt.Errorf("unknown RUN_MODE %s", runMode)
 	}
 	c.RunMode = runMode
+	if c.LegacyMysql != nil {
+		if c.Database != (DatabaseConfig{}) {
+			return fmt.Errorf("both database and mysql are configured for RUN_MODE %s, mysql is renamed to database, remove it", runMode)
+		}
+		c.Database = DatabaseConfig{Driver: DBDriverMySQL, DSN: c.LegacyMysql.DSN}
+	}
 	GlobalConfig = c
 
 	// read some env to overwrite config, usually is some sensitive config
-	if mysqlDSN := os.Getenv("MYSQL_DSN"); mysqlDSN != "" {
-		GlobalConfig.Mysql.DSN = mysqlDSN
+	if databaseDSN := os.Getenv("DATABASE_DSN"); databaseDSN != "" {
+		GlobalConfig.Database.DSN = databaseDSN
+	} else if mysqlDSN := os.Getenv("MYSQL_DSN"); mysqlDSN != "" { // the former name before other drivers supported
+		GlobalConfig.Database.DSN = mysqlDSN
 	}
 
 	if emailServiceSender := os.Getenv("EMAIL_SERVICE_SENDER"); emailServiceSender != "" {
@@ -82,5 +228,9 @@ func InitConfig(filePath string) error {
 		GlobalConfig.JWT.Cypher = jwtCypher
 	}
 
+	if wechatAppSecret := os.Getenv("WECHAT_APP_SECRET"); wechatAppSecret != "" {
+		GlobalConfig.Wechat.AppSecret = wechatAppSecret
+	}
+
 	return nil
 }
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// initTestConfig init the global config from the yaml content of the test run mode
+func initTestConfig(t *testing.T, content string) error {
+	filePath := path.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(filePath, []byte("test:\n"+content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RUN_MODE", RunModelTest)
+	t.Setenv("DATABASE_DSN", "")
+	t.Setenv("MYSQL_DSN", "")
+	return InitConfig(filePath)
+}
+
+func TestLegacyMysqlConfig(t *testing.T) {
+	err := initTestConfig(t, "  database:\n    driver: 'sqlite'\n    dsn: 'test.db'\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if GlobalConfig.Database.Driver != DBDriverSQLite || GlobalConfig.Database.DSN != "test.db" {
+		t.Fatalf("unexpected database config %+v", GlobalConfig.Database)
+	}
+
+	// the former key is read as mysql
+	err = initTestConfig(t, "  mysql:\n    dsn: 'user:pass@tcp(localhost:3306)/habit'\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if GlobalConfig.Database.Driver != DBDriverMySQL || GlobalConfig.Database.DSN != "user:pass@tcp(localhost:3306)/habit" {
+		t.Fatalf("unexpected database config %+v", GlobalConfig.Database)
+	}
+
+	err = initTestConfig(t, "  database:\n    driver: 'sqlite'\n    dsn: 'test.db'\n  mysql:\n    dsn: 'habit'\n")
+	if err == nil || !strings.Contains(err.Error(), "mysql is renamed to database") {
+		t.Fatal("should not configure both keys", err)
+	}
+}
@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"github.com/swordandtea/lets-habit-server/biz/service"
+	"github.com/swordandtea/lets-habit-server/util"
+	"gorm.io/gorm"
+	"time"
+)
+
+type AdminCtrl struct {
+	repos *Repositories
+}
+
+// NewAdminCtrl create an AdminCtrl operating on the repos
+func NewAdminCtrl(repos *Repositories) *AdminCtrl {
+	return &AdminCtrl{repos: repos}
+}
+
+// AdminOperator the admin who takes an action, recorded in the audit log
+type AdminOperator struct {
+	UID dal.UID
+	IP  string
+}
+
+// addAuditLog record an action the admin takes
+func (c *AdminCtrl) addAuditLog(db *gorm.DB, op *AdminOperator, action dal.AdminAction, targetUID dal.UID, targetHabitID uint64, detail string) response.SError {
+	return c.repos.AdminAuditLogs.Add(db, &dal.AdminAuditLog{
+		AdminUID:      op.UID,
+		Action:        action,
+		TargetUID:     targetUID,
+		TargetHabitID: targetHabitID,
+		Detail:        detail,
+		IP:            op.IP,
+		CreateAt:      time.Now().UTC(),
+	})
+}
+
+// getTargetUser get the user an admin action is taken on, return invalid param error if not found
+func (c *AdminCtrl) getTargetUser(db *gorm.DB, uid dal.UID) (*dal.User, response.SError) {
+	user, sErr := c.repos.Users.GetByUID(db, uid)
+	if sErr != nil {
+		return nil, sErr
+	}
+	if user == nil {
+		return nil, response.ErrorCode_InvalidParam.New("invalid uid, no user found")
+	}
+	return user, nil
+}
+
+// IsAdmin check whether the user is an admin and not disabled
+func (c *AdminCtrl) IsAdmin(ctx context.Context, uid dal.UID) (bool, response.SError) {
+	user, sErr := c.repos.Users.GetByUID(c.repos.DB(ctx), uid)
+	if sErr != nil {
+		return false, sErr
+	}
+	return user != nil && user.Role == dal.UserRoleAdmin && !user.Disabled, nil
+}
+
+// SearchUsers search users by uid, name or email, return the users and the total count
+func (c *AdminCtrl) SearchUsers(ctx context.Context, op *AdminOperator, text string, pagination *dal.Pagination) ([]*dal.User, uint, response.SError) {
+	var users []*dal.User
+	var total uint
+	sErr := c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		var sErr response.SError
+		users, total, sErr = c.repos.Users.SearchUserForAdmin(tx, text, pagination)
+		if sErr != nil {
+			return sErr
+		}
+		return c.addAuditLog(tx, op, dal.AdminActionSearchUsers, "", 0, text)
+	})
+	if sErr != nil {
+		return nil, 0, sErr
+	}
+	return users, total, nil
+}
+
+// ListUserHabits list the habits a user joined together with the habit logs between fromTime and toTime
+func (c *AdminCtrl) ListUserHabits(ctx context.Context, op *AdminOperator, uid dal.UID, pagination *dal.Pagination, fromTime *time.Time, toTime *time.Time) ([]*DetailedHabit, uint, response.SError) {
+	_, sErr := c.getTargetUser(c.repos.DB(ctx), uid)
+	if sErr != nil {
+		return nil, 0, sErr
+	}
+	habits, total, sErr := NewHabitCtrl(c.repos).ListHabitsByUID(ctx, uid, pagination, fromTime, toTime)
+	if sErr != nil {
+		return nil, 0, sErr
+	}
+	sErr = c.addAuditLog(c.repos.DB(ctx), op, dal.AdminActionViewHabits, uid, 0, "")
+	if sErr != nil {
+		return nil, 0, sErr
+	}
+	return habits, total, nil
+}
+
+// DisableUser disable a user and revoke all the sessions of it, a disabled user can not login until enabled again
+func (c *AdminCtrl) DisableUser(ctx context.Context, op *AdminOperator, uid dal.UID, reason string) response.SError {
+	if uid == op.UID {
+		return response.ErrorCode_InvalidParam.New("can not disable yourself")
+	}
+	return c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		_, sErr := c.getTargetUser(tx, uid)
+		if sErr != nil {
+			return sErr
+		}
+		sErr = c.repos.Users.SetDisabled(tx, uid, true)
+		if sErr != nil {
+			return sErr
+		}
+		sErr = c.repos.Sessions.RevokeByUID(tx, uid, "", time.Now().UTC())
+		if sErr != nil {
+			return sErr
+		}
+		return c.addAuditLog(tx, op, dal.AdminActionDisableUser, uid, 0, reason)
+	})
+}
+
+// EnableUser enable a disabled user
+func (c *AdminCtrl) EnableUser(ctx context.Context, op *AdminOperator, uid dal.UID, reason string) response.SError {
+	return c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		_, sErr := c.getTargetUser(tx, uid)
+		if sErr != nil {
+			return sErr
+		}
+		sErr = c.repos.Users.SetDisabled(tx, uid, false)
+		if sErr != nil {
+			return sErr
+		}
+		return c.addAuditLog(tx, op, dal.AdminActionEnableUser, uid, 0, reason)
+	})
+}
+
+// ForceActivateEmail mark the email of a user as activated without the user clicking the activate link
+func (c *AdminCtrl) ForceActivateEmail(ctx context.Context, op *AdminOperator, uid dal.UID, reason string) response.SError {
+	return c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		user, sErr := c.getTargetUser(tx, uid)
+		if sErr != nil {
+			return sErr
+		}
+		if user.Email == nil {
+			return response.ErrorCode_InvalidParam.New("user has no email")
+		}
+		if user.EmailActive {
+			return response.ErrorCode_InvalidParam.New("email already activated")
+		}
+		sErr = c.repos.Users.UpdateUser(tx, uid, &dal.UserUpdatableFields{EmailActive: util.LiteralValuePtr(true)})
+		if sErr != nil {
+			return sErr
+		}
+		return c.addAuditLog(tx, op, dal.AdminActionActivateEmail, uid, 0, reason)
+	})
+}
+
+// ResetStreak reset the current and longest streak of a user on a habit to zero
+func (c *AdminCtrl) ResetStreak(ctx context.Context, op *AdminOperator, uid dal.UID, habitID uint64, reason string) response.SError {
+	return c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		config, sErr := c.repos.UserHabitConfigs.GetByUIDAndHabitID(tx, uid, habitID)
+		if sErr != nil {
+			return sErr
+		}
+		if config == nil {
+			return response.ErrorCode_InvalidParam.New("user has not joined the habit")
+		}
+		sErr = c.repos.UserHabitConfigs.Update(tx, uid, habitID, &dal.UserHabitConfigUpdatableFields{
+			CurrentStreak: util.LiteralValuePtr(uint32(0)),
+			LongestStreak: util.LiteralValuePtr(uint32(0)),
+		})
+		if sErr != nil {
+			return sErr
+		}
+		detail := fmt.Sprintf("current %d, longest %d", config.CurrentStreak, config.LongestStreak)
+		if reason != "" {
+			detail = reason + "; " + detail
+		}
+		return c.addAuditLog(tx, op, dal.AdminActionResetStreak, uid, habitID, detail)
+	})
+}
+
+// RemoveName remove the name of a user, like an abusive one, the removed name is kept in the audit log
+func (c *AdminCtrl) RemoveName(ctx context.Context, op *AdminOperator, uid dal.UID, reason string) response.SError {
+	return c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		user, sErr := c.getTargetUser(tx, uid)
+		if sErr != nil {
+			return sErr
+		}
+		if user.Name == nil {
+			return response.ErrorCode_InvalidParam.New("user has no name")
+		}
+		sErr = c.repos.Users.ClearName(tx, uid)
+		if sErr != nil {
+			return sErr
+		}
+		detail := "name " + *user.Name
+		if reason != "" {
+			detail = reason + "; " + detail
+		}
+		return c.addAuditLog(tx, op, dal.AdminActionRemoveName, uid, 0, detail)
+	})
+}
+
+// RemovePortrait remove the portrait of a user, like an abusive one, the portrait data is deleted as well
+func (c *AdminCtrl) RemovePortrait(ctx context.Context, op *AdminOperator, uid dal.UID, reason string) response.SError {
+	return c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		user, sErr := c.getTargetUser(tx, uid)
+		if sErr != nil {
+			return sErr
+		}
+		if user.Portrait == nil {
+			return response.ErrorCode_InvalidParam.New("user has no portrait")
+		}
+		sErr = c.repos.Users.ClearPortrait(tx, uid)
+		if sErr != nil {
+			return sErr
+		}
+		sErr = c.addAuditLog(tx, op, dal.AdminActionRemovePortrait, uid, 0, reason)
+		if sErr != nil {
+			return sErr
+		}
+		err := service.GetObjectStorageExecutor().DeleteObject(ctx, *user.Portrait)
+		if err != nil {
+			return response.ErrroCode_InternalUnknownError.Wrap(err, "delete portrait data fail")
+		}
+		return nil
+	})
+}
+
+// ListAuditLogs list the admin audit logs, only of the target user if targetUID is not empty,
+// return the logs and the total count
+func (c *AdminCtrl) ListAuditLogs(ctx context.Context, op *AdminOperator, targetUID dal.UID, pagination *dal.Pagination) ([]*dal.AdminAuditLog, uint, response.SError) {
+	var logs []*dal.AdminAuditLog
+	var total uint
+	sErr := c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		var sErr response.SError
+		logs, total, sErr = c.repos.AdminAuditLogs.List(tx, targetUID, pagination)
+		if sErr != nil {
+			return sErr
+		}
+		return c.addAuditLog(tx, op, dal.AdminActionViewAuditLogs, targetUID, 0, "")
+	})
+	if sErr != nil {
+		return nil, 0, sErr
+	}
+	return logs, total, nil
+}
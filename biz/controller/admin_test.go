@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"github.com/swordandtea/lets-habit-server/biz/service"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestAdminModeration(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	storageDir := t.TempDir()
+	err := service.InitObjectStorageWithLocalMockImpl("http://localhost", storageDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestUser(t, db, "admin", "admin@test.com", "password")
+	err = db.Model(&dal.User{}).Where("uid=?", "admin").Update("role", dal.UserRoleAdmin).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := addTestUser(t, db, "u1", "u1@test.com", "password")
+
+	ctrl := NewAdminCtrl(NewRepositories())
+	if isAdmin, sErr := ctrl.IsAdmin(ctx, "admin"); sErr != nil || !isAdmin {
+		t.Fatal("admin not recognized", sErr)
+	}
+	if isAdmin, sErr := ctrl.IsAdmin(ctx, user.UID); sErr != nil || isAdmin {
+		t.Fatal("normal user recognized as admin", sErr)
+	}
+	op := &AdminOperator{UID: "admin", IP: "127.0.0.1"}
+
+	// disable revokes the sessions and prevents new ones
+	sessionCtrl := NewSessionCtrl(NewRepositories())
+	client := &SessionClient{DeviceName: "phone", UserAgent: "test", IP: "127.0.0.1"}
+	session, refreshToken, sErr := sessionCtrl.CreateSession(ctx, user.UID, client)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if ctrl.DisableUser(ctx, op, op.UID, "") == nil {
+		t.Fatal("admin should not be able to disable itself")
+	}
+	sErr = ctrl.DisableUser(ctx, op, user.UID, "spam")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if sessionCtrl.VerifySession(ctx, user.UID, session.SID) == nil {
+		t.Fatal("session should be revoked after user disabled")
+	}
+	_, _, sErr = sessionCtrl.CreateSession(ctx, user.UID, client)
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_UserNoPermission {
+		t.Fatal("disabled user should not be able to login", sErr)
+	}
+	_, _, sErr = sessionCtrl.RefreshSession(ctx, refreshToken, client)
+	if sErr == nil {
+		t.Fatal("disabled user should not be able to refresh token")
+	}
+	sErr = ctrl.EnableUser(ctx, op, user.UID, "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	_, _, sErr = sessionCtrl.CreateSession(ctx, user.UID, client)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+
+	// force email activation
+	err = db.Model(&dal.User{}).Where("uid=?", user.UID).Update("email_active", false).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	sErr = ctrl.ForceActivateEmail(ctx, op, user.UID, "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if ctrl.ForceActivateEmail(ctx, op, user.UID, "") == nil {
+		t.Fatal("activated email should not be activated again")
+	}
+
+	// reset streak
+	sErr = dal.UserHabitConfigDBHD.Add(db, &dal.UserHabitConfig{UID: user.UID, HabitID: 1, CurrentStreak: 3, LongestStreak: 10})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	sErr = ctrl.ResetStreak(ctx, op, user.UID, 1, "cheating")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	config, sErr := dal.UserHabitConfigDBHD.GetByUIDAndHabitID(db, user.UID, 1)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if config.CurrentStreak != 0 || config.LongestStreak != 0 {
+		t.Fatalf("streak not reset, current %d, longest %d", config.CurrentStreak, config.LongestStreak)
+	}
+	if ctrl.ResetStreak(ctx, op, user.UID, 2, "") == nil {
+		t.Fatal("should not reset streak of a habit not joined")
+	}
+
+	// remove name and portrait
+	portrait := "portrait/u1.png"
+	err = os.MkdirAll(path.Join(storageDir, "portrait"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path.Join(storageDir, portrait), []byte("png"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sErr = dal.UserDBHD.UpdateUser(db, user.UID, &dal.UserUpdatableFields{Name: "abusive", Portrait: portrait})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	sErr = ctrl.RemoveName(ctx, op, user.UID, "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	sErr = ctrl.RemovePortrait(ctx, op, user.UID, "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	user, sErr = dal.UserDBHD.GetByUID(db, user.UID)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if user.Name != nil || user.Portrait != nil {
+		t.Fatal("name or portrait not removed")
+	}
+	if _, err = os.Stat(path.Join(storageDir, portrait)); !os.IsNotExist(err) {
+		t.Fatal("portrait data not deleted", err)
+	}
+
+	// search users
+	users, total, sErr := ctrl.SearchUsers(ctx, op, "u1@test", &dal.Pagination{Page: 1, PageSize: 10})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if total != 1 || len(users) != 1 || users[0].UID != user.UID {
+		t.Fatalf("unexpected search result, total %d, users %v", total, users)
+	}
+
+	// every action is audited, the latest first
+	logs, total, sErr := ctrl.ListAuditLogs(ctx, op, user.UID, &dal.Pagination{Page: 1, PageSize: 100})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	expectedActions := []dal.AdminAction{dal.AdminActionRemovePortrait, dal.AdminActionRemoveName,
+		dal.AdminActionResetStreak, dal.AdminActionActivateEmail, dal.AdminActionEnableUser, dal.AdminActionDisableUser}
+	if total != uint(len(expectedActions)) || len(logs) != len(expectedActions) {
+		t.Fatalf("expect %d audit logs, got %d", len(expectedActions), total)
+	}
+	for i, action := range expectedActions {
+		if logs[i].Action != action || logs[i].AdminUID != op.UID || logs[i].IP != op.IP {
+			t.Fatalf("unexpected audit log %d: %+v", i, logs[i])
+		}
+	}
+	if logs[5].Detail != "spam" || logs[2].TargetHabitID != 1 || logs[1].Detail != "name abusive" {
+		t.Fatal("audit log detail not recorded")
+	}
+}
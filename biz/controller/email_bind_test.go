@@ -0,0 +1,224 @@
+package controller
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/config"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var bindCodeRegexp = regexp.MustCompile(`bind_code=([\w-]+)`)
+var cancelCodeRegexp = regexp.MustCompile(`cancel_code=([\w-]+)`)
+
+func setupEmailBindConfig() {
+	config.GlobalConfig.EmailService.BindURI = "http://test/bind"
+	config.GlobalConfig.EmailService.BindParam = "bind_code"
+	config.GlobalConfig.EmailService.BindCancelURI = "http://test/bind/cancel"
+	config.GlobalConfig.EmailService.BindCancelParam = "cancel_code"
+}
+
+func TestBindEmail(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	setupEmailBindConfig()
+	mailer := setupFakeMailService()
+	addTestUser(t, db, "u1", "old@test.com", "passw0rd1")
+	addTestUser(t, db, "u2", "used@test.com", "passw0rd1")
+	ctrl := NewUserCtrl(NewRepositories())
+
+	sErr := ctrl.StartEmailBinding(ctx, "u1", "", "used@test.com")
+	if sErr == nil {
+		t.Fatal("bind an email used by another user should fail")
+	}
+	sErr = ctrl.StartEmailBinding(ctx, "u1", "", "old@test.com")
+	if sErr == nil {
+		t.Fatal("bind the same email should fail")
+	}
+
+	sErr = ctrl.StartEmailBinding(ctx, "u1", "", "new@test.com")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(mailer.sent(t)) != 2 {
+		t.Fatalf("expect a confirm mail and a notice mail, got %d mails", len(mailer.sent(t)))
+	}
+	if !strings.Contains(mailer.sent(t)[0], "To: new@test.com") || !strings.Contains(mailer.sent(t)[1], "To: old@test.com") {
+		t.Fatal("mails sent to wrong addresses")
+	}
+	bindCode := bindCodeRegexp.FindStringSubmatch(mailer.sent(t)[0])[1]
+
+	// the email is not changed before confirmed
+	user, sErr := dal.UserDBHD.GetByUID(db, "u1")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if *user.Email != "old@test.com" {
+		t.Fatal("email changed before confirmed")
+	}
+
+	_, sErr = ctrl.ConfirmBindEmail(ctx, bindCode)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	user, sErr = dal.UserDBHD.GetByUID(db, "u1")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if *user.Email != "new@test.com" || !user.EmailBind || !user.EmailActive {
+		t.Fatalf("unexpected user after confirmed %+v", user)
+	}
+
+	_, sErr = ctrl.ConfirmBindEmail(ctx, bindCode)
+	if sErr == nil {
+		t.Fatal("confirm twice should fail")
+	}
+}
+
+func TestBindEmailCancel(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	setupEmailBindConfig()
+	mailer := setupFakeMailService()
+	addTestUser(t, db, "u1", "old@test.com", "passw0rd1")
+	ctrl := NewUserCtrl(NewRepositories())
+
+	sErr := ctrl.StartEmailBinding(ctx, "u1", "", "new@test.com")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	bindCode := bindCodeRegexp.FindStringSubmatch(mailer.sent(t)[0])[1]
+	cancelCode := cancelCodeRegexp.FindStringSubmatch(mailer.sent(t)[1])[1]
+
+	sErr = ctrl.CancelBindEmail(ctx, cancelCode)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	_, sErr = ctrl.ConfirmBindEmail(ctx, bindCode)
+	if sErr == nil {
+		t.Fatal("confirm a canceled change should fail")
+	}
+	user, sErr := dal.UserDBHD.GetByUID(db, "u1")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if *user.Email != "old@test.com" {
+		t.Fatal("email changed after canceled")
+	}
+}
+
+func TestBindEmailTakenBeforeConfirm(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	setupEmailBindConfig()
+	mailer := setupFakeMailService()
+	addTestUser(t, db, "u1", "u1@test.com", "passw0rd1")
+	addTestUser(t, db, "u2", "u2@test.com", "passw0rd1")
+	ctrl := NewUserCtrl(NewRepositories())
+
+	// both users want the same email, only the first confirmed one gets it
+	sErr := ctrl.StartEmailBinding(ctx, "u1", "", "new@test.com")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	sErr = ctrl.StartEmailBinding(ctx, "u2", "", "new@test.com")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	bindCode1 := bindCodeRegexp.FindStringSubmatch(mailer.sent(t)[0])[1]
+	bindCode2 := bindCodeRegexp.FindStringSubmatch(mailer.sent(t)[2])[1]
+
+	_, sErr = ctrl.ConfirmBindEmail(ctx, bindCode2)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	_, sErr = ctrl.ConfirmBindEmail(ctx, bindCode1)
+	if sErr == nil {
+		t.Fatal("confirm an email taken by another user should fail")
+	}
+	user, sErr := dal.UserDBHD.GetByUID(db, "u1")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if *user.Email != "u1@test.com" {
+		t.Fatal("email of u1 should not be changed")
+	}
+}
+
+func TestBindEmailRevert(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	setupEmailBindConfig()
+	mailer := setupFakeMailService()
+	addTestUser(t, db, "u1", "old@test.com", "passw0rd1")
+	ctrl := NewUserCtrl(NewRepositories())
+	sessionCtrl := NewSessionCtrl(NewRepositories())
+	current, _, sErr := sessionCtrl.CreateSession(ctx, "u1", &SessionClient{DeviceName: "current"})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	other, _, sErr := sessionCtrl.CreateSession(ctx, "u1", &SessionClient{DeviceName: "other"})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+
+	sErr = ctrl.StartEmailBinding(ctx, "u1", current.SID, "new@test.com")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	bindCode := bindCodeRegexp.FindStringSubmatch(mailer.sent(t)[0])[1]
+	cancelCode := cancelCodeRegexp.FindStringSubmatch(mailer.sent(t)[1])[1]
+	_, sErr = ctrl.ConfirmBindEmail(ctx, bindCode)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if sErr = sessionCtrl.VerifySession(ctx, "u1", current.SID); sErr != nil {
+		t.Fatal("the session starting the change should be kept", sErr)
+	}
+	if sErr = sessionCtrl.VerifySession(ctx, "u1", other.SID); sErr == nil {
+		t.Fatal("the other sessions should be revoked on confirm")
+	}
+
+	// the old email reverts the confirmed change and logs out everywhere
+	sErr = ctrl.CancelBindEmail(ctx, cancelCode)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	user, sErr := dal.UserDBHD.GetByUID(db, "u1")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if *user.Email != "old@test.com" {
+		t.Fatalf("expect the old email restored, got %s", *user.Email)
+	}
+	if sErr = sessionCtrl.VerifySession(ctx, "u1", current.SID); sErr == nil {
+		t.Fatal("all the sessions should be revoked on revert")
+	}
+	sErr = ctrl.CancelBindEmail(ctx, cancelCode)
+	if sErr == nil {
+		t.Fatal("revert twice should fail")
+	}
+
+	// a change confirmed too long ago can not be reverted
+	sErr = ctrl.StartEmailBinding(ctx, "u1", "", "new@test.com")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	bindCode = bindCodeRegexp.FindStringSubmatch(mailer.sent(t)[2])[1]
+	cancelCode = cancelCodeRegexp.FindStringSubmatch(mailer.sent(t)[3])[1]
+	_, sErr = ctrl.ConfirmBindEmail(ctx, bindCode)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	err := db.Model(&dal.EmailBindRequest{}).Where("confirm_at is not null and cancel_at is null").
+		Update("confirm_at", time.Now().UTC().Add(-emailBindRevertTime)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	sErr = ctrl.CancelBindEmail(ctx, cancelCode)
+	if sErr == nil {
+		t.Fatal("revert after the revert time should fail")
+	}
+}
@@ -1,7 +1,9 @@
 package controller
 
 import (
+	"context"
 	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/realtime"
 	"github.com/swordandtea/lets-habit-server/biz/response"
 	"github.com/swordandtea/lets-habit-server/biz/service"
 	"github.com/swordandtea/lets-habit-server/util"
@@ -9,7 +11,14 @@ import (
 	"time"
 )
 
-type HabitCtrl struct{}
+type HabitCtrl struct {
+	repos *Repositories
+}
+
+// NewHabitCtrl create a HabitCtrl operating on the repos
+func NewHabitCtrl(repos *Repositories) *HabitCtrl {
+	return &HabitCtrl{repos: repos}
+}
 
 const CooperatorLimit = 5
 
@@ -27,12 +36,12 @@ type DetailedHabit struct {
 }
 
 // AddHabit add a habit and its group user info
-func (c *HabitCtrl) AddHabit(habit *dal.Habit, creator dal.UID, cooperators []dal.UID, customConfig *HabitCustomConfig) (*DetailedHabit, response.SError) {
+func (c *HabitCtrl) AddHabit(ctx context.Context, habit *dal.Habit, creator dal.UID, cooperators []dal.UID, customConfig *HabitCustomConfig) (*DetailedHabit, response.SError) {
 	if len(cooperators) > CooperatorLimit {
 		return nil, response.ErrorCode_InvalidParam.New("cooperator exceed limit")
 	}
-	db := service.GetDBExecutor()
-	users, sErr := dal.UserDBHD.ListByUIDs(db, cooperators)
+	db := c.repos.DB(ctx)
+	users, sErr := c.repos.Users.ListByUIDs(db, cooperators)
 	if sErr != nil {
 		return nil, sErr
 	}
@@ -42,12 +51,13 @@ func (c *HabitCtrl) AddHabit(habit *dal.Habit, creator dal.UID, cooperators []da
 
 	habit.Owner = creator
 	habit.CreateAt = time.Now().UTC()
-	sErr = WithDBTx(nil, func(tx *gorm.DB) response.SError {
-		sErr = dal.HabitDBHD.Add(tx, habit)
+	var hgs []*dal.HabitGroup
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		sErr = c.repos.Habits.Add(tx, habit)
 		if sErr != nil {
 			return sErr
 		}
-		hgs := make([]*dal.HabitGroup, 0, len(cooperators)+1)
+		hgs = make([]*dal.HabitGroup, 0, len(cooperators)+1)
 		hgs = append(hgs, &dal.HabitGroup{
 			HabitID: habit.ID,
 			UID:     creator,
@@ -60,23 +70,27 @@ func (c *HabitCtrl) AddHabit(habit *dal.Habit, creator dal.UID, cooperators []da
 				})
 			}
 		}
-		sErr = dal.HabitGroupDBHD.AddMulti(tx, hgs)
+		sErr = c.repos.HabitGroups.AddMulti(tx, hgs)
 		if sErr != nil {
 			return sErr
 		}
 
-		uhc := &dal.UserHabitConfig{
-			UID:                     creator,
-			HabitID:                 habit.ID,
-			CurrentStreak:           0,
-			LongestStreak:           0,
-			RemainRetroactiveChance: 0,
-			HeatmapColor:            customConfig.HeatmapColor,
-		}
-
-		sErr = dal.UserHabitConfigDBHD.Add(tx, uhc)
-		if sErr != nil {
-			return sErr
+		// every member needs a config to keep the streak, the custom config only applies to the creator
+		for _, hg := range hgs {
+			uhc := &dal.UserHabitConfig{
+				UID:                     hg.UID,
+				HabitID:                 habit.ID,
+				CurrentStreak:           0,
+				LongestStreak:           0,
+				RemainRetroactiveChance: 0,
+			}
+			if hg.UID == creator {
+				uhc.HeatmapColor = customConfig.HeatmapColor
+			}
+			sErr = c.repos.UserHabitConfigs.Add(tx, uhc)
+			if sErr != nil {
+				return sErr
+			}
 		}
 
 		return nil
@@ -84,6 +98,13 @@ func (c *HabitCtrl) AddHabit(habit *dal.Habit, creator dal.UID, cooperators []da
 	if sErr != nil {
 		return nil, sErr
 	}
+	members := habitGroupUIDs(hgs)
+	publishHabitEvent(ctx, members, &realtime.Event{
+		Type:    realtime.EventMemberJoined,
+		HabitID: habit.ID,
+		UID:     creator,
+		Members: members,
+	})
 
 	SimplifiedUsers := make([]*SimplifiedUser, 0, len(users))
 	for _, u := range users {
@@ -126,10 +147,10 @@ func (u *UserHabitConfigUpdatableField) IsValid() bool {
 	return u.HeatmapColor != ""
 }
 
-func (c *HabitCtrl) UpdateHabit(uid dal.UID, habitID uint64, basicInfo *HabitUpdatableInfo,
+func (c *HabitCtrl) UpdateHabit(ctx context.Context, uid dal.UID, habitID uint64, basicInfo *HabitUpdatableInfo,
 	customConfig *UserHabitConfigUpdatableField) response.SError {
-	db := service.GetDBExecutor()
-	habit, sErr := dal.HabitDBHD.GetByID(db, habitID)
+	db := c.repos.DB(ctx)
+	habit, sErr := c.repos.Habits.GetByID(db, habitID, false)
 	if sErr != nil {
 		return sErr
 	}
@@ -137,7 +158,7 @@ func (c *HabitCtrl) UpdateHabit(uid dal.UID, habitID uint64, basicInfo *HabitUpd
 		return response.ErrorCode_InvalidParam.New("invalid habit id, not found")
 	}
 
-	habitGroups, sErr := dal.HabitGroupDBHD.ListByHabitID(db, habitID)
+	habitGroups, sErr := c.repos.HabitGroups.ListByHabitID(db, habitID)
 	if sErr != nil {
 		return sErr
 	}
@@ -150,7 +171,7 @@ func (c *HabitCtrl) UpdateHabit(uid dal.UID, habitID uint64, basicInfo *HabitUpd
 		}
 	}
 
-	sErr = WithDBTx(db, func(tx *gorm.DB) response.SError {
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
 		// TODO: verify cooperators to Add and cooperators to delete
 		if basicInfo.IsValid() {
 			if habit.Owner != uid {
@@ -161,7 +182,7 @@ func (c *HabitCtrl) UpdateHabit(uid dal.UID, habitID uint64, basicInfo *HabitUpd
 			}
 
 			if basicInfo.Name != "" || basicInfo.Identity != "" {
-				sErr = dal.HabitDBHD.UpdateHabit(tx, habitID, &dal.HabitUpdatableFields{
+				sErr = c.repos.Habits.UpdateHabit(tx, habitID, &dal.HabitUpdatableFields{
 					Name:     basicInfo.Name,
 					Identity: basicInfo.Identity,
 				})
@@ -178,13 +199,19 @@ func (c *HabitCtrl) UpdateHabit(uid dal.UID, habitID uint64, basicInfo *HabitUpd
 						UID:     cooperator,
 					})
 				}
-				sErr = dal.HabitGroupDBHD.AddMulti(tx, hgsToAdd)
+				sErr = c.repos.HabitGroups.AddMulti(tx, hgsToAdd)
 				if sErr != nil {
 					return sErr
 				}
+				for _, cooperator := range basicInfo.CooperatorsToAdd {
+					sErr = c.repos.UserHabitConfigs.Add(tx, &dal.UserHabitConfig{UID: cooperator, HabitID: habitID})
+					if sErr != nil {
+						return sErr
+					}
+				}
 			}
 			if len(basicInfo.CooperatorsToDelete) != 0 {
-				sErr = dal.HabitGroupDBHD.DeleteByHabitIDAndUIDs(tx, habitID, basicInfo.CooperatorsToDelete)
+				sErr = c.repos.HabitGroups.DeleteByHabitIDAndUIDs(tx, habitID, basicInfo.CooperatorsToDelete)
 				if sErr != nil {
 					return sErr
 				}
@@ -195,7 +222,7 @@ func (c *HabitCtrl) UpdateHabit(uid dal.UID, habitID uint64, basicInfo *HabitUpd
 			if !inGroup {
 				return response.ErrorCode_UserNoPermission.New("current user not in this habit")
 			}
-			sErr = dal.UserHabitConfigDBHD.Update(tx, uid, habitID, &dal.UserHabitConfigUpdatableFields{
+			sErr = c.repos.UserHabitConfigs.Update(tx, uid, habitID, &dal.UserHabitConfigUpdatableFields{
 				HeatmapColor: customConfig.HeatmapColor,
 			})
 			if sErr != nil {
@@ -207,14 +234,55 @@ func (c *HabitCtrl) UpdateHabit(uid dal.UID, habitID uint64, basicInfo *HabitUpd
 	if sErr != nil {
 		return sErr
 	}
+
+	if basicInfo.IsValid() {
+		publishUpdateEvents(ctx, uid, habitID, habitGroups, basicInfo)
+	}
 	return nil
 }
 
+// publishUpdateEvents push the changes of the basic info of a habit to the members after the update,
+// and to the members removed by it
+func publishUpdateEvents(ctx context.Context, uid dal.UID, habitID uint64, habitGroups []*dal.HabitGroup,
+	basicInfo *HabitUpdatableInfo) {
+	deleted := make(map[dal.UID]bool, len(basicInfo.CooperatorsToDelete))
+	for _, cooperator := range basicInfo.CooperatorsToDelete {
+		deleted[cooperator] = true
+	}
+	members := make([]dal.UID, 0, len(habitGroups)+len(basicInfo.CooperatorsToAdd))
+	for _, hg := range habitGroups {
+		if !deleted[hg.UID] {
+			members = append(members, hg.UID)
+		}
+	}
+	members = append(members, basicInfo.CooperatorsToAdd...)
+
+	if basicInfo.Name != "" || basicInfo.Identity != "" {
+		publishHabitEvent(ctx, members, &realtime.Event{Type: realtime.EventHabitUpdated, HabitID: habitID, UID: uid})
+	}
+	if len(basicInfo.CooperatorsToAdd) != 0 {
+		publishHabitEvent(ctx, members, &realtime.Event{
+			Type:    realtime.EventMemberJoined,
+			HabitID: habitID,
+			UID:     uid,
+			Members: basicInfo.CooperatorsToAdd,
+		})
+	}
+	if len(basicInfo.CooperatorsToDelete) != 0 {
+		publishHabitEvent(ctx, append(members, basicInfo.CooperatorsToDelete...), &realtime.Event{
+			Type:    realtime.EventMemberQuit,
+			HabitID: habitID,
+			UID:     uid,
+			Members: basicInfo.CooperatorsToDelete,
+		})
+	}
+}
+
 // GetHabitByID get a habit and its group info by habit id,
 // if current user not in its group, return error
-func (c *HabitCtrl) GetHabitByID(habitID uint64, uid dal.UID) (*DetailedHabit, response.SError) {
-	db := service.GetDBExecutor()
-	habit, sErr := dal.HabitDBHD.GetByID(db, habitID)
+func (c *HabitCtrl) GetHabitByID(ctx context.Context, habitID uint64, uid dal.UID) (*DetailedHabit, response.SError) {
+	db := c.repos.DB(ctx)
+	habit, sErr := c.repos.Habits.GetByID(db, habitID, false)
 	if sErr != nil {
 		return nil, sErr
 	}
@@ -222,7 +290,7 @@ func (c *HabitCtrl) GetHabitByID(habitID uint64, uid dal.UID) (*DetailedHabit, r
 		return nil, response.ErrorCode_InvalidParam.New("habit id not exist")
 	}
 
-	hgs, sErr := dal.HabitGroupDBHD.ListByHabitID(db, habit.ID)
+	hgs, sErr := c.repos.HabitGroups.ListByHabitID(db, habit.ID)
 	if sErr != nil {
 		return nil, sErr
 	}
@@ -241,12 +309,12 @@ func (c *HabitCtrl) GetHabitByID(habitID uint64, uid dal.UID) (*DetailedHabit, r
 		return nil, response.ErrorCode_UserNoPermission.New("current user not participated in this habit")
 	}
 
-	users, sErr := dal.UserDBHD.ListByUIDs(db, uids)
+	users, sErr := c.repos.Users.ListByUIDs(db, uids)
 	if sErr != nil {
 		return nil, sErr
 	}
 
-	userHabitConfig, sErr := dal.UserHabitConfigDBHD.GetByUIDAndHabitID(db, uid, habitID)
+	userHabitConfig, sErr := c.repos.UserHabitConfigs.GetByUIDAndHabitID(db, uid, habitID)
 	if sErr != nil {
 		return nil, sErr
 	}
@@ -278,11 +346,11 @@ func getTodayBeginEndTime(now *time.Time) (time.Time, time.Time) {
 }
 
 // ListHabitsByUID get all the habit the user joined
-func (c *HabitCtrl) ListHabitsByUID(uid dal.UID, pagination *dal.Pagination, fromTime *time.Time, toTime *time.Time) ([]*DetailedHabit, uint, response.SError) {
-	db := service.GetDBExecutor()
+func (c *HabitCtrl) ListHabitsByUID(ctx context.Context, uid dal.UID, pagination *dal.Pagination, fromTime *time.Time, toTime *time.Time) ([]*DetailedHabit, uint, response.SError) {
+	db := c.repos.DB(ctx)
 
 	// get user joined habits
-	habits, total, sErr := dal.HabitDBHD.ListUserJoinedHabits(db, uid, pagination)
+	habits, total, sErr := c.repos.Habits.ListUserJoinedHabits(db, uid, pagination)
 	if sErr != nil {
 		return nil, 0, sErr
 	}
@@ -292,7 +360,7 @@ func (c *HabitCtrl) ListHabitsByUID(uid dal.UID, pagination *dal.Pagination, fro
 	}
 
 	// get user habit config
-	userHabitConfigs, sErr := dal.UserHabitConfigDBHD.ListUserHabitConfig(db, uid, habitIDs)
+	userHabitConfigs, sErr := c.repos.UserHabitConfigs.ListUserHabitConfig(db, uid, habitIDs)
 	if sErr != nil {
 		return nil, 0, sErr
 	}
@@ -303,7 +371,7 @@ func (c *HabitCtrl) ListHabitsByUID(uid dal.UID, pagination *dal.Pagination, fro
 	}
 
 	// get user habit log record
-	habitLogRecords, sErr := dal.HabitLogRecordDBHD.ListByUIDHabitIDs(db, uid, habitIDs, fromTime, toTime)
+	habitLogRecords, sErr := c.repos.HabitLogRecords.ListByUIDHabitIDs(db, uid, habitIDs, fromTime, toTime)
 	if sErr != nil {
 		return nil, 0, sErr
 	}
@@ -332,7 +400,7 @@ func (c *HabitCtrl) ListHabitsByUID(uid dal.UID, pagination *dal.Pagination, fro
 
 	var unconfirmedHabitLogRecords []*dal.HabitLogRecord
 	if toTime.Unix() >= todayBegin.Unix() && toTime.Unix() < todayEnd.Unix() { // TODay included
-		unconfirmedHabitLogRecords, sErr = dal.UnconfirmedHabitLogRecordDBHD.ListByUIDHabitIDs(db, uid, habitIDs, &todayBegin, &now)
+		unconfirmedHabitLogRecords, sErr = c.repos.UnconfirmedHabitLogRecords.ListByUIDHabitIDs(db, uid, habitIDs, &todayBegin, &now)
 		if sErr != nil {
 			return nil, 0, sErr
 		}
@@ -377,8 +445,8 @@ func (c *HabitCtrl) ListHabitsByUID(uid dal.UID, pagination *dal.Pagination, fro
 	}
 
 	if len(habitToClearStreak) != 0 {
-		sErr = WithDBTx(db, func(tx *gorm.DB) response.SError {
-			sErr = dal.UserHabitConfigDBHD.UpdateMany(tx, uid, habitToClearStreak, &dal.UserHabitConfigUpdatableFields{
+		sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+			sErr = c.repos.UserHabitConfigs.UpdateMany(tx, uid, habitToClearStreak, &dal.UserHabitConfigUpdatableFields{
 				CurrentStreak:  util.LiteralValuePtr(uint32(0)),
 				StreakUpdateAt: util.LiteralValuePtr(now.UTC()),
 			})
@@ -394,9 +462,24 @@ func (c *HabitCtrl) ListHabitsByUID(uid dal.UID, pagination *dal.Pagination, fro
 	return detailedHabits, total, nil
 }
 
-func (c *HabitCtrl) LogHabit(uid dal.UID, habitID uint64, logTime *time.Time) (*dal.HabitLogRecord, response.SError) {
-	db := service.GetDBExecutor()
-	habit, sErr := dal.HabitDBHD.GetByID(db, habitID)
+// habitLogResult the result of logging a habit for a day
+type habitLogResult struct {
+	// record the record logged, or the one logged before if the user has logged the habit on the day
+	record *dal.HabitLogRecord
+	// confirmedRecord the confirmed record of the user if the log completed the group, nil if not
+	confirmedRecord *dal.HabitLogRecord
+	// alreadyLogged the user has logged the habit on the day, nothing is logged
+	alreadyLogged bool
+	// members the members of the habit when logged
+	members []dal.UID
+}
+
+// logHabitInTx log a habit at logAt for the day logAt is in of its zone, the logs of the day are confirmed and
+// the streaks of the group increased once all the members have logged. It must run in a transaction, the habit
+// row is locked first, so the members logging at the same time are serialized and the last of them always sees
+// the logs of the others
+func (c *HabitCtrl) logHabitInTx(tx *gorm.DB, uid dal.UID, habitID uint64, logAt time.Time, clientID *string) (*habitLogResult, response.SError) {
+	habit, sErr := c.repos.Habits.GetByID(tx, habitID, true)
 	if sErr != nil {
 		return nil, sErr
 	}
@@ -404,7 +487,7 @@ func (c *HabitCtrl) LogHabit(uid dal.UID, habitID uint64, logTime *time.Time) (*
 		return nil, response.ErrorCode_InvalidParam.New("habit not exist")
 	}
 
-	hgs, sErr := dal.HabitGroupDBHD.ListByHabitID(db, habitID)
+	hgs, sErr := c.repos.HabitGroups.ListByHabitID(tx, habitID)
 	if sErr != nil {
 		return nil, sErr
 	}
@@ -420,108 +503,169 @@ func (c *HabitCtrl) LogHabit(uid dal.UID, habitID uint64, logTime *time.Time) (*
 		return nil, response.ErrorCode_UserNoPermission.New("current user has not joined this habit")
 	}
 
-	now := time.Now().In(logTime.Location())
-	dayBitMask := 1 << now.Add(-time.Hour*time.Duration(dal.HabitLogDelayHours)).Weekday()
+	dayBegin, dayEnd := getTodayBeginEndTime(&logAt)
+	dayBitMask := 1 << dayBegin.Weekday()
 	if !habit.LogDays.Has(dal.CheckDay(dayBitMask)) {
 		return nil, response.ErrorCode_InvalidParam.New("current day no need to log")
 	}
 
-	todayBegin, todayEnd := getTodayBeginEndTime(&now)
-	allChecked := true
-	newRecord := &dal.HabitLogRecord{
-		HabitID: habitID,
-		UID:     uid,
-		LogAt:   now.UTC(),
+	logRecords, sErr := c.repos.UnconfirmedHabitLogRecords.ListByHabitID(tx, habitID, &dayBegin, &dayEnd)
+	if sErr != nil {
+		return nil, sErr
 	}
-	sErr = WithDBTx(db, func(tx *gorm.DB) response.SError {
-		logRecords, sErr := dal.UnconfirmedHabitLogRecordDBHD.ListByHabitID(tx, habitID, &todayBegin, &todayEnd)
-		if sErr != nil {
-			return sErr
+
+	logMap := make(map[dal.UID]*dal.HabitLogRecord)
+	for _, lr := range logRecords {
+		if lr.UID == uid {
+			return &habitLogResult{record: lr, alreadyLogged: true}, nil
 		}
+		logMap[lr.UID] = lr
+	}
 
-		logMap := make(map[dal.UID]bool)
-		uidList := make([]dal.UID, 0, len(logRecords))
-		for _, lr := range logRecords {
-			if lr.UID == uid {
-				return response.ErrorCode_InvalidParam.New("already logged today")
-			}
-			lr.ID = 0 // clear primary key id
-			logMap[lr.UID] = true
-			uidList = append(uidList, lr.UID)
+	now := time.Now().UTC()
+	newRecord := &dal.HabitLogRecord{
+		HabitID:  habitID,
+		UID:      uid,
+		LogAt:    logAt.UTC(),
+		LogDay:   dayBegin.Format(dal.LogDayLayout),
+		ClientID: clientID,
+		UpdateAt: now,
+	}
+	sErr = c.repos.UnconfirmedHabitLogRecords.Add(tx, newRecord)
+	if sErr != nil {
+		if service.IsDuplicateKeyError(sErr.Cause()) {
+			return &habitLogResult{alreadyLogged: true}, nil
 		}
-		logMap[uid] = true
+		return nil, sErr
+	}
+	logMap[uid] = newRecord
+	result := &habitLogResult{record: newRecord, members: habitGroupUIDs(hgs)}
 
-		sErr = dal.UnconfirmedHabitLogRecordDBHD.Add(tx, newRecord)
-		if sErr != nil {
-			return sErr
+	for _, hg := range hgs {
+		if logMap[hg.UID] == nil {
+			return result, nil
 		}
+	}
 
-		for _, hg := range hgs {
-			if !logMap[hg.UID] {
-				allChecked = false
-				break
-			}
+	// the logs of the members have been confirmed already if the group was complete before someone joined the day
+	confirmed, sErr := c.repos.HabitLogRecords.ListByHabitIDAndLogDay(tx, habitID, newRecord.LogDay)
+	if sErr != nil {
+		return nil, sErr
+	}
+	confirmedMap := make(map[dal.UID]bool, len(confirmed))
+	for _, r := range confirmed {
+		confirmedMap[r.UID] = true
+	}
+	var toConfirm []*dal.HabitLogRecord
+	var uidList []dal.UID
+	for _, hg := range hgs {
+		if confirmedMap[hg.UID] {
+			continue
 		}
-		if allChecked {
-			newRecord.ID = 0
-			logRecords = append(logRecords, newRecord)
-			sErr = dal.HabitLogRecordDBHD.AddMulti(tx, logRecords)
-			if sErr != nil {
-				return sErr
-			}
-			//sErr = dal.UnconfirmedHabitLogRecordDBHD.DeleteByHabitID(tx, habitID, &todayBegin, &todayEnd)
-			//if sErr != nil {
-			//	return sErr
-			//}
-			uidList = append(uidList, uid)
-			sErr = dal.UserHabitConfigDBHD.IncreaseCurrentStreakByOne(tx, uidList, habitID)
-			if sErr != nil {
-				return sErr
-			}
-		} else {
-			sErr = dal.UnconfirmedHabitLogRecordDBHD.Add(tx, newRecord)
-			if sErr != nil {
-				return sErr
-			}
+		record := *logMap[hg.UID]
+		record.ID = 0 // clear primary key id
+		record.UpdateAt = now
+		toConfirm = append(toConfirm, &record)
+		uidList = append(uidList, hg.UID)
+		if hg.UID == uid {
+			result.confirmedRecord = &record
 		}
-		return nil
-	})
-
+	}
+	sErr = c.repos.HabitLogRecords.AddMulti(tx, toConfirm)
+	if sErr != nil {
+		return nil, sErr
+	}
+	sErr = c.repos.UserHabitConfigs.IncreaseCurrentStreakByOne(tx, uidList, habitID)
 	if sErr != nil {
 		return nil, sErr
 	}
+	return result, nil
+}
 
-	if allChecked {
-		return newRecord, nil
+// LogHabit log a habit for the current day of logTime's zone, return the confirmed log record of the user
+// if the log completed the group
+func (c *HabitCtrl) LogHabit(ctx context.Context, uid dal.UID, habitID uint64, logTime *time.Time) (*dal.HabitLogRecord, response.SError) {
+	var result *habitLogResult
+	sErr := c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		var sErr response.SError
+		result, sErr = c.logHabitInTx(tx, uid, habitID, time.Now().In(logTime.Location()), nil)
+		return sErr
+	})
+	if sErr != nil {
+		return nil, sErr
+	}
+	if result.alreadyLogged {
+		return nil, response.ErrorCode_InvalidParam.New("already logged today")
 	}
-	return nil, nil
+	publishLogEvents(ctx, uid, result)
+	return result.confirmedRecord, nil
 }
 
-func deleteHabitCommonInfo(tx *gorm.DB, habitID uint64, uid dal.UID) response.SError {
-	sErr := dal.HabitGroupDBHD.DeleteByHabitIDAndUID(tx, habitID, uid)
+func (r *Repositories) deleteHabitCommonInfo(tx *gorm.DB, habitID uint64, uid dal.UID) response.SError {
+	sErr := r.HabitGroups.DeleteByHabitIDAndUID(tx, habitID, uid)
 	if sErr != nil {
 		return sErr
 	}
-	sErr = dal.UserHabitConfigDBHD.DeleteByHabitIDAndUID(tx, habitID, uid)
+	sErr = r.UserHabitConfigs.DeleteByHabitIDAndUID(tx, habitID, uid)
 	if sErr != nil {
 		return sErr
 	}
-	sErr = dal.HabitLogRecordDBHD.DeleteByHabitIDAndUID(tx, habitID, uid)
+	sErr = r.HabitLogRecords.DeleteByHabitIDAndUID(tx, habitID, uid)
 	if sErr != nil {
 		return sErr
 	}
-	sErr = dal.UnconfirmedHabitLogRecordDBHD.DeleteByHabitIDAndUID(tx, habitID, uid)
+	sErr = r.UnconfirmedHabitLogRecords.DeleteByHabitIDAndUID(tx, habitID, uid)
 	if sErr != nil {
 		return sErr
 	}
 	return nil
 }
 
+// quitHabit remove a user from the group of a habit, if the user owns the habit, the ownership is handed
+// over to another member, and the habit itself is deleted when the user is the last one participate in it
+func (r *Repositories) quitHabit(tx *gorm.DB, habit *dal.Habit, uid dal.UID) response.SError {
+	if habit.Owner == uid {
+		successor, sErr := r.HabitGroups.GetByHabitIDAndExcludeUID(tx, habit.ID, uid)
+		if sErr != nil {
+			return sErr
+		}
+		if successor == nil { // no successor means current use is the last one participate in this habit
+			sErr = r.deleteHabitCommonInfo(tx, habit.ID, uid)
+			if sErr != nil {
+				return sErr
+			}
+			return r.Habits.DeleteByID(tx, habit.ID)
+		}
+		sErr = r.Habits.UpdateHabit(tx, habit.ID, &dal.HabitUpdatableFields{Owner: successor.UID})
+		if sErr != nil {
+			return sErr
+		}
+	}
+	return r.deleteHabitCommonInfo(tx, habit.ID, uid)
+}
+
+// leaveErasedHabit hand the ownership of a habit over as quitHabit does when the account of the user is erased,
+// while the rows of the user are erased by eraseUser instead of leaving tombstones carrying the uid, the habit is
+// touched for the other members to pull all its members again. A habit without other members is erased with its owner
+func (r *Repositories) leaveErasedHabit(tx *gorm.DB, habit *dal.Habit, uid dal.UID) response.SError {
+	successor, sErr := r.HabitGroups.GetByHabitIDAndExcludeUID(tx, habit.ID, uid)
+	if sErr != nil {
+		return sErr
+	}
+	if successor == nil {
+		return nil
+	}
+	if habit.Owner == uid {
+		return r.Habits.UpdateHabit(tx, habit.ID, &dal.HabitUpdatableFields{Owner: successor.UID})
+	}
+	return r.Habits.Touch(tx, habit.ID)
+}
+
 // DeleteHabitByID delete a habit, only the owner can delete
 // and all the user inside its group will be removed for their habits list
-func (c *HabitCtrl) DeleteHabitByID(habitID uint64, uid dal.UID) response.SError {
-	db := service.GetDBExecutor()
-	habit, sErr := dal.HabitDBHD.GetByID(db, habitID)
+func (c *HabitCtrl) DeleteHabitByID(ctx context.Context, habitID uint64, uid dal.UID) response.SError {
+	db := c.repos.DB(ctx)
+	habit, sErr := c.repos.Habits.GetByID(db, habitID, false)
 	if sErr != nil {
 		return sErr
 	}
@@ -529,44 +673,28 @@ func (c *HabitCtrl) DeleteHabitByID(habitID uint64, uid dal.UID) response.SError
 		return response.ErrorCode_InvalidParam.New("habit not exist")
 	}
 
-	hg, sErr := dal.HabitGroupDBHD.GetByHabitIDAndUID(db, habitID, uid)
+	hgs, sErr := c.repos.HabitGroups.ListByHabitID(db, habitID)
 	if sErr != nil {
 		return sErr
 	}
-	if hg == nil {
+	members := habitGroupUIDs(hgs)
+	inGroup := false
+	for _, member := range members {
+		if member == uid {
+			inGroup = true
+			break
+		}
+	}
+	if !inGroup {
 		return response.ErrorCode_UserNoPermission.New("current user has not joined this habit")
 	}
 
-	if habit.Owner == uid {
-		var successor *dal.HabitGroup
-		successor, sErr = dal.HabitGroupDBHD.GetByHabitIDAndExcludeUID(db, habitID, uid)
-		if sErr != nil {
-			return sErr
-		}
-		if successor == nil { // no successor means current use is the last one participate in this habit
-			sErr = WithDBTx(db, func(tx *gorm.DB) response.SError {
-				sErr = deleteHabitCommonInfo(tx, habitID, uid)
-				if sErr != nil {
-					return sErr
-				}
-				return dal.HabitDBHD.DeleteByID(db, habitID)
-			})
-		} else {
-			sErr = WithDBTx(db, func(tx *gorm.DB) response.SError {
-				sErr = dal.HabitDBHD.UpdateHabit(tx, habitID, &dal.HabitUpdatableFields{Owner: uid})
-				if sErr != nil {
-					return sErr
-				}
-				return deleteHabitCommonInfo(tx, habitID, uid)
-			})
-		}
-	} else {
-		sErr = WithDBTx(db, func(tx *gorm.DB) response.SError {
-			return deleteHabitCommonInfo(tx, habitID, uid)
-		})
-	}
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		return c.repos.quitHabit(tx, habit, uid)
+	})
 	if sErr != nil {
 		return sErr
 	}
+	publishQuitEvents(ctx, habit, uid, members)
 	return nil
 }
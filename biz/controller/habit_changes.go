@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"time"
+)
+
+// TombstoneRetention how long the tombstones of the deleted habits, groups, configs and log records are kept,
+// the clients not pulling the changes for longer have to pull all the data again
+const TombstoneRetention = time.Hour * 24 * 30
+
+// Changes the habits, groups, configs and log records visible to a user changed after a cursor, the deleted ones
+// are tombstones with delete_at set. A record may show up again in the next pull, the client should upsert by key
+type Changes struct {
+	// Habits the habits the user is in, every one of a habit joined after the cursor
+	Habits []*dal.Habit `json:"habits"`
+	// HabitGroups the members of the habits the user is in, and the tombstones of the habits the user quit,
+	// the client should drop the habits it quit. Every member of a habit in Habits is listed, the client should
+	// replace the members of the habit with them, as a member whose account is erased leaves no tombstone
+	HabitGroups      []*dal.HabitGroup      `json:"habit_groups"`
+	UserHabitConfigs []*dal.UserHabitConfig `json:"user_habit_configs"`
+	LogRecords       []*LogRecordChange     `json:"log_records"`
+	// Reset the cursor is too old that the tombstones after it might be purged, the changes are all the data
+	// from the beginning, the client should replace its local data with them
+	Reset bool `json:"reset"`
+	// Cursor the cursor of the next pull
+	Cursor string `json:"cursor"`
+}
+
+// ListChanges list the changes visible to a user after a cursor, an empty cursor lists all the data
+func (c *HabitCtrl) ListChanges(ctx context.Context, uid dal.UID, cursor string) (*Changes, response.SError) {
+	after, sErr := decodeChangeCursor(cursor)
+	if sErr != nil {
+		return nil, sErr
+	}
+	pulledAt := time.Now()
+	changes := &Changes{}
+	if !after.IsZero() && after.Before(pulledAt.Add(-TombstoneRetention)) {
+		after = time.Time{}
+		changes.Reset = true
+	}
+
+	db := c.repos.DB(ctx)
+	memberships, sErr := c.repos.HabitGroups.ListByUIDUpdatedAfter(db, uid, after)
+	if sErr != nil {
+		return nil, sErr
+	}
+	joined, sErr := c.repos.HabitGroups.ListByUID(db, uid)
+	if sErr != nil {
+		return nil, sErr
+	}
+	// the habits joined after the cursor are new to the client, all of them are listed
+	var habitIDs, joinedHabitIDs []uint64
+	for _, hg := range joined {
+		if !after.IsZero() && hg.UpdateAt.After(after) {
+			joinedHabitIDs = append(joinedHabitIDs, hg.HabitID)
+		} else {
+			habitIDs = append(habitIDs, hg.HabitID)
+		}
+	}
+
+	for _, q := range []struct {
+		habitIDs []uint64
+		after    time.Time
+	}{{habitIDs, after}, {joinedHabitIDs, time.Time{}}} {
+		habits, sErr := c.repos.Habits.ListByIDsUpdatedAfter(db, q.habitIDs, q.after)
+		if sErr != nil {
+			return nil, sErr
+		}
+		changes.Habits = append(changes.Habits, habits...)
+		groups, sErr := c.repos.HabitGroups.ListByHabitIDsUpdatedAfter(db, q.habitIDs, q.after)
+		if sErr != nil {
+			return nil, sErr
+		}
+		// the members of the habits changed are all listed, the ones changed after anyway are not listed twice
+		changedIDs := make([]uint64, 0, len(habits))
+		for _, habit := range habits {
+			changedIDs = append(changedIDs, habit.ID)
+		}
+		if len(changedIDs) > 0 && !q.after.IsZero() {
+			allGroups, sErr := c.repos.HabitGroups.ListByHabitIDsUpdatedAfter(db, changedIDs, time.Time{})
+			if sErr != nil {
+				return nil, sErr
+			}
+			for _, hg := range allGroups {
+				if !hg.UpdateAt.After(q.after) {
+					groups = append(groups, hg)
+				}
+			}
+		}
+		changes.HabitGroups = append(changes.HabitGroups, groups...)
+	}
+	// the tombstones of the habits the user quit
+	for _, hg := range memberships {
+		if hg.DeleteAt.Valid {
+			changes.HabitGroups = append(changes.HabitGroups, hg)
+		}
+	}
+
+	changes.UserHabitConfigs, sErr = c.repos.UserHabitConfigs.ListByUIDUpdatedAfter(db, uid, after)
+	if sErr != nil {
+		return nil, sErr
+	}
+	changes.LogRecords, sErr = c.listLogRecordChanges(ctx, uid, after)
+	if sErr != nil {
+		return nil, sErr
+	}
+
+	next := pulledAt.Add(-changeCursorLag)
+	if next.Before(after) {
+		next = after
+	}
+	changes.Cursor = encodeChangeCursor(next)
+	return changes, nil
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"testing"
+	"time"
+)
+
+func TestListChanges(t *testing.T) {
+	ctx := context.Background()
+	repos := newFakeRepositories()
+	ctrl := NewHabitCtrl(repos)
+	habit := addFakeHabit(t, repos, dal.CheckDayAll, 0, 0, "alice", "bob")
+	otherHabit := addFakeHabit(t, repos, dal.CheckDayAll, 0, 0, "bob")
+
+	changes, sErr := ctrl.ListChanges(ctx, "alice", "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(changes.Habits) != 1 || len(changes.HabitGroups) != 2 || len(changes.UserHabitConfigs) != 1 ||
+		len(changes.LogRecords) != 0 || changes.Reset || changes.Cursor == "" {
+		t.Fatalf("first pull should list all the data of alice, got %+v", changes)
+	}
+
+	// the cursor is in milliseconds
+	time.Sleep(time.Millisecond * 2)
+	cursor := encodeChangeCursor(time.Now())
+	time.Sleep(time.Millisecond * 2)
+
+	// bob quits the habit, alice logs it alone and joins the other habit
+	sErr = ctrl.DeleteHabitByID(ctx, habit.ID, "bob")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	now := time.Now()
+	_, sErr = ctrl.LogHabit(ctx, "alice", habit.ID, &now)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	sErr = ctrl.UpdateHabit(ctx, "bob", otherHabit.ID, &HabitUpdatableInfo{CooperatorsToAdd: []dal.UID{"alice"}}, &UserHabitConfigUpdatableField{})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+
+	changes, sErr = ctrl.ListChanges(ctx, "alice", cursor)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(changes.Habits) != 1 || changes.Habits[0].ID != otherHabit.ID {
+		t.Fatalf("expect the habit joined though not changed, got %+v", changes.Habits)
+	}
+	quit := 0
+	for _, hg := range changes.HabitGroups {
+		if hg.DeleteAt.Valid {
+			quit++
+			if hg.HabitID != habit.ID || hg.UID != "bob" {
+				t.Fatalf("unexpected tombstone %+v", hg)
+			}
+		}
+	}
+	if len(changes.HabitGroups) != 3 || quit != 1 {
+		t.Fatalf("expect the tombstone of bob and the members of the joined habit, got %+v", changes.HabitGroups)
+	}
+	if len(changes.UserHabitConfigs) != 2 {
+		t.Fatalf("expect the config of the streak and the joined habit, got %+v", changes.UserHabitConfigs)
+	}
+	if len(changes.LogRecords) != 2 {
+		t.Fatalf("expect the unconfirmed and the confirmed record, got %+v", changes.LogRecords)
+	}
+
+	// bob learns the habit quit and the member joined
+	changes, sErr = ctrl.ListChanges(ctx, "bob", cursor)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(changes.Habits) != 0 || len(changes.HabitGroups) != 2 || len(changes.UserHabitConfigs) != 1 ||
+		!changes.UserHabitConfigs[0].DeleteAt.Valid {
+		t.Fatalf("unexpected changes of bob %+v", changes)
+	}
+
+	changes, sErr = ctrl.ListChanges(ctx, "alice", changes.Cursor)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if changes.Reset {
+		t.Fatal("recent cursor should not reset")
+	}
+
+	// the tombstones before a cursor too old might be purged, all the data is listed again
+	changes, sErr = ctrl.ListChanges(ctx, "alice", encodeChangeCursor(time.Now().Add(-TombstoneRetention-time.Hour)))
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if !changes.Reset || len(changes.Habits) != 2 {
+		t.Fatalf("too old cursor should reset, got %+v", changes)
+	}
+
+	_, sErr = ctrl.ListChanges(ctx, "alice", "-1")
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_InvalidParam {
+		t.Fatal("invalid cursor should be rejected", sErr)
+	}
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"context"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/realtime"
+)
+
+// publishHabitEvent push an event to the members of a habit once its change is committed, the event only hints
+// the clients to refresh, so a failure is logged rather than failing the request
+func publishHabitEvent(ctx context.Context, recipients []dal.UID, e *realtime.Event) {
+	if err := realtime.DefaultHub().Publish(ctx, recipients, e); err != nil {
+		hlog.CtxErrorf(ctx, "publish habit event fail, type: %s, habit: %d, err=%v", e.Type, e.HabitID, err)
+	}
+}
+
+// publishLogEvents push the check-in of a user to the members of the habit, and the completion of the day
+// if the check-in completed the group
+func publishLogEvents(ctx context.Context, uid dal.UID, result *habitLogResult) {
+	publishHabitEvent(ctx, result.members, &realtime.Event{
+		Type:    realtime.EventCheckIn,
+		HabitID: result.record.HabitID,
+		UID:     uid,
+		Record:  result.record,
+	})
+	if result.confirmedRecord != nil {
+		publishHabitEvent(ctx, result.members, &realtime.Event{
+			Type:    realtime.EventGroupCompleted,
+			HabitID: result.record.HabitID,
+			UID:     uid,
+			LogDay:  result.record.LogDay,
+		})
+	}
+}
+
+// publishQuitEvents push the quit of a user to the members of the habit before the quit, and the hand-over
+// of the ownership if the user owned the habit
+func publishQuitEvents(ctx context.Context, habit *dal.Habit, uid dal.UID, members []dal.UID) {
+	publishHabitEvent(ctx, members, &realtime.Event{
+		Type:    realtime.EventMemberQuit,
+		HabitID: habit.ID,
+		UID:     uid,
+		Members: []dal.UID{uid},
+	})
+	if habit.Owner == uid && len(members) > 1 {
+		publishHabitEvent(ctx, members, &realtime.Event{
+			Type:    realtime.EventHabitUpdated,
+			HabitID: habit.ID,
+			UID:     uid,
+		})
+	}
+}
+
+// publishErasedMemberEvent push the update of a habit to its other members when a member's account is erased,
+// the event carries no uid of the erased member, the clients pull the members of the habit again
+func publishErasedMemberEvent(ctx context.Context, habitID uint64, others []dal.UID) {
+	if len(others) == 0 {
+		return
+	}
+	publishHabitEvent(ctx, others, &realtime.Event{
+		Type:    realtime.EventHabitUpdated,
+		HabitID: habitID,
+	})
+}
+
+// habitGroupUIDs the uids of the members in the groups
+func habitGroupUIDs(hgs []*dal.HabitGroup) []dal.UID {
+	uids := make([]dal.UID, 0, len(hgs))
+	for _, hg := range hgs {
+		uids = append(uids, hg.UID)
+	}
+	return uids
+}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/realtime"
+	"testing"
+	"time"
+)
+
+// expectEvents check the events received by sub are of types in order, and nothing more
+func expectEvents(t *testing.T, sub *realtime.Subscriber, types ...realtime.EventType) []*realtime.Event {
+	t.Helper()
+	events := make([]*realtime.Event, 0, len(types))
+	for _, typ := range types {
+		select {
+		case e := <-sub.Events():
+			if e.Type != typ {
+				t.Fatalf("expect event %s, got %+v", typ, e)
+			}
+			events = append(events, e)
+		default:
+			t.Fatalf("expect event %s, got none", typ)
+		}
+	}
+	if len(sub.Events()) != 0 {
+		t.Fatalf("unexpected event %+v", <-sub.Events())
+	}
+	return events
+}
+
+func TestHabitEvents(t *testing.T) {
+	ctx := context.Background()
+	repos := newFakeRepositories()
+	ctrl := NewHabitCtrl(repos)
+	hub := realtime.NewHub(realtime.NewMemoryBroker())
+	defaultHub := realtime.DefaultHub()
+	realtime.InitDefaultHub(hub)
+	defer realtime.InitDefaultHub(defaultHub)
+	defer hub.Close()
+
+	habit := addFakeHabit(t, repos, dal.CheckDayAll, 0, 0, "alice", "bob")
+	alice := hub.Subscribe("alice")
+	bob := hub.Subscribe("bob")
+	carol := hub.Subscribe("carol")
+
+	now := time.Now()
+	_, sErr := ctrl.LogHabit(ctx, "alice", habit.ID, &now)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	events := expectEvents(t, bob, realtime.EventCheckIn)
+	if events[0].UID != "alice" || events[0].Record == nil || events[0].Record.HabitID != habit.ID {
+		t.Fatalf("unexpected check-in event %+v", events[0])
+	}
+	expectEvents(t, alice, realtime.EventCheckIn)
+
+	_, sErr = ctrl.LogHabit(ctx, "bob", habit.ID, &now)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	events = expectEvents(t, alice, realtime.EventCheckIn, realtime.EventGroupCompleted)
+	if events[1].LogDay != events[0].Record.LogDay {
+		t.Fatalf("unexpected completion event %+v", events[1])
+	}
+	expectEvents(t, bob, realtime.EventCheckIn, realtime.EventGroupCompleted)
+
+	// alice owns the habit, renames it and swaps bob for carol
+	sErr = ctrl.UpdateHabit(ctx, "alice", habit.ID, &HabitUpdatableInfo{
+		Name:                "read more",
+		CooperatorsToAdd:    []dal.UID{"carol"},
+		CooperatorsToDelete: []dal.UID{"bob"},
+	}, &UserHabitConfigUpdatableField{})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	expectEvents(t, alice, realtime.EventHabitUpdated, realtime.EventMemberJoined, realtime.EventMemberQuit)
+	events = expectEvents(t, carol, realtime.EventHabitUpdated, realtime.EventMemberJoined, realtime.EventMemberQuit)
+	if len(events[1].Members) != 1 || events[1].Members[0] != "carol" || len(events[2].Members) != 1 || events[2].Members[0] != "bob" {
+		t.Fatalf("unexpected membership events %+v %+v", events[1], events[2])
+	}
+	// the removed member learns it is removed
+	expectEvents(t, bob, realtime.EventMemberQuit)
+
+	// the owner quits and hands the habit over to carol
+	sErr = ctrl.DeleteHabitByID(ctx, habit.ID, "alice")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	expectEvents(t, carol, realtime.EventMemberQuit, realtime.EventHabitUpdated)
+	expectEvents(t, alice, realtime.EventMemberQuit, realtime.EventHabitUpdated)
+	expectEvents(t, bob)
+
+	// a failed request pushes nothing
+	_, sErr = ctrl.LogHabit(ctx, "alice", habit.ID, &now)
+	if sErr == nil {
+		t.Fatal("user quit should not log")
+	}
+	expectEvents(t, carol)
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MaxCheckInClientIDLength the max length of the client id of a check-in, long enough for a uuid or a ulid
+const MaxCheckInClientIDLength = 64
+
+// syncClockSkew how far the clock of a client may run ahead of the server
+const syncClockSkew = time.Minute * 5
+
+// syncMaxDaysBack how many days before today a check-in made offline can be synced
+const syncMaxDaysBack = 1
+
+// changeCursorLag how far the next cursor is behind the time the changes are read, so that the changes of
+// the transactions committed late are pulled by the next sync, clients dedupe the changes by record id
+const changeCursorLag = time.Minute
+
+// syncInitialWindow how far back the first sync of a client, with an empty cursor, pulls the log records,
+// a client wanting the older ones pulls them from the change feed
+const syncInitialWindow = TombstoneRetention
+
+// CheckIn a check-in made by a client, maybe offline
+type CheckIn struct {
+	ClientID string    `json:"client_id"` // generated by the client, unique among the check-ins of the user
+	HabitID  uint64    `json:"habit_id"`
+	LogAt    time.Time `json:"log_at"` // the local time of the client, with its zone offset
+}
+
+type CheckInStatus string
+
+const (
+	CheckInStatusApplied   CheckInStatus = "applied"   // logged
+	CheckInStatusDuplicate CheckInStatus = "duplicate" // the check-in was synced before, nothing is logged
+	CheckInStatusConflict  CheckInStatus = "conflict"  // the day was logged already by another check-in
+	CheckInStatusRejected  CheckInStatus = "rejected"  // invalid check-in, see the message
+)
+
+// CheckInResult the result of syncing a check-in
+type CheckInResult struct {
+	ClientID string        `json:"client_id"`
+	Status   CheckInStatus `json:"status"`
+	Message  string        `json:"message,omitempty"`
+	// Record the record logged if applied, or the existing record if duplicate or conflict
+	Record *dal.HabitLogRecord `json:"record,omitempty"`
+	// Confirmed whether the check-in completed the group and confirmed the logs of the day
+	Confirmed bool `json:"confirmed"`
+}
+
+// LogRecordChange a log record of the user changed on the server
+type LogRecordChange struct {
+	*dal.HabitLogRecord
+	Confirmed bool `json:"confirmed"`
+}
+
+// encodeChangeCursor encode the time the changes are pulled after as an opaque cursor
+func encodeChangeCursor(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// decodeChangeCursor decode a cursor from encodeChangeCursor, an empty cursor means from the beginning
+func decodeChangeCursor(cursor string) (time.Time, response.SError) {
+	if cursor == "" {
+		return time.Time{}, nil
+	}
+	ms, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || ms < 0 {
+		return time.Time{}, response.ErrorCode_InvalidParam.New("invalid cursor")
+	}
+	return time.UnixMilli(ms).UTC(), nil
+}
+
+// SyncCheckIns apply the check-ins of a user in the order of their log time, then the client id, so that
+// the same batch always resolves the same way: the first check-in of a habit on a day is applied, the later
+// ones conflict with it. A check-in synced before is reported as duplicate, so a batch is safe to retry.
+// Return the results in the order of checkIns, the log records of the user changed after cursor, or within
+// syncInitialWindow if cursor is empty, and the cursor of the next sync
+func (c *HabitCtrl) SyncCheckIns(ctx context.Context, uid dal.UID, checkIns []*CheckIn, cursor string) ([]*CheckInResult, []*LogRecordChange, string, response.SError) {
+	after, sErr := decodeChangeCursor(cursor)
+	if sErr != nil {
+		return nil, nil, "", sErr
+	}
+	if after.IsZero() {
+		after = time.Now().Add(-syncInitialWindow).UTC()
+	}
+
+	ordered := make([]int, len(checkIns))
+	for i := range ordered {
+		ordered[i] = i
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := checkIns[ordered[i]], checkIns[ordered[j]]
+		if !a.LogAt.Equal(b.LogAt) {
+			return a.LogAt.Before(b.LogAt)
+		}
+		return a.ClientID < b.ClientID
+	})
+
+	results := make([]*CheckInResult, len(checkIns))
+	for _, i := range ordered {
+		result, sErr := c.syncCheckIn(ctx, uid, checkIns[i])
+		if sErr != nil {
+			return nil, nil, "", sErr
+		}
+		results[i] = result
+	}
+
+	pulledAt := time.Now()
+	changes, sErr := c.listLogRecordChanges(ctx, uid, after)
+	if sErr != nil {
+		return nil, nil, "", sErr
+	}
+	next := pulledAt.Add(-changeCursorLag)
+	if next.Before(after) {
+		next = after
+	}
+	return results, changes, encodeChangeCursor(next), nil
+}
+
+// syncCheckIn apply a check-in in its own transaction, return a rejected result for an invalid check-in
+func (c *HabitCtrl) syncCheckIn(ctx context.Context, uid dal.UID, checkIn *CheckIn) (*CheckInResult, response.SError) {
+	result := &CheckInResult{ClientID: checkIn.ClientID}
+	reject := func(msg string) (*CheckInResult, response.SError) {
+		result.Status = CheckInStatusRejected
+		result.Message = msg
+		return result, nil
+	}
+
+	if checkIn.ClientID == "" || len(checkIn.ClientID) > MaxCheckInClientIDLength {
+		return reject("invalid client id")
+	}
+	if checkIn.LogAt.IsZero() {
+		return reject("invalid log time")
+	}
+
+	existing, sErr := c.repos.UnconfirmedHabitLogRecords.GetByUIDAndClientID(c.repos.DB(ctx), uid, checkIn.ClientID)
+	if sErr != nil {
+		return nil, sErr
+	}
+	if existing != nil {
+		result.Status = CheckInStatusDuplicate
+		result.Record = existing
+		return result, nil
+	}
+
+	now := time.Now().In(checkIn.LogAt.Location())
+	if checkIn.LogAt.After(now.Add(syncClockSkew)) {
+		return reject("log time in the future")
+	}
+	todayBegin, _ := getTodayBeginEndTime(&now)
+	dayBegin, _ := getTodayBeginEndTime(&checkIn.LogAt)
+	if dayBegin.After(todayBegin) {
+		return reject("log time in the future")
+	}
+	if dayBegin.Before(todayBegin.AddDate(0, 0, -syncMaxDaysBack)) {
+		return reject("log time too old to sync")
+	}
+
+	var logResult *habitLogResult
+	clientID := checkIn.ClientID
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		var sErr response.SError
+		logResult, sErr = c.logHabitInTx(tx, uid, checkIn.HabitID, checkIn.LogAt, &clientID)
+		return sErr
+	})
+	if sErr != nil {
+		switch sErr.ErrorCode() {
+		case response.ErrorCode_InvalidParam, response.ErrorCode_UserNoPermission:
+			return reject(sErr.Message())
+		}
+		return nil, sErr
+	}
+
+	if logResult.alreadyLogged {
+		record := logResult.record
+		if record != nil && record.ClientID != nil && *record.ClientID == checkIn.ClientID {
+			// a concurrent sync of the same check-in logged the day first
+			result.Status = CheckInStatusDuplicate
+			result.Record = record
+			return result, nil
+		}
+		if record == nil {
+			// lost the race to a concurrent log of the day, or a concurrent sync of the same check-in
+			record, sErr = c.repos.UnconfirmedHabitLogRecords.GetByUIDAndClientID(c.repos.DB(ctx), uid, checkIn.ClientID)
+			if sErr != nil {
+				return nil, sErr
+			}
+			if record != nil {
+				result.Status = CheckInStatusDuplicate
+				result.Record = record
+				return result, nil
+			}
+		}
+		result.Status = CheckInStatusConflict
+		result.Record = record
+		return result, nil
+	}
+
+	publishLogEvents(ctx, uid, logResult)
+	result.Status = CheckInStatusApplied
+	result.Record = logResult.record
+	result.Confirmed = logResult.confirmedRecord != nil
+	return result, nil
+}
+
+// listLogRecordChanges list the confirmed and unconfirmed log records of a user updated after a time
+func (c *HabitCtrl) listLogRecordChanges(ctx context.Context, uid dal.UID, after time.Time) ([]*LogRecordChange, response.SError) {
+	db := c.repos.DB(ctx)
+	unconfirmed, sErr := c.repos.UnconfirmedHabitLogRecords.ListByUIDUpdatedAfter(db, uid, after)
+	if sErr != nil {
+		return nil, sErr
+	}
+	confirmed, sErr := c.repos.HabitLogRecords.ListByUIDUpdatedAfter(db, uid, after)
+	if sErr != nil {
+		return nil, sErr
+	}
+
+	changes := make([]*LogRecordChange, 0, len(unconfirmed)+len(confirmed))
+	for _, r := range unconfirmed {
+		changes = append(changes, &LogRecordChange{HabitLogRecord: r})
+	}
+	for _, r := range confirmed {
+		changes = append(changes, &LogRecordChange{HabitLogRecord: r, Confirmed: true})
+	}
+	return changes, nil
+}
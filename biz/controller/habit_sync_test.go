@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func checkSyncStatuses(t *testing.T, results []*CheckInResult, expected ...CheckInStatus) {
+	t.Helper()
+	if len(results) != len(expected) {
+		t.Fatalf("expect %d results, got %d", len(expected), len(results))
+	}
+	for i, result := range results {
+		if result.Status != expected[i] {
+			t.Fatalf("check-in %s should be %s, got %s %s", result.ClientID, expected[i], result.Status, result.Message)
+		}
+	}
+}
+
+func TestSyncCheckIns(t *testing.T) {
+	ctx := context.Background()
+	repos := newFakeRepositories()
+	ctrl := NewHabitCtrl(repos)
+	habit := addFakeHabit(t, repos, dal.CheckDayAll, 0, 0, "alice", "bob")
+
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1)
+	checkIns := []*CheckIn{
+		{ClientID: "c1", HabitID: habit.ID, LogAt: now},
+		{ClientID: "c2", HabitID: habit.ID, LogAt: now.Add(-time.Second)},
+		{ClientID: "c3", HabitID: habit.ID, LogAt: yesterday},
+		{ClientID: "c4", HabitID: habit.ID, LogAt: now.Add(time.Hour)},
+		{ClientID: "c5", HabitID: habit.ID, LogAt: now.AddDate(0, 0, -3)},
+		{ClientID: "", HabitID: habit.ID, LogAt: now},
+		{ClientID: "c6", HabitID: habit.ID + 1, LogAt: now},
+	}
+	results, changes, cursor, sErr := ctrl.SyncCheckIns(ctx, "alice", checkIns, "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	// the earlier check-in of a day is applied whatever the order in the batch
+	checkSyncStatuses(t, results, CheckInStatusConflict, CheckInStatusApplied, CheckInStatusApplied,
+		CheckInStatusRejected, CheckInStatusRejected, CheckInStatusRejected, CheckInStatusRejected)
+	if results[0].Record == nil || results[0].Record.ID != results[1].Record.ID {
+		t.Fatalf("conflict should return the record applied, got %+v", results[0].Record)
+	}
+	if results[1].Record.ClientID == nil || *results[1].Record.ClientID != "c2" || results[1].Confirmed {
+		t.Fatalf("unexpected applied record %+v", results[1].Record)
+	}
+	if len(changes) != 2 || cursor == "" {
+		t.Fatalf("expect the 2 records applied as changes, got %d", len(changes))
+	}
+
+	// retry of the same batch changes nothing
+	results, _, _, sErr = ctrl.SyncCheckIns(ctx, "alice", checkIns, cursor)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	checkSyncStatuses(t, results, CheckInStatusConflict, CheckInStatusDuplicate, CheckInStatusDuplicate,
+		CheckInStatusRejected, CheckInStatusRejected, CheckInStatusRejected, CheckInStatusRejected)
+	unconfirmed, sErr := repos.UnconfirmedHabitLogRecords.ListByHabitID(nil, habit.ID, nil, nil)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(unconfirmed) != 2 {
+		t.Fatalf("expect 2 unconfirmed records, got %d", len(unconfirmed))
+	}
+
+	// the check-ins of bob complete the group for both days
+	results, _, _, sErr = ctrl.SyncCheckIns(ctx, "bob", []*CheckIn{
+		{ClientID: "c1", HabitID: habit.ID, LogAt: yesterday},
+		{ClientID: "c2", HabitID: habit.ID, LogAt: now},
+	}, "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	checkSyncStatuses(t, results, CheckInStatusApplied, CheckInStatusApplied)
+	if !results[0].Confirmed || !results[1].Confirmed {
+		t.Fatal("check-ins completing the group should be confirmed")
+	}
+	checkStreak(t, repos, "alice", habit.ID, 2, 2)
+
+	// alice pulls the confirmed records
+	_, changes, _, sErr = ctrl.SyncCheckIns(ctx, "alice", nil, encodeChangeCursor(now.Add(-time.Hour)))
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	confirmed := 0
+	for _, change := range changes {
+		if change.UID != "alice" {
+			t.Fatalf("should not pull the records of others, got %+v", change.HabitLogRecord)
+		}
+		if change.Confirmed {
+			confirmed++
+		}
+	}
+	if len(changes) != 4 || confirmed != 2 {
+		t.Fatalf("expect 2 unconfirmed and 2 confirmed changes, got %d and %d", len(changes)-confirmed, confirmed)
+	}
+	_, changes, _, sErr = ctrl.SyncCheckIns(ctx, "alice", nil, encodeChangeCursor(time.Now().Add(time.Hour)))
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("expect no change after the cursor, got %d", len(changes))
+	}
+
+	_, _, _, sErr = ctrl.SyncCheckIns(ctx, "alice", nil, "not a cursor")
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_InvalidParam {
+		t.Fatal("invalid cursor should be rejected", sErr)
+	}
+}
+
+func TestSyncCheckInsNoNeedToLog(t *testing.T) {
+	ctx := context.Background()
+	repos := newFakeRepositories()
+	ctrl := NewHabitCtrl(repos)
+	now := time.Now()
+	today := dal.CheckDay(1 << now.Add(-time.Hour*time.Duration(dal.HabitLogDelayHours)).Weekday())
+	habit := addFakeHabit(t, repos, dal.CheckDayAll&^today, 0, 0, "alice")
+
+	results, _, _, sErr := ctrl.SyncCheckIns(ctx, "alice", []*CheckIn{{ClientID: "c1", HabitID: habit.ID, LogAt: now}}, "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	checkSyncStatuses(t, results, CheckInStatusRejected)
+	results, _, _, sErr = ctrl.SyncCheckIns(ctx, "stranger", []*CheckIn{{ClientID: "c1", HabitID: habit.ID, LogAt: now}}, "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	checkSyncStatuses(t, results, CheckInStatusRejected)
+}
+
+func TestSyncCheckInsInitialWindow(t *testing.T) {
+	ctx := context.Background()
+	repos := newFakeRepositories()
+	ctrl := NewHabitCtrl(repos)
+	now := time.Now().UTC()
+	for i, updateAt := range []time.Time{now.Add(-syncInitialWindow - time.Hour), now.Add(-syncInitialWindow + time.Hour)} {
+		sErr := repos.HabitLogRecords.Add(nil, &dal.HabitLogRecord{HabitID: uint64(i + 1), UID: "alice", LogAt: updateAt,
+			LogDay: updateAt.Format(dal.LogDayLayout), UpdateAt: updateAt})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+	}
+
+	// the first sync pulls the recent records only
+	_, changes, _, sErr := ctrl.SyncCheckIns(ctx, "alice", nil, "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(changes) != 1 || changes[0].HabitID != 2 {
+		t.Fatalf("expect the record within the initial window only, got %d changes", len(changes))
+	}
+}
+
+// raceClientIDRepository make the first lookups of the check-ins by client id miss,
+// as if a concurrent sync of the same check-in committed right after them
+type raceClientIDRepository struct {
+	dal.UnconfirmedHabitLogRecordRepository
+	misses int
+}
+
+func (r *raceClientIDRepository) GetByUIDAndClientID(db *gorm.DB, uid dal.UID, clientID string) (*dal.HabitLogRecord, response.SError) {
+	if r.misses > 0 {
+		r.misses--
+		return nil, nil
+	}
+	return r.UnconfirmedHabitLogRecordRepository.GetByUIDAndClientID(db, uid, clientID)
+}
+
+func TestSyncCheckInsReplay(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	owner := addTestUser(t, db, "alice", "alice@test.com", "password")
+	addTestUser(t, db, "bob", "bob@test.com", "password")
+	repos := NewRepositories()
+	unconfirmed := &raceClientIDRepository{UnconfirmedHabitLogRecordRepository: repos.UnconfirmedHabitLogRecords}
+	repos.UnconfirmedHabitLogRecords = unconfirmed
+	ctrl := NewHabitCtrl(repos)
+	detailedHabit, sErr := ctrl.AddHabit(ctx, &dal.Habit{Name: "read", LogDays: dal.CheckDayAll}, owner.UID, []dal.UID{"bob"}, &HabitCustomConfig{})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	habitID := detailedHabit.Habit.ID
+
+	// the same client id replayed on another day misses the lookup and hits the unique key inside the transaction
+	now := time.Now()
+	checkIns := []*CheckIn{
+		{ClientID: "c1", HabitID: habitID, LogAt: now.AddDate(0, 0, -1)},
+		{ClientID: "c1", HabitID: habitID, LogAt: now},
+	}
+	unconfirmed.misses = 2
+	results, _, _, sErr := ctrl.SyncCheckIns(ctx, "alice", checkIns, "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	checkSyncStatuses(t, results, CheckInStatusApplied, CheckInStatusDuplicate)
+	if results[1].Record == nil || results[1].Record.ID != results[0].Record.ID {
+		t.Fatalf("duplicate should return the record applied, got %+v", results[1].Record)
+	}
+
+	// replayed on the same day, the record of the day is the one of the check-in
+	unconfirmed.misses = 1
+	results, _, _, sErr = ctrl.SyncCheckIns(ctx, "alice", checkIns[:1], "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	checkSyncStatuses(t, results, CheckInStatusDuplicate)
+
+	results, changes, _, sErr := ctrl.SyncCheckIns(ctx, "alice", checkIns, "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	checkSyncStatuses(t, results, CheckInStatusDuplicate, CheckInStatusDuplicate)
+	if len(changes) != 1 {
+		t.Fatalf("expect 1 record logged, got %d", len(changes))
+	}
+}
@@ -0,0 +1,514 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"github.com/swordandtea/lets-habit-server/util"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHabitLifecycle(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	owner := addTestUser(t, db, "owner", "owner@test.com", "password")
+	cooperator := addTestUser(t, db, "coop", "coop@test.com", "password")
+	addTestUser(t, db, "stranger", "stranger@test.com", "password")
+	ctrl := NewHabitCtrl(NewRepositories())
+
+	// add
+	_, sErr := ctrl.AddHabit(ctx, &dal.Habit{Name: "read", LogDays: dal.CheckDayAll}, owner.UID, []dal.UID{"nobody"}, &HabitCustomConfig{})
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_InvalidParam {
+		t.Fatal("should not add habit with non-exist cooperator", sErr)
+	}
+	detailedHabit, sErr := ctrl.AddHabit(ctx, &dal.Habit{Name: "read", LogDays: dal.CheckDayAll}, owner.UID,
+		[]dal.UID{cooperator.UID}, &HabitCustomConfig{HeatmapColor: "#00ff00"})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	habitID := detailedHabit.Habit.ID
+	if habitID == 0 || detailedHabit.Habit.Owner != owner.UID || len(detailedHabit.Cooperators) != 1 {
+		t.Fatalf("unexpected added habit %+v", detailedHabit)
+	}
+
+	// get
+	detailedHabit, sErr = ctrl.GetHabitByID(ctx, habitID, cooperator.UID)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(detailedHabit.Cooperators) != 2 || detailedHabit.UserHabitConfig == nil {
+		t.Fatalf("unexpected habit %+v", detailedHabit)
+	}
+	_, sErr = ctrl.GetHabitByID(ctx, habitID, "stranger")
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_UserNoPermission {
+		t.Fatal("stranger should not get the habit", sErr)
+	}
+
+	// update, only the owner can update the basic info, every member can update its own config
+	sErr = ctrl.UpdateHabit(ctx, cooperator.UID, habitID, &HabitUpdatableInfo{Name: "write"}, &UserHabitConfigUpdatableField{})
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_UserNoPermission {
+		t.Fatal("cooperator should not update the basic info", sErr)
+	}
+	sErr = ctrl.UpdateHabit(ctx, owner.UID, habitID, &HabitUpdatableInfo{Name: "write"}, &UserHabitConfigUpdatableField{})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	sErr = ctrl.UpdateHabit(ctx, cooperator.UID, habitID, &HabitUpdatableInfo{}, &UserHabitConfigUpdatableField{HeatmapColor: "#0000ff"})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	detailedHabit, sErr = ctrl.GetHabitByID(ctx, habitID, cooperator.UID)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if detailedHabit.Habit.Name != "write" || detailedHabit.UserHabitConfig.HeatmapColor != "#0000ff" {
+		t.Fatalf("habit not updated %+v %+v", detailedHabit.Habit, detailedHabit.UserHabitConfig)
+	}
+
+	// log, the records are unconfirmed until every member logged
+	now := time.Now()
+	record, sErr := ctrl.LogHabit(ctx, owner.UID, habitID, &now)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if record != nil {
+		t.Fatal("record should not be confirmed until every member logged")
+	}
+	_, sErr = ctrl.LogHabit(ctx, owner.UID, habitID, &now)
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_InvalidParam {
+		t.Fatal("should not log twice a day", sErr)
+	}
+	_, sErr = ctrl.LogHabit(ctx, "stranger", habitID, &now)
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_UserNoPermission {
+		t.Fatal("stranger should not log the habit", sErr)
+	}
+	fromTime := now.AddDate(0, 0, -7)
+	habits, total, sErr := ctrl.ListHabitsByUID(ctx, owner.UID, &dal.Pagination{Page: 1, PageSize: 10}, &fromTime, &now)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if total != 1 || len(habits) != 1 || !habits[0].TodayLogged || len(habits[0].LogRecords) != 0 {
+		t.Fatalf("unexpected habits %+v", habits)
+	}
+
+	record, sErr = ctrl.LogHabit(ctx, cooperator.UID, habitID, &now)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if record == nil || record.ID == 0 {
+		t.Fatal("record should be confirmed after every member logged")
+	}
+	toTime := time.Now()
+	for _, uid := range []dal.UID{owner.UID, cooperator.UID} {
+		habits, _, sErr = ctrl.ListHabitsByUID(ctx, uid, &dal.Pagination{Page: 1, PageSize: 10}, &fromTime, &toTime)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(habits) != 1 || !habits[0].TodayLogged || len(habits[0].LogRecords) != 1 {
+			t.Fatalf("unexpected habits of %s %+v", uid, habits)
+		}
+		config := habits[0].UserHabitConfig
+		if config.CurrentStreak != 1 || config.LongestStreak != 1 {
+			t.Fatalf("unexpected streak of %s, current %d, longest %d", uid, config.CurrentStreak, config.LongestStreak)
+		}
+	}
+
+	// the longest streak only grows with the current one
+	sErr = dal.UserHabitConfigDBHD.Update(db, cooperator.UID, habitID, &dal.UserHabitConfigUpdatableFields{
+		LongestStreak: util.LiteralValuePtr(uint32(10)),
+	})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	sErr = dal.UserHabitConfigDBHD.IncreaseCurrentStreakByOne(db, []dal.UID{owner.UID, cooperator.UID}, habitID)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	configs, sErr := dal.UserHabitConfigDBHD.ListUserHabitConfig(db, owner.UID, []uint64{habitID})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if configs[0].CurrentStreak != 2 || configs[0].LongestStreak != 2 {
+		t.Fatalf("unexpected streak of owner, current %d, longest %d", configs[0].CurrentStreak, configs[0].LongestStreak)
+	}
+	configs, sErr = dal.UserHabitConfigDBHD.ListUserHabitConfig(db, cooperator.UID, []uint64{habitID})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if configs[0].CurrentStreak != 2 || configs[0].LongestStreak != 10 {
+		t.Fatalf("unexpected streak of cooperator, current %d, longest %d", configs[0].CurrentStreak, configs[0].LongestStreak)
+	}
+
+	// delete, the ownership is handed over until the last member quits
+	sErr = ctrl.DeleteHabitByID(ctx, habitID, "stranger")
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_UserNoPermission {
+		t.Fatal("stranger should not delete the habit", sErr)
+	}
+	sErr = ctrl.DeleteHabitByID(ctx, habitID, owner.UID)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	habits, total, sErr = ctrl.ListHabitsByUID(ctx, owner.UID, &dal.Pagination{Page: 1, PageSize: 10}, &fromTime, &toTime)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if total != 0 || len(habits) != 0 {
+		t.Fatalf("owner should have quit the habit, got %+v", habits)
+	}
+	detailedHabit, sErr = ctrl.GetHabitByID(ctx, habitID, cooperator.UID)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if detailedHabit.Habit.Owner != cooperator.UID {
+		t.Fatal("ownership not handed over")
+	}
+	sErr = ctrl.DeleteHabitByID(ctx, habitID, cooperator.UID)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	habit, sErr := dal.HabitDBHD.GetByID(db, habitID, false)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if habit != nil {
+		t.Fatal("habit should be deleted after the last member quit")
+	}
+}
+
+// addFakeHabit add a habit logged on logDays joined by members into the fake repos, the first member owns it,
+// the streaks of every member are set to currentStreak and longestStreak
+func addFakeHabit(t *testing.T, repos *Repositories, logDays dal.CheckDay, currentStreak uint32, longestStreak uint32, members ...dal.UID) *dal.Habit {
+	habit := &dal.Habit{Name: "read", LogDays: logDays, Owner: members[0], CreateAt: time.Now().UTC()}
+	sErr := repos.Habits.Add(nil, habit)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	for _, uid := range members {
+		sErr = repos.HabitGroups.Add(nil, &dal.HabitGroup{HabitID: habit.ID, UID: uid})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		sErr = repos.UserHabitConfigs.Add(nil, &dal.UserHabitConfig{
+			UID:           uid,
+			HabitID:       habit.ID,
+			CurrentStreak: currentStreak,
+			LongestStreak: longestStreak,
+		})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+	}
+	return habit
+}
+
+// checkStreak check the current and longest streak of a user on a habit in the fake repos
+func checkStreak(t *testing.T, repos *Repositories, uid dal.UID, habitID uint64, current uint32, longest uint32) {
+	config, sErr := repos.UserHabitConfigs.GetByUIDAndHabitID(nil, uid, habitID)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if config.CurrentStreak != current || config.LongestStreak != longest {
+		t.Fatalf("unexpected streak of %s, current %d, longest %d, expect %d and %d",
+			uid, config.CurrentStreak, config.LongestStreak, current, longest)
+	}
+	if config.StreakUpdateAt == nil {
+		t.Fatalf("streak update time of %s not set", uid)
+	}
+}
+
+func TestLogHabitSingleMember(t *testing.T) {
+	ctx := context.Background()
+	repos := newFakeRepositories()
+	ctrl := NewHabitCtrl(repos)
+	habit := addFakeHabit(t, repos, dal.CheckDayAll, 0, 0, "alice")
+
+	now := time.Now()
+	record, sErr := ctrl.LogHabit(ctx, "alice", habit.ID, &now)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if record == nil || record.ID == 0 || record.UID != "alice" || record.HabitID != habit.ID {
+		t.Fatalf("record should be confirmed at once when the only member logged, got %+v", record)
+	}
+	records, sErr := repos.HabitLogRecords.ListByUID(nil, "alice", nil, nil)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expect 1 confirmed record, got %d", len(records))
+	}
+	checkStreak(t, repos, "alice", habit.ID, 1, 1)
+
+	_, sErr = ctrl.LogHabit(ctx, "alice", habit.ID, &now)
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_InvalidParam {
+		t.Fatal("should not log twice a day", sErr)
+	}
+	checkStreak(t, repos, "alice", habit.ID, 1, 1)
+}
+
+func TestLogHabitGroupConfirmation(t *testing.T) {
+	ctx := context.Background()
+	repos := newFakeRepositories()
+	ctrl := NewHabitCtrl(repos)
+	members := []dal.UID{"alice", "bob", "carol"}
+	habit := addFakeHabit(t, repos, dal.CheckDayAll, 0, 0, members...)
+
+	now := time.Now()
+	_, sErr := ctrl.LogHabit(ctx, "stranger", habit.ID, &now)
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_UserNoPermission {
+		t.Fatal("stranger should not log the habit", sErr)
+	}
+	_, sErr = ctrl.LogHabit(ctx, "alice", habit.ID+1, &now)
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_InvalidParam {
+		t.Fatal("should not log a non-exist habit", sErr)
+	}
+
+	// the records stay unconfirmed until the last member logged
+	for _, uid := range members[:2] {
+		record, sErr := ctrl.LogHabit(ctx, uid, habit.ID, &now)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if record != nil {
+			t.Fatalf("record of %s should not be confirmed until every member logged", uid)
+		}
+		confirmed, sErr := repos.HabitLogRecords.ListByUIDHabitIDs(nil, uid, []uint64{habit.ID}, nil, nil)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(confirmed) != 0 {
+			t.Fatalf("%s should have no confirmed record", uid)
+		}
+	}
+	_, sErr = ctrl.LogHabit(ctx, "bob", habit.ID, &now)
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_InvalidParam {
+		t.Fatal("should not log twice a day", sErr)
+	}
+	unconfirmed, sErr := repos.UnconfirmedHabitLogRecords.ListByHabitID(nil, habit.ID, nil, nil)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(unconfirmed) != 2 {
+		t.Fatalf("expect 2 unconfirmed records, got %d", len(unconfirmed))
+	}
+
+	record, sErr := ctrl.LogHabit(ctx, "carol", habit.ID, &now)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if record == nil || record.UID != "carol" {
+		t.Fatalf("record should be confirmed after every member logged, got %+v", record)
+	}
+	for _, uid := range members {
+		records, sErr := repos.HabitLogRecords.ListByUIDHabitIDs(nil, uid, []uint64{habit.ID}, nil, nil)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(records) != 1 {
+			t.Fatalf("expect 1 confirmed record of %s, got %d", uid, len(records))
+		}
+		checkStreak(t, repos, uid, habit.ID, 1, 1)
+	}
+}
+
+func TestLogHabitStreak(t *testing.T) {
+	ctx := context.Background()
+	repos := newFakeRepositories()
+	ctrl := NewHabitCtrl(repos)
+	habit := addFakeHabit(t, repos, dal.CheckDayAll, 4, 4, "alice", "bob")
+	// bob broke a longer streak before
+	sErr := repos.UserHabitConfigs.Update(nil, "bob", habit.ID, &dal.UserHabitConfigUpdatableFields{
+		CurrentStreak: util.LiteralValuePtr(uint32(2)),
+		LongestStreak: util.LiteralValuePtr(uint32(10)),
+	})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+
+	now := time.Now()
+	_, sErr = ctrl.LogHabit(ctx, "alice", habit.ID, &now)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	config, sErr := repos.UserHabitConfigs.GetByUIDAndHabitID(nil, "alice", habit.ID)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if config.CurrentStreak != 4 {
+		t.Fatalf("streak should not grow until confirmed, got %d", config.CurrentStreak)
+	}
+
+	_, sErr = ctrl.LogHabit(ctx, "bob", habit.ID, &now)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	checkStreak(t, repos, "alice", habit.ID, 5, 5)
+	checkStreak(t, repos, "bob", habit.ID, 3, 10)
+}
+
+func TestLogHabitDayNoNeedToLog(t *testing.T) {
+	ctx := context.Background()
+	repos := newFakeRepositories()
+	ctrl := NewHabitCtrl(repos)
+	now := time.Now()
+	today := dal.CheckDay(1 << now.Add(-time.Hour*time.Duration(dal.HabitLogDelayHours)).Weekday())
+	habit := addFakeHabit(t, repos, dal.CheckDayAll&^today, 0, 0, "alice")
+
+	_, sErr := ctrl.LogHabit(ctx, "alice", habit.ID, &now)
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_InvalidParam {
+		t.Fatal("should not log on a day no need to log", sErr)
+	}
+	unconfirmed, sErr := repos.UnconfirmedHabitLogRecords.ListByHabitID(nil, habit.ID, nil, nil)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(unconfirmed) != 0 {
+		t.Fatal("no record should be added")
+	}
+}
+
+func TestLogHabitMemberJoinedAfterConfirmed(t *testing.T) {
+	ctx := context.Background()
+	repos := newFakeRepositories()
+	ctrl := NewHabitCtrl(repos)
+	habit := addFakeHabit(t, repos, dal.CheckDayAll, 0, 0, "alice")
+
+	now := time.Now()
+	_, sErr := ctrl.LogHabit(ctx, "alice", habit.ID, &now)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	sErr = repos.HabitGroups.Add(nil, &dal.HabitGroup{HabitID: habit.ID, UID: "bob"})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	sErr = repos.UserHabitConfigs.Add(nil, &dal.UserHabitConfig{UID: "bob", HabitID: habit.ID})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+
+	// the log of alice confirmed before bob joined is not confirmed again
+	record, sErr := ctrl.LogHabit(ctx, "bob", habit.ID, &now)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if record == nil || record.UID != "bob" {
+		t.Fatalf("record of bob should be confirmed, got %+v", record)
+	}
+	records, sErr := repos.HabitLogRecords.ListByUIDHabitIDs(nil, "alice", []uint64{habit.ID}, nil, nil)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expect 1 confirmed record of alice, got %d", len(records))
+	}
+	checkStreak(t, repos, "alice", habit.ID, 1, 1)
+	checkStreak(t, repos, "bob", habit.ID, 1, 1)
+}
+
+func TestCanceledContext(t *testing.T) {
+	db := setupTestDB(t)
+	owner := addTestUser(t, db, "owner", "owner@test.com", "password")
+	ctrl := NewHabitCtrl(NewRepositories())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, sErr := ctrl.AddHabit(ctx, &dal.Habit{Name: "read", LogDays: dal.CheckDayAll}, owner.UID, nil, &HabitCustomConfig{})
+	if sErr == nil || !errors.Is(sErr.Cause(), context.Canceled) {
+		t.Fatal("queries should be canceled with the request", sErr)
+	}
+	now := time.Now()
+	fromTime := now.AddDate(0, 0, -7)
+	_, total, sErr := ctrl.ListHabitsByUID(context.Background(), owner.UID, &dal.Pagination{Page: 1, PageSize: 10}, &fromTime, &now)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if total != 0 {
+		t.Fatal("no habit should be added by a canceled request")
+	}
+}
+
+// TestLogHabitConcurrently every member of a group logs the habit several times at the same time,
+// only one log of each member should succeed and the group should be confirmed exactly once
+func TestLogHabitConcurrently(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	const memberCount, logTimes = 5, 3
+	var members []dal.UID
+	for i := 0; i < memberCount; i++ {
+		uid := dal.UID(fmt.Sprintf("member%d", i))
+		addTestUser(t, db, uid, string(uid)+"@test.com", "password")
+		members = append(members, uid)
+	}
+	ctrl := NewHabitCtrl(NewRepositories())
+	detailedHabit, sErr := ctrl.AddHabit(ctx, &dal.Habit{Name: "read", LogDays: dal.CheckDayAll}, members[0], members[1:], &HabitCustomConfig{})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	habitID := detailedHabit.Habit.ID
+
+	type result struct {
+		uid    dal.UID
+		record *dal.HabitLogRecord
+		sErr   response.SError
+	}
+	results := make(chan result, memberCount*logTimes)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, uid := range members {
+		for i := 0; i < logTimes; i++ {
+			wg.Add(1)
+			go func(uid dal.UID) {
+				defer wg.Done()
+				<-start
+				now := time.Now()
+				record, sErr := ctrl.LogHabit(ctx, uid, habitID, &now)
+				results <- result{uid: uid, record: record, sErr: sErr}
+			}(uid)
+		}
+	}
+	close(start)
+	wg.Wait()
+	close(results)
+
+	succeeded := make(map[dal.UID]int)
+	confirmed := 0
+	for r := range results {
+		if r.sErr != nil {
+			if r.sErr.ErrorCode() != response.ErrorCode_InvalidParam {
+				t.Fatalf("unexpected error of %s: %v", r.uid, r.sErr)
+			}
+			continue
+		}
+		succeeded[r.uid]++
+		if r.record != nil {
+			confirmed++
+		}
+	}
+	if confirmed != 1 {
+		t.Fatalf("the group should be confirmed exactly once, got %d", confirmed)
+	}
+	for _, uid := range members {
+		if succeeded[uid] != 1 {
+			t.Fatalf("expect 1 successful log of %s, got %d", uid, succeeded[uid])
+		}
+		records, sErr := dal.HabitLogRecordDBHD.ListByUIDHabitIDs(db, uid, []uint64{habitID}, nil, nil)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(records) != 1 {
+			t.Fatalf("expect 1 confirmed record of %s, got %d", uid, len(records))
+		}
+		config, sErr := dal.UserHabitConfigDBHD.GetByUIDAndHabitID(db, uid, habitID)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if config.CurrentStreak != 1 || config.LongestStreak != 1 {
+			t.Fatalf("unexpected streak of %s, current %d, longest %d", uid, config.CurrentStreak, config.LongestStreak)
+		}
+	}
+}
@@ -1,22 +1,63 @@
 package controller
 
 import (
+	"context"
 	"github.com/swordandtea/lets-habit-server/biz/response"
 	"github.com/swordandtea/lets-habit-server/biz/service"
 	"gorm.io/gorm"
+	"time"
 )
 
-func WithDBTx(dbConn *gorm.DB, dbop func(tx *gorm.DB) response.SError) response.SError {
+// maxTxAttempts how many times a transaction is run at most when it is aborted by a deadlock or a lock wait timeout
+const maxTxAttempts = 5
+
+// txRetryBackoff the wait before running an aborted transaction again, multiplied by the number of the attempts
+const txRetryBackoff = 10 * time.Millisecond
+
+// WithDBTx run dbop in a transaction bound to ctx, so that the transaction is aborted when ctx is canceled,
+// the global db executor is used if dbConn is nil. The transaction is rolled back if dbop returns an error or panics,
+// and is run again if aborted by a deadlock or a lock wait timeout, so dbop should not keep the state of a former run
+func WithDBTx(ctx context.Context, dbConn *gorm.DB, dbop func(tx *gorm.DB) response.SError) response.SError {
 	if dbConn == nil {
 		dbConn = service.GetDBExecutor()
 	}
-	tx := dbConn.Begin()
+	var sErr response.SError
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		sErr = runDBTx(ctx, dbConn, dbop)
+		if sErr == nil || !service.IsRetryableTxError(sErr.Cause()) {
+			return sErr
+		}
+		select {
+		case <-ctx.Done():
+			return sErr
+		case <-time.After(txRetryBackoff * time.Duration(attempt)):
+		}
+	}
+	return sErr
+}
 
-	err := dbop(tx)
+// runDBTx run dbop in a transaction once, the transaction is rolled back unless committed,
+// and a panic of dbop goes on after the rollback
+func runDBTx(ctx context.Context, dbConn *gorm.DB, dbop func(tx *gorm.DB) response.SError) response.SError {
+	tx := dbConn.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(tx.Error, "begin transaction fail")
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	sErr := dbop(tx)
+	if sErr != nil {
+		return sErr
+	}
+	err := tx.Commit().Error
+	committed = true // a failed commit can not be rolled back either
 	if err != nil {
-		tx.Rollback()
-		return err
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "commit transaction fail")
 	}
-	tx.Commit()
 	return nil
 }
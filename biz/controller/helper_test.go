@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/glebarez/sqlite"
+	"github.com/swordandtea/lets-habit-server/biz/config"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/dal/daltest"
+	"github.com/swordandtea/lets-habit-server/biz/mailqueue"
+	"github.com/swordandtea/lets-habit-server/biz/migration"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"github.com/swordandtea/lets-habit-server/biz/service"
+	"gorm.io/gorm"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"path"
+	"testing"
+)
+
+// setupTestDB init the global db executor with a sqlite database inside a temp dir and create all the tables
+// by the migrations,
+// also init a global config for test
+func setupTestDB(t *testing.T) *gorm.DB {
+	config.GlobalConfig = &config.RuntimeConfig{
+		JWT: config.JWTConfig{Cypher: "test_cypher"},
+	}
+
+	dsn := path.Join(t.TempDir(), "test.db") + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"
+	err := service.InitDBWithDialector(sqlite.Open(dsn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := service.GetDBExecutor()
+	migrator, err := migration.NewDefaultMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = migrator.Up(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newFakeRepositories create the Repositories on the in-memory fakes of daltest for unit test without a database,
+// notice the fakes do not roll back what dbop changed when it returns an error
+func newFakeRepositories() *Repositories {
+	habitGroups := daltest.NewHabitGroupRepository()
+	return &Repositories{
+		DB: func(ctx context.Context) *gorm.DB { return nil },
+		Tx: func(ctx context.Context, dbop func(tx *gorm.DB) response.SError) response.SError {
+			return dbop(nil)
+		},
+		Users:                      daltest.NewUserRepository(),
+		Habits:                     daltest.NewHabitRepository(habitGroups),
+		HabitGroups:                habitGroups,
+		UserHabitConfigs:           daltest.NewUserHabitConfigRepository(),
+		HabitLogRecords:            daltest.NewHabitLogRecordRepository(),
+		UnconfirmedHabitLogRecords: daltest.NewHabitLogRecordRepository(),
+		Sessions:                   daltest.NewSessionRepository(),
+		UserIdentities:             daltest.NewUserIdentityRepository(),
+		EmailBindRequests:          daltest.NewEmailBindRequestRepository(),
+		LoginCodes:                 daltest.NewLoginCodeRepository(),
+		UserTwoFactors:             daltest.NewUserTwoFactorRepository(),
+		RecoveryCodes:              daltest.NewRecoveryCodeRepository(),
+		SecurityEvents:             daltest.NewSecurityEventRepository(),
+		OutboxMails:                daltest.NewOutboxMailRepository(),
+	}
+}
+
+// addTestUser insert a user registered by email with password into db
+func addTestUser(t *testing.T, db *gorm.DB, uid dal.UID, email string, password string) *dal.User {
+	user := &dal.User{
+		UID:              uid,
+		Email:            &email,
+		EmailActive:      true,
+		Password:         dal.NewRawPassword(password),
+		UserRegisterType: dal.UserRegisterTypeEmail,
+	}
+	sErr := dal.UserDBHD.Add(db, user)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	return user
+}
+
+// fakeMailer deliver the queued mails into a service.MemoryMailService
+type fakeMailer struct {
+	sink *service.MemoryMailService
+}
+
+// sent deliver all the queued mails and get all the mails sent so far, every mail is kept as its To and Subject headers
+// followed by the decoded plain text body
+func (m *fakeMailer) sent(t *testing.T) []string {
+	_, err := mailqueue.NewWorker(service.GetDBExecutor(), m.sink, mailqueue.WorkerOption{}).RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mails []string
+	for _, sentMail := range m.sink.Mails() {
+		msg, err := mail.ReadMessage(bytes.NewReader(sentMail.Content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		subject, err := (&mime.WordDecoder{}).DecodeHeader(msg.Header.Get("Subject"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		part, err := multipart.NewReader(msg.Body, params["boundary"]).NextPart() // the plain text part
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mails = append(mails, fmt.Sprintf("To: %s\nSubject: %s\n\n%s", msg.Header.Get("To"), subject, text))
+	}
+	return mails
+}
+
+// setupFakeMailService init the global mail executor with a service.MemoryMailService,
+// the queued mails are delivered into it when fakeMailer.sent is called
+func setupFakeMailService() *fakeMailer {
+	m := &fakeMailer{sink: service.NewMemoryMailService("noreply@test.com")}
+	service.InitMailServiceWithImpl(m.sink)
+	return m
+}
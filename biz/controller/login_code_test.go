@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/config"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var loginCodeRegexp = regexp.MustCompile(`login code is (\d{6})`)
+var loginCodeRegexpZh = regexp.MustCompile(`登录验证码是 (\d{6})`)
+var loginLinkRegexp = regexp.MustCompile(`token=([\w-]+)`)
+
+func TestLoginByEmailCode(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	mailer := setupFakeMailService()
+	config.GlobalConfig.EmailService.LoginURI = "http://test/login"
+	config.GlobalConfig.EmailService.LoginParam = "token"
+	email := "u1@test.com"
+	sErr := dal.UserDBHD.Add(db, &dal.User{ // a user without password
+		UID:              "u1",
+		Email:            &email,
+		UserRegisterType: dal.UserRegisterTypeEmail,
+	})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	ctrl := NewUserCtrl(NewRepositories())
+
+	sErr = ctrl.StartEmailLogin(ctx, "not-exist@test.com")
+	if sErr != nil || len(mailer.sent(t)) != 0 {
+		t.Fatal("unknown email should be ignored silently")
+	}
+
+	sErr = ctrl.StartEmailLogin(ctx, email)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(mailer.sent(t)) != 1 {
+		t.Fatal("login email not sent")
+	}
+	code := loginCodeRegexp.FindStringSubmatch(mailer.sent(t)[0])[1]
+	wrongCode := "000000"
+	if code == wrongCode {
+		wrongCode = "111111"
+	}
+
+	_, sErr = ctrl.LoginByEmailCode(ctx, email, wrongCode)
+	if sErr == nil {
+		t.Fatal("wrong code should fail")
+	}
+	user, sErr := ctrl.LoginByEmailCode(ctx, email, code)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if user.UID != "u1" || !user.EmailActive {
+		t.Fatalf("unexpected user %+v", user)
+	}
+	_, sErr = ctrl.LoginByEmailCode(ctx, email, code)
+	if sErr == nil {
+		t.Fatal("used code should fail")
+	}
+
+	// the code is invalidated after too many failed attempts
+	sErr = ctrl.StartEmailLogin(ctx, email)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	code = loginCodeRegexp.FindStringSubmatch(mailer.sent(t)[1])[1]
+	wrongCode = "000000"
+	if code == wrongCode {
+		wrongCode = "111111"
+	}
+	for i := 0; i < loginCodeMaxAttempts; i++ {
+		_, sErr = ctrl.LoginByEmailCode(ctx, email, wrongCode)
+		if sErr == nil {
+			t.Fatal("wrong code should fail")
+		}
+	}
+	_, sErr = ctrl.LoginByEmailCode(ctx, email, code)
+	if sErr == nil {
+		t.Fatal("code should be invalidated after too many failed attempts")
+	}
+}
+
+func TestLoginByEmailLink(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	mailer := setupFakeMailService()
+	config.GlobalConfig.EmailService.LoginURI = "http://test/login"
+	config.GlobalConfig.EmailService.LoginParam = "token"
+	addTestUser(t, db, "u1", "u1@test.com", "passw0rd1")
+	ctrl := NewUserCtrl(NewRepositories())
+
+	sErr := ctrl.StartEmailLogin(ctx, "u1@test.com")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	oldToken := loginLinkRegexp.FindStringSubmatch(mailer.sent(t)[0])[1]
+	sErr = ctrl.StartEmailLogin(ctx, "u1@test.com")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	token := loginLinkRegexp.FindStringSubmatch(mailer.sent(t)[1])[1]
+
+	_, sErr = ctrl.LoginByEmailLink(ctx, oldToken)
+	if sErr == nil {
+		t.Fatal("link replaced by a new one should fail")
+	}
+	user, sErr := ctrl.LoginByEmailLink(ctx, token)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if user.UID != "u1" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+	_, sErr = ctrl.LoginByEmailLink(ctx, token)
+	if sErr == nil {
+		t.Fatal("used link should fail")
+	}
+}
+
+func TestEmailLanguage(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	mailer := setupFakeMailService()
+	addTestUser(t, db, "u1", "u1@test.com", "passw0rd1")
+	ctrl := NewUserCtrl(NewRepositories())
+
+	sErr := ctrl.StartEmailLogin(ctx, "u1@test.com")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if !strings.Contains(mailer.sent(t)[0], "Subject: [lets-habits] Login code") {
+		t.Fatalf("expect mail in default locale, got %s", mailer.sent(t)[0])
+	}
+
+	user, sErr := ctrl.UpdateLanguage(ctx, "u1", "zh")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if user.Language != "zh" {
+		t.Fatal("language not updated")
+	}
+	sErr = ctrl.StartEmailLogin(ctx, "u1@test.com")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if !strings.Contains(mailer.sent(t)[1], "Subject: [lets-habits] 登录验证码") || !loginCodeRegexpZh.MatchString(mailer.sent(t)[1]) {
+		t.Fatalf("expect mail in zh locale, got %s", mailer.sent(t)[1])
+	}
+}
@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/rs/xid"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"github.com/swordandtea/lets-habit-server/biz/service"
+	"github.com/swordandtea/lets-habit-server/util"
+	"gorm.io/gorm"
+	"time"
+)
+
+type OAuthCtrl struct {
+	repos *Repositories
+}
+
+// NewOAuthCtrl create a OAuthCtrl operating on the repos
+func NewOAuthCtrl(repos *Repositories) *OAuthCtrl {
+	return &OAuthCtrl{repos: repos}
+}
+
+// oauthStateExpireTime how long the user can take to authorize at the provider
+const oauthStateExpireTime = time.Minute * 10
+
+// getOAuthProvider get a configured OAuth provider, wechat is not an OAuth provider here since it has its own login flow
+func getOAuthProvider(provider dal.IdentityProvider) (service.OAuthProvider, response.SError) {
+	p, ok := service.GetOAuthProvider(string(provider))
+	if !ok || provider == dal.IdentityProviderWechat {
+		return nil, response.ErrorCode_InvalidParam.New("unsupported oauth provider")
+	}
+	return p, nil
+}
+
+// StartOAuth start an authorization code flow with PKCE, return the url to redirect the user to,
+// if linkUID is not empty, the identity will be linked to this user instead of logging in when the flow finishes
+func (c *OAuthCtrl) StartOAuth(ctx context.Context, provider dal.IdentityProvider, linkUID dal.UID) (string, response.SError) {
+	p, sErr := getOAuthProvider(provider)
+	if sErr != nil {
+		return "", sErr
+	}
+
+	state, err := util.RandomToken(32)
+	if err != nil {
+		return "", response.ErrroCode_InternalUnknownError.Wrap(err, "generate oauth state fail")
+	}
+	codeVerifier, err := util.RandomToken(32)
+	if err != nil {
+		return "", response.ErrroCode_InternalUnknownError.Wrap(err, "generate pkce code verifier fail")
+	}
+	nonce, err := util.RandomToken(16)
+	if err != nil {
+		return "", response.ErrroCode_InternalUnknownError.Wrap(err, "generate oauth nonce fail")
+	}
+
+	db := c.repos.DB(ctx)
+	now := time.Now().UTC()
+	sErr = c.repos.OAuthStates.DeleteExpired(db, now)
+	if sErr != nil {
+		return "", sErr
+	}
+	sErr = c.repos.OAuthStates.Add(db, &dal.OAuthState{
+		StateHash:    util.HashToken(state),
+		Provider:     provider,
+		CodeVerifier: codeVerifier,
+		Nonce:        nonce,
+		LinkUID:      linkUID,
+		CreateAt:     now,
+		ExpireAt:     now.Add(oauthStateExpireTime),
+	})
+	if sErr != nil {
+		return "", sErr
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	return p.AuthCodeURL(state, base64.RawURLEncoding.EncodeToString(challenge[:]), nonce), nil
+}
+
+// OAuthResult the result of a finished OAuth flow
+type OAuthResult struct {
+	User    *dal.User
+	NewUser bool // a new user is registered by the identity
+	Linked  bool // the identity is linked to an existing user instead of logging in
+}
+
+// FinishOAuth finish an authorization code flow by the code and state the provider redirected back with,
+// the identity is linked to the user if the flow is started for linking, otherwise the user owns the identity
+// is logged in, and a new user is registered if no user owns it.
+// callerUID is the logged-in user finishing the flow, a linking flow can only be finished by the user started it,
+// so that a user can not be tricked into linking an identity to the account of another, and a login flow is finished without login
+func (c *OAuthCtrl) FinishOAuth(ctx context.Context, provider dal.IdentityProvider, state string, code string,
+	callerUID dal.UID) (*OAuthResult, response.SError) {
+	p, sErr := getOAuthProvider(provider)
+	if sErr != nil {
+		return nil, sErr
+	}
+
+	db := c.repos.DB(ctx)
+	oauthState, sErr := c.repos.OAuthStates.Consume(db, util.HashToken(state))
+	if sErr != nil {
+		return nil, sErr
+	}
+	now := time.Now().UTC()
+	if oauthState == nil || oauthState.Provider != provider || now.After(oauthState.ExpireAt) {
+		return nil, response.ErrorCode_UserNoPermission.New("invalid or expired oauth state")
+	}
+	if oauthState.LinkUID != callerUID {
+		return nil, response.ErrorCode_UserNoPermission.New("oauth flow not started by the current user")
+	}
+
+	identity, err := p.Exchange(ctx, code, oauthState.CodeVerifier, oauthState.Nonce)
+	if err != nil {
+		return nil, response.ErrorCode_UserAuthFail.Wrap(err, "exchange oauth code fail")
+	}
+
+	linked, sErr := c.repos.UserIdentities.GetByProviderAndSubject(db, provider, identity.Subject)
+	if sErr != nil {
+		return nil, sErr
+	}
+
+	if oauthState.LinkUID != "" {
+		return c.linkIdentity(db, oauthState.LinkUID, provider, identity, linked)
+	}
+
+	if linked != nil {
+		user, sErr := c.repos.Users.GetByUID(db, linked.UID)
+		if sErr != nil {
+			return nil, sErr
+		}
+		if user == nil {
+			return nil, response.ErrroCode_InternalUnknownError.New("user of oauth identity not found")
+		}
+		if user.DeleteAt != nil {
+			return nil, response.ErrorCode_UserNoPermission.New("account is pending deletion")
+		}
+		return &OAuthResult{User: user}, nil
+	}
+
+	// register a new user, the verified email is bound if no other user uses it,
+	// an existing user with the same email is never logged in automatically, it should link the identity itself
+	user := &dal.User{
+		UID:              dal.UID(xid.New().String()),
+		UserRegisterType: dal.UserRegisterTypeOAuth,
+	}
+	if identity.Email != "" && identity.EmailVerified {
+		emailUser, sErr := c.repos.Users.GetByEmail(db, identity.Email)
+		if sErr != nil {
+			return nil, sErr
+		}
+		if emailUser == nil {
+			user.Email = &identity.Email
+			user.EmailActive = true
+		}
+	}
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		sErr = c.repos.Users.Add(tx, user)
+		if sErr != nil {
+			return sErr
+		}
+		return c.repos.UserIdentities.Add(tx, &dal.UserIdentity{
+			UID:      user.UID,
+			Provider: provider,
+			Subject:  identity.Subject,
+			CreateAt: now,
+		})
+	})
+	if sErr != nil {
+		return nil, sErr
+	}
+	return &OAuthResult{User: user, NewUser: true}, nil
+}
+
+func (c *OAuthCtrl) linkIdentity(db *gorm.DB, uid dal.UID, provider dal.IdentityProvider,
+	identity *service.OAuthIdentity, linked *dal.UserIdentity) (*OAuthResult, response.SError) {
+	user, sErr := c.repos.Users.GetByUID(db, uid)
+	if sErr != nil {
+		return nil, sErr
+	}
+	if user == nil {
+		return nil, response.ErrorCode_InvalidParam.New("invalid uid, no user found")
+	}
+
+	if linked != nil {
+		if linked.UID != uid {
+			return nil, response.ErrorCode_UserNoPermission.New("identity already linked to another account")
+		}
+		return &OAuthResult{User: user, Linked: true}, nil
+	}
+
+	sErr = c.repos.UserIdentities.Add(db, &dal.UserIdentity{
+		UID:      uid,
+		Provider: provider,
+		Subject:  identity.Subject,
+		CreateAt: time.Now().UTC(),
+	})
+	if sErr != nil {
+		return nil, sErr
+	}
+	return &OAuthResult{User: user, Linked: true}, nil
+}
+
+// ListIdentities list all the external identities linked to a user
+func (c *OAuthCtrl) ListIdentities(ctx context.Context, uid dal.UID) ([]*dal.UserIdentity, response.SError) {
+	return c.repos.UserIdentities.ListByUID(c.repos.DB(ctx), uid)
+}
+
+// UnlinkIdentity unlink an external identity from a user,
+// the last identity can not be unlinked if the user has no password to login with
+func (c *OAuthCtrl) UnlinkIdentity(ctx context.Context, uid dal.UID, provider dal.IdentityProvider) response.SError {
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByUID(db, uid)
+	if sErr != nil {
+		return sErr
+	}
+	if user == nil {
+		return response.ErrorCode_InvalidParam.New("invalid uid, no user found")
+	}
+
+	identities, sErr := c.repos.UserIdentities.ListByUID(db, uid)
+	if sErr != nil {
+		return sErr
+	}
+	found := false
+	for _, i := range identities {
+		if i.Provider == provider {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return response.ErrorCode_InvalidParam.New("identity not linked")
+	}
+
+	canLoginByPassword := user.Email != nil && user.Password != nil
+	if len(identities) == 1 && !canLoginByPassword {
+		return response.ErrorCode_UserNoPermission.New("can not unlink the only way to login, set a password first")
+	}
+
+	return c.repos.UserIdentities.DeleteByUIDAndProvider(db, uid, provider)
+}
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/service"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestOAuthLoginLinkAndUnlink(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	subjects := map[string]string{"code-1": "subject-1", "code-2": "subject-2"}
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			_ = r.ParseForm()
+			if r.PostForm.Get("code_verifier") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":"invalid_request"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token":"` + r.PostForm.Get("code") + `"}`))
+		case "/user":
+			code := r.Header.Get("Authorization")[len("Bearer "):]
+			_, _ = w.Write([]byte(`{"sub":"` + subjects[code] + `","email":"o@test.com","email_verified":true}`))
+		}
+	}))
+	defer stub.Close()
+	err := service.InitOAuthProviders(map[string]*service.OAuthProviderOption{
+		"test": {
+			ClientID:    "client",
+			AuthURL:     stub.URL + "/authorize",
+			TokenURL:    stub.URL + "/token",
+			UserInfoURL: stub.URL + "/user",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctrl := NewOAuthCtrl(NewRepositories())
+	startState := func(linkUID dal.UID) string {
+		authURL, sErr := ctrl.StartOAuth(ctx, "test", linkUID)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		u, err := url.Parse(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.Query().Get("state")
+	}
+
+	_, sErr := ctrl.StartOAuth(ctx, "unknown", "")
+	if sErr == nil {
+		t.Fatal("unknown provider should fail")
+	}
+
+	// first login registers a new user with the verified email
+	state := startState("")
+	result, sErr := ctrl.FinishOAuth(ctx, "test", state, "code-1", "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if !result.NewUser || result.User.Email == nil || *result.User.Email != "o@test.com" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	uid := result.User.UID
+
+	// a state can only be used once
+	_, sErr = ctrl.FinishOAuth(ctx, "test", state, "code-1", "")
+	if sErr == nil {
+		t.Fatal("reused state should fail")
+	}
+
+	// login again finds the same user
+	result, sErr = ctrl.FinishOAuth(ctx, "test", startState(""), "code-1", "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if result.NewUser || result.User.UID != uid {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	// the only identity of a user without password can not be unlinked
+	sErr = ctrl.UnlinkIdentity(ctx, uid, "test")
+	if sErr == nil {
+		t.Fatal("unlink the only login method should fail")
+	}
+
+	// an identity already linked to another user can not be linked
+	addTestUser(t, db, "u2", "u2@test.com", "passw0rd1")
+	_, sErr = ctrl.FinishOAuth(ctx, "test", startState("u2"), "code-1", "u2")
+	if sErr == nil {
+		t.Fatal("link identity of another user should fail")
+	}
+
+	// a linking flow can only be finished by the user started it, and a login flow without login
+	_, sErr = ctrl.FinishOAuth(ctx, "test", startState("u2"), "code-2", uid)
+	if sErr == nil {
+		t.Fatal("linking flow finished by another user should fail")
+	}
+	_, sErr = ctrl.FinishOAuth(ctx, "test", startState("u2"), "code-2", "")
+	if sErr == nil {
+		t.Fatal("linking flow finished without login should fail")
+	}
+	_, sErr = ctrl.FinishOAuth(ctx, "test", startState(""), "code-2", "u2")
+	if sErr == nil {
+		t.Fatal("login flow finished as a linking one should fail")
+	}
+
+	result, sErr = ctrl.FinishOAuth(ctx, "test", startState("u2"), "code-2", "u2")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if !result.Linked || result.User.UID != "u2" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	identities, sErr := ctrl.ListIdentities(ctx, "u2")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(identities) != 1 || identities[0].Subject != "subject-2" {
+		t.Fatalf("unexpected identities %+v", identities)
+	}
+
+	// a user with password can unlink its only identity
+	sErr = ctrl.UnlinkIdentity(ctx, "u2", "test")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	identities, sErr = ctrl.ListIdentities(ctx, "u2")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(identities) != 0 {
+		t.Fatal("identity not unlinked")
+	}
+}
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"github.com/swordandtea/lets-habit-server/biz/service"
+	"gorm.io/gorm"
+)
+
+// Repositories the repositories of the tables a controller operates on, and how to get the db executor passed to them,
+// any of them can be replaced, like by the in-memory fakes of daltest in unit test
+type Repositories struct {
+	// DB get the db executor to operate outside a transaction, the queries are canceled with ctx
+	DB func(ctx context.Context) *gorm.DB
+	// Tx run dbop in a transaction bound to ctx, roll it back if dbop returns an error
+	Tx func(ctx context.Context, dbop func(tx *gorm.DB) response.SError) response.SError
+
+	Users                      dal.UserRepository
+	Habits                     dal.HabitRepository
+	HabitGroups                dal.HabitGroupRepository
+	UserHabitConfigs           dal.UserHabitConfigRepository
+	HabitLogRecords            dal.HabitLogRecordRepository
+	UnconfirmedHabitLogRecords dal.UnconfirmedHabitLogRecordRepository
+	Sessions                   dal.SessionRepository
+	UserIdentities             dal.UserIdentityRepository
+	EmailBindRequests          dal.EmailBindRequestRepository
+	LoginCodes                 dal.LoginCodeRepository
+	UserTwoFactors             dal.UserTwoFactorRepository
+	RecoveryCodes              dal.RecoveryCodeRepository
+	SecurityEvents             dal.SecurityEventRepository
+	OAuthStates                dal.OAuthStateRepository
+	AdminAuditLogs             dal.AdminAuditLogRepository
+	OutboxMails                dal.OutboxMailRepository
+}
+
+// NewRepositories create the Repositories operating on the global db executor
+func NewRepositories() *Repositories {
+	return &Repositories{
+		DB: func(ctx context.Context) *gorm.DB {
+			return service.GetDBExecutor().WithContext(ctx)
+		},
+		Tx: func(ctx context.Context, dbop func(tx *gorm.DB) response.SError) response.SError {
+			return WithDBTx(ctx, nil, dbop)
+		},
+		Users:                      dal.UserDBHD,
+		Habits:                     dal.HabitDBHD,
+		HabitGroups:                dal.HabitGroupDBHD,
+		UserHabitConfigs:           dal.UserHabitConfigDBHD,
+		HabitLogRecords:            dal.HabitLogRecordDBHD,
+		UnconfirmedHabitLogRecords: dal.UnconfirmedHabitLogRecordDBHD,
+		Sessions:                   dal.SessionDBHD,
+		UserIdentities:             dal.UserIdentityDBHD,
+		EmailBindRequests:          dal.EmailBindRequestDBHD,
+		LoginCodes:                 dal.LoginCodeDBHD,
+		UserTwoFactors:             dal.UserTwoFactorDBHD,
+		RecoveryCodes:              dal.RecoveryCodeDBHD,
+		SecurityEvents:             dal.SecurityEventDBHD,
+		OAuthStates:                dal.OAuthStateDBHD,
+		AdminAuditLogs:             dal.AdminAuditLogDBHD,
+		OutboxMails:                dal.OutboxMailDBHD,
+	}
+}
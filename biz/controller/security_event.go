@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"time"
+)
+
+type SecurityEventCtrl struct {
+	repos *Repositories
+}
+
+// NewSecurityEventCtrl create a SecurityEventCtrl operating on the repos
+func NewSecurityEventCtrl(repos *Repositories) *SecurityEventCtrl {
+	return &SecurityEventCtrl{repos: repos}
+}
+
+// maxSecurityEventDetailLength the max length of the detail of a security event
+const maxSecurityEventDetailLength = 255
+
+// Record append a security event of the user caused by the request of the client
+func (c *SecurityEventCtrl) Record(ctx context.Context, uid dal.UID, event dal.SecurityEventType, client *SessionClient, detail string) response.SError {
+	return c.repos.SecurityEvents.Add(c.repos.DB(ctx), &dal.SecurityEvent{
+		UID:       uid,
+		Event:     event,
+		IP:        client.IP,
+		UserAgent: truncateString(client.UserAgent, maxUserAgentLength),
+		Detail:    truncateString(detail, maxSecurityEventDetailLength),
+		CreateAt:  time.Now().UTC(),
+	})
+}
+
+// RecordByEmail append a security event of the user registered with the email, nothing is recorded
+// if no user registered with it, like a login failure of an unknown email
+func (c *SecurityEventCtrl) RecordByEmail(ctx context.Context, email string, event dal.SecurityEventType, client *SessionClient, detail string) response.SError {
+	user, sErr := c.repos.Users.GetByEmail(c.repos.DB(ctx), email)
+	if sErr != nil {
+		return sErr
+	}
+	if user == nil {
+		return nil
+	}
+	return c.Record(ctx, user.UID, event, client, detail)
+}
+
+// ListRecentEvents list the latest security events of the user, at most limit ones
+func (c *SecurityEventCtrl) ListRecentEvents(ctx context.Context, uid dal.UID, limit int) ([]*dal.SecurityEvent, response.SError) {
+	return c.repos.SecurityEvents.ListRecentByUID(c.repos.DB(ctx), uid, limit)
+}
@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"strings"
+	"testing"
+)
+
+func TestSecurityEvents(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	user := addTestUser(t, db, "u1", "u1@test.com", "password")
+	ctrl := NewSecurityEventCtrl(NewRepositories())
+	client := &SessionClient{UserAgent: strings.Repeat("a", 300), IP: "10.0.0.1"}
+
+	sErr := ctrl.Record(ctx, user.UID, dal.SecurityEventRegister, client, "email")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	sErr = ctrl.RecordByEmail(ctx, "u1@test.com", dal.SecurityEventLoginFailure, client, "password")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	// nothing is recorded for an unknown email
+	sErr = ctrl.RecordByEmail(ctx, "unknown@test.com", dal.SecurityEventLoginFailure, client, "password")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	sErr = ctrl.Record(ctx, user.UID, dal.SecurityEventEmailBind, client, "new@test.com")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	sErr = ctrl.Record(ctx, "u2", dal.SecurityEventRegister, client, "email")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+
+	events, sErr := ctrl.ListRecentEvents(ctx, user.UID, 10)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	expected := []dal.SecurityEventType{dal.SecurityEventEmailBind, dal.SecurityEventLoginFailure, dal.SecurityEventRegister}
+	if len(events) != len(expected) {
+		t.Fatalf("expect %d events, got %d", len(expected), len(events))
+	}
+	for i, event := range expected {
+		if events[i].Event != event {
+			t.Fatalf("expect event %d to be %s, got %s", i, event, events[i].Event)
+		}
+		if events[i].IP != client.IP || len(events[i].UserAgent) != maxUserAgentLength {
+			t.Fatalf("client of event %d not recorded: %+v", i, events[i])
+		}
+	}
+	if events[0].Detail != "new@test.com" {
+		t.Fatal("event detail not recorded")
+	}
+
+	events, sErr = ctrl.ListRecentEvents(ctx, user.UID, 1)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(events) != 1 || events[0].Event != dal.SecurityEventEmailBind {
+		t.Fatal("should only list the latest event")
+	}
+}
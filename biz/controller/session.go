@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"context"
+	"github.com/rs/xid"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"github.com/swordandtea/lets-habit-server/util"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+type SessionCtrl struct {
+	repos *Repositories
+}
+
+// NewSessionCtrl create a SessionCtrl operating on the repos
+func NewSessionCtrl(repos *Repositories) *SessionCtrl {
+	return &SessionCtrl{repos: repos}
+}
+
+// RefreshTokenExpireTime how long a session is kept alive since the last time its refresh token was used
+const RefreshTokenExpireTime = time.Hour * 24 * 30 // 30 days
+
+// refreshTokenSecretBytes the random bytes of the secret part of a refresh token
+const refreshTokenSecretBytes = 32
+
+// maxDeviceNameLength the max length of a session device name
+const maxDeviceNameLength = 64
+
+// maxUserAgentLength the max length of a session user agent
+const maxUserAgentLength = 255
+
+// SessionClient the info of the client which a session is created for or used by
+type SessionClient struct {
+	DeviceName string
+	UserAgent  string
+	IP         string
+}
+
+// newRefreshToken generate a refresh token of session sid, the token is in format <sid>.<secret>,
+// return the token and the hash of it
+func newRefreshToken(sid string) (string, string, response.SError) {
+	secret, err := util.RandomToken(refreshTokenSecretBytes)
+	if err != nil {
+		return "", "", response.ErrroCode_InternalUnknownError.Wrap(err, "generate refresh token fail")
+	}
+	token := sid + "." + secret
+	return token, util.HashToken(token), nil
+}
+
+func truncateString(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// checkUserNotDisabled return no permission error if the user is disabled by an admin
+func (r *Repositories) checkUserNotDisabled(db *gorm.DB, uid dal.UID) response.SError {
+	user, sErr := r.Users.GetByUID(db, uid)
+	if sErr != nil {
+		return sErr
+	}
+	if user != nil && user.Disabled {
+		return response.ErrorCode_UserNoPermission.New("user is disabled")
+	}
+	return nil
+}
+
+// CreateSession create a new login session for a user, return the session and its refresh token,
+// no session is created for a disabled user
+func (c *SessionCtrl) CreateSession(ctx context.Context, uid dal.UID, client *SessionClient) (*dal.Session, string, response.SError) {
+	sErr := c.repos.checkUserNotDisabled(c.repos.DB(ctx), uid)
+	if sErr != nil {
+		return nil, "", sErr
+	}
+
+	sid := xid.New().String()
+	refreshToken, refreshHash, sErr := newRefreshToken(sid)
+	if sErr != nil {
+		return nil, "", sErr
+	}
+
+	now := time.Now().UTC()
+	session := &dal.Session{
+		SID:         sid,
+		UID:         uid,
+		RefreshHash: refreshHash,
+		DeviceName:  truncateString(client.DeviceName, maxDeviceNameLength),
+		UserAgent:   truncateString(client.UserAgent, maxUserAgentLength),
+		LastUsedIP:  client.IP,
+		LastUsedAt:  now,
+		CreateAt:    now,
+		ExpireAt:    now.Add(RefreshTokenExpireTime),
+	}
+	sErr = c.repos.Sessions.Add(c.repos.DB(ctx), session)
+	if sErr != nil {
+		return nil, "", sErr
+	}
+	return session, refreshToken, nil
+}
+
+// RefreshSession rotate the refresh token of a session, return the session and the new refresh token,
+// the old refresh token can not be used anymore, and presenting the last rotated refresh token
+// revokes the whole session since it means the token has leaked, while a refresh token never issued
+// for the session is just rejected, as the sid part of it is not a secret
+func (c *SessionCtrl) RefreshSession(ctx context.Context, refreshToken string, client *SessionClient) (*dal.Session, string, response.SError) {
+	sid, _, found := strings.Cut(refreshToken, ".")
+	if !found || sid == "" {
+		return nil, "", response.ErrorCode_UserAuthFail.New("invalid refresh token")
+	}
+
+	db := c.repos.DB(ctx)
+	session, sErr := c.repos.Sessions.GetBySID(db, sid)
+	if sErr != nil {
+		return nil, "", sErr
+	}
+	now := time.Now().UTC()
+	if session == nil || !session.IsActive(now) {
+		return nil, "", response.ErrorCode_UserAuthFail.New("session expired or revoked")
+	}
+	sErr = c.repos.checkUserNotDisabled(db, session.UID)
+	if sErr != nil {
+		return nil, "", sErr
+	}
+
+	oldRefreshHash := util.HashToken(refreshToken)
+	if session.PrevRefreshHash != "" && oldRefreshHash == session.PrevRefreshHash {
+		sErr = c.repos.Sessions.RevokeBySID(db, sid, now)
+		if sErr != nil {
+			return nil, "", sErr
+		}
+		return nil, "", response.ErrorCode_UserAuthFail.New("refresh token reused, session revoked")
+	}
+	if oldRefreshHash != session.RefreshHash {
+		return nil, "", response.ErrorCode_UserAuthFail.New("invalid refresh token")
+	}
+
+	newToken, newHash, sErr := newRefreshToken(sid)
+	if sErr != nil {
+		return nil, "", sErr
+	}
+	rotateFields := &dal.SessionRotateFields{
+		RefreshHash: newHash,
+		LastUsedIP:  client.IP,
+		LastUsedAt:  now,
+		ExpireAt:    now.Add(RefreshTokenExpireTime),
+	}
+	rotated, sErr := c.repos.Sessions.Rotate(db, sid, oldRefreshHash, rotateFields)
+	if sErr != nil {
+		return nil, "", sErr
+	}
+	if !rotated { // someone else rotated it at the same time
+		return nil, "", response.ErrorCode_UserAuthFail.New("refresh token already used")
+	}
+
+	session.RefreshHash = newHash
+	session.LastUsedIP = rotateFields.LastUsedIP
+	session.LastUsedAt = rotateFields.LastUsedAt
+	session.ExpireAt = rotateFields.ExpireAt
+	return session, newToken, nil
+}
+
+// VerifySession check whether a session belongs to the user and is still active
+func (c *SessionCtrl) VerifySession(ctx context.Context, uid dal.UID, sid string) response.SError {
+	session, sErr := c.repos.Sessions.GetBySID(c.repos.DB(ctx), sid)
+	if sErr != nil {
+		return sErr
+	}
+	if session == nil || session.UID != uid {
+		return response.ErrorCode_UserAuthFail.New("session not found")
+	}
+	if !session.IsActive(time.Now().UTC()) {
+		return response.ErrorCode_UserAuthFail.New("session expired or revoked")
+	}
+	return nil
+}
+
+// ListActiveSessions list all the active sessions of a user
+func (c *SessionCtrl) ListActiveSessions(ctx context.Context, uid dal.UID) ([]*dal.Session, response.SError) {
+	return c.repos.Sessions.ListActiveByUID(c.repos.DB(ctx), uid, time.Now().UTC())
+}
+
+// RevokeSession revoke a session of a user
+func (c *SessionCtrl) RevokeSession(ctx context.Context, uid dal.UID, sid string) response.SError {
+	db := c.repos.DB(ctx)
+	session, sErr := c.repos.Sessions.GetBySID(db, sid)
+	if sErr != nil {
+		return sErr
+	}
+	if session == nil || session.UID != uid {
+		return response.ErrorCode_InvalidParam.New("session not found")
+	}
+	return c.repos.Sessions.RevokeBySID(db, sid, time.Now().UTC())
+}
@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"testing"
+)
+
+func TestRefreshSession(t *testing.T) {
+	ctx := context.Background()
+	setupTestDB(t)
+	uid := dal.UID("u1")
+	ctrl := NewSessionCtrl(NewRepositories())
+	client := &SessionClient{DeviceName: "phone", UserAgent: "test", IP: "127.0.0.1"}
+
+	session, refreshToken, sErr := ctrl.CreateSession(ctx, uid, client)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+
+	_, newRefreshToken, sErr := ctrl.RefreshSession(ctx, refreshToken, client)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if newRefreshToken == refreshToken {
+		t.Fatal("refresh token not rotated")
+	}
+	if ctrl.VerifySession(ctx, uid, session.SID) != nil {
+		t.Fatal("session should still be active after refresh")
+	}
+
+	// a forged refresh token of the session is rejected without revoking it, as the sid is not a secret
+	_, _, sErr = ctrl.RefreshSession(ctx, session.SID+".forged", client)
+	if sErr == nil {
+		t.Fatal("forged refresh token should not be accepted")
+	}
+	if ctrl.VerifySession(ctx, uid, session.SID) != nil {
+		t.Fatal("session should not be revoked by a forged refresh token")
+	}
+
+	// reuse the rotated refresh token revokes the session
+	_, _, sErr = ctrl.RefreshSession(ctx, refreshToken, client)
+	if sErr == nil {
+		t.Fatal("rotated refresh token should not be accepted")
+	}
+	if ctrl.VerifySession(ctx, uid, session.SID) == nil {
+		t.Fatal("session should be revoked after refresh token reused")
+	}
+	_, _, sErr = ctrl.RefreshSession(ctx, newRefreshToken, client)
+	if sErr == nil {
+		t.Fatal("refresh token of revoked session should not be accepted")
+	}
+
+	if ctrl.VerifySession(ctx, "u2", session.SID) == nil {
+		t.Fatal("session should not be verified for another user")
+	}
+}
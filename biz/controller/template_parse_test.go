@@ -1,24 +1,28 @@
 package controller
 
 import (
-	"bytes"
+	"github.com/swordandtea/lets-habit-server/biz/mailtemplate"
 	"testing"
-	"text/template"
 )
 
-func TestTemplate(t *testing.T) {
-	tmpl, err := template.New("email_activate_tmpl_test").Parse(emailActivateTmplStr)
-	if err != nil {
-		t.Fatal(err)
+func TestPreviewEmail(t *testing.T) {
+	ctrl := NewUserCtrl(NewRepositories())
+	renderer := mailtemplate.DefaultRenderer()
+	// every template must render with its filler in every locale
+	for _, name := range renderer.Names() {
+		for _, locale := range renderer.Locales(name) {
+			msg, sErr := ctrl.PreviewEmail(name, locale)
+			if sErr != nil {
+				t.Fatalf("preview %s/%s fail, %v", locale, name, sErr)
+			}
+			if msg.Subject == "" || msg.Text == "" || msg.HTML == "" {
+				t.Fatalf("preview %s/%s got empty content", locale, name)
+			}
+		}
 	}
-	data := &bytes.Buffer{}
-	err = tmpl.Execute(data, &emailActivateTmplFiller{
-		From:       "<from>",
-		To:         "<to>",
-		ActiveLink: "<link>",
-	})
-	if err != nil {
-		t.Fatal(err)
+
+	_, sErr := ctrl.PreviewEmail("not_exist", mailtemplate.DefaultLocale)
+	if sErr == nil {
+		t.Fatal("preview an unknown template should fail")
 	}
-	t.Logf("data is %s", data.String())
 }
@@ -0,0 +1,271 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"github.com/swordandtea/lets-habit-server/util"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+type TwoFactorCtrl struct {
+	repos *Repositories
+}
+
+// NewTwoFactorCtrl create a TwoFactorCtrl operating on the repos
+func NewTwoFactorCtrl(repos *Repositories) *TwoFactorCtrl {
+	return &TwoFactorCtrl{repos: repos}
+}
+
+// totpIssuer the issuer shown in the authenticator apps
+const totpIssuer = "lets-habit"
+
+// totpSkew the time steps of clock drift allowed before and after the current one
+const totpSkew = 1
+
+// recoveryCodeCount how many recovery codes are generated each time
+const recoveryCodeCount = 10
+
+// twoFactorMaxFailedAttempts the consecutive failed verifications allowed before the two-factor verification is locked
+const twoFactorMaxFailedAttempts = 5
+
+// twoFactorLockTime how long the two-factor verification is locked after too many failed attempts
+const twoFactorLockTime = time.Minute * 15
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateRecoveryCodes generate a batch of recovery codes like xxxx-xxxx-xxxx-xxxx, return the plain codes and the records to store
+func generateRecoveryCodes(uid dal.UID, now time.Time) ([]string, []*dal.RecoveryCode, error) {
+	plain := make([]string, 0, recoveryCodeCount)
+	records := make([]*dal.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		code := s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16]
+		plain = append(plain, code)
+		records = append(records, &dal.RecoveryCode{
+			UID:      uid,
+			CodeHash: util.HashToken(normalizeRecoveryCode(code)),
+			CreateAt: now,
+		})
+	}
+	return plain, records, nil
+}
+
+// normalizeRecoveryCode remove the separators and spaces the user may type
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// isTOTPCode whether the code looks like a TOTP code rather than a recovery code
+func isTOTPCode(code string) bool {
+	if len(code) != util.TOTPDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// StartEnrollment start the two-factor enrollment of a user, return the TOTP secret and its provisioning uri,
+// the enrollment takes effect only after confirmed by a valid code, a former pending enrollment is replaced
+func (c *TwoFactorCtrl) StartEnrollment(ctx context.Context, uid dal.UID) (string, string, response.SError) {
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByUID(db, uid)
+	if sErr != nil {
+		return "", "", sErr
+	}
+	if user == nil {
+		return "", "", response.ErrorCode_InvalidParam.New("invalid uid, no user found")
+	}
+	tf, sErr := c.repos.UserTwoFactors.GetByUID(db, uid)
+	if sErr != nil {
+		return "", "", sErr
+	}
+	if tf != nil && tf.IsEnabled() {
+		return "", "", response.ErrorCode_InvalidParam.New("two-factor authentication already enabled")
+	}
+
+	secret, err := util.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", response.ErrroCode_InternalUnknownError.Wrap(err, "generate totp secret fail")
+	}
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		sErr = c.repos.UserTwoFactors.DeleteByUID(tx, uid)
+		if sErr != nil {
+			return sErr
+		}
+		return c.repos.UserTwoFactors.Add(tx, &dal.UserTwoFactor{
+			UID:      uid,
+			Secret:   secret,
+			CreateAt: time.Now().UTC(),
+		})
+	})
+	if sErr != nil {
+		return "", "", sErr
+	}
+
+	account := string(uid)
+	if user.Email != nil {
+		account = *user.Email
+	}
+	return secret, util.TOTPProvisioningURI(totpIssuer, account, secret), nil
+}
+
+// ConfirmEnrollment confirm the pending two-factor enrollment by a code from the authenticator,
+// return the recovery codes which are only shown this time
+func (c *TwoFactorCtrl) ConfirmEnrollment(ctx context.Context, uid dal.UID, code string) ([]string, response.SError) {
+	db := c.repos.DB(ctx)
+	tf, sErr := c.repos.UserTwoFactors.GetByUID(db, uid)
+	if sErr != nil {
+		return nil, sErr
+	}
+	if tf == nil {
+		return nil, response.ErrorCode_InvalidParam.New("two-factor enrollment not started")
+	}
+	if tf.IsEnabled() {
+		return nil, response.ErrorCode_InvalidParam.New("two-factor authentication already enabled")
+	}
+
+	now := time.Now().UTC()
+	step, err := util.VerifyTOTP(tf.Secret, code, now, totpSkew)
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "verify totp code fail")
+	}
+	if step < 0 {
+		return nil, response.ErrorCode_InvalidParam.New("wrong two-factor code")
+	}
+
+	plain, records, err := generateRecoveryCodes(uid, now)
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "generate recovery codes fail")
+	}
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		sErr = c.repos.UserTwoFactors.Confirm(tx, tf.ID, step, now)
+		if sErr != nil {
+			return sErr
+		}
+		sErr = c.repos.RecoveryCodes.DeleteByUID(tx, uid)
+		if sErr != nil {
+			return sErr
+		}
+		return c.repos.RecoveryCodes.BatchAdd(tx, records)
+	})
+	if sErr != nil {
+		return nil, sErr
+	}
+	return plain, nil
+}
+
+// IsEnabled whether a user has enabled the two-factor authentication
+func (c *TwoFactorCtrl) IsEnabled(ctx context.Context, uid dal.UID) (bool, response.SError) {
+	tf, sErr := c.repos.UserTwoFactors.GetByUID(c.repos.DB(ctx), uid)
+	if sErr != nil {
+		return false, sErr
+	}
+	return tf != nil && tf.IsEnabled(), nil
+}
+
+// Verify verify a TOTP code or a recovery code of a user which has enabled the two-factor authentication,
+// a TOTP code can only be used once and a recovery code is consumed,
+// the verification is locked for a while after too many consecutive failures
+func (c *TwoFactorCtrl) Verify(ctx context.Context, uid dal.UID, code string) response.SError {
+	db := c.repos.DB(ctx)
+	tf, sErr := c.repos.UserTwoFactors.GetByUID(db, uid)
+	if sErr != nil {
+		return sErr
+	}
+	if tf == nil || !tf.IsEnabled() {
+		return response.ErrorCode_InvalidParam.New("two-factor authentication not enabled")
+	}
+	return c.verify(db, tf, code)
+}
+
+func (c *TwoFactorCtrl) verify(db *gorm.DB, tf *dal.UserTwoFactor, code string) response.SError {
+	now := time.Now().UTC()
+	if tf.FailedAttempts >= twoFactorMaxFailedAttempts && tf.LastFailAt != nil && now.Before(tf.LastFailAt.Add(twoFactorLockTime)) {
+		return response.ErrorCode_UserNoPermission.New("too many failed two-factor attempts, try again later")
+	}
+
+	if isTOTPCode(code) {
+		step, err := util.VerifyTOTP(tf.Secret, code, now, totpSkew)
+		if err != nil {
+			return response.ErrroCode_InternalUnknownError.Wrap(err, "verify totp code fail")
+		}
+		if step >= 0 {
+			used, sErr := c.repos.UserTwoFactors.UseStep(db, tf.ID, step)
+			if sErr != nil {
+				return sErr
+			}
+			if !used {
+				return response.ErrorCode_UserAuthFail.New("two-factor code already used")
+			}
+			return nil
+		}
+	} else {
+		used, sErr := c.repos.RecoveryCodes.Use(db, tf.UID, util.HashToken(normalizeRecoveryCode(code)), now)
+		if sErr != nil {
+			return sErr
+		}
+		if used {
+			return c.repos.UserTwoFactors.ResetFailedAttempts(db, tf.ID)
+		}
+	}
+
+	sErr := c.repos.UserTwoFactors.IncreaseFailedAttempts(db, tf.ID, now)
+	if sErr != nil {
+		return sErr
+	}
+	return response.ErrorCode_UserAuthFail.New("wrong two-factor code")
+}
+
+// RegenerateRecoveryCodes replace all the recovery codes of a user after verifying a two-factor code,
+// return the new recovery codes which are only shown this time
+func (c *TwoFactorCtrl) RegenerateRecoveryCodes(ctx context.Context, uid dal.UID, code string) ([]string, response.SError) {
+	sErr := c.Verify(ctx, uid, code)
+	if sErr != nil {
+		return nil, sErr
+	}
+
+	plain, records, err := generateRecoveryCodes(uid, time.Now().UTC())
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "generate recovery codes fail")
+	}
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		sErr = c.repos.RecoveryCodes.DeleteByUID(tx, uid)
+		if sErr != nil {
+			return sErr
+		}
+		return c.repos.RecoveryCodes.BatchAdd(tx, records)
+	})
+	if sErr != nil {
+		return nil, sErr
+	}
+	return plain, nil
+}
+
+// Disable turn off the two-factor authentication of a user after verifying a two-factor code
+func (c *TwoFactorCtrl) Disable(ctx context.Context, uid dal.UID, code string) response.SError {
+	sErr := c.Verify(ctx, uid, code)
+	if sErr != nil {
+		return sErr
+	}
+	return c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		sErr = c.repos.UserTwoFactors.DeleteByUID(tx, uid)
+		if sErr != nil {
+			return sErr
+		}
+		return c.repos.RecoveryCodes.DeleteByUID(tx, uid)
+	})
+}
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/util"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestTwoFactor(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	uid := dal.UID("u1")
+	addTestUser(t, db, uid, "u1@test.com", "passw0rd1")
+	ctrl := NewTwoFactorCtrl(NewRepositories())
+
+	secret, uri, sErr := ctrl.StartEnrollment(ctx, uid)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Query().Get("secret") != secret {
+		t.Fatalf("unexpected provisioning uri %s", uri)
+	}
+	enabled, sErr := ctrl.IsEnabled(ctx, uid)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if enabled {
+		t.Fatal("two-factor should not be enabled before confirmed")
+	}
+
+	now := time.Now()
+	code, err := util.TOTPCode(secret, util.TOTPStep(now)-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoveryCodes, sErr := ctrl.ConfirmEnrollment(ctx, uid, code)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("expect %d recovery codes, got %d", recoveryCodeCount, len(recoveryCodes))
+	}
+	enabled, sErr = ctrl.IsEnabled(ctx, uid)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if !enabled {
+		t.Fatal("two-factor should be enabled after confirmed")
+	}
+
+	// the code used to confirm can not be replayed
+	if ctrl.Verify(ctx, uid, code) == nil {
+		t.Fatal("replayed code should fail")
+	}
+	code, err = util.TOTPCode(secret, util.TOTPStep(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sErr = ctrl.Verify(ctx, uid, code); sErr != nil {
+		t.Fatal(sErr)
+	}
+
+	// a recovery code can only be used once
+	if sErr = ctrl.Verify(ctx, uid, recoveryCodes[0]); sErr != nil {
+		t.Fatal(sErr)
+	}
+	if ctrl.Verify(ctx, uid, recoveryCodes[0]) == nil {
+		t.Fatal("used recovery code should fail")
+	}
+
+	// locked after too many failures, even the right recovery code is rejected,
+	// the used recovery code above counts as one failure
+	for i := 0; i < twoFactorMaxFailedAttempts-2; i++ {
+		if ctrl.Verify(ctx, uid, "wrong-code") == nil {
+			t.Fatal("wrong code should fail")
+		}
+	}
+	if ctrl.Verify(ctx, uid, recoveryCodes[1]) != nil {
+		t.Fatal("recovery code should pass before locked")
+	}
+	for i := 0; i < twoFactorMaxFailedAttempts; i++ {
+		if ctrl.Verify(ctx, uid, "wrong-code") == nil {
+			t.Fatal("wrong code should fail")
+		}
+	}
+	if ctrl.Verify(ctx, uid, recoveryCodes[2]) == nil {
+		t.Fatal("verification should be locked after too many failures")
+	}
+
+	_, _, sErr = ctrl.StartEnrollment(ctx, uid)
+	if sErr == nil {
+		t.Fatal("enroll again when enabled should fail")
+	}
+}
+
+func TestTwoFactorKeptOnEmailLogin(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	setupFakeMailService()
+	uid := dal.UID("u1")
+	addTestUser(t, db, uid, "u1@test.com", "passw0rd1")
+	ctrl := NewTwoFactorCtrl(NewRepositories())
+
+	secret, _, sErr := ctrl.StartEnrollment(ctx, uid)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	code, err := util.TOTPCode(secret, util.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, sErr = ctrl.ConfirmEnrollment(ctx, uid, code)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+
+	sErr = (NewUserCtrl(NewRepositories())).StartEmailLogin(ctx, "u1@test.com")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	enabled, sErr := ctrl.IsEnabled(ctx, uid)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if !enabled {
+		t.Fatal("two-factor should be kept after an email login is started")
+	}
+}
@@ -1,13 +1,19 @@
 package controller
 
 import (
-	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/rs/xid"
 	"github.com/swordandtea/lets-habit-server/biz/config"
 	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/mailqueue"
+	"github.com/swordandtea/lets-habit-server/biz/mailtemplate"
+	"github.com/swordandtea/lets-habit-server/biz/ratelimit"
 	"github.com/swordandtea/lets-habit-server/biz/response"
 	"github.com/swordandtea/lets-habit-server/biz/service"
 	"github.com/swordandtea/lets-habit-server/util"
@@ -15,89 +21,128 @@ import (
 	"gorm.io/gorm"
 	"io"
 	"mime/multipart"
-	"sync"
-	"text/template"
+	"strings"
 	"time"
 )
 
-type UserCtrl struct{}
+type UserCtrl struct {
+	repos *Repositories
+}
 
-var onceEmailActivate = &sync.Once{}
-var onceEmailBind = &sync.Once{}
-var emailActivateTmpl *template.Template
-var emailBindTmpl *template.Template
+// NewUserCtrl create a UserCtrl operating on the repos
+func NewUserCtrl(repos *Repositories) *UserCtrl {
+	return &UserCtrl{repos: repos}
+}
 
-// emailActivateTmplStr the email template used for user registering from email
-const emailActivateTmplStr = `From: {{.From}}
-To: {{.To}}
-Subject: [lets-habits] 邮箱激活 (mail activate)
-Content-Type: text/plain; charset=utf-8
+// emailActivateAllowedInterval the max time interval that allow a user to resend account activate email
+const emailActivateAllowedInterval = time.Minute
 
-欢迎加入lets-habits，点击下方链接以激活账户:
-{{.ActiveLink}}
+// emailCodeExpireTime the token expired time for email activate and bind
+const emailCodeExpireTime = time.Minute * 30
 
-Welcome to join lets-habits, click the link below to activate your account:
-{{.ActiveLink}}
-`
+// emailBindRevertTime the time that a confirmed email change can still be reverted from the old email
+const emailBindRevertTime = time.Hour * 24 * 7
 
-// emailBindTmplStr the email template used for user to bind an email address
-const emailBindTmplStr = `From: {{.From}}
-To: {{.To}}
-Subject: [lets-habits] 邮箱绑定 (mail activate)
-Content-Type: text/plain; charset=utf-8
+// emailActivateCodeSubject the subject of an email activate code, to distinguish it from other codes signed by the same key
+const emailActivateCodeSubject = "email_activate"
 
-你正在为你的账户绑定邮箱，如果这是你的操作点击下方链接以完成绑定:
-{{.BindLink}}
-如果你没有操作绑定此邮箱，请忽略此邮件
+// passwordResetCodeExpireTime the expired time for password reset link
+const passwordResetCodeExpireTime = time.Minute * 15
 
-You are current binding email for account, if it's your operation, click the link below to bind:
-{{.BindLink}}
-otherwise, please ignore this message
-`
+// passwordResetCodeSubject the subject of a password reset code, to distinguish it from other codes signed by the same key
+const passwordResetCodeSubject = "password_reset"
 
-// emailActivateAllowedInterval the max time interval that allow a user to resend account activate email
-const emailActivateAllowedInterval = time.Minute
+// loginCodeExpireTime the expired time for passwordless login code and link
+const loginCodeExpireTime = time.Minute * 10
 
-// emailCodeExpireTime the token expired time for email activate and bind
-const emailCodeExpireTime = time.Minute * 30
+// loginCodeLength the digit count of a passwordless login code
+const loginCodeLength = 6
 
-// userTokenExpireTime user auth token expire time: 7 day
-const userTokenExpireTime = time.Hour * 24 * 7
+// loginCodeMaxAttempts the max failed attempts to verify a login code, the code is invalidated after that
+const loginCodeMaxAttempts = 5
+
+// reauthRecentLoginTime the max time since a session logged in that it is trusted to re-authenticate without another factor
+const reauthRecentLoginTime = time.Minute * 5
 
 type emailActivateTmplFiller struct {
-	From       string
-	To         string
 	ActiveLink string
 }
 
 type emailBindTmplFiller struct {
-	From     string
-	To       string
 	BindLink string
 }
 
-// GetEmailActivateTemplate lazy load email activate template
-func GetEmailActivateTemplate() *template.Template {
-	onceEmailActivate.Do(func() {
-		emailActivateTmpl, _ = template.New("mail-activate-tmpl").Parse(emailActivateTmplStr)
-	})
-	return emailActivateTmpl
+type emailBindNoticeTmplFiller struct {
+	NewEmail   string
+	CancelLink string
+	RevertDays int
 }
 
-// GetEmailBindTemplate lazy load email bind template
-func GetEmailBindTemplate() *template.Template {
-	onceEmailBind.Do(func() {
-		emailActivateTmpl, _ = template.New("mail-bind-tmpl").Parse(emailBindTmplStr)
-	})
-	return emailBindTmpl
+type emailResetPasswordTmplFiller struct {
+	ResetLink     string
+	ExpireMinutes int
+}
+
+type emailLoginTmplFiller struct {
+	Code          string
+	LoginLink     string
+	ExpireMinutes int
 }
 
-// sendActivateEmail send email activate email to targe email address
-func (c *UserCtrl) sendActivateEmail(toMail string, uid dal.UID) response.SError {
+// emailPreviewFillers the sample data to preview each email template
+var emailPreviewFillers = map[string]interface{}{
+	mailtemplate.NameActivate:      &emailActivateTmplFiller{ActiveLink: "https://example.com/activate?code=xxxx"},
+	mailtemplate.NameBind:          &emailBindTmplFiller{BindLink: "https://example.com/bind?code=xxxx"},
+	mailtemplate.NameBindNotice:    &emailBindNoticeTmplFiller{NewEmail: "new@example.com", CancelLink: "https://example.com/bind/cancel?code=xxxx", RevertDays: 7},
+	mailtemplate.NameResetPassword: &emailResetPasswordTmplFiller{ResetLink: "https://example.com/reset?code=xxxx", ExpireMinutes: 15},
+	mailtemplate.NameLogin:         &emailLoginTmplFiller{Code: "123456", LoginLink: "https://example.com/login?token=xxxx", ExpireMinutes: 10},
+}
+
+// sendTemplateEmail render the email template in the locale and enqueue it to be sent to the target email address,
+// pass the transaction that causes the email as db so that the email is sent only if the transaction is committed
+func (c *UserCtrl) sendTemplateEmail(db *gorm.DB, toMail string, locale string, tmplName string, filler interface{}) response.SError {
+	msg, err := mailtemplate.DefaultRenderer().Render(tmplName, locale, filler)
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "fill email %s template fail", tmplName)
+	}
 	mailExecutor := service.GetMailExecutor()
+	content, err := mailtemplate.BuildMIME(mailExecutor.Sender(), toMail, msg)
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "build email %s fail", tmplName)
+	}
+	return mailqueue.Enqueue(db, toMail, content)
+}
+
+// PreviewEmail render an email template with sample data, for checking the templates in local run mode
+func (c *UserCtrl) PreviewEmail(tmplName string, locale string) (*mailtemplate.Message, response.SError) {
+	filler, ok := emailPreviewFillers[tmplName]
+	if !ok {
+		return nil, response.ErrorCode_InvalidParam.New("unknown email template %s", tmplName)
+	}
+	msg, err := mailtemplate.DefaultRenderer().Render(tmplName, locale, filler)
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "fill email %s template fail", tmplName)
+	}
+	return msg, nil
+}
+
+// sendActivateEmail enqueue email activate email to targe email address
+func (c *UserCtrl) sendActivateEmail(ctx context.Context, db *gorm.DB, toMail string, locale string, uid dal.UID) response.SError {
+	// at most one activate email of a user inside the allowed interval
+	result, err := ratelimit.Allow(ctx, ratelimit.DefaultStore(), "activate_email:"+string(uid),
+		1, emailActivateAllowedInterval)
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "check activate email rate limit fail")
+	}
+	if !result.Allowed {
+		return response.ErrorCode_TooManyRequests.New("activate email sent too frequently, retry after %d seconds",
+			int(result.RetryAfter.Seconds())+1)
+	}
+
 	// prepare email message
 	// generate activate token
 	claims := &jwt.RegisteredClaims{
+		Subject:   emailActivateCodeSubject,
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(emailCodeExpireTime)),
 		ID:        string(uid),
 	}
@@ -107,64 +152,76 @@ func (c *UserCtrl) sendActivateEmail(toMail string, uid dal.UID) response.SError
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "sign activate code fail")
 	}
 
-	data := &bytes.Buffer{}
-	err = GetEmailActivateTemplate().Execute(data, &emailActivateTmplFiller{
-		From: mailExecutor.Sender(),
-		To:   toMail,
+	return c.sendTemplateEmail(db, toMail, locale, mailtemplate.NameActivate, &emailActivateTmplFiller{
 		ActiveLink: fmt.Sprintf("%s?%s=%s", config.GlobalConfig.EmailService.ActivateURI,
 			config.GlobalConfig.EmailService.ActivateParam, tokenStr),
 	})
-	if err != nil {
-		return response.ErrroCode_InternalUnknownError.Wrap(err, "fill email activate template fail")
-	}
+}
 
-	// send email
-	err = mailExecutor.SendMail([]string{toMail}, data.Bytes())
-	if err != nil {
-		return response.ErrroCode_InternalUnknownError.Wrap(err, "send email fail")
+// sendEmailBindEmail send email bind email with the confirm token to the new email address
+func (c *UserCtrl) sendEmailBindEmail(db *gorm.DB, toMail string, locale string, token string) response.SError {
+	return c.sendTemplateEmail(db, toMail, locale, mailtemplate.NameBind, &emailBindTmplFiller{
+		BindLink: fmt.Sprintf("%s?%s=%s", config.GlobalConfig.EmailService.BindURI,
+			config.GlobalConfig.EmailService.BindParam, token),
+	})
+}
+
+// sendEmailBindNoticeEmail notify the old email address of an email change, with the cancel token
+func (c *UserCtrl) sendEmailBindNoticeEmail(db *gorm.DB, toMail string, locale string, newEmail string, cancelToken string) response.SError {
+	return c.sendTemplateEmail(db, toMail, locale, mailtemplate.NameBindNotice, &emailBindNoticeTmplFiller{
+		NewEmail: newEmail,
+		CancelLink: fmt.Sprintf("%s?%s=%s", config.GlobalConfig.EmailService.BindCancelURI,
+			config.GlobalConfig.EmailService.BindCancelParam, cancelToken),
+		RevertDays: int(emailBindRevertTime / (time.Hour * 24)),
+	})
+}
+
+// passwordResetClaims the claims of a password reset code
+type passwordResetClaims struct {
+	jwt.RegisteredClaims
+	// PasswordFingerprint the fingerprint of the password when the code is issued,
+	// so that the code becomes invalid once the password is changed
+	PasswordFingerprint string `json:"pfp"`
+}
+
+// passwordFingerprint generate a fingerprint of a user's current hashed password
+func passwordFingerprint(user *dal.User) string {
+	hashed := ""
+	if user.Password != nil {
+		hashed = user.Password.HashedValue()
 	}
-	return nil
+	sum := sha256.Sum256([]byte(string(user.UID) + ":" + hashed))
+	return hex.EncodeToString(sum[:16])
 }
 
-// sendEmailBindEmail send email bind email to target email address
-func (c *UserCtrl) sendEmailBindEmail(toMail string, uid dal.UID) response.SError {
-	mailExecutor := service.GetMailExecutor()
-	// prepare email message
-	claims := &jwt.RegisteredClaims{
-		Subject:   toMail,
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(emailCodeExpireTime)),
-		ID:        string(uid),
+// sendResetPasswordEmail send password reset email to target email address
+func (c *UserCtrl) sendResetPasswordEmail(db *gorm.DB, user *dal.User) response.SError {
+	claims := &passwordResetClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   passwordResetCodeSubject,
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(passwordResetCodeExpireTime)),
+			ID:        string(user.UID),
+		},
+		PasswordFingerprint: passwordFingerprint(user),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
 	tokenStr, err := token.SignedString([]byte(config.GlobalConfig.JWT.Cypher))
 	if err != nil {
-		return response.ErrroCode_InternalUnknownError.Wrap(err, "sign bind code fail")
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "sign reset code fail")
 	}
 
-	data := &bytes.Buffer{}
-	err = GetEmailBindTemplate().Execute(data, &emailBindTmplFiller{
-		From: mailExecutor.Sender(),
-		To:   toMail,
-		BindLink: fmt.Sprintf("%s?%s=%s", config.GlobalConfig.EmailService.BindURI,
-			config.GlobalConfig.EmailService.BindParam, tokenStr),
+	return c.sendTemplateEmail(db, *user.Email, user.Language, mailtemplate.NameResetPassword, &emailResetPasswordTmplFiller{
+		ResetLink: fmt.Sprintf("%s?%s=%s", config.GlobalConfig.EmailService.ResetURI,
+			config.GlobalConfig.EmailService.ResetParam, tokenStr),
+		ExpireMinutes: int(passwordResetCodeExpireTime / time.Minute),
 	})
-	if err != nil {
-		return response.ErrroCode_InternalUnknownError.Wrap(err, "fill email bind template fail")
-	}
-
-	// send email
-	err = mailExecutor.SendMail([]string{toMail}, data.Bytes())
-	if err != nil {
-		return response.ErrroCode_InternalUnknownError.Wrap(err, "send email fail")
-	}
-	return nil
 }
 
-// EmailRegister do email register, will send an email activate email to user
-func (c *UserCtrl) EmailRegister(email string, password *dal.Password) (*dal.User, response.SError) {
-	db := service.GetDBExecutor()
-	user, sErr := dal.UserDBHD.GetByEmail(db, email)
+// EmailRegister do email register, will send an email activate email to user in the language,
+// the language is the locale of the emails sent to the user, empty means the default one
+func (c *UserCtrl) EmailRegister(ctx context.Context, email string, password *dal.Password, language string) (*dal.User, response.SError) {
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByEmail(db, email)
 	if sErr != nil {
 		return nil, sErr
 	}
@@ -180,15 +237,16 @@ func (c *UserCtrl) EmailRegister(email string, password *dal.Password) (*dal.Use
 		EmailActive:      false,
 		Password:         password,
 		UserRegisterType: dal.UserRegisterTypeEmail,
+		Language:         language,
 	}
 
-	sErr = WithDBTx(db, func(tx *gorm.DB) response.SError {
-		sErr = dal.UserDBHD.Add(tx, user)
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		sErr = c.repos.Users.Add(tx, user)
 		if sErr != nil {
 			return sErr
 		}
 
-		sErr = c.sendActivateEmail(email, uid)
+		sErr = c.sendActivateEmail(ctx, tx, email, language, uid)
 		if sErr != nil {
 			return sErr
 		}
@@ -203,8 +261,8 @@ func (c *UserCtrl) EmailRegister(email string, password *dal.Password) (*dal.Use
 
 // CheckEmailActivated check whether user has activated account email
 //func (c *UserCtrl) CheckEmailActivated(uid dal.UID) (bool, response.SError) {
-//	db := service.GetDBExecutor()
-//	user, sErr := dal.UserDBHD.GetByUID(db, uid)
+//	db := c.repos.DB(ctx)
+//	user, sErr := c.repos.Users.GetByUID(db, uid)
 //	if sErr != nil {
 //		return false, sErr
 //	}
@@ -214,9 +272,9 @@ func (c *UserCtrl) EmailRegister(email string, password *dal.Password) (*dal.Use
 //	return user.EmailActive, nil
 //}
 
-func (c *UserCtrl) ResendActivateEmail(uid dal.UID) response.SError {
-	db := service.GetDBExecutor()
-	user, sErr := dal.UserDBHD.GetByUID(db, uid)
+func (c *UserCtrl) ResendActivateEmail(ctx context.Context, uid dal.UID) response.SError {
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByUID(db, uid)
 	if sErr != nil {
 		return sErr
 	}
@@ -233,66 +291,54 @@ func (c *UserCtrl) ResendActivateEmail(uid dal.UID) response.SError {
 		return response.ErrorCode_UserNoPermission.New("user not registered by email")
 	}
 
-	sErr = c.sendActivateEmail(*user.Email, user.UID)
+	sErr = c.sendActivateEmail(ctx, db, *user.Email, user.Language, user.UID)
 	if sErr != nil {
 		return sErr
 	}
 	return nil
 }
 
-// EmailActivate confirm email activate, create a new user if activate success
-func (c *UserCtrl) EmailActivate(activateCode string) (*dal.User, string /*user token*/, response.SError) {
+// EmailActivate confirm email activate, mark the user email activated if activate success
+func (c *UserCtrl) EmailActivate(ctx context.Context, activateCode string) (*dal.User, response.SError) {
 	// verify activate code
 	claims := &jwt.RegisteredClaims{}
 	_, err := jwt.ParseWithClaims(activateCode, claims, func(token *jwt.Token) (interface{}, error) {
 		return []byte(config.GlobalConfig.JWT.Cypher), nil
 	})
 	if err != nil {
-		return nil, "", response.ErrorCode_UserNoPermission.Wrap(err, "invalid activate code")
+		return nil, response.ErrorCode_UserNoPermission.Wrap(err, "invalid activate code")
 	}
-	db := service.GetDBExecutor()
+	if claims.Subject != emailActivateCodeSubject {
+		return nil, response.ErrorCode_UserNoPermission.New("invalid activate code, not an activate code")
+	}
+	db := c.repos.DB(ctx)
 	uid := dal.UID(claims.ID)
-	user, sErr := dal.UserDBHD.GetByUID(db, uid)
+	user, sErr := c.repos.Users.GetByUID(db, uid)
 	if sErr != nil {
-		return nil, "", sErr
+		return nil, sErr
 	}
 	if user == nil {
-		return nil, "", response.ErrorCode_InvalidParam.Wrap(err, "invalid activate code, no user found")
+		return nil, response.ErrorCode_InvalidParam.Wrap(err, "invalid activate code, no user found")
 	}
 	if user.EmailActive {
-		return nil, "", response.ErrorCode_UserNoPermission.Wrap(err, "email already activated")
+		return nil, response.ErrorCode_UserNoPermission.Wrap(err, "email already activated")
 	}
 
-	// do activate
-	var tokenStr string
-	sErr = WithDBTx(db, func(tx *gorm.DB) response.SError {
-		// mark user email activated
-		sErr = dal.UserDBHD.UpdateUser(tx, uid, &dal.UserUpdatableFields{EmailActive: util.LiteralValuePtr(true)})
-		if sErr != nil {
-			return sErr
-		}
-		// gen user token
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(userTokenExpireTime)),
-			ID:        xid.New().String(),
-		})
-		tokenStr, err = token.SignedString([]byte(config.GlobalConfig.JWT.Cypher))
-		if err != nil {
-			return response.ErrroCode_InternalUnknownError.Wrap(err, "sign user token fail")
-		}
-		return nil
-	})
+	// mark user email activated
+	sErr = c.repos.Users.UpdateUser(db, uid, &dal.UserUpdatableFields{EmailActive: util.LiteralValuePtr(true)})
 	if sErr != nil {
-		return nil, "", sErr
+		return nil, sErr
 	}
 	user.EmailActive = true
-	return user, tokenStr, nil
+	return user, nil
 }
 
-// StartEmailBinding begin email bind process, will send an email bind email to user
-func (c *UserCtrl) StartEmailBinding(uid dal.UID, email string) response.SError {
-	db := service.GetDBExecutor()
-	user, sErr := dal.UserDBHD.GetByUID(db, uid)
+// StartEmailBinding begin email bind process, a pending change is recorded and a confirm email is sent to the new address,
+// the old address is notified with a cancel link if the user has one, a former pending change of the user is canceled,
+// sid is the session starting the change, which is kept when the other sessions are revoked on confirm
+func (c *UserCtrl) StartEmailBinding(ctx context.Context, uid dal.UID, sid string, email string) response.SError {
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByUID(db, uid)
 	if sErr != nil {
 		return sErr
 	}
@@ -305,28 +351,254 @@ func (c *UserCtrl) StartEmailBinding(uid dal.UID, email string) response.SError
 		return response.ErrorCode_InvalidParam.New("same email with the email already bond")
 	}
 
-	sErr = c.sendEmailBindEmail(email, uid)
+	emailUser, sErr := c.repos.Users.GetByEmail(db, email)
 	if sErr != nil {
 		return sErr
 	}
+	if emailUser != nil {
+		return response.ErrorCode_InvalidParam.New("email already used by another account")
+	}
+
+	token, err := util.RandomToken(32)
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "generate bind token fail")
+	}
+	cancelToken, cancelHash := "", ""
+	if user.Email != nil {
+		cancelToken, err = util.RandomToken(32)
+		if err != nil {
+			return response.ErrroCode_InternalUnknownError.Wrap(err, "generate bind cancel token fail")
+		}
+		cancelHash = util.HashToken(cancelToken)
+	}
 
+	now := time.Now().UTC()
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		sErr = c.repos.EmailBindRequests.CancelPendingByUID(tx, uid, now)
+		if sErr != nil {
+			return sErr
+		}
+		sErr = c.repos.EmailBindRequests.Add(tx, &dal.EmailBindRequest{
+			UID:        uid,
+			SID:        sid,
+			OldEmail:   user.Email,
+			NewEmail:   email,
+			TokenHash:  util.HashToken(token),
+			CancelHash: cancelHash,
+			CreateAt:   now,
+			ExpireAt:   now.Add(emailCodeExpireTime),
+		})
+		if sErr != nil {
+			return sErr
+		}
+
+		sErr = c.sendEmailBindEmail(tx, email, user.Language, token)
+		if sErr != nil {
+			return sErr
+		}
+		if user.Email != nil {
+			return c.sendEmailBindNoticeEmail(tx, *user.Email, user.Language, email, cancelToken)
+		}
+		return nil
+	})
+	if sErr != nil {
+		return sErr
+	}
 	return nil
 }
 
-// ConfirmBindEmail confirm bind user email, update user email info
-func (c *UserCtrl) ConfirmBindEmail(bindCode string) response.SError {
-	// verify bind code
-	claims := &jwt.RegisteredClaims{}
-	_, err := jwt.ParseWithClaims(bindCode, claims, func(token *jwt.Token) (interface{}, error) {
+// ConfirmBindEmail confirm a pending email change, the new email replaces the old one if it is still not used by another user,
+// and the sessions of the user except the one starting the change are revoked, return the confirmed request
+func (c *UserCtrl) ConfirmBindEmail(ctx context.Context, bindCode string) (*dal.EmailBindRequest, response.SError) {
+	db := c.repos.DB(ctx)
+	req, sErr := c.repos.EmailBindRequests.GetByTokenHash(db, util.HashToken(bindCode))
+	if sErr != nil {
+		return nil, sErr
+	}
+	now := time.Now().UTC()
+	if req == nil || !req.IsPending(now) {
+		return nil, response.ErrorCode_UserNoPermission.New("invalid, used or expired bind code")
+	}
+
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		confirmed, sErr := c.repos.EmailBindRequests.Confirm(tx, req.ID, now)
+		if sErr != nil {
+			return sErr
+		}
+		if !confirmed {
+			return response.ErrorCode_UserNoPermission.New("invalid, used or expired bind code")
+		}
+
+		emailUser, sErr := c.repos.Users.GetByEmail(tx, req.NewEmail)
+		if sErr != nil {
+			return sErr
+		}
+		if emailUser != nil {
+			return response.ErrorCode_InvalidParam.New("email already used by another account")
+		}
+
+		// the unique key of email makes the swap fail if another user takes the email concurrently
+		sErr = c.repos.Users.UpdateUser(tx, req.UID, &dal.UserUpdatableFields{
+			Email:       req.NewEmail,
+			EmailActive: util.LiteralValuePtr(true),
+			EmailBind:   util.LiteralValuePtr(true),
+		})
+		if sErr != nil {
+			return sErr
+		}
+		return c.repos.Sessions.RevokeByUID(tx, req.UID, req.SID, now)
+	})
+	if sErr != nil {
+		return nil, sErr
+	}
+	return req, nil
+}
+
+// CancelBindEmail cancel a pending email change by the cancel code sent to the old email, or revert the change if it is
+// confirmed within emailBindRevertTime, see revertBindEmail
+func (c *UserCtrl) CancelBindEmail(ctx context.Context, cancelCode string) response.SError {
+	db := c.repos.DB(ctx)
+	req, sErr := c.repos.EmailBindRequests.GetByCancelHash(db, util.HashToken(cancelCode))
+	if sErr != nil {
+		return sErr
+	}
+	now := time.Now().UTC()
+	if req != nil && req.IsRevertable(now, emailBindRevertTime) {
+		return c.revertBindEmail(ctx, req, now)
+	}
+	if req == nil || !req.IsPending(now) {
+		return response.ErrorCode_UserNoPermission.New("invalid cancel code or the email change is no longer revertable")
+	}
+
+	canceled, sErr := c.repos.EmailBindRequests.Cancel(db, req.ID, now)
+	if sErr != nil {
+		return sErr
+	}
+	if !canceled {
+		return response.ErrorCode_UserNoPermission.New("invalid cancel code or the email change is no longer revertable")
+	}
+	return nil
+}
+
+// revertBindEmail restore the old email of a confirmed email change, all the sessions of the user are revoked and the
+// pending changes are canceled, as the change may be made by someone taking over the account
+func (c *UserCtrl) revertBindEmail(ctx context.Context, req *dal.EmailBindRequest, now time.Time) response.SError {
+	return c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		reverted, sErr := c.repos.EmailBindRequests.Revert(tx, req.ID, now)
+		if sErr != nil {
+			return sErr
+		}
+		if !reverted {
+			return response.ErrorCode_UserNoPermission.New("invalid cancel code or the email change is no longer revertable")
+		}
+
+		emailUser, sErr := c.repos.Users.GetByEmail(tx, *req.OldEmail)
+		if sErr != nil {
+			return sErr
+		}
+		if emailUser != nil && emailUser.UID != req.UID {
+			return response.ErrorCode_InvalidParam.New("the old email already used by another account")
+		}
+
+		sErr = c.repos.EmailBindRequests.CancelPendingByUID(tx, req.UID, now)
+		if sErr != nil {
+			return sErr
+		}
+		sErr = c.repos.Users.UpdateUser(tx, req.UID, &dal.UserUpdatableFields{
+			Email:       *req.OldEmail,
+			EmailActive: util.LiteralValuePtr(true),
+		})
+		if sErr != nil {
+			return sErr
+		}
+		return c.repos.Sessions.RevokeByUID(tx, req.UID, "", now)
+	})
+}
+
+// StartPasswordReset send a password reset email to the user registered with the email,
+// nothing is sent and no error is returned if the email is not registered to not leak registered emails
+func (c *UserCtrl) StartPasswordReset(ctx context.Context, email string) response.SError {
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByEmail(db, email)
+	if sErr != nil {
+		return sErr
+	}
+	if user == nil {
+		return nil
+	}
+	return c.sendResetPasswordEmail(db, user)
+}
+
+// ResetPassword set a new password by a password reset code, all the outstanding reset codes and
+// sessions of the user are invalidated
+func (c *UserCtrl) ResetPassword(ctx context.Context, resetCode string, password *dal.Password) (*dal.User, response.SError) {
+	claims := &passwordResetClaims{}
+	_, err := jwt.ParseWithClaims(resetCode, claims, func(token *jwt.Token) (interface{}, error) {
 		return []byte(config.GlobalConfig.JWT.Cypher), nil
 	})
 	if err != nil {
-		return response.ErrorCode_UserNoPermission.Wrap(err, "invalid activate code")
+		return nil, response.ErrorCode_UserNoPermission.Wrap(err, "invalid reset code")
+	}
+	if claims.Subject != passwordResetCodeSubject {
+		return nil, response.ErrorCode_UserNoPermission.New("invalid reset code, not a password reset code")
+	}
+
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByUID(db, dal.UID(claims.ID))
+	if sErr != nil {
+		return nil, sErr
+	}
+	if user == nil {
+		return nil, response.ErrorCode_InvalidParam.New("invalid reset code, no user found")
+	}
+	if claims.PasswordFingerprint != passwordFingerprint(user) {
+		return nil, response.ErrorCode_UserNoPermission.New("reset code already used or expired")
+	}
+
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		// the reset email proves the ownership of the email, so mark it activated as well
+		sErr = c.repos.Users.UpdateUser(tx, user.UID, &dal.UserUpdatableFields{
+			EmailActive: util.LiteralValuePtr(true),
+			Password:    password,
+		})
+		if sErr != nil {
+			return sErr
+		}
+		return c.repos.Sessions.RevokeByUID(tx, user.UID, "", time.Now().UTC())
+	})
+	if sErr != nil {
+		return nil, sErr
+	}
+	user.EmailActive = true
+	return user, nil
+}
+
+// ChangePassword change the password of a user after verifying the current one,
+// all the sessions of the user except the current one are revoked
+func (c *UserCtrl) ChangePassword(ctx context.Context, uid dal.UID, currentSID string, oldPassword *dal.Password, newPassword *dal.Password) response.SError {
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByUID(db, uid)
+	if sErr != nil {
+		return sErr
+	}
+	if user == nil {
+		return response.ErrorCode_InvalidParam.New("invalid uid, no user found")
+	}
+
+	if oldPassword.Data == newPassword.Data {
+		return response.ErrorCode_InvalidParam.New("new password is the same as the old one")
+	}
+	sErr = checkUserPassword(user, oldPassword)
+	if sErr != nil {
+		return sErr
 	}
 
-	db := service.GetDBExecutor()
-	sErr := dal.UserDBHD.UpdateUser(db, dal.UID(claims.ID), &dal.UserUpdatableFields{
-		Email: claims.Subject,
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		sErr = c.repos.Users.UpdateUser(tx, uid, &dal.UserUpdatableFields{Password: newPassword})
+		if sErr != nil {
+			return sErr
+		}
+		return c.repos.Sessions.RevokeByUID(tx, uid, currentSID, time.Now().UTC())
 	})
 	if sErr != nil {
 		return sErr
@@ -334,28 +606,175 @@ func (c *UserCtrl) ConfirmBindEmail(bindCode string) response.SError {
 	return nil
 }
 
-func (c *UserCtrl) LoginByEmail(email string, password *dal.Password) (*dal.User, response.SError) {
-	db := service.GetDBExecutor()
-	user, sErr := dal.UserDBHD.GetByEmail(db, email)
+func (c *UserCtrl) LoginByEmail(ctx context.Context, email string, password *dal.Password) (*dal.User, response.SError) {
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByEmail(db, email)
 	if sErr != nil {
 		return nil, sErr
 	}
 	if user == nil {
 		return nil, response.ErrorCode_InvalidParam.New("email not registered")
 	}
-	if user.Password == nil {
-		return nil, response.ErrorCode_InvalidParam.New("user has not set password yet")
+	sErr = checkUserPassword(user, password)
+	if sErr != nil {
+		return nil, sErr
 	}
-	err := bcrypt.CompareHashAndPassword([]byte(user.Password.Data), []byte(password.Data))
+	if user.DeleteAt != nil {
+		return nil, response.ErrorCode_UserNoPermission.New("account is pending deletion, restore it to login")
+	}
+	return user, nil
+}
+
+// loginCodeHash get the keyed hash of a login code, a plain hash of a short code could be brute forced offline
+func loginCodeHash(uid dal.UID, code string) string {
+	mac := hmac.New(sha256.New, []byte(config.GlobalConfig.JWT.Cypher))
+	mac.Write([]byte(string(uid) + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// StartEmailLogin send a one-time login code and magic link to the user registered with the email,
+// the former outstanding ones are invalidated, nothing is sent and no error is returned if the email
+// is not registered to not leak registered emails, the code is also sent to an account pending deletion
+// since it can re-authenticate the user to restore the account
+func (c *UserCtrl) StartEmailLogin(ctx context.Context, email string) response.SError {
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByEmail(db, email)
+	if sErr != nil {
+		return sErr
+	}
+	if user == nil {
+		return nil
+	}
+
+	code, err := util.RandomDigits(loginCodeLength)
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "generate login code fail")
+	}
+	linkToken, err := util.RandomToken(32)
 	if err != nil {
-		return nil, response.ErrorCode_InvalidParam.New("wrong password")
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "generate login link token fail")
+	}
+
+	now := time.Now().UTC()
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		sErr = c.repos.LoginCodes.DeleteByUID(tx, user.UID)
+		if sErr != nil {
+			return sErr
+		}
+		sErr = c.repos.LoginCodes.Add(tx, &dal.LoginCode{
+			UID:      user.UID,
+			CodeHash: loginCodeHash(user.UID, code),
+			LinkHash: util.HashToken(linkToken),
+			CreateAt: now,
+			ExpireAt: now.Add(loginCodeExpireTime),
+		})
+		if sErr != nil {
+			return sErr
+		}
+
+		return c.sendTemplateEmail(tx, *user.Email, user.Language, mailtemplate.NameLogin, &emailLoginTmplFiller{
+			Code: code,
+			LoginLink: fmt.Sprintf("%s?%s=%s", config.GlobalConfig.EmailService.LoginURI,
+				config.GlobalConfig.EmailService.LoginParam, linkToken),
+			ExpireMinutes: int(loginCodeExpireTime / time.Minute),
+		})
+	})
+	if sErr != nil {
+		return sErr
+	}
+	return nil
+}
+
+// LoginByEmailCode login by the one-time code sent to the email, works for users without password,
+// the code is invalidated after too many failed attempts
+func (c *UserCtrl) LoginByEmailCode(ctx context.Context, email string, code string) (*dal.User, response.SError) {
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByEmail(db, email)
+	if sErr != nil {
+		return nil, sErr
 	}
+	if user == nil {
+		return nil, response.ErrorCode_UserAuthFail.New("invalid or expired login code")
+	}
+
+	loginCode, sErr := c.checkLoginCode(db, user, code)
+	if sErr != nil {
+		return nil, sErr
+	}
+	return c.finishEmailLogin(ctx, user, loginCode)
+}
+
+// checkLoginCode verify the code against the latest login code of the user, a wrong code counts a failed attempt
+func (c *UserCtrl) checkLoginCode(db *gorm.DB, user *dal.User, code string) (*dal.LoginCode, response.SError) {
+	loginCode, sErr := c.repos.LoginCodes.GetLatestByUID(db, user.UID)
+	if sErr != nil {
+		return nil, sErr
+	}
+	if loginCode == nil || !loginCode.IsActive(time.Now().UTC(), loginCodeMaxAttempts) {
+		return nil, response.ErrorCode_UserAuthFail.New("invalid or expired login code")
+	}
+	if !hmac.Equal([]byte(loginCode.CodeHash), []byte(loginCodeHash(user.UID, code))) {
+		sErr = c.repos.LoginCodes.IncreaseAttempts(db, loginCode.ID)
+		if sErr != nil {
+			return nil, sErr
+		}
+		return nil, response.ErrorCode_UserAuthFail.New("wrong login code")
+	}
+	return loginCode, nil
+}
+
+// LoginByEmailLink login by the magic link token sent to the email, works for users without password
+func (c *UserCtrl) LoginByEmailLink(ctx context.Context, linkToken string) (*dal.User, response.SError) {
+	db := c.repos.DB(ctx)
+	loginCode, sErr := c.repos.LoginCodes.GetByLinkHash(db, util.HashToken(linkToken))
+	if sErr != nil {
+		return nil, sErr
+	}
+	if loginCode == nil || !loginCode.IsActive(time.Now().UTC(), loginCodeMaxAttempts) {
+		return nil, response.ErrorCode_UserAuthFail.New("invalid or expired login link")
+	}
+
+	user, sErr := c.repos.Users.GetByUID(db, loginCode.UID)
+	if sErr != nil {
+		return nil, sErr
+	}
+	if user == nil {
+		return nil, response.ErrorCode_UserAuthFail.New("invalid login link, no user found")
+	}
+	return c.finishEmailLogin(ctx, user, loginCode)
+}
+
+// finishEmailLogin consume the verified login code, the email is marked activated since the user proves the ownership
+func (c *UserCtrl) finishEmailLogin(ctx context.Context, user *dal.User, loginCode *dal.LoginCode) (*dal.User, response.SError) {
+	if user.DeleteAt != nil {
+		return nil, response.ErrorCode_UserNoPermission.New("account is pending deletion, restore it to login")
+	}
+
+	sErr := c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		consumed, sErr := c.repos.LoginCodes.Consume(tx, loginCode.ID, time.Now().UTC())
+		if sErr != nil {
+			return sErr
+		}
+		if !consumed {
+			return response.ErrorCode_UserAuthFail.New("login code already used")
+		}
+		if user.EmailActive {
+			return nil
+		}
+		return c.repos.Users.UpdateUser(tx, user.UID, &dal.UserUpdatableFields{
+			EmailActive: util.LiteralValuePtr(true),
+		})
+	})
+	if sErr != nil {
+		return nil, sErr
+	}
+	user.EmailActive = true
 	return user, nil
 }
 
-func (c *UserCtrl) GetUserByUID(uid dal.UID) (*dal.User, response.SError) {
-	db := service.GetDBExecutor()
-	user, sErr := dal.UserDBHD.GetByUID(db, uid)
+func (c *UserCtrl) GetUserByUID(ctx context.Context, uid dal.UID) (*dal.User, response.SError) {
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByUID(db, uid)
 	if sErr != nil {
 		return nil, sErr
 	}
@@ -372,10 +791,9 @@ type UpdateUserBaseInfoFields struct {
 	Portrait *multipart.FileHeader
 }
 
-func (c *UserCtrl) UpdateUserBaseInfo(uid dal.UID, updateFields *UpdateUserBaseInfoFields) (*dal.User, response.SError) {
-	ctx := context.Background()
-	db := service.GetDBExecutor()
-	user, sErr := dal.UserDBHD.GetByUID(db, uid)
+func (c *UserCtrl) UpdateUserBaseInfo(ctx context.Context, uid dal.UID, updateFields *UpdateUserBaseInfoFields) (*dal.User, response.SError) {
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByUID(db, uid)
 	if sErr != nil {
 		return nil, sErr
 	}
@@ -412,8 +830,8 @@ func (c *UserCtrl) UpdateUserBaseInfo(uid dal.UID, updateFields *UpdateUserBaseI
 		user.PortraitURL = service.GetObjectStorageExecutor().ObjectKeyToURL(updates.Portrait)
 	}
 
-	sErr = WithDBTx(db, func(tx *gorm.DB) response.SError {
-		sErr = dal.UserDBHD.UpdateUser(tx, uid, updates)
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		sErr = c.repos.Users.UpdateUser(tx, uid, updates)
 		if sErr != nil {
 			return sErr
 		}
@@ -433,15 +851,34 @@ func (c *UserCtrl) UpdateUserBaseInfo(uid dal.UID, updateFields *UpdateUserBaseI
 	return user, nil
 }
 
+// UpdateLanguage update the language preference of the user, which is the locale of the emails sent to the user
+func (c *UserCtrl) UpdateLanguage(ctx context.Context, uid dal.UID, language string) (*dal.User, response.SError) {
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByUID(db, uid)
+	if sErr != nil {
+		return nil, sErr
+	}
+	if user == nil {
+		return nil, response.ErrorCode_InvalidParam.New("invalid uid, no user found")
+	}
+
+	sErr = c.repos.Users.UpdateUser(db, uid, &dal.UserUpdatableFields{Language: &language})
+	if sErr != nil {
+		return nil, sErr
+	}
+	user.Language = language
+	return user, nil
+}
+
 type SimplifiedUser struct {
 	UID      dal.UID `json:"uid"`
 	Name     *string `json:"name"`
 	Portrait string  `json:"portrait"`
 }
 
-func (c *UserCtrl) SearchUserByNameOrUID(text string) ([]*SimplifiedUser, response.SError) {
-	db := service.GetDBExecutor()
-	users, sErr := dal.UserDBHD.SearchUserByNameOrUID(db, text, &dal.Pagination{
+func (c *UserCtrl) SearchUserByNameOrUID(ctx context.Context, text string) ([]*SimplifiedUser, response.SError) {
+	db := c.repos.DB(ctx)
+	users, sErr := c.repos.Users.SearchUserByNameOrUID(db, text, &dal.Pagination{
 		Page:     1,
 		PageSize: 10, // default only show ten people
 	})
@@ -460,3 +897,339 @@ func (c *UserCtrl) SearchUserByNameOrUID(text string) ([]*SimplifiedUser, respon
 
 	return simplifiedUsers, nil
 }
+
+// checkUserPassword re-authenticate a user by password
+func checkUserPassword(user *dal.User, password *dal.Password) response.SError {
+	if user.Password == nil {
+		return response.ErrorCode_InvalidParam.New("user has not set password yet")
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(user.Password.Data), []byte(password.Data))
+	if err != nil {
+		return response.ErrorCode_InvalidParam.New("wrong password")
+	}
+	return nil
+}
+
+// Reauth the factors to re-authenticate a user before a sensitive operation, any one of them is enough
+type Reauth struct {
+	Password  *dal.Password // the password of the user
+	LoginCode string        // the login code sent to the email of the user by StartEmailLogin
+	SID       string        // the session making the request, enough if it logged in within reauthRecentLoginTime
+}
+
+// reauthUser re-authenticate a user by the first factor given in reauth, the users without password,
+// like the ones registered by wechat or oauth, can re-authenticate by a login code or a recent login
+func (c *UserCtrl) reauthUser(ctx context.Context, user *dal.User, reauth *Reauth) response.SError {
+	if reauth.Password != nil {
+		return checkUserPassword(user, reauth.Password)
+	}
+
+	db := c.repos.DB(ctx)
+	now := time.Now().UTC()
+	if reauth.LoginCode != "" {
+		loginCode, sErr := c.checkLoginCode(db, user, reauth.LoginCode)
+		if sErr != nil {
+			return sErr
+		}
+		consumed, sErr := c.repos.LoginCodes.Consume(db, loginCode.ID, now)
+		if sErr != nil {
+			return sErr
+		}
+		if !consumed {
+			return response.ErrorCode_UserAuthFail.New("login code already used")
+		}
+		return nil
+	}
+	if reauth.SID != "" {
+		session, sErr := c.repos.Sessions.GetBySID(db, reauth.SID)
+		if sErr != nil {
+			return sErr
+		}
+		if session == nil || session.UID != user.UID || !session.IsActive(now) || now.Sub(session.CreateAt) > reauthRecentLoginTime {
+			return response.ErrorCode_UserAuthFail.New("not logged in recently, re-authenticate by password or login code")
+		}
+		return nil
+	}
+	return response.ErrorCode_InvalidParam.New("password or login code required")
+}
+
+// DeleteAccount delete a user account after re-authenticate by reauth,
+// if a delete grace period is configured, the account is only scheduled to be erased and can be restored
+// before the returned time, otherwise all the user data is erased immediately and nil time is returned
+func (c *UserCtrl) DeleteAccount(ctx context.Context, uid dal.UID, reauth *Reauth) (*time.Time, response.SError) {
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByUID(db, uid)
+	if sErr != nil {
+		return nil, sErr
+	}
+	if user == nil {
+		return nil, response.ErrorCode_InvalidParam.New("invalid uid, no user found")
+	}
+	if user.DeleteAt != nil {
+		return nil, response.ErrorCode_InvalidParam.New("account deletion already requested")
+	}
+
+	sErr = c.reauthUser(ctx, user, reauth)
+	if sErr != nil {
+		return nil, sErr
+	}
+
+	gracePeriod := config.GlobalConfig.Account.DeleteGracePeriod
+	if gracePeriod <= 0 {
+		return nil, c.eraseUser(ctx, user)
+	}
+
+	now := time.Now().UTC()
+	deleteAt := now.Add(gracePeriod)
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		sErr = c.repos.Users.SetDeleteAt(tx, uid, &deleteAt)
+		if sErr != nil {
+			return sErr
+		}
+		return c.repos.Sessions.RevokeByUID(tx, uid, "", now)
+	})
+	if sErr != nil {
+		return nil, sErr
+	}
+	return &deleteAt, nil
+}
+
+// RestoreAccount cancel the deletion of an account which is still in the delete grace period,
+// the user is re-authenticated by the password or the login code in reauth, the sessions are revoked on deletion
+func (c *UserCtrl) RestoreAccount(ctx context.Context, email string, reauth *Reauth) (*dal.User, response.SError) {
+	db := c.repos.DB(ctx)
+	user, sErr := c.repos.Users.GetByEmail(db, email)
+	if sErr != nil {
+		return nil, sErr
+	}
+	if user == nil {
+		return nil, response.ErrorCode_InvalidParam.New("email not registered")
+	}
+
+	sErr = c.reauthUser(ctx, user, reauth)
+	if sErr != nil {
+		return nil, sErr
+	}
+
+	if user.DeleteAt == nil {
+		return nil, response.ErrorCode_InvalidParam.New("account deletion not requested")
+	}
+
+	sErr = c.repos.Users.SetDeleteAt(db, user.UID, nil)
+	if sErr != nil {
+		return nil, sErr
+	}
+	user.DeleteAt = nil
+	return user, nil
+}
+
+// accountPurgeBatchSize the max number of accounts erased in one PurgeDeletedAccounts call
+const accountPurgeBatchSize = 100
+
+// PurgeDeletedAccounts erase all the accounts whose delete grace period ended before now, an account failing
+// to erase is logged and skipped to erase the rest, return the number of accounts erased and an error listing
+// the accounts failed if any
+func (c *UserCtrl) PurgeDeletedAccounts(ctx context.Context, now time.Time) (int, response.SError) {
+	db := c.repos.DB(ctx)
+	users, sErr := c.repos.Users.ListDeleteDue(db, now, accountPurgeBatchSize)
+	if sErr != nil {
+		return 0, sErr
+	}
+	erased := 0
+	var failures []string
+	for _, user := range users {
+		sErr = c.eraseUser(ctx, user)
+		if sErr != nil {
+			hlog.CtxErrorf(ctx, "erase deleted account fail, uid: %s, err=%v", user.UID, sErr)
+			failures = append(failures, fmt.Sprintf("%s: %s", user.UID, sErr.Error()))
+			continue
+		}
+		erased++
+	}
+	if len(failures) > 0 {
+		return erased, response.ErrroCode_InternalUnknownError.New("erase %d of %d deleted accounts fail, %s",
+			len(failures), len(users), strings.Join(failures, "; "))
+	}
+	return erased, nil
+}
+
+// eraseUser remove the user from every habit group, handing habit ownership over as DeleteHabitByID does,
+// then erase the user's habit groups, configs, log records, sessions, identities, login codes, email bind requests,
+// two-factor settings, user record and portrait in one transaction. The habit rows of the user are removed for good
+// including the tombstones, so no uid of the user is kept or pulled by the former members through the change feed
+func (c *UserCtrl) eraseUser(ctx context.Context, user *dal.User) response.SError {
+	// the habits left and their other members, to push the change once committed
+	var leftHabits []uint64
+	var leftMembers [][]dal.UID
+	sErr := c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		leftHabits, leftMembers = nil, nil // reset on retry
+		hgs, sErr := c.repos.HabitGroups.ListByUID(tx, user.UID)
+		if sErr != nil {
+			return sErr
+		}
+		for _, hg := range hgs {
+			habit, sErr := c.repos.Habits.GetByID(tx, hg.HabitID, false)
+			if sErr != nil {
+				return sErr
+			}
+			if habit == nil { // dangling group info, erased below
+				continue
+			}
+			sErr = c.repos.leaveErasedHabit(tx, habit, user.UID)
+			if sErr != nil {
+				return sErr
+			}
+			members, sErr := c.repos.HabitGroups.ListByHabitID(tx, habit.ID)
+			if sErr != nil {
+				return sErr
+			}
+			var others []dal.UID
+			for _, member := range habitGroupUIDs(members) {
+				if member != user.UID {
+					others = append(others, member)
+				}
+			}
+			leftHabits = append(leftHabits, habit.ID)
+			leftMembers = append(leftMembers, others)
+		}
+
+		sErr = c.repos.HabitGroups.EraseByUID(tx, user.UID)
+		if sErr != nil {
+			return sErr
+		}
+
+		sErr = c.repos.UserHabitConfigs.EraseByUID(tx, user.UID)
+		if sErr != nil {
+			return sErr
+		}
+
+		sErr = c.repos.HabitLogRecords.EraseByUID(tx, user.UID)
+		if sErr != nil {
+			return sErr
+		}
+
+		sErr = c.repos.UnconfirmedHabitLogRecords.EraseByUID(tx, user.UID)
+		if sErr != nil {
+			return sErr
+		}
+
+		// the habits left without other members, and the tombstones of the habits deleted by the user
+		sErr = c.repos.Habits.EraseByOwner(tx, user.UID)
+		if sErr != nil {
+			return sErr
+		}
+
+		sErr = c.repos.Sessions.DeleteByUID(tx, user.UID)
+		if sErr != nil {
+			return sErr
+		}
+
+		sErr = c.repos.UserIdentities.DeleteByUID(tx, user.UID)
+		if sErr != nil {
+			return sErr
+		}
+
+		sErr = c.repos.LoginCodes.DeleteByUID(tx, user.UID)
+		if sErr != nil {
+			return sErr
+		}
+
+		sErr = c.repos.EmailBindRequests.DeleteByUID(tx, user.UID)
+		if sErr != nil {
+			return sErr
+		}
+
+		sErr = c.repos.UserTwoFactors.DeleteByUID(tx, user.UID)
+		if sErr != nil {
+			return sErr
+		}
+
+		sErr = c.repos.RecoveryCodes.DeleteByUID(tx, user.UID)
+		if sErr != nil {
+			return sErr
+		}
+
+		sErr = c.repos.SecurityEvents.DeleteByUID(tx, user.UID)
+		if sErr != nil {
+			return sErr
+		}
+
+		if user.Email != nil {
+			sErr = c.repos.OutboxMails.DeleteByToMail(tx, *user.Email)
+			if sErr != nil {
+				return sErr
+			}
+		}
+
+		sErr = c.repos.Users.DeleteByUID(tx, user.UID)
+		if sErr != nil {
+			return sErr
+		}
+
+		if user.Portrait != nil {
+			err := service.GetObjectStorageExecutor().DeleteObject(ctx, *user.Portrait)
+			if err != nil {
+				return response.ErrroCode_InternalUnknownError.Wrap(err, "delete portrait data fail")
+			}
+		}
+		return nil
+	})
+	if sErr != nil {
+		return sErr
+	}
+
+	for i, habitID := range leftHabits {
+		publishErasedMemberEvent(ctx, habitID, leftMembers[i])
+	}
+	return nil
+}
+
+// LoginByWechat login by a wechat mini-program login code, a new user is registered if the wechat user
+// has not logged in before, return the user and whether it is newly registered
+func (c *UserCtrl) LoginByWechat(ctx context.Context, code string) (*dal.User, bool, response.SError) {
+	session, err := service.GetWechatExecutor().Code2Session(ctx, code)
+	if err != nil {
+		return nil, false, response.ErrorCode_UserAuthFail.Wrap(err, "exchange wechat login code fail")
+	}
+
+	db := c.repos.DB(ctx)
+	identity, sErr := c.repos.UserIdentities.GetByProviderAndSubject(db, dal.IdentityProviderWechat, session.OpenID)
+	if sErr != nil {
+		return nil, false, sErr
+	}
+	if identity != nil {
+		user, sErr := c.repos.Users.GetByUID(db, identity.UID)
+		if sErr != nil {
+			return nil, false, sErr
+		}
+		if user == nil {
+			return nil, false, response.ErrroCode_InternalUnknownError.New("user of wechat identity not found")
+		}
+		if user.DeleteAt != nil {
+			return nil, false, response.ErrorCode_UserNoPermission.New("account is pending deletion")
+		}
+		return user, false, nil
+	}
+
+	now := time.Now().UTC()
+	user := &dal.User{
+		UID:              dal.UID(xid.New().String()),
+		UserRegisterType: dal.UserRegisterTypeWechat,
+	}
+	sErr = c.repos.Tx(ctx, func(tx *gorm.DB) response.SError {
+		sErr = c.repos.Users.Add(tx, user)
+		if sErr != nil {
+			return sErr
+		}
+		return c.repos.UserIdentities.Add(tx, &dal.UserIdentity{
+			UID:      user.UID,
+			Provider: dal.IdentityProviderWechat,
+			Subject:  session.OpenID,
+			CreateAt: now,
+		})
+	})
+	if sErr != nil {
+		return nil, false, sErr
+	}
+	return user, true, nil
+}
@@ -0,0 +1,553 @@
+package controller
+
+import (
+	"context"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/swordandtea/lets-habit-server/biz/config"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/ratelimit"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"github.com/swordandtea/lets-habit-server/biz/service"
+	"github.com/swordandtea/lets-habit-server/util"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signTestResetCode sign a password reset code of the user as sendResetPasswordEmail does
+func signTestResetCode(t *testing.T, user *dal.User) string {
+	claims := &passwordResetClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   passwordResetCodeSubject,
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(passwordResetCodeExpireTime)),
+			ID:        string(user.UID),
+		},
+		PasswordFingerprint: passwordFingerprint(user),
+	}
+	code, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.GlobalConfig.JWT.Cypher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestResetPassword(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	uid := dal.UID("u1")
+	addTestUser(t, db, uid, "u1@test.com", "oldPassw0rd")
+	user, sErr := dal.UserDBHD.GetByUID(db, uid) // the stored hash the fingerprint is taken from
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	ctrl := NewUserCtrl(NewRepositories())
+	sessionCtrl := NewSessionCtrl(NewRepositories())
+	session, _, sErr := sessionCtrl.CreateSession(ctx, uid, &SessionClient{DeviceName: "phone"})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	code := signTestResetCode(t, user)
+
+	_, sErr = ctrl.ResetPassword(ctx, "not a code", dal.NewRawPassword("newPassw0rd"))
+	if sErr == nil {
+		t.Fatal("invalid reset code should be rejected")
+	}
+
+	_, sErr = ctrl.ResetPassword(ctx, code, dal.NewRawPassword("newPassw0rd"))
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if sessionCtrl.VerifySession(ctx, uid, session.SID) == nil {
+		t.Fatal("sessions should be revoked by the reset")
+	}
+	_, sErr = ctrl.LoginByEmail(ctx, "u1@test.com", dal.NewRawPassword("newPassw0rd"))
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+
+	// the code is invalidated once the password changed
+	_, sErr = ctrl.ResetPassword(ctx, code, dal.NewRawPassword("otherPassw0rd"))
+	if sErr == nil {
+		t.Fatal("reset code should be used once")
+	}
+}
+
+func TestEmailActivateCodeSubject(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	uid := dal.UID("u1")
+	addTestUser(t, db, uid, "u1@test.com", "passw0rd1")
+	sErr := dal.UserDBHD.UpdateUser(db, uid, &dal.UserUpdatableFields{EmailActive: util.LiteralValuePtr(false)})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	user, sErr := dal.UserDBHD.GetByUID(db, uid)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	ctrl := NewUserCtrl(NewRepositories())
+
+	// another kind of code signed by the same key can not activate the email
+	_, sErr = ctrl.EmailActivate(ctx, signTestResetCode(t, user))
+	if sErr == nil {
+		t.Fatal("reset code should not be accepted as activate code")
+	}
+
+	claims := &jwt.RegisteredClaims{
+		Subject:   emailActivateCodeSubject,
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(emailCodeExpireTime)),
+		ID:        string(uid),
+	}
+	code, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.GlobalConfig.JWT.Cypher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, sErr = ctrl.EmailActivate(ctx, code)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if !user.EmailActive {
+		t.Fatal("email should be activated")
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	uid := dal.UID("u1")
+	addTestUser(t, db, uid, "u1@test.com", "oldPassw0rd")
+	ctrl := NewUserCtrl(NewRepositories())
+	sessionCtrl := NewSessionCtrl(NewRepositories())
+	current, _, sErr := sessionCtrl.CreateSession(ctx, uid, &SessionClient{DeviceName: "current"})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	other, _, sErr := sessionCtrl.CreateSession(ctx, uid, &SessionClient{DeviceName: "other"})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+
+	sErr = ctrl.ChangePassword(ctx, uid, current.SID, dal.NewRawPassword("wrongPassw0rd"), dal.NewRawPassword("newPassw0rd"))
+	if sErr == nil {
+		t.Fatal("change password with wrong old password should fail")
+	}
+
+	sErr = ctrl.ChangePassword(ctx, uid, current.SID, dal.NewRawPassword("oldPassw0rd"), dal.NewRawPassword("oldPassw0rd"))
+	if sErr == nil {
+		t.Fatal("change password to the same password should fail")
+	}
+
+	sErr = ctrl.ChangePassword(ctx, "not-exist", current.SID, dal.NewRawPassword("oldPassw0rd"), dal.NewRawPassword("newPassw0rd"))
+	if sErr == nil {
+		t.Fatal("change password of not exist user should fail")
+	}
+
+	sErr = ctrl.ChangePassword(ctx, uid, current.SID, dal.NewRawPassword("oldPassw0rd"), dal.NewRawPassword("newPassw0rd"))
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+
+	user, sErr := dal.UserDBHD.GetByUID(db, uid)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if !user.Password.Hashed || user.Password.Data == "newPassw0rd" {
+		t.Fatal("password not stored hashed")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password.Data), []byte("newPassw0rd")) != nil {
+		t.Fatal("new password not stored")
+	}
+	if sessionCtrl.VerifySession(ctx, uid, current.SID) != nil {
+		t.Fatal("current session should not be revoked")
+	}
+	if sessionCtrl.VerifySession(ctx, uid, other.SID) == nil {
+		t.Fatal("other session should be revoked")
+	}
+
+	_, sErr = ctrl.LoginByEmail(ctx, "u1@test.com", dal.NewRawPassword("oldPassw0rd"))
+	if sErr == nil {
+		t.Fatal("login with old password should fail")
+	}
+	_, sErr = ctrl.LoginByEmail(ctx, "u1@test.com", dal.NewRawPassword("newPassw0rd"))
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+}
+
+func TestLoginByWechat(t *testing.T) {
+	ctx := context.Background()
+	setupTestDB(t)
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"openid":"openid-` + r.URL.Query().Get("js_code") + `","session_key":"key"}`))
+	}))
+	defer stub.Close()
+	err := service.InitWechatService("app", "secret", stub.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl := NewUserCtrl(NewRepositories())
+
+	user, newUser, sErr := ctrl.LoginByWechat(ctx, "a")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if !newUser || user.UserRegisterType != dal.UserRegisterTypeWechat {
+		t.Fatal("first wechat login should register a wechat user")
+	}
+
+	again, newUser, sErr := ctrl.LoginByWechat(ctx, "a")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if newUser || again.UID != user.UID {
+		t.Fatal("second wechat login should find the registered user")
+	}
+
+	other, newUser, sErr := ctrl.LoginByWechat(ctx, "b")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if !newUser || other.UID == user.UID {
+		t.Fatal("another wechat user should be registered as a new user")
+	}
+}
+
+func TestResendActivateEmailInterval(t *testing.T) {
+	ctx := context.Background()
+	setupTestDB(t)
+	mailer := setupFakeMailService()
+	ratelimit.InitDefaultStore(ratelimit.NewMemoryStore())
+	ctrl := NewUserCtrl(NewRepositories())
+
+	user, sErr := ctrl.EmailRegister(ctx, "u1@test.com", dal.NewRawPassword("passw0rd1"), "")
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	sErr = ctrl.ResendActivateEmail(ctx, user.UID)
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_TooManyRequests {
+		t.Fatalf("resend activate email right after register should be rejected, got %v", sErr)
+	}
+	if len(mailer.sent(t)) != 1 {
+		t.Fatalf("expect 1 mail sent, got %d", len(mailer.sent(t)))
+	}
+}
+
+// addFakeUser add a user registered by email with password into the fake repos
+func addFakeUser(t *testing.T, repos *Repositories, uid dal.UID, password string) *dal.User {
+	email := string(uid) + "@test.com"
+	user := &dal.User{UID: uid, Email: &email, EmailActive: true, Password: dal.NewRawPassword(password),
+		UserRegisterType: dal.UserRegisterTypeEmail}
+	sErr := repos.Users.Add(nil, user)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	return user
+}
+
+// addFakeSession add an active session of the user into the fake repos
+func addFakeSession(t *testing.T, repos *Repositories, uid dal.UID, sid string) {
+	now := time.Now().UTC()
+	sErr := repos.Sessions.Add(nil, &dal.Session{SID: sid, UID: uid, CreateAt: now, LastUsedAt: now, ExpireAt: now.Add(time.Hour)})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+}
+
+func TestDeleteAccountImmediately(t *testing.T) {
+	ctx := context.Background()
+	config.GlobalConfig = &config.RuntimeConfig{}
+	repos := newFakeRepositories()
+	ctrl := NewUserCtrl(repos)
+	addFakeUser(t, repos, "alice", "passw0rd")
+	addFakeUser(t, repos, "bob", "passw0rd")
+	addFakeSession(t, repos, "alice", "s1")
+	shared := addFakeHabit(t, repos, dal.CheckDayAll, 0, 0, "alice", "bob")
+	alone := addFakeHabit(t, repos, dal.CheckDayAll, 0, 0, "alice")
+	quit := addFakeHabit(t, repos, dal.CheckDayAll, 0, 0, "bob", "alice")
+	habitCtrl := NewHabitCtrl(repos)
+	sErr := habitCtrl.DeleteHabitByID(ctx, quit.ID, "alice") // leaves the tombstones of alice
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	now := time.Now().UTC()
+	sErr = repos.HabitLogRecords.Add(nil, &dal.HabitLogRecord{HabitID: shared.ID, UID: "alice", LogAt: now, LogDay: now.Format("2006-01-02")})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	for _, to := range []string{"alice@test.com", "bob@test.com"} {
+		sErr = repos.OutboxMails.Add(nil, &dal.OutboxMail{ToMail: to, Content: []byte("hello"), Status: dal.OutboxMailStatusSent, CreateAt: now})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+	}
+	time.Sleep(time.Millisecond * 10)
+	cursor := encodeChangeCursor(time.Now())
+	time.Sleep(time.Millisecond * 10)
+
+	_, sErr = ctrl.DeleteAccount(ctx, "alice", &Reauth{Password: dal.NewRawPassword("wrong")})
+	if sErr == nil {
+		t.Fatal("should re-authenticate by password")
+	}
+	deleteAt, sErr := ctrl.DeleteAccount(ctx, "alice", &Reauth{Password: dal.NewRawPassword("passw0rd")})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if deleteAt != nil {
+		t.Fatal("should erase immediately without a grace period")
+	}
+
+	user, _ := repos.Users.GetByUID(nil, "alice")
+	session, _ := repos.Sessions.GetBySID(nil, "s1")
+	if user != nil || session != nil {
+		t.Fatal("the user and the sessions should be erased")
+	}
+	// the shared habit is handed over to the member left, the one of alice alone is deleted
+	habit, _ := repos.Habits.GetByID(nil, shared.ID, false)
+	if habit == nil || habit.Owner != "bob" {
+		t.Fatalf("expect the shared habit owned by bob, got %+v", habit)
+	}
+	members, _ := repos.HabitGroups.ListByHabitID(nil, shared.ID)
+	if len(members) != 1 || members[0].UID != "bob" {
+		t.Fatalf("expect bob left in the shared habit, got %d members", len(members))
+	}
+	habit, _ = repos.Habits.GetByID(nil, alone.ID, false)
+	if habit != nil {
+		t.Fatal("the habit of alice alone should be deleted")
+	}
+
+	// no row of alice is left, not even a tombstone
+	habitIDs := []uint64{shared.ID, alone.ID, quit.ID}
+	groups, _ := repos.HabitGroups.ListByHabitIDsUpdatedAfter(nil, habitIDs, time.Time{})
+	for _, hg := range groups {
+		if hg.UID == "alice" {
+			t.Fatalf("group of alice left, %+v", hg)
+		}
+	}
+	habits, _ := repos.Habits.ListByIDsUpdatedAfter(nil, habitIDs, time.Time{})
+	configs, _ := repos.UserHabitConfigs.ListByUIDUpdatedAfter(nil, "alice", time.Time{})
+	records, _ := repos.HabitLogRecords.ListByUIDUpdatedAfter(nil, "alice", time.Time{})
+	if len(habits) != 2 || len(configs) != 0 || len(records) != 0 {
+		t.Fatalf("expect the 2 habits of bob and no config and record of alice, got %d, %d, %d", len(habits), len(configs), len(records))
+	}
+
+	if count, _ := repos.OutboxMails.CountByStatus(nil, dal.OutboxMailStatusSent); count != 1 {
+		t.Fatalf("expect only the mail to bob left in the outbox, got %d", count)
+	}
+
+	// bob pulls the shared habit and all its members again, which drops alice
+	changes, sErr := habitCtrl.ListChanges(ctx, "bob", cursor)
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if len(changes.Habits) != 1 || changes.Habits[0].ID != shared.ID {
+		t.Fatalf("expect the shared habit changed, got %+v", changes.Habits)
+	}
+	for _, hg := range changes.HabitGroups {
+		if hg.UID == "alice" {
+			t.Fatalf("group of alice pulled, %+v", hg)
+		}
+	}
+	if len(changes.HabitGroups) != 1 || changes.HabitGroups[0].HabitID != shared.ID || changes.HabitGroups[0].DeleteAt.Valid {
+		t.Fatalf("expect bob listed as the only member of the shared habit, got %+v", changes.HabitGroups)
+	}
+}
+
+func TestDeleteAccountGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	config.GlobalConfig = &config.RuntimeConfig{Account: config.AccountConfig{DeleteGracePeriod: time.Hour * 24 * 7}}
+	repos := newFakeRepositories()
+	ctrl := NewUserCtrl(repos)
+	addFakeUser(t, repos, "alice", "passw0rd")
+	addFakeSession(t, repos, "alice", "s1")
+	habit := addFakeHabit(t, repos, dal.CheckDayAll, 0, 0, "alice")
+
+	deleteAt, sErr := ctrl.DeleteAccount(ctx, "alice", &Reauth{Password: dal.NewRawPassword("passw0rd")})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if deleteAt == nil || deleteAt.Before(time.Now().Add(time.Hour*24*6)) {
+		t.Fatalf("expect to be erased after the grace period, got %v", deleteAt)
+	}
+	session, _ := repos.Sessions.GetBySID(nil, "s1")
+	if session == nil || session.RevokeAt == nil {
+		t.Fatal("the sessions should be revoked")
+	}
+	if stored, _ := repos.Habits.GetByID(nil, habit.ID, false); stored == nil {
+		t.Fatal("nothing should be erased within the grace period")
+	}
+	_, sErr = ctrl.DeleteAccount(ctx, "alice", &Reauth{Password: dal.NewRawPassword("passw0rd")})
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_InvalidParam {
+		t.Fatal("should not request the deletion twice", sErr)
+	}
+
+	// login is refused until restored
+	_, sErr = ctrl.LoginByEmail(ctx, "alice@test.com", dal.NewRawPassword("passw0rd"))
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_UserNoPermission {
+		t.Fatal("login should be refused while the deletion is pending", sErr)
+	}
+	_, sErr = ctrl.RestoreAccount(ctx, "alice@test.com", &Reauth{Password: dal.NewRawPassword("wrong")})
+	if sErr == nil {
+		t.Fatal("should restore by password only")
+	}
+	user, sErr := ctrl.RestoreAccount(ctx, "alice@test.com", &Reauth{Password: dal.NewRawPassword("passw0rd")})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if user.DeleteAt != nil {
+		t.Fatal("the deletion should be canceled")
+	}
+	_, sErr = ctrl.LoginByEmail(ctx, "alice@test.com", dal.NewRawPassword("passw0rd"))
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	_, sErr = ctrl.RestoreAccount(ctx, "alice@test.com", &Reauth{Password: dal.NewRawPassword("passw0rd")})
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_InvalidParam {
+		t.Fatal("should not restore an account not pending deletion", sErr)
+	}
+
+	count, sErr := ctrl.PurgeDeletedAccounts(ctx, time.Now().Add(time.Hour*24*8))
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if count != 0 {
+		t.Fatalf("a restored account should not be purged, purged %d", count)
+	}
+}
+
+// failingSessionRepository fail to delete the sessions of a user
+type failingSessionRepository struct {
+	dal.SessionRepository
+	failUID dal.UID
+}
+
+func (r *failingSessionRepository) DeleteByUID(db *gorm.DB, uid dal.UID) response.SError {
+	if uid == r.failUID {
+		return response.ErrroCode_InternalUnknownError.New("delete user sessions fail")
+	}
+	return r.SessionRepository.DeleteByUID(db, uid)
+}
+
+func TestPurgeDeletedAccounts(t *testing.T) {
+	ctx := context.Background()
+	config.GlobalConfig = &config.RuntimeConfig{}
+	repos := newFakeRepositories()
+	repos.Sessions = &failingSessionRepository{SessionRepository: repos.Sessions, failUID: "carol"}
+	ctrl := NewUserCtrl(repos)
+	now := time.Now().UTC()
+	for _, u := range []struct {
+		uid      dal.UID
+		deleteAt *time.Time
+	}{
+		{"alice", util.LiteralValuePtr(now.Add(-time.Hour))},
+		{"bob", util.LiteralValuePtr(now.Add(time.Hour))},
+		{"carol", util.LiteralValuePtr(now.Add(-time.Hour))},
+		{"dave", util.LiteralValuePtr(now.Add(-time.Minute))},
+		{"erin", nil},
+	} {
+		addFakeUser(t, repos, u.uid, "passw0rd")
+		sErr := repos.Users.SetDeleteAt(nil, u.uid, u.deleteAt)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+	}
+	habit := addFakeHabit(t, repos, dal.CheckDayAll, 0, 0, "alice", "erin")
+
+	// the account failing to erase does not stop the others
+	count, sErr := ctrl.PurgeDeletedAccounts(ctx, now)
+	if count != 2 {
+		t.Fatalf("expect the 2 due accounts erased, got %d", count)
+	}
+	if sErr == nil || !strings.Contains(sErr.Message(), "carol") {
+		t.Fatal("should report the account failed", sErr)
+	}
+	for uid, exist := range map[dal.UID]bool{"alice": false, "bob": true, "carol": true, "dave": false, "erin": true} {
+		user, _ := repos.Users.GetByUID(nil, uid)
+		if (user != nil) != exist {
+			t.Fatalf("expect %s exist: %v", uid, exist)
+		}
+	}
+	stored, _ := repos.Habits.GetByID(nil, habit.ID, false)
+	if stored == nil || stored.Owner != "erin" {
+		t.Fatalf("expect the habit handed over to erin, got %+v", stored)
+	}
+}
+
+// addFakeLoginCode add an active login code of the user into the fake repos
+func addFakeLoginCode(t *testing.T, repos *Repositories, uid dal.UID, code string) {
+	now := time.Now().UTC()
+	sErr := repos.LoginCodes.Add(nil, &dal.LoginCode{UID: uid, CodeHash: loginCodeHash(uid, code), LinkHash: code,
+		CreateAt: now, ExpireAt: now.Add(loginCodeExpireTime)})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+}
+
+func TestReauthWithoutPassword(t *testing.T) {
+	ctx := context.Background()
+	config.GlobalConfig = &config.RuntimeConfig{Account: config.AccountConfig{DeleteGracePeriod: time.Hour * 24 * 7}}
+	config.GlobalConfig.JWT.Cypher = "test_cypher"
+	repos := newFakeRepositories()
+	ctrl := NewUserCtrl(repos)
+	for _, uid := range []dal.UID{"alice", "bob"} { // users registered without password
+		email := string(uid) + "@test.com"
+		sErr := repos.Users.Add(nil, &dal.User{UID: uid, Email: &email, EmailActive: true, UserRegisterType: dal.UserRegisterTypeWechat})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+	}
+	now := time.Now().UTC()
+	sErr := repos.Sessions.Add(nil, &dal.Session{SID: "old", UID: "alice", CreateAt: now.Add(-time.Hour), LastUsedAt: now, ExpireAt: now.Add(time.Hour)})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	addFakeSession(t, repos, "bob", "recent")
+
+	_, sErr = ctrl.DeleteAccount(ctx, "alice", &Reauth{SID: "old"})
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_UserAuthFail {
+		t.Fatal("a session logged in long ago should not re-authenticate", sErr)
+	}
+	_, sErr = ctrl.DeleteAccount(ctx, "alice", &Reauth{SID: "recent"})
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_UserAuthFail {
+		t.Fatal("the session of another user should not re-authenticate", sErr)
+	}
+	_, sErr = ctrl.DeleteAccount(ctx, "alice", &Reauth{Password: dal.NewRawPassword("passw0rd")})
+	if sErr == nil {
+		t.Fatal("a user without password should not re-authenticate by password")
+	}
+
+	addFakeLoginCode(t, repos, "alice", "123456")
+	_, sErr = ctrl.DeleteAccount(ctx, "alice", &Reauth{LoginCode: "654321"})
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_UserAuthFail {
+		t.Fatal("wrong login code should fail", sErr)
+	}
+	deleteAt, sErr := ctrl.DeleteAccount(ctx, "alice", &Reauth{LoginCode: "123456"})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if deleteAt == nil {
+		t.Fatal("expect to be erased after the grace period")
+	}
+
+	_, sErr = ctrl.RestoreAccount(ctx, "alice@test.com", &Reauth{LoginCode: "123456"})
+	if sErr == nil || sErr.ErrorCode() != response.ErrorCode_UserAuthFail {
+		t.Fatal("used login code should fail", sErr)
+	}
+	addFakeLoginCode(t, repos, "alice", "234567")
+	user, sErr := ctrl.RestoreAccount(ctx, "alice@test.com", &Reauth{LoginCode: "234567"})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	if user.DeleteAt != nil {
+		t.Fatal("the deletion should be canceled")
+	}
+
+	// a recent login is enough
+	_, sErr = ctrl.DeleteAccount(ctx, "bob", &Reauth{SID: "recent"})
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+}
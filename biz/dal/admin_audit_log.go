@@ -0,0 +1,79 @@
+package dal
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"time"
+)
+
+// AdminAction the action an admin takes
+type AdminAction string
+
+const (
+	AdminActionSearchUsers    AdminAction = "search_users"
+	AdminActionViewHabits     AdminAction = "view_habits"
+	AdminActionDisableUser    AdminAction = "disable_user"
+	AdminActionEnableUser     AdminAction = "enable_user"
+	AdminActionActivateEmail  AdminAction = "activate_email"
+	AdminActionResetStreak    AdminAction = "reset_streak"
+	AdminActionRemoveName     AdminAction = "remove_name"
+	AdminActionRemovePortrait AdminAction = "remove_portrait"
+	AdminActionViewAuditLogs  AdminAction = "view_audit_logs"
+)
+
+// AdminAuditLog a record of an action an admin took through the admin api
+type AdminAuditLog struct {
+	ID            uint64      `json:"id"`
+	AdminUID      UID         `json:"admin_uid"`
+	Action        AdminAction `json:"action"`
+	TargetUID     UID         `json:"target_uid"`
+	TargetHabitID uint64      `json:"target_habit_id"`
+	Detail        string      `json:"detail"`
+	IP            string      `json:"ip"`
+	CreateAt      time.Time   `json:"create_at"`
+}
+
+// AdminAuditLogRepository the operations on the admin_audit_log table, implemented by adminAuditLogDBHD
+type AdminAuditLogRepository interface {
+	Add(db *gorm.DB, l *AdminAuditLog) response.SError
+	List(db *gorm.DB, targetUID UID, pagination *Pagination) ([]*AdminAuditLog, uint, response.SError)
+}
+
+// adminAuditLogDBHD the handler to operate the admin_audit_log table
+type adminAuditLogDBHD struct{}
+
+// AdminAuditLogDBHD the default adminAuditLogDBHD
+var AdminAuditLogDBHD = &adminAuditLogDBHD{}
+
+var _ AdminAuditLogRepository = AdminAuditLogDBHD
+
+// Add insert an AdminAuditLog record
+func (hd *adminAuditLogDBHD) Add(db *gorm.DB, l *AdminAuditLog) response.SError {
+	err := db.Create(l).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "add admin audit log fail")
+	}
+	return nil
+}
+
+// List list the AdminAuditLogs, the latest first, only of the target user if targetUID is not empty,
+// return the logs and the total count
+func (hd *adminAuditLogDBHD) List(db *gorm.DB, targetUID UID, pagination *Pagination) ([]*AdminAuditLog, uint, response.SError) {
+	q := db.Model(&AdminAuditLog{})
+	if targetUID != "" {
+		q = q.Where("target_uid=?", targetUID)
+	}
+	var count int64
+	err := q.Count(&count).Error
+	if err != nil {
+		return nil, 0, response.ErrroCode_InternalUnknownError.Wrap(err, "list admin audit logs fail")
+	}
+
+	var logs []*AdminAuditLog
+	offset := (pagination.Page - 1) * pagination.PageSize
+	err = q.Order("id desc").Offset(int(offset)).Limit(int(pagination.PageSize)).Find(&logs).Error
+	if err != nil {
+		return nil, 0, response.ErrroCode_InternalUnknownError.Wrap(err, "list admin audit logs fail")
+	}
+	return logs, uint(count), nil
+}
@@ -1,15 +1,22 @@
+// Package dal the handlers to operate the database tables, every handler method takes the db executor to run on,
+// pass the one bound to the request context by db.WithContext, or the transaction begun from it,
+// so that the queries are canceled together with the request
 package dal
 
 import (
 	"database/sql/driver"
 	"github.com/pkg/errors"
+	"github.com/swordandtea/lets-habit-server/biz/response"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"strings"
+	"time"
 )
 
 // UID user id
 type UID string
 
-// Password a tool model to represent a hashed encrypted passport
+// Password a tool model to represent a hashed encrypted password
 type Password struct {
 	Data   string
 	Hashed bool
@@ -27,20 +34,28 @@ func (p *Password) Scan(value interface{}) error {
 	if value == nil {
 		return nil
 	}
-	bytes, ok := value.([]byte)
-	if !ok {
+	var data string
+	switch v := value.(type) {
+	case []byte:
+		data = string(v)
+	case string: // some drivers scan text column as string
+		data = v
+	default:
 		return errors.New("invalid type")
 	}
 	if p == nil {
 		*p = Password{}
 	}
-	p.Data = string(bytes)
+	p.Data = data
 	p.Hashed = true // in db, always store the hashed password
 	return nil
 }
 
 // Value an implement of driver.Valuer
 func (p *Password) Value() (driver.Value, error) {
+	if p == nil { // user registered by third party may have no password
+		return nil, nil
+	}
 	if p.Hashed {
 		return p.Data, nil
 	}
@@ -63,6 +78,57 @@ func (p *Password) HashedValue() string {
 	return string(hashed)
 }
 
+// containsCondition build a case-insensitive condition that any of the columns contains the text, and the args of it,
+// like of mysql and sqlite ignores case already while postgres needs ilike, the wildcards in the text are matched literally
+func containsCondition(db *gorm.DB, text string, columns ...string) (string, []interface{}) {
+	operator := "like"
+	if db.Dialector.Name() == "postgres" {
+		operator = "ilike"
+	}
+	pattern := "%" + likeEscaper.Replace(text) + "%"
+	conditions := make([]string, 0, len(columns))
+	args := make([]interface{}, 0, len(columns))
+	for _, column := range columns {
+		conditions = append(conditions, column+" "+operator+" ? escape '"+likeEscapeChar+"'")
+		args = append(args, pattern)
+	}
+	return strings.Join(conditions, " or "), args
+}
+
+// likeEscapeChar the escape char of like, backslash is not used as mysql takes it as an escape of the string literal too
+const likeEscapeChar = "!"
+
+// likeEscaper escape the wildcards and the escape char itself in a like pattern
+var likeEscaper = strings.NewReplacer(likeEscapeChar, likeEscapeChar+likeEscapeChar, "%", likeEscapeChar+"%", "_", likeEscapeChar+"_")
+
+// softDelete mark the rows of the query as deleted, the tombstones are kept for the clients to pull the deletions,
+// until purged by PurgeTombstones. The delete of gorm is not used for soft delete, as it does not touch update_at
+func softDelete(q *gorm.DB) error {
+	now := time.Now().UTC()
+	return q.Updates(map[string]interface{}{"delete_at": now, "update_at": now}).Error
+}
+
+// PurgeTombstones remove the rows of habits, groups, configs and log records deleted before the time for good
+func PurgeTombstones(db *gorm.DB, before time.Time) response.SError {
+	tables := []struct {
+		name  string
+		model interface{}
+	}{
+		{"habits", &Habit{}},
+		{"habit_groups", &HabitGroup{}},
+		{"user_habit_configs", &UserHabitConfig{}},
+		{habitLogRecordTable, &HabitLogRecord{}},
+		{unconfirmedHabitLogRecordTable, &HabitLogRecord{}},
+	}
+	for _, table := range tables {
+		err := db.Table(table.name).Unscoped().Where("delete_at < ?", before.UTC()).Delete(table.model).Error
+		if err != nil {
+			return response.ErrroCode_InternalUnknownError.Wrap(err, "purge tombstones of %s fail", table.name)
+		}
+	}
+	return nil
+}
+
 type Pagination struct {
 	Page     uint
 	PageSize uint
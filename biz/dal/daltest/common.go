@@ -0,0 +1,148 @@
+// Package daltest provides in-memory fakes of the dal repositories for unit test, the db executor passed to them
+// is ignored, the records returned are copies so that modifying them does not affect the stored ones
+package daltest
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+// copyOf copy the record v points to
+func copyOf[T any](v *T) *T {
+	c := *v
+	return &c
+}
+
+// inTimeRange whether t is inside [fromTime, toTime], a nil bound means unlimited
+func inTimeRange(t time.Time, fromTime *time.Time, toTime *time.Time) bool {
+	if fromTime != nil && t.Before(*fromTime) {
+		return false
+	}
+	if toTime != nil && t.After(*toTime) {
+		return false
+	}
+	return true
+}
+
+// paginate get the page of items
+func paginate[T any](items []T, pagination *dal.Pagination) []T {
+	offset := int((pagination.Page - 1) * pagination.PageSize)
+	if offset >= len(items) {
+		return nil
+	}
+	end := offset + int(pagination.PageSize)
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[offset:end]
+}
+
+// hasValue whether values contains v
+func hasValue[T comparable](values []T, v T) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// tombstone the delete mark of a record deleted at now
+func tombstone(now time.Time) gorm.DeletedAt {
+	return gorm.DeletedAt{Time: now, Valid: true}
+}
+
+// updatedAfter whether a record updated at t is listed by the queries of the records updated after a time,
+// a zero time lists all of them
+func updatedAfter(t time.Time, after time.Time) bool {
+	return after.IsZero() || t.After(after)
+}
+
+// filter get the items matching the condition, in place
+func filter[T any](items []T, match func(item T) bool) []T {
+	filtered := items[:0]
+	for _, item := range items {
+		if match(item) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// table an in-memory table of the records of type T, the records added get increasing ids set by setID
+type table[T any] struct {
+	mu      sync.Mutex
+	nextID  uint64
+	records []*T
+	setID   func(r *T, id uint64)
+}
+
+// newTable create an empty table
+func newTable[T any](setID func(r *T, id uint64)) *table[T] {
+	return &table[T]{nextID: 1, setID: setID}
+}
+
+// add store copies of the records with their ids set
+func (t *table[T]) add(rs ...*T) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, r := range rs {
+		t.setID(r, t.nextID)
+		t.nextID++
+		t.records = append(t.records, copyOf(r))
+	}
+}
+
+// list get the copies of the stored records matching the condition, in the order added
+func (t *table[T]) list(match func(r *T) bool) []*T {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var rs []*T
+	for _, r := range t.records {
+		if match(r) {
+			rs = append(rs, copyOf(r))
+		}
+	}
+	return rs
+}
+
+// first get the copy of the first stored record matching the condition, nil if not found
+func (t *table[T]) first(match func(r *T) bool) *T {
+	rs := t.list(match)
+	if len(rs) == 0 {
+		return nil
+	}
+	return rs[0]
+}
+
+// last get the copy of the last stored record matching the condition, nil if not found
+func (t *table[T]) last(match func(r *T) bool) *T {
+	rs := t.list(match)
+	if len(rs) == 0 {
+		return nil
+	}
+	return rs[len(rs)-1]
+}
+
+// update apply fn to the stored records matching the condition, return the number of records updated
+func (t *table[T]) update(match func(r *T) bool, fn func(r *T)) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	updated := 0
+	for _, r := range t.records {
+		if match(r) {
+			fn(r)
+			updated++
+		}
+	}
+	return updated
+}
+
+// delete remove the stored records matching the condition
+func (t *table[T]) delete(match func(r *T) bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.records = filter(t.records, func(r *T) bool { return !match(r) })
+}
@@ -0,0 +1,80 @@
+package daltest
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"time"
+)
+
+// EmailBindRequestRepository an in-memory dal.EmailBindRequestRepository
+type EmailBindRequestRepository struct {
+	requests *table[dal.EmailBindRequest]
+}
+
+var _ dal.EmailBindRequestRepository = &EmailBindRequestRepository{}
+
+// NewEmailBindRequestRepository create an empty EmailBindRequestRepository
+func NewEmailBindRequestRepository() *EmailBindRequestRepository {
+	return &EmailBindRequestRepository{requests: newTable(func(r *dal.EmailBindRequest, id uint64) { r.ID = id })}
+}
+
+// bindPending whether a request is neither confirmed nor canceled
+func bindPending(req *dal.EmailBindRequest) bool {
+	return req.ConfirmAt == nil && req.CancelAt == nil
+}
+
+func (r *EmailBindRequestRepository) Add(db *gorm.DB, req *dal.EmailBindRequest) response.SError {
+	r.requests.add(req)
+	return nil
+}
+
+func (r *EmailBindRequestRepository) GetByTokenHash(db *gorm.DB, tokenHash string) (*dal.EmailBindRequest, response.SError) {
+	return r.requests.first(func(req *dal.EmailBindRequest) bool { return req.TokenHash == tokenHash }), nil
+}
+
+func (r *EmailBindRequestRepository) GetByCancelHash(db *gorm.DB, cancelHash string) (*dal.EmailBindRequest, response.SError) {
+	return r.requests.first(func(req *dal.EmailBindRequest) bool { return req.CancelHash == cancelHash }), nil
+}
+
+func (r *EmailBindRequestRepository) Confirm(db *gorm.DB, id uint64, confirmAt time.Time) (bool, response.SError) {
+	updated := r.requests.update(func(req *dal.EmailBindRequest) bool { return req.ID == id && bindPending(req) },
+		func(req *dal.EmailBindRequest) {
+			t := confirmAt.UTC()
+			req.ConfirmAt = &t
+		})
+	return updated == 1, nil
+}
+
+func (r *EmailBindRequestRepository) Cancel(db *gorm.DB, id uint64, cancelAt time.Time) (bool, response.SError) {
+	updated := r.requests.update(func(req *dal.EmailBindRequest) bool { return req.ID == id && bindPending(req) },
+		func(req *dal.EmailBindRequest) {
+			t := cancelAt.UTC()
+			req.CancelAt = &t
+		})
+	return updated == 1, nil
+}
+
+func (r *EmailBindRequestRepository) Revert(db *gorm.DB, id uint64, revertAt time.Time) (bool, response.SError) {
+	updated := r.requests.update(func(req *dal.EmailBindRequest) bool {
+		return req.ID == id && req.ConfirmAt != nil && req.CancelAt == nil
+	}, func(req *dal.EmailBindRequest) {
+		t := revertAt.UTC()
+		req.CancelAt = &t
+	})
+	return updated == 1, nil
+}
+
+func (r *EmailBindRequestRepository) CancelPendingByUID(db *gorm.DB, uid dal.UID, cancelAt time.Time) response.SError {
+	r.requests.update(func(req *dal.EmailBindRequest) bool { return req.UID == uid && bindPending(req) },
+		func(req *dal.EmailBindRequest) {
+			t := cancelAt.UTC()
+			req.CancelAt = &t
+		})
+	return nil
+}
+
+func (r *EmailBindRequestRepository) DeleteByUID(db *gorm.DB, uid dal.UID) response.SError {
+	r.requests.delete(func(req *dal.EmailBindRequest) bool { return req.UID == uid })
+	return nil
+}
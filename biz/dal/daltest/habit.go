@@ -0,0 +1,136 @@
+package daltest
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HabitRepository an in-memory dal.HabitRepository, the joined habits are looked up in the HabitGroupRepository
+type HabitRepository struct {
+	mu     sync.Mutex
+	nextID uint64
+	habits []*dal.Habit
+	groups *HabitGroupRepository
+}
+
+var _ dal.HabitRepository = &HabitRepository{}
+
+// NewHabitRepository create an empty HabitRepository, the user joined habits are decided by the groups
+func NewHabitRepository(groups *HabitGroupRepository) *HabitRepository {
+	return &HabitRepository{nextID: 1, groups: groups}
+}
+
+func (r *HabitRepository) Add(db *gorm.DB, h *dal.Habit) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h.ID = r.nextID
+	h.UpdateAt = time.Now().UTC()
+	r.nextID++
+	r.habits = append(r.habits, copyOf(h))
+	return nil
+}
+
+// find get the stored habit of id, nil if not found or deleted
+func (r *HabitRepository) find(id uint64) *dal.Habit {
+	for _, h := range r.habits {
+		if h.ID == id && !h.DeleteAt.Valid {
+			return h
+		}
+	}
+	return nil
+}
+
+// GetByID get a habit by id, the row is not locked for forUpdate, the callers of a fake are not run in a real transaction
+func (r *HabitRepository) GetByID(db *gorm.DB, id uint64, forUpdate bool) (*dal.Habit, response.SError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h := r.find(id); h != nil {
+		return copyOf(h), nil
+	}
+	return nil, nil
+}
+
+func (r *HabitRepository) ListUserJoinedHabits(db *gorm.DB, uid dal.UID, pagination *dal.Pagination) ([]*dal.Habit, uint, response.SError) {
+	hgs, _ := r.groups.ListByUID(db, uid)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var habits []*dal.Habit
+	for _, h := range r.habits {
+		if h.DeleteAt.Valid {
+			continue
+		}
+		for _, hg := range hgs {
+			if hg.HabitID == h.ID {
+				habits = append(habits, copyOf(h))
+				break
+			}
+		}
+	}
+	return paginate(habits, pagination), uint(len(habits)), nil
+}
+
+func (r *HabitRepository) UpdateHabit(db *gorm.DB, id uint64, updateFields *dal.HabitUpdatableFields) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := r.find(id)
+	if h == nil {
+		return nil
+	}
+	if updateFields.Name != "" || updateFields.Identity != "" || updateFields.Owner != "" {
+		h.UpdateAt = time.Now().UTC()
+	}
+	if updateFields.Name != "" {
+		h.Name = updateFields.Name
+	}
+	if updateFields.Identity != "" {
+		identity := updateFields.Identity
+		h.Identity = &identity
+	}
+	if updateFields.Owner != "" {
+		h.Owner = updateFields.Owner
+	}
+	return nil
+}
+
+func (r *HabitRepository) ListByIDsUpdatedAfter(db *gorm.DB, ids []uint64, after time.Time) ([]*dal.Habit, response.SError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var habits []*dal.Habit
+	for _, h := range r.habits {
+		if hasValue(ids, h.ID) && updatedAfter(h.UpdateAt, after) {
+			habits = append(habits, copyOf(h))
+		}
+	}
+	sort.SliceStable(habits, func(i, j int) bool { return habits[i].UpdateAt.Before(habits[j].UpdateAt) })
+	return habits, nil
+}
+
+func (r *HabitRepository) DeleteByID(db *gorm.DB, id uint64) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h := r.find(id); h != nil {
+		h.UpdateAt = time.Now().UTC()
+		h.DeleteAt = tombstone(h.UpdateAt)
+	}
+	return nil
+}
+
+func (r *HabitRepository) Touch(db *gorm.DB, id uint64) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h := r.find(id); h != nil {
+		h.UpdateAt = time.Now().UTC()
+	}
+	return nil
+}
+
+func (r *HabitRepository) EraseByOwner(db *gorm.DB, owner dal.UID) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.habits = filter(r.habits, func(h *dal.Habit) bool { return h.Owner != owner })
+	return nil
+}
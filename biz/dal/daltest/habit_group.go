@@ -0,0 +1,136 @@
+package daltest
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HabitGroupRepository an in-memory dal.HabitGroupRepository
+type HabitGroupRepository struct {
+	mu     sync.Mutex
+	groups []*dal.HabitGroup
+}
+
+var _ dal.HabitGroupRepository = &HabitGroupRepository{}
+
+// NewHabitGroupRepository create an empty HabitGroupRepository
+func NewHabitGroupRepository() *HabitGroupRepository {
+	return &HabitGroupRepository{}
+}
+
+func (r *HabitGroupRepository) Add(db *gorm.DB, hg *dal.HabitGroup) response.SError {
+	return r.AddMulti(db, []*dal.HabitGroup{hg})
+}
+
+func (r *HabitGroupRepository) AddMulti(db *gorm.DB, hgs []*dal.HabitGroup) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, hg := range hgs {
+		for _, stored := range r.groups {
+			if stored.HabitID == hg.HabitID && stored.UID == hg.UID && !stored.DeleteAt.Valid {
+				return response.ErrroCode_InternalUnknownError.New("add habit group fail, duplicated habit id and uid")
+			}
+		}
+	}
+	// the tombstones of the members joining back are replaced
+	r.groups = filter(r.groups, func(stored *dal.HabitGroup) bool {
+		for _, hg := range hgs {
+			if stored.HabitID == hg.HabitID && stored.UID == hg.UID {
+				return false
+			}
+		}
+		return true
+	})
+	now := time.Now().UTC()
+	for _, hg := range hgs {
+		hg.UpdateAt = now
+		r.groups = append(r.groups, copyOf(hg))
+	}
+	return nil
+}
+
+// list get the copies of the stored groups matching the condition, the tombstones are excluded
+func (r *HabitGroupRepository) list(match func(hg *dal.HabitGroup) bool) []*dal.HabitGroup {
+	return r.listUnscoped(func(hg *dal.HabitGroup) bool { return !hg.DeleteAt.Valid && match(hg) })
+}
+
+// listUnscoped get the copies of the stored groups matching the condition, including the tombstones,
+// ordered by the update time
+func (r *HabitGroupRepository) listUnscoped(match func(hg *dal.HabitGroup) bool) []*dal.HabitGroup {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var hgs []*dal.HabitGroup
+	for _, hg := range r.groups {
+		if match(hg) {
+			hgs = append(hgs, copyOf(hg))
+		}
+	}
+	sort.SliceStable(hgs, func(i, j int) bool { return hgs[i].UpdateAt.Before(hgs[j].UpdateAt) })
+	return hgs
+}
+
+// first get the first one of hgs, nil if empty
+func first(hgs []*dal.HabitGroup) *dal.HabitGroup {
+	if len(hgs) == 0 {
+		return nil
+	}
+	return hgs[0]
+}
+
+func (r *HabitGroupRepository) GetByHabitIDAndUID(db *gorm.DB, habitID uint64, uid dal.UID) (*dal.HabitGroup, response.SError) {
+	return first(r.list(func(hg *dal.HabitGroup) bool { return hg.HabitID == habitID && hg.UID == uid })), nil
+}
+
+func (r *HabitGroupRepository) GetByHabitIDAndExcludeUID(db *gorm.DB, habitID uint64, uid dal.UID) (*dal.HabitGroup, response.SError) {
+	return first(r.list(func(hg *dal.HabitGroup) bool { return hg.HabitID == habitID && hg.UID != uid })), nil
+}
+
+func (r *HabitGroupRepository) ListByHabitID(db *gorm.DB, habitID uint64) ([]*dal.HabitGroup, response.SError) {
+	return r.list(func(hg *dal.HabitGroup) bool { return hg.HabitID == habitID }), nil
+}
+
+func (r *HabitGroupRepository) ListByHabitIDs(db *gorm.DB, habitIDs []uint64) ([]*dal.HabitGroup, response.SError) {
+	return r.list(func(hg *dal.HabitGroup) bool { return hasValue(habitIDs, hg.HabitID) }), nil
+}
+
+func (r *HabitGroupRepository) ListByUID(db *gorm.DB, uid dal.UID) ([]*dal.HabitGroup, response.SError) {
+	return r.list(func(hg *dal.HabitGroup) bool { return hg.UID == uid }), nil
+}
+
+func (r *HabitGroupRepository) ListByUIDUpdatedAfter(db *gorm.DB, uid dal.UID, after time.Time) ([]*dal.HabitGroup, response.SError) {
+	return r.listUnscoped(func(hg *dal.HabitGroup) bool { return hg.UID == uid && updatedAfter(hg.UpdateAt, after) }), nil
+}
+
+func (r *HabitGroupRepository) ListByHabitIDsUpdatedAfter(db *gorm.DB, habitIDs []uint64, after time.Time) ([]*dal.HabitGroup, response.SError) {
+	return r.listUnscoped(func(hg *dal.HabitGroup) bool {
+		return hasValue(habitIDs, hg.HabitID) && updatedAfter(hg.UpdateAt, after)
+	}), nil
+}
+
+func (r *HabitGroupRepository) DeleteByHabitIDAndUID(db *gorm.DB, habitID uint64, uid dal.UID) response.SError {
+	return r.DeleteByHabitIDAndUIDs(db, habitID, []dal.UID{uid})
+}
+
+func (r *HabitGroupRepository) DeleteByHabitIDAndUIDs(db *gorm.DB, habitID uint64, uids []dal.UID) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	for _, hg := range r.groups {
+		if hg.HabitID == habitID && hasValue(uids, hg.UID) && !hg.DeleteAt.Valid {
+			hg.UpdateAt = now
+			hg.DeleteAt = tombstone(now)
+		}
+	}
+	return nil
+}
+
+func (r *HabitGroupRepository) EraseByUID(db *gorm.DB, uid dal.UID) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.groups = filter(r.groups, func(hg *dal.HabitGroup) bool { return hg.UID != uid })
+	return nil
+}
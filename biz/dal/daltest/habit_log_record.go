@@ -0,0 +1,167 @@
+package daltest
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HabitLogRecordRepository an in-memory store of HabitLogRecord, implements both dal.HabitLogRecordRepository
+// and dal.UnconfirmedHabitLogRecordRepository, use one for each
+type HabitLogRecordRepository struct {
+	mu      sync.Mutex
+	nextID  uint64
+	records []*dal.HabitLogRecord
+}
+
+var _ dal.HabitLogRecordRepository = &HabitLogRecordRepository{}
+var _ dal.UnconfirmedHabitLogRecordRepository = &HabitLogRecordRepository{}
+
+// NewHabitLogRecordRepository create an empty HabitLogRecordRepository
+func NewHabitLogRecordRepository() *HabitLogRecordRepository {
+	return &HabitLogRecordRepository{nextID: 1}
+}
+
+func (r *HabitLogRecordRepository) Add(db *gorm.DB, record *dal.HabitLogRecord) response.SError {
+	return r.AddMulti(db, []*dal.HabitLogRecord{record})
+}
+
+func (r *HabitLogRecordRepository) AddMulti(db *gorm.DB, rs []*dal.HabitLogRecord) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, record := range rs {
+		// the tombstones taking the unique keys of the record are replaced, like the dal does
+		r.records = filter(r.records, func(stored *dal.HabitLogRecord) bool {
+			return !stored.DeleteAt.Valid || !sameUniqueKey(stored, record)
+		})
+		if record.ID != 0 {
+			for _, stored := range r.records {
+				if stored.ID == record.ID {
+					return response.ErrroCode_InternalUnknownError.New("add habit log record fail, duplicated id %d", record.ID)
+				}
+			}
+		} else {
+			record.ID = r.nextID
+		}
+		if record.ID >= r.nextID {
+			r.nextID = record.ID + 1
+		}
+		stored := copyOf(record)
+		stored.LogAt = stored.LogAt.UTC()
+		stored.UpdateAt = stored.UpdateAt.UTC()
+		r.records = append(r.records, stored)
+	}
+	return nil
+}
+
+// sameUniqueKey whether two records take the same unique key, the log day of a habit or the client id of a user
+func sameUniqueKey(a *dal.HabitLogRecord, b *dal.HabitLogRecord) bool {
+	if a.HabitID == b.HabitID && a.UID == b.UID && a.LogDay == b.LogDay {
+		return true
+	}
+	return a.UID == b.UID && a.ClientID != nil && b.ClientID != nil && *a.ClientID == *b.ClientID
+}
+
+// list get the copies of the stored records matching the condition, the tombstones are excluded
+func (r *HabitLogRecordRepository) list(match func(record *dal.HabitLogRecord) bool) []*dal.HabitLogRecord {
+	return r.listUnscoped(func(record *dal.HabitLogRecord) bool { return !record.DeleteAt.Valid && match(record) })
+}
+
+// listUnscoped get the copies of the stored records matching the condition, including the tombstones
+func (r *HabitLogRecordRepository) listUnscoped(match func(record *dal.HabitLogRecord) bool) []*dal.HabitLogRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var records []*dal.HabitLogRecord
+	for _, record := range r.records {
+		if match(record) {
+			records = append(records, copyOf(record))
+		}
+	}
+	return records
+}
+
+// Records get all the stored records but the tombstones
+func (r *HabitLogRecordRepository) Records() []*dal.HabitLogRecord {
+	return r.list(func(*dal.HabitLogRecord) bool { return true })
+}
+
+func (r *HabitLogRecordRepository) ListByUID(db *gorm.DB, uid dal.UID, fromTime *time.Time, toTime *time.Time) ([]*dal.HabitLogRecord, response.SError) {
+	return r.list(func(record *dal.HabitLogRecord) bool {
+		return record.UID == uid && inTimeRange(record.LogAt, fromTime, toTime)
+	}), nil
+}
+
+func (r *HabitLogRecordRepository) ListByHabitID(db *gorm.DB, habitID uint64, fromTime *time.Time, toTime *time.Time) ([]*dal.HabitLogRecord, response.SError) {
+	return r.list(func(record *dal.HabitLogRecord) bool {
+		return record.HabitID == habitID && inTimeRange(record.LogAt, fromTime, toTime)
+	}), nil
+}
+
+func (r *HabitLogRecordRepository) ListByUIDHabitIDs(db *gorm.DB, uid dal.UID, habitIDs []uint64, fromTime *time.Time, toTime *time.Time) ([]*dal.HabitLogRecord, response.SError) {
+	return r.list(func(record *dal.HabitLogRecord) bool {
+		return record.UID == uid && hasValue(habitIDs, record.HabitID) && inTimeRange(record.LogAt, fromTime, toTime)
+	}), nil
+}
+
+func (r *HabitLogRecordRepository) ListByHabitIDAndLogDay(db *gorm.DB, habitID uint64, logDay string) ([]*dal.HabitLogRecord, response.SError) {
+	return r.list(func(record *dal.HabitLogRecord) bool {
+		return record.HabitID == habitID && record.LogDay == logDay
+	}), nil
+}
+
+func (r *HabitLogRecordRepository) ListByUIDUpdatedAfter(db *gorm.DB, uid dal.UID, after time.Time) ([]*dal.HabitLogRecord, response.SError) {
+	records := r.listUnscoped(func(record *dal.HabitLogRecord) bool {
+		return record.UID == uid && updatedAfter(record.UpdateAt, after)
+	})
+	sort.SliceStable(records, func(i, j int) bool {
+		if !records[i].UpdateAt.Equal(records[j].UpdateAt) {
+			return records[i].UpdateAt.Before(records[j].UpdateAt)
+		}
+		return records[i].ID < records[j].ID
+	})
+	return records, nil
+}
+
+func (r *HabitLogRecordRepository) GetByUIDAndClientID(db *gorm.DB, uid dal.UID, clientID string) (*dal.HabitLogRecord, response.SError) {
+	records := r.list(func(record *dal.HabitLogRecord) bool {
+		return record.UID == uid && record.ClientID != nil && *record.ClientID == clientID
+	})
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return records[0], nil
+}
+
+// delete mark the stored records matching the condition as deleted
+func (r *HabitLogRecordRepository) delete(match func(record *dal.HabitLogRecord) bool) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	for _, record := range r.records {
+		if match(record) && !record.DeleteAt.Valid {
+			record.UpdateAt = now
+			record.DeleteAt = tombstone(now)
+		}
+	}
+	return nil
+}
+
+func (r *HabitLogRecordRepository) DeleteByHabitIDAndUID(db *gorm.DB, habitID uint64, uid dal.UID) response.SError {
+	return r.delete(func(record *dal.HabitLogRecord) bool { return record.HabitID == habitID && record.UID == uid })
+}
+
+func (r *HabitLogRecordRepository) DeleteByHabitID(db *gorm.DB, habitID uint64, fromTime *time.Time, toTime *time.Time) response.SError {
+	return r.delete(func(record *dal.HabitLogRecord) bool {
+		return record.HabitID == habitID && inTimeRange(record.LogAt, fromTime, toTime)
+	})
+}
+
+func (r *HabitLogRecordRepository) EraseByUID(db *gorm.DB, uid dal.UID) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = filter(r.records, func(record *dal.HabitLogRecord) bool { return record.UID != uid })
+	return nil
+}
@@ -0,0 +1,51 @@
+package daltest
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"time"
+)
+
+// LoginCodeRepository an in-memory dal.LoginCodeRepository
+type LoginCodeRepository struct {
+	codes *table[dal.LoginCode]
+}
+
+var _ dal.LoginCodeRepository = &LoginCodeRepository{}
+
+// NewLoginCodeRepository create an empty LoginCodeRepository
+func NewLoginCodeRepository() *LoginCodeRepository {
+	return &LoginCodeRepository{codes: newTable(func(c *dal.LoginCode, id uint64) { c.ID = id })}
+}
+
+func (r *LoginCodeRepository) Add(db *gorm.DB, c *dal.LoginCode) response.SError {
+	r.codes.add(c)
+	return nil
+}
+
+func (r *LoginCodeRepository) GetLatestByUID(db *gorm.DB, uid dal.UID) (*dal.LoginCode, response.SError) {
+	return r.codes.last(func(c *dal.LoginCode) bool { return c.UID == uid }), nil
+}
+
+func (r *LoginCodeRepository) GetByLinkHash(db *gorm.DB, linkHash string) (*dal.LoginCode, response.SError) {
+	return r.codes.first(func(c *dal.LoginCode) bool { return c.LinkHash == linkHash }), nil
+}
+
+func (r *LoginCodeRepository) IncreaseAttempts(db *gorm.DB, id uint64) response.SError {
+	r.codes.update(func(c *dal.LoginCode) bool { return c.ID == id }, func(c *dal.LoginCode) { c.Attempts++ })
+	return nil
+}
+
+func (r *LoginCodeRepository) Consume(db *gorm.DB, id uint64, consumeAt time.Time) (bool, response.SError) {
+	updated := r.codes.update(func(c *dal.LoginCode) bool { return c.ID == id && c.ConsumeAt == nil }, func(c *dal.LoginCode) {
+		t := consumeAt.UTC()
+		c.ConsumeAt = &t
+	})
+	return updated == 1, nil
+}
+
+func (r *LoginCodeRepository) DeleteByUID(db *gorm.DB, uid dal.UID) response.SError {
+	r.codes.delete(func(c *dal.LoginCode) bool { return c.UID == uid })
+	return nil
+}
@@ -0,0 +1,85 @@
+package daltest
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"time"
+)
+
+// OutboxMailRepository an in-memory dal.OutboxMailRepository
+type OutboxMailRepository struct {
+	mails *table[dal.OutboxMail]
+}
+
+var _ dal.OutboxMailRepository = &OutboxMailRepository{}
+
+// NewOutboxMailRepository create an empty OutboxMailRepository
+func NewOutboxMailRepository() *OutboxMailRepository {
+	return &OutboxMailRepository{mails: newTable(func(m *dal.OutboxMail, id uint64) { m.ID = id })}
+}
+
+func (r *OutboxMailRepository) Add(db *gorm.DB, m *dal.OutboxMail) response.SError {
+	r.mails.add(m)
+	return nil
+}
+
+func (r *OutboxMailRepository) ListDue(db *gorm.DB, now time.Time, limit int) ([]*dal.OutboxMail, response.SError) {
+	mails := r.mails.list(func(m *dal.OutboxMail) bool {
+		return m.Status == dal.OutboxMailStatusPending && !m.NextAttemptAt.After(now)
+	})
+	if len(mails) > limit {
+		mails = mails[:limit]
+	}
+	return mails, nil
+}
+
+func (r *OutboxMailRepository) Claim(db *gorm.DB, id uint64, attempts uint32, leaseUntil time.Time) (bool, response.SError) {
+	updated := r.mails.update(func(m *dal.OutboxMail) bool {
+		return m.ID == id && m.Status == dal.OutboxMailStatusPending && m.Attempts == attempts
+	}, func(m *dal.OutboxMail) {
+		m.Attempts = attempts + 1
+		m.NextAttemptAt = leaseUntil.UTC()
+	})
+	return updated == 1, nil
+}
+
+func (r *OutboxMailRepository) MarkSent(db *gorm.DB, id uint64, sentAt time.Time) response.SError {
+	r.mails.update(func(m *dal.OutboxMail) bool { return m.ID == id }, func(m *dal.OutboxMail) {
+		t := sentAt.UTC()
+		m.Status, m.Content, m.LastError, m.SentAt = dal.OutboxMailStatusSent, nil, "", &t
+	})
+	return nil
+}
+
+func (r *OutboxMailRepository) MarkRetry(db *gorm.DB, id uint64, lastError string, nextAttemptAt time.Time) response.SError {
+	r.mails.update(func(m *dal.OutboxMail) bool { return m.ID == id }, func(m *dal.OutboxMail) {
+		m.LastError, m.NextAttemptAt = lastError, nextAttemptAt.UTC()
+	})
+	return nil
+}
+
+func (r *OutboxMailRepository) MarkDead(db *gorm.DB, id uint64, lastError string) response.SError {
+	r.mails.update(func(m *dal.OutboxMail) bool { return m.ID == id }, func(m *dal.OutboxMail) {
+		m.Status, m.Content, m.LastError = dal.OutboxMailStatusDead, nil, lastError
+	})
+	return nil
+}
+
+func (r *OutboxMailRepository) CountByStatus(db *gorm.DB, status dal.OutboxMailStatus) (int64, response.SError) {
+	return int64(len(r.mails.list(func(m *dal.OutboxMail) bool { return m.Status == status }))), nil
+}
+
+func (r *OutboxMailRepository) DeleteByToMail(db *gorm.DB, toMail string) response.SError {
+	r.mails.delete(func(m *dal.OutboxMail) bool { return m.ToMail == toMail })
+	return nil
+}
+
+func (r *OutboxMailRepository) PurgeFinished(db *gorm.DB, before time.Time) (int64, response.SError) {
+	finished := func(m *dal.OutboxMail) bool {
+		return m.Status != dal.OutboxMailStatusPending && m.CreateAt.Before(before)
+	}
+	count := len(r.mails.list(finished))
+	r.mails.delete(finished)
+	return int64(count), nil
+}
@@ -0,0 +1,41 @@
+package daltest
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+)
+
+// SecurityEventRepository an in-memory dal.SecurityEventRepository
+type SecurityEventRepository struct {
+	events *table[dal.SecurityEvent]
+}
+
+var _ dal.SecurityEventRepository = &SecurityEventRepository{}
+
+// NewSecurityEventRepository create an empty SecurityEventRepository
+func NewSecurityEventRepository() *SecurityEventRepository {
+	return &SecurityEventRepository{events: newTable(func(e *dal.SecurityEvent, id uint64) { e.ID = id })}
+}
+
+func (r *SecurityEventRepository) Add(db *gorm.DB, e *dal.SecurityEvent) response.SError {
+	r.events.add(e)
+	return nil
+}
+
+func (r *SecurityEventRepository) ListRecentByUID(db *gorm.DB, uid dal.UID, limit int) ([]*dal.SecurityEvent, response.SError) {
+	events := r.events.list(func(e *dal.SecurityEvent) bool { return e.UID == uid })
+	// the latest first
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (r *SecurityEventRepository) DeleteByUID(db *gorm.DB, uid dal.UID) response.SError {
+	r.events.delete(func(e *dal.SecurityEvent) bool { return e.UID == uid })
+	return nil
+}
@@ -0,0 +1,75 @@
+package daltest
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"sort"
+	"time"
+)
+
+// SessionRepository an in-memory dal.SessionRepository
+type SessionRepository struct {
+	sessions *table[dal.Session]
+}
+
+var _ dal.SessionRepository = &SessionRepository{}
+
+// NewSessionRepository create an empty SessionRepository
+func NewSessionRepository() *SessionRepository {
+	return &SessionRepository{sessions: newTable(func(s *dal.Session, id uint64) { s.ID = id })}
+}
+
+func (r *SessionRepository) Add(db *gorm.DB, s *dal.Session) response.SError {
+	if r.sessions.first(func(stored *dal.Session) bool { return stored.SID == s.SID }) != nil {
+		return response.ErrroCode_InternalUnknownError.New("add session fail, duplicated sid")
+	}
+	r.sessions.add(s)
+	return nil
+}
+
+func (r *SessionRepository) GetBySID(db *gorm.DB, sid string) (*dal.Session, response.SError) {
+	return r.sessions.first(func(s *dal.Session) bool { return s.SID == sid }), nil
+}
+
+func (r *SessionRepository) ListActiveByUID(db *gorm.DB, uid dal.UID, now time.Time) ([]*dal.Session, response.SError) {
+	ss := r.sessions.list(func(s *dal.Session) bool { return s.UID == uid && s.IsActive(now) })
+	sort.SliceStable(ss, func(i, j int) bool { return ss[i].LastUsedAt.After(ss[j].LastUsedAt) })
+	return ss, nil
+}
+
+func (r *SessionRepository) Rotate(db *gorm.DB, sid string, oldRefreshHash string, fields *dal.SessionRotateFields) (bool, response.SError) {
+	updated := r.sessions.update(func(s *dal.Session) bool {
+		return s.SID == sid && s.RefreshHash == oldRefreshHash && s.RevokeAt == nil
+	}, func(s *dal.Session) {
+		s.RefreshHash = fields.RefreshHash
+		s.PrevRefreshHash = oldRefreshHash
+		s.LastUsedIP = fields.LastUsedIP
+		s.LastUsedAt = fields.LastUsedAt.UTC()
+		s.ExpireAt = fields.ExpireAt.UTC()
+	})
+	return updated == 1, nil
+}
+
+func (r *SessionRepository) RevokeBySID(db *gorm.DB, sid string, revokeAt time.Time) response.SError {
+	r.sessions.update(func(s *dal.Session) bool { return s.SID == sid && s.RevokeAt == nil }, func(s *dal.Session) {
+		t := revokeAt.UTC()
+		s.RevokeAt = &t
+	})
+	return nil
+}
+
+func (r *SessionRepository) RevokeByUID(db *gorm.DB, uid dal.UID, exceptSID string, revokeAt time.Time) response.SError {
+	r.sessions.update(func(s *dal.Session) bool {
+		return s.UID == uid && s.RevokeAt == nil && (exceptSID == "" || s.SID != exceptSID)
+	}, func(s *dal.Session) {
+		t := revokeAt.UTC()
+		s.RevokeAt = &t
+	})
+	return nil
+}
+
+func (r *SessionRepository) DeleteByUID(db *gorm.DB, uid dal.UID) response.SError {
+	r.sessions.delete(func(s *dal.Session) bool { return s.UID == uid })
+	return nil
+}
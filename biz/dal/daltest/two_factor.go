@@ -0,0 +1,107 @@
+package daltest
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"time"
+)
+
+// UserTwoFactorRepository an in-memory dal.UserTwoFactorRepository
+type UserTwoFactorRepository struct {
+	twoFactors *table[dal.UserTwoFactor]
+}
+
+var _ dal.UserTwoFactorRepository = &UserTwoFactorRepository{}
+
+// NewUserTwoFactorRepository create an empty UserTwoFactorRepository
+func NewUserTwoFactorRepository() *UserTwoFactorRepository {
+	return &UserTwoFactorRepository{twoFactors: newTable(func(tf *dal.UserTwoFactor, id uint64) { tf.ID = id })}
+}
+
+func (r *UserTwoFactorRepository) Add(db *gorm.DB, tf *dal.UserTwoFactor) response.SError {
+	if r.twoFactors.first(func(stored *dal.UserTwoFactor) bool { return stored.UID == tf.UID }) != nil {
+		return response.ErrroCode_InternalUnknownError.New("add user two factor fail, duplicated uid")
+	}
+	r.twoFactors.add(tf)
+	return nil
+}
+
+func (r *UserTwoFactorRepository) GetByUID(db *gorm.DB, uid dal.UID) (*dal.UserTwoFactor, response.SError) {
+	return r.twoFactors.first(func(tf *dal.UserTwoFactor) bool { return tf.UID == uid }), nil
+}
+
+func (r *UserTwoFactorRepository) Confirm(db *gorm.DB, id uint64, step int64, confirmAt time.Time) response.SError {
+	r.twoFactors.update(func(tf *dal.UserTwoFactor) bool { return tf.ID == id }, func(tf *dal.UserTwoFactor) {
+		t := confirmAt.UTC()
+		tf.ConfirmAt = &t
+		tf.LastUsedStep = step
+	})
+	return nil
+}
+
+func (r *UserTwoFactorRepository) UseStep(db *gorm.DB, id uint64, step int64) (bool, response.SError) {
+	updated := r.twoFactors.update(func(tf *dal.UserTwoFactor) bool { return tf.ID == id && tf.LastUsedStep < step },
+		func(tf *dal.UserTwoFactor) {
+			tf.LastUsedStep = step
+			tf.FailedAttempts = 0
+		})
+	return updated == 1, nil
+}
+
+func (r *UserTwoFactorRepository) ResetFailedAttempts(db *gorm.DB, id uint64) response.SError {
+	r.twoFactors.update(func(tf *dal.UserTwoFactor) bool { return tf.ID == id }, func(tf *dal.UserTwoFactor) {
+		tf.FailedAttempts = 0
+	})
+	return nil
+}
+
+func (r *UserTwoFactorRepository) IncreaseFailedAttempts(db *gorm.DB, id uint64, failAt time.Time) response.SError {
+	r.twoFactors.update(func(tf *dal.UserTwoFactor) bool { return tf.ID == id }, func(tf *dal.UserTwoFactor) {
+		t := failAt.UTC()
+		tf.FailedAttempts++
+		tf.LastFailAt = &t
+	})
+	return nil
+}
+
+func (r *UserTwoFactorRepository) DeleteByUID(db *gorm.DB, uid dal.UID) response.SError {
+	r.twoFactors.delete(func(tf *dal.UserTwoFactor) bool { return tf.UID == uid })
+	return nil
+}
+
+// RecoveryCodeRepository an in-memory dal.RecoveryCodeRepository
+type RecoveryCodeRepository struct {
+	codes *table[dal.RecoveryCode]
+}
+
+var _ dal.RecoveryCodeRepository = &RecoveryCodeRepository{}
+
+// NewRecoveryCodeRepository create an empty RecoveryCodeRepository
+func NewRecoveryCodeRepository() *RecoveryCodeRepository {
+	return &RecoveryCodeRepository{codes: newTable(func(c *dal.RecoveryCode, id uint64) { c.ID = id })}
+}
+
+func (r *RecoveryCodeRepository) BatchAdd(db *gorm.DB, codes []*dal.RecoveryCode) response.SError {
+	r.codes.add(codes...)
+	return nil
+}
+
+func (r *RecoveryCodeRepository) Use(db *gorm.DB, uid dal.UID, codeHash string, useAt time.Time) (bool, response.SError) {
+	updated := r.codes.update(func(c *dal.RecoveryCode) bool {
+		return c.UID == uid && c.CodeHash == codeHash && c.UseAt == nil
+	}, func(c *dal.RecoveryCode) {
+		t := useAt.UTC()
+		c.UseAt = &t
+	})
+	return updated > 0, nil
+}
+
+func (r *RecoveryCodeRepository) CountUnusedByUID(db *gorm.DB, uid dal.UID) (int64, response.SError) {
+	return int64(len(r.codes.list(func(c *dal.RecoveryCode) bool { return c.UID == uid && c.UseAt == nil }))), nil
+}
+
+func (r *RecoveryCodeRepository) DeleteByUID(db *gorm.DB, uid dal.UID) response.SError {
+	r.codes.delete(func(c *dal.RecoveryCode) bool { return c.UID == uid })
+	return nil
+}
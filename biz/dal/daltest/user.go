@@ -0,0 +1,194 @@
+package daltest
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UserRepository an in-memory dal.UserRepository, the portrait url is not filled
+type UserRepository struct {
+	mu     sync.Mutex
+	nextID uint64
+	users  []*dal.User
+}
+
+var _ dal.UserRepository = &UserRepository{}
+
+// NewUserRepository create an empty UserRepository
+func NewUserRepository() *UserRepository {
+	return &UserRepository{nextID: 1}
+}
+
+// find get the stored user matching the condition, nil if not found
+func (r *UserRepository) find(match func(u *dal.User) bool) *dal.User {
+	for _, u := range r.users {
+		if match(u) {
+			return u
+		}
+	}
+	return nil
+}
+
+func (r *UserRepository) Add(db *gorm.DB, user *dal.User) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.find(func(u *dal.User) bool {
+		return u.UID == user.UID || (u.Email != nil && user.Email != nil && *u.Email == *user.Email) ||
+			(u.Name != nil && user.Name != nil && *u.Name == *user.Name)
+	}) != nil {
+		return response.ErrroCode_InternalUnknownError.New("add user fail, duplicated uid, email or name")
+	}
+	user.ID = r.nextID
+	r.nextID++
+	stored := copyOf(user)
+	if user.Password != nil { // the password is always stored hashed
+		stored.Password = &dal.Password{Data: user.Password.HashedValue(), Hashed: true}
+	}
+	r.users = append(r.users, stored)
+	return nil
+}
+
+func (r *UserRepository) GetByUID(db *gorm.DB, uid dal.UID) (*dal.User, response.SError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if u := r.find(func(u *dal.User) bool { return u.UID == uid }); u != nil {
+		return copyOf(u), nil
+	}
+	return nil, nil
+}
+
+func (r *UserRepository) GetByEmail(db *gorm.DB, email string) (*dal.User, response.SError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if u := r.find(func(u *dal.User) bool { return u.Email != nil && *u.Email == email }); u != nil {
+		return copyOf(u), nil
+	}
+	return nil, nil
+}
+
+// list get the copies of the stored users matching the condition
+func (r *UserRepository) list(match func(u *dal.User) bool) []*dal.User {
+	var users []*dal.User
+	for _, u := range r.users {
+		if match(u) {
+			users = append(users, copyOf(u))
+		}
+	}
+	return users
+}
+
+func (r *UserRepository) ListByUIDs(db *gorm.DB, uids []dal.UID) ([]*dal.User, response.SError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.list(func(u *dal.User) bool { return hasValue(uids, u.UID) }), nil
+}
+
+// update apply fn to the stored user of uid if exists
+func (r *UserRepository) update(uid dal.UID, fn func(u *dal.User)) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if u := r.find(func(u *dal.User) bool { return u.UID == uid }); u != nil {
+		fn(u)
+	}
+	return nil
+}
+
+func (r *UserRepository) UpdateUser(db *gorm.DB, uid dal.UID, updateFields *dal.UserUpdatableFields) response.SError {
+	return r.update(uid, func(u *dal.User) {
+		if updateFields.Name != "" {
+			u.Name = &updateFields.Name
+		}
+		if updateFields.Email != "" {
+			u.Email = &updateFields.Email
+		}
+		if updateFields.EmailActive != nil {
+			u.EmailActive = *updateFields.EmailActive
+		}
+		if updateFields.EmailBind != nil {
+			u.EmailBind = *updateFields.EmailBind
+		}
+		if updateFields.Password != nil {
+			u.Password = &dal.Password{Data: updateFields.Password.HashedValue(), Hashed: true}
+		}
+		if updateFields.Portrait != "" {
+			u.Portrait = &updateFields.Portrait
+		}
+		if updateFields.Language != nil {
+			u.Language = *updateFields.Language
+		}
+	})
+}
+
+// containsIgnoreCase whether any of the values contains the text ignoring case
+func containsIgnoreCase(text string, values ...*string) bool {
+	text = strings.ToLower(text)
+	for _, v := range values {
+		if v != nil && strings.Contains(strings.ToLower(*v), text) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *UserRepository) SearchUserByNameOrUID(db *gorm.DB, text string, pagination *dal.Pagination) ([]*dal.User, response.SError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	users := r.list(func(u *dal.User) bool {
+		uid := string(u.UID)
+		return containsIgnoreCase(text, u.Name, &uid)
+	})
+	return paginate(users, pagination), nil
+}
+
+func (r *UserRepository) SearchUserForAdmin(db *gorm.DB, text string, pagination *dal.Pagination) ([]*dal.User, uint, response.SError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	users := r.list(func(u *dal.User) bool {
+		uid := string(u.UID)
+		return containsIgnoreCase(text, &uid, u.Name, u.Email)
+	})
+	return paginate(users, pagination), uint(len(users)), nil
+}
+
+func (r *UserRepository) SetDisabled(db *gorm.DB, uid dal.UID, disabled bool) response.SError {
+	return r.update(uid, func(u *dal.User) { u.Disabled = disabled })
+}
+
+func (r *UserRepository) ClearName(db *gorm.DB, uid dal.UID) response.SError {
+	return r.update(uid, func(u *dal.User) { u.Name = nil })
+}
+
+func (r *UserRepository) ClearPortrait(db *gorm.DB, uid dal.UID) response.SError {
+	return r.update(uid, func(u *dal.User) { u.Portrait = nil })
+}
+
+func (r *UserRepository) SetDeleteAt(db *gorm.DB, uid dal.UID, deleteAt *time.Time) response.SError {
+	return r.update(uid, func(u *dal.User) { u.DeleteAt = deleteAt })
+}
+
+func (r *UserRepository) ListDeleteDue(db *gorm.DB, before time.Time, limit int) ([]*dal.User, response.SError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	users := r.list(func(u *dal.User) bool { return u.DeleteAt != nil && !u.DeleteAt.After(before) })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (r *UserRepository) DeleteByUID(db *gorm.DB, uid dal.UID) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	users := r.users[:0]
+	for _, u := range r.users {
+		if u.UID != uid {
+			users = append(users, u)
+		}
+	}
+	r.users = users
+	return nil
+}
@@ -0,0 +1,152 @@
+package daltest
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"sort"
+	"sync"
+	"time"
+)
+
+// UserHabitConfigRepository an in-memory dal.UserHabitConfigRepository
+type UserHabitConfigRepository struct {
+	mu      sync.Mutex
+	configs []*dal.UserHabitConfig
+}
+
+var _ dal.UserHabitConfigRepository = &UserHabitConfigRepository{}
+
+// NewUserHabitConfigRepository create an empty UserHabitConfigRepository
+func NewUserHabitConfigRepository() *UserHabitConfigRepository {
+	return &UserHabitConfigRepository{}
+}
+
+func (r *UserHabitConfigRepository) Add(db *gorm.DB, c *dal.UserHabitConfig) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, stored := range r.configs {
+		if stored.UID == c.UID && stored.HabitID == c.HabitID && !stored.DeleteAt.Valid {
+			return response.ErrroCode_InternalUnknownError.New("add one user habit config fail, duplicated uid and habit id")
+		}
+	}
+	r.configs = filter(r.configs, func(stored *dal.UserHabitConfig) bool {
+		return stored.UID != c.UID || stored.HabitID != c.HabitID
+	})
+	c.UpdateAt = time.Now().UTC()
+	r.configs = append(r.configs, copyOf(c))
+	return nil
+}
+
+// update apply the update fields to every stored config matching the condition
+func (r *UserHabitConfigRepository) update(match func(c *dal.UserHabitConfig) bool, updateFields *dal.UserHabitConfigUpdatableFields) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	for _, c := range r.configs {
+		if !match(c) || c.DeleteAt.Valid {
+			continue
+		}
+		if updateFields.CurrentStreak != nil || updateFields.LongestStreak != nil ||
+			updateFields.StreakUpdateAt != nil || updateFields.HeatmapColor != "" {
+			c.UpdateAt = now
+		}
+		if updateFields.CurrentStreak != nil {
+			c.CurrentStreak = *updateFields.CurrentStreak
+		}
+		if updateFields.LongestStreak != nil {
+			c.LongestStreak = *updateFields.LongestStreak
+		}
+		if updateFields.StreakUpdateAt != nil {
+			streakUpdateAt := *updateFields.StreakUpdateAt
+			c.StreakUpdateAt = &streakUpdateAt
+		}
+		if updateFields.HeatmapColor != "" {
+			c.HeatmapColor = updateFields.HeatmapColor
+		}
+	}
+}
+
+func (r *UserHabitConfigRepository) Update(db *gorm.DB, uid dal.UID, habitID uint64, updateFields *dal.UserHabitConfigUpdatableFields) response.SError {
+	r.update(func(c *dal.UserHabitConfig) bool { return c.UID == uid && c.HabitID == habitID }, updateFields)
+	return nil
+}
+
+func (r *UserHabitConfigRepository) UpdateMany(db *gorm.DB, uid dal.UID, habitIDs []uint64, updateFields *dal.UserHabitConfigUpdatableFields) response.SError {
+	r.update(func(c *dal.UserHabitConfig) bool { return c.UID == uid && hasValue(habitIDs, c.HabitID) }, updateFields)
+	return nil
+}
+
+func (r *UserHabitConfigRepository) IncreaseCurrentStreakByOne(db *gorm.DB, uids []dal.UID, habitID uint64) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	for _, c := range r.configs {
+		if c.HabitID != habitID || !hasValue(uids, c.UID) || c.DeleteAt.Valid {
+			continue
+		}
+		c.CurrentStreak++
+		c.StreakUpdateAt = &now
+		c.UpdateAt = now
+		if c.CurrentStreak > c.LongestStreak {
+			c.LongestStreak = c.CurrentStreak
+		}
+	}
+	return nil
+}
+
+func (r *UserHabitConfigRepository) GetByUIDAndHabitID(db *gorm.DB, uid dal.UID, habitID uint64) (*dal.UserHabitConfig, response.SError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.configs {
+		if c.UID == uid && c.HabitID == habitID && !c.DeleteAt.Valid {
+			return copyOf(c), nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *UserHabitConfigRepository) ListUserHabitConfig(db *gorm.DB, uid dal.UID, habits []uint64) ([]*dal.UserHabitConfig, response.SError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var configs []*dal.UserHabitConfig
+	for _, c := range r.configs {
+		if c.UID == uid && hasValue(habits, c.HabitID) && !c.DeleteAt.Valid {
+			configs = append(configs, copyOf(c))
+		}
+	}
+	return configs, nil
+}
+
+func (r *UserHabitConfigRepository) ListByUIDUpdatedAfter(db *gorm.DB, uid dal.UID, after time.Time) ([]*dal.UserHabitConfig, response.SError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var configs []*dal.UserHabitConfig
+	for _, c := range r.configs {
+		if c.UID == uid && updatedAfter(c.UpdateAt, after) {
+			configs = append(configs, copyOf(c))
+		}
+	}
+	sort.SliceStable(configs, func(i, j int) bool { return configs[i].UpdateAt.Before(configs[j].UpdateAt) })
+	return configs, nil
+}
+
+func (r *UserHabitConfigRepository) DeleteByHabitIDAndUID(db *gorm.DB, habitID uint64, uid dal.UID) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	for _, c := range r.configs {
+		if c.HabitID == habitID && c.UID == uid && !c.DeleteAt.Valid {
+			c.UpdateAt = now
+			c.DeleteAt = tombstone(now)
+		}
+	}
+	return nil
+}
+
+func (r *UserHabitConfigRepository) EraseByUID(db *gorm.DB, uid dal.UID) response.SError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.configs = filter(r.configs, func(c *dal.UserHabitConfig) bool { return c.UID != uid })
+	return nil
+}
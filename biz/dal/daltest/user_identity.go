@@ -0,0 +1,47 @@
+package daltest
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+)
+
+// UserIdentityRepository an in-memory dal.UserIdentityRepository
+type UserIdentityRepository struct {
+	identities *table[dal.UserIdentity]
+}
+
+var _ dal.UserIdentityRepository = &UserIdentityRepository{}
+
+// NewUserIdentityRepository create an empty UserIdentityRepository
+func NewUserIdentityRepository() *UserIdentityRepository {
+	return &UserIdentityRepository{identities: newTable(func(i *dal.UserIdentity, id uint64) { i.ID = id })}
+}
+
+func (r *UserIdentityRepository) Add(db *gorm.DB, i *dal.UserIdentity) response.SError {
+	if r.identities.first(func(stored *dal.UserIdentity) bool {
+		return stored.Provider == i.Provider && stored.Subject == i.Subject
+	}) != nil {
+		return response.ErrroCode_InternalUnknownError.New("add user identity fail, duplicated provider and subject")
+	}
+	r.identities.add(i)
+	return nil
+}
+
+func (r *UserIdentityRepository) GetByProviderAndSubject(db *gorm.DB, provider dal.IdentityProvider, subject string) (*dal.UserIdentity, response.SError) {
+	return r.identities.first(func(i *dal.UserIdentity) bool { return i.Provider == provider && i.Subject == subject }), nil
+}
+
+func (r *UserIdentityRepository) ListByUID(db *gorm.DB, uid dal.UID) ([]*dal.UserIdentity, response.SError) {
+	return r.identities.list(func(i *dal.UserIdentity) bool { return i.UID == uid }), nil
+}
+
+func (r *UserIdentityRepository) DeleteByUID(db *gorm.DB, uid dal.UID) response.SError {
+	r.identities.delete(func(i *dal.UserIdentity) bool { return i.UID == uid })
+	return nil
+}
+
+func (r *UserIdentityRepository) DeleteByUIDAndProvider(db *gorm.DB, uid dal.UID, provider dal.IdentityProvider) response.SError {
+	r.identities.delete(func(i *dal.UserIdentity) bool { return i.UID == uid && i.Provider == provider })
+	return nil
+}
@@ -0,0 +1,134 @@
+package dal
+
+import (
+	"github.com/pkg/errors"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"time"
+)
+
+// EmailBindRequest a change of a user's email, it takes effect only after confirmed from the new address,
+// and can be canceled from the old address before that, or reverted from the old address for a while after that
+type EmailBindRequest struct {
+	ID         uint64
+	UID        UID
+	SID        string  `gorm:"column:sid"` // the session starting the change, the other sessions are revoked on confirm
+	OldEmail   *string // the email before the change, restored on revert
+	NewEmail   string
+	TokenHash  string // hash of the confirm token sent to the new address
+	CancelHash string // hash of the cancel token sent to the old address, empty if the user has no email before
+	CreateAt   time.Time
+	ExpireAt   time.Time
+	ConfirmAt  *time.Time
+	CancelAt   *time.Time // cancel time of a pending request, or revert time of a confirmed one
+}
+
+// IsPending whether the request is neither confirmed, canceled nor expired
+func (r *EmailBindRequest) IsPending(now time.Time) bool {
+	return r.ConfirmAt == nil && r.CancelAt == nil && now.Before(r.ExpireAt)
+}
+
+// IsRevertable whether the request is confirmed less than window ago and not reverted yet
+func (r *EmailBindRequest) IsRevertable(now time.Time, window time.Duration) bool {
+	return r.ConfirmAt != nil && r.CancelAt == nil && r.OldEmail != nil && now.Before(r.ConfirmAt.Add(window))
+}
+
+// EmailBindRequestRepository the operations on the email_bind_request table, implemented by emailBindRequestDBHD
+type EmailBindRequestRepository interface {
+	Add(db *gorm.DB, r *EmailBindRequest) response.SError
+	GetByTokenHash(db *gorm.DB, tokenHash string) (*EmailBindRequest, response.SError)
+	GetByCancelHash(db *gorm.DB, cancelHash string) (*EmailBindRequest, response.SError)
+	Confirm(db *gorm.DB, id uint64, confirmAt time.Time) (bool, response.SError)
+	Cancel(db *gorm.DB, id uint64, cancelAt time.Time) (bool, response.SError)
+	Revert(db *gorm.DB, id uint64, revertAt time.Time) (bool, response.SError)
+	CancelPendingByUID(db *gorm.DB, uid UID, cancelAt time.Time) response.SError
+	DeleteByUID(db *gorm.DB, uid UID) response.SError
+}
+
+// emailBindRequestDBHD the handler to operate the email_bind_request table
+type emailBindRequestDBHD struct{}
+
+// EmailBindRequestDBHD the default emailBindRequestDBHD
+var EmailBindRequestDBHD = &emailBindRequestDBHD{}
+
+var _ EmailBindRequestRepository = EmailBindRequestDBHD
+
+// Add insert an EmailBindRequest record
+func (hd *emailBindRequestDBHD) Add(db *gorm.DB, r *EmailBindRequest) response.SError {
+	err := db.Create(r).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "add email bind request fail")
+	}
+	return nil
+}
+
+func (hd *emailBindRequestDBHD) getBy(db *gorm.DB, column string, value string) (*EmailBindRequest, response.SError) {
+	var r *EmailBindRequest
+	err := db.Where(column+"=?", value).First(&r).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "get email bind request fail")
+	}
+	return r, nil
+}
+
+// GetByTokenHash get an EmailBindRequest by the hash of its confirm token, return nil if not found
+func (hd *emailBindRequestDBHD) GetByTokenHash(db *gorm.DB, tokenHash string) (*EmailBindRequest, response.SError) {
+	return hd.getBy(db, "token_hash", tokenHash)
+}
+
+// GetByCancelHash get an EmailBindRequest by the hash of its cancel token, return nil if not found
+func (hd *emailBindRequestDBHD) GetByCancelHash(db *gorm.DB, cancelHash string) (*EmailBindRequest, response.SError) {
+	return hd.getBy(db, "cancel_hash", cancelHash)
+}
+
+// Confirm mark a pending EmailBindRequest as confirmed, return false if it is no longer pending
+func (hd *emailBindRequestDBHD) Confirm(db *gorm.DB, id uint64, confirmAt time.Time) (bool, response.SError) {
+	ret := db.Model(&EmailBindRequest{}).Where("id=? and confirm_at is null and cancel_at is null", id).
+		Update("confirm_at", confirmAt.UTC())
+	if ret.Error != nil {
+		return false, response.ErrroCode_InternalUnknownError.Wrap(ret.Error, "confirm email bind request fail")
+	}
+	return ret.RowsAffected == 1, nil
+}
+
+// Cancel mark a pending EmailBindRequest as canceled, return false if it is no longer pending
+func (hd *emailBindRequestDBHD) Cancel(db *gorm.DB, id uint64, cancelAt time.Time) (bool, response.SError) {
+	ret := db.Model(&EmailBindRequest{}).Where("id=? and confirm_at is null and cancel_at is null", id).
+		Update("cancel_at", cancelAt.UTC())
+	if ret.Error != nil {
+		return false, response.ErrroCode_InternalUnknownError.Wrap(ret.Error, "cancel email bind request fail")
+	}
+	return ret.RowsAffected == 1, nil
+}
+
+// Revert mark a confirmed EmailBindRequest as reverted, return false if it is not confirmed or already reverted
+func (hd *emailBindRequestDBHD) Revert(db *gorm.DB, id uint64, revertAt time.Time) (bool, response.SError) {
+	ret := db.Model(&EmailBindRequest{}).Where("id=? and confirm_at is not null and cancel_at is null", id).
+		Update("cancel_at", revertAt.UTC())
+	if ret.Error != nil {
+		return false, response.ErrroCode_InternalUnknownError.Wrap(ret.Error, "revert email bind request fail")
+	}
+	return ret.RowsAffected == 1, nil
+}
+
+// CancelPendingByUID cancel all the pending EmailBindRequests of a user
+func (hd *emailBindRequestDBHD) CancelPendingByUID(db *gorm.DB, uid UID, cancelAt time.Time) response.SError {
+	err := db.Model(&EmailBindRequest{}).Where("uid=? and confirm_at is null and cancel_at is null", uid).
+		Update("cancel_at", cancelAt.UTC()).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "cancel email bind requests fail")
+	}
+	return nil
+}
+
+// DeleteByUID delete all the EmailBindRequests of a user
+func (hd *emailBindRequestDBHD) DeleteByUID(db *gorm.DB, uid UID) response.SError {
+	err := db.Where("uid=?", uid).Delete(&EmailBindRequest{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete email bind requests fail")
+	}
+	return nil
+}
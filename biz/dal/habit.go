@@ -4,6 +4,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/swordandtea/lets-habit-server/biz/response"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"time"
 )
 
@@ -33,12 +34,26 @@ func (c CheckDay) Has(d CheckDay) bool {
 
 // Habit the habit model to represent a habit
 type Habit struct {
-	ID       uint64    `json:"id"`
-	Name     string    `json:"name"`
-	Identity *string   `json:"identity"`
-	LogDays  CheckDay  `json:"log_days"`
-	Owner    UID       `json:"owner"`
-	CreateAt time.Time `json:"create_at"`
+	ID       uint64         `json:"id"`
+	Name     string         `json:"name"`
+	Identity *string        `json:"identity"`
+	LogDays  CheckDay       `json:"log_days"`
+	Owner    UID            `json:"owner"`
+	CreateAt time.Time      `json:"create_at"`
+	UpdateAt time.Time      `json:"update_at"`
+	DeleteAt gorm.DeletedAt `json:"delete_at"` // set for the tombstone of a deleted habit, filtered out by the queries
+}
+
+// HabitRepository the operations on the habit table, implemented by habitDBHD
+type HabitRepository interface {
+	Add(db *gorm.DB, h *Habit) response.SError
+	GetByID(db *gorm.DB, id uint64, forUpdate bool) (*Habit, response.SError)
+	ListUserJoinedHabits(db *gorm.DB, uid UID, pagination *Pagination) ([]*Habit, uint, response.SError)
+	UpdateHabit(db *gorm.DB, id uint64, updateFields *HabitUpdatableFields) response.SError
+	ListByIDsUpdatedAfter(db *gorm.DB, ids []uint64, after time.Time) ([]*Habit, response.SError)
+	DeleteByID(db *gorm.DB, id uint64) response.SError
+	Touch(db *gorm.DB, id uint64) response.SError
+	EraseByOwner(db *gorm.DB, owner UID) response.SError
 }
 
 // habitDBHD the handler to operate the habit table
@@ -47,8 +62,11 @@ type habitDBHD struct{}
 // HabitDBHD the default habitDBHD
 var HabitDBHD = &habitDBHD{}
 
+var _ HabitRepository = HabitDBHD
+
 // Add insert a Habit record into db
 func (hd *habitDBHD) Add(db *gorm.DB, h *Habit) response.SError {
+	h.UpdateAt = time.Now().UTC()
 	err := db.Create(h).Error
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "add one habit fail")
@@ -56,8 +74,20 @@ func (hd *habitDBHD) Add(db *gorm.DB, h *Habit) response.SError {
 	return nil
 }
 
-// GetByID get ad Habit by id
-func (hd *habitDBHD) GetByID(db *gorm.DB, id uint64) (*Habit, response.SError) {
+// GetByID get ad Habit by id, lock the row until the transaction ends if forUpdate
+func (hd *habitDBHD) GetByID(db *gorm.DB, id uint64, forUpdate bool) (*Habit, response.SError) {
+	if forUpdate {
+		if db.Dialector.Name() == "sqlite" {
+			// sqlite has no row lock and ignores the locking clause, take the write lock of the database by a no-op
+			// update instead, or two transactions reading before writing fail each other with database is locked
+			err := db.Model(&Habit{}).Where("id=?", id).Update("id", gorm.Expr("id")).Error
+			if err != nil {
+				return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "lock habit fail")
+			}
+		} else {
+			db = db.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+	}
 	var h *Habit
 	err := db.Where("id=?", id).First(&h).Error
 	if err != nil {
@@ -81,7 +111,7 @@ func (hd *habitDBHD) ListUserJoinedHabits(db *gorm.DB, uid UID, pagination *Pagi
 	}
 
 	offset := (pagination.Page - 1) * pagination.PageSize
-	err = db.Where("id in (?)", subquery).Offset(int(offset)).Limit(int(pagination.PageSize)).Find(&hs).Error
+	err = db.Where("id in (?)", subquery).Order("id").Offset(int(offset)).Limit(int(pagination.PageSize)).Find(&hs).Error
 	if err != nil {
 		return nil, 0, response.ErrroCode_InternalUnknownError.Wrap(err, "list user joined habits fail")
 	}
@@ -108,6 +138,7 @@ func (hd *habitDBHD) UpdateHabit(db *gorm.DB, id uint64, updateFields *HabitUpda
 	if len(updates) == 0 {
 		return nil
 	}
+	updates["update_at"] = time.Now().UTC()
 	err := db.Model(&Habit{}).Where("id=?", id).Updates(updates).Error
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "update habit fail")
@@ -115,11 +146,47 @@ func (hd *habitDBHD) UpdateHabit(db *gorm.DB, id uint64, updateFields *HabitUpda
 	return nil
 }
 
-// DeleteByID delete a Habit record from db by id
+// ListByIDsUpdatedAfter list the Habits of the ids updated or deleted after a time, including the tombstones,
+// a zero time lists all of them
+func (hd *habitDBHD) ListByIDsUpdatedAfter(db *gorm.DB, ids []uint64, after time.Time) ([]*Habit, response.SError) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	q := db.Unscoped().Where("id in (?)", ids)
+	if !after.IsZero() {
+		q = q.Where("update_at > ?", after.UTC())
+	}
+	var hs []*Habit
+	err := q.Order("update_at, id").Find(&hs).Error
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "list habits updated after fail")
+	}
+	return hs, nil
+}
+
+// DeleteByID delete a Habit record by id, leaving its tombstone
 func (hd *habitDBHD) DeleteByID(db *gorm.DB, id uint64) response.SError {
-	err := db.Where("id=?", id).Delete(&Habit{}).Error
+	err := softDelete(db.Model(&Habit{}).Where("id=?", id))
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete habit fail")
 	}
 	return nil
 }
+
+// Touch mark a Habit updated without changing it, so that the clients pull it and all its members again
+func (hd *habitDBHD) Touch(db *gorm.DB, id uint64) response.SError {
+	err := db.Model(&Habit{}).Where("id=?", id).Update("update_at", time.Now().UTC()).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "touch habit fail")
+	}
+	return nil
+}
+
+// EraseByOwner remove the Habits of an owner for good including the tombstones, when the owner's account is erased
+func (hd *habitDBHD) EraseByOwner(db *gorm.DB, owner UID) response.SError {
+	err := db.Unscoped().Where("owner=?", owner).Delete(&Habit{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "erase habits of owner fail")
+	}
+	return nil
+}
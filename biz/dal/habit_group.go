@@ -4,12 +4,31 @@ import (
 	"github.com/pkg/errors"
 	"github.com/swordandtea/lets-habit-server/biz/response"
 	"gorm.io/gorm"
+	"time"
 )
 
 // HabitGroup the model to record the related between user and their joined habits
 type HabitGroup struct {
-	HabitID uint64 `json:"habitID"`
-	UID     UID    `json:"uid"`
+	HabitID  uint64         `json:"habitID"`
+	UID      UID            `json:"uid"`
+	UpdateAt time.Time      `json:"update_at"`
+	DeleteAt gorm.DeletedAt `json:"delete_at"` // set for the tombstone of a member quit, filtered out by the queries
+}
+
+// HabitGroupRepository the operations on the habit_group table, implemented by habitGroupDBHD
+type HabitGroupRepository interface {
+	Add(db *gorm.DB, hg *HabitGroup) response.SError
+	AddMulti(db *gorm.DB, hgs []*HabitGroup) response.SError
+	GetByHabitIDAndUID(db *gorm.DB, habitID uint64, uid UID) (*HabitGroup, response.SError)
+	GetByHabitIDAndExcludeUID(db *gorm.DB, habitID uint64, uid UID) (*HabitGroup, response.SError)
+	ListByHabitID(db *gorm.DB, habitID uint64) ([]*HabitGroup, response.SError)
+	ListByHabitIDs(db *gorm.DB, habitIDs []uint64) ([]*HabitGroup, response.SError)
+	ListByUID(db *gorm.DB, uid UID) ([]*HabitGroup, response.SError)
+	ListByUIDUpdatedAfter(db *gorm.DB, uid UID, after time.Time) ([]*HabitGroup, response.SError)
+	ListByHabitIDsUpdatedAfter(db *gorm.DB, habitIDs []uint64, after time.Time) ([]*HabitGroup, response.SError)
+	DeleteByHabitIDAndUID(db *gorm.DB, habitID uint64, uid UID) response.SError
+	DeleteByHabitIDAndUIDs(db *gorm.DB, habitID uint64, uids []UID) response.SError
+	EraseByUID(db *gorm.DB, uid UID) response.SError
 }
 
 // habitGroupDBHD the handler to operate the habit_group table
@@ -18,20 +37,31 @@ type habitGroupDBHD struct{}
 // HabitGroupDBHD the default habitGroupDBHD
 var HabitGroupDBHD = &habitGroupDBHD{}
 
+var _ HabitGroupRepository = HabitGroupDBHD
+
+// purgeTombstone remove the tombstone of a member quit before, so that the member can join again
+func (hd *habitGroupDBHD) purgeTombstone(db *gorm.DB, hg *HabitGroup) error {
+	return db.Unscoped().Where("habit_id=? and uid=? and delete_at is not null", hg.HabitID, hg.UID).Delete(&HabitGroup{}).Error
+}
+
 // Add insert a HabitGroup record
 func (hd *habitGroupDBHD) Add(db *gorm.DB, hg *HabitGroup) response.SError {
-	err := db.Create(hg).Error
-	if err != nil {
-		return response.ErrroCode_InternalUnknownError.Wrap(err, "add habit group fail")
-	}
-	return nil
+	return hd.AddMulti(db, []*HabitGroup{hg})
 }
 
 // AddMulti insert multiple HabitGroup record at one time
 func (hd *habitGroupDBHD) AddMulti(db *gorm.DB, hgs []*HabitGroup) response.SError {
+	now := time.Now().UTC()
+	for _, hg := range hgs {
+		err := hd.purgeTombstone(db, hg)
+		if err != nil {
+			return response.ErrroCode_InternalUnknownError.Wrap(err, "purge habit group tombstone fail")
+		}
+		hg.UpdateAt = now
+	}
 	err := db.Create(hgs).Error
 	if err != nil {
-		return response.ErrroCode_InternalUnknownError.Wrap(err, "add multi habit group fail")
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "add habit group fail")
 	}
 	return nil
 }
@@ -90,18 +120,62 @@ func (hd *habitGroupDBHD) ListByUID(db *gorm.DB, uid UID) ([]*HabitGroup, respon
 	return hgs, nil
 }
 
+// ListByUIDUpdatedAfter list the HabitGroups of a user joined or quit after a time, including the tombstones,
+// a zero time lists all of them
+func (hd *habitGroupDBHD) ListByUIDUpdatedAfter(db *gorm.DB, uid UID, after time.Time) ([]*HabitGroup, response.SError) {
+	q := db.Unscoped().Where("uid=?", uid)
+	if !after.IsZero() {
+		q = q.Where("update_at > ?", after.UTC())
+	}
+	var hgs []*HabitGroup
+	err := q.Order("update_at").Find(&hgs).Error
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "list habit group by uid updated after fail")
+	}
+	return hgs, nil
+}
+
+// ListByHabitIDsUpdatedAfter list the HabitGroups of the habits joined or quit after a time, including the tombstones,
+// a zero time lists all of them
+func (hd *habitGroupDBHD) ListByHabitIDsUpdatedAfter(db *gorm.DB, habitIDs []uint64, after time.Time) ([]*HabitGroup, response.SError) {
+	if len(habitIDs) == 0 {
+		return nil, nil
+	}
+	q := db.Unscoped().Where("habit_id in (?)", habitIDs)
+	if !after.IsZero() {
+		q = q.Where("update_at > ?", after.UTC())
+	}
+	var hgs []*HabitGroup
+	err := q.Order("update_at").Find(&hgs).Error
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "list habit group by habit ids updated after fail")
+	}
+	return hgs, nil
+}
+
+// DeleteByHabitIDAndUID remove a member from the group of a habit, leaving its tombstone
 func (hd *habitGroupDBHD) DeleteByHabitIDAndUID(db *gorm.DB, habitID uint64, uid UID) response.SError {
-	err := db.Where("habit_id=? and uid=?", habitID, uid).Delete(&HabitGroup{}).Error
+	err := softDelete(db.Model(&HabitGroup{}).Where("habit_id=? and uid=?", habitID, uid))
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete one habit group fail")
 	}
 	return nil
 }
 
+// DeleteByHabitIDAndUIDs remove the members from the group of a habit, leaving their tombstones
 func (hd *habitGroupDBHD) DeleteByHabitIDAndUIDs(db *gorm.DB, habitID uint64, uids []UID) response.SError {
-	err := db.Where("habit_id=? and uid in (?)", habitID, uids).Delete(&HabitGroup{}).Error
+	err := softDelete(db.Model(&HabitGroup{}).Where("habit_id=? and uid in (?)", habitID, uids))
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete habit groups fail")
 	}
 	return nil
 }
+
+// EraseByUID remove all the HabitGroups of a user for good including the tombstones, when the user's account is erased
+func (hd *habitGroupDBHD) EraseByUID(db *gorm.DB, uid UID) response.SError {
+	err := db.Unscoped().Where("uid=?", uid).Delete(&HabitGroup{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "erase habit groups of user fail")
+	}
+	return nil
+}
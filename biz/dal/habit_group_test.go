@@ -0,0 +1,113 @@
+package dal
+
+import (
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func TestHabitGroupTombstone(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *gorm.DB) {
+		habit := &Habit{Name: "read", LogDays: CheckDayAll, Owner: "u1", CreateAt: time.Now().UTC()}
+		sErr := HabitDBHD.Add(db, habit)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		sErr = HabitGroupDBHD.AddMulti(db, []*HabitGroup{{HabitID: habit.ID, UID: "u1"}, {HabitID: habit.ID, UID: "u2"}})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		sErr = UserHabitConfigDBHD.Add(db, &UserHabitConfig{UID: "u2", HabitID: habit.ID})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		// the datetime of mysql keeps whole seconds by default
+		beforeQuit := time.Now().Add(-time.Second)
+
+		// the quit member is left as a tombstone, hidden from the queries but the changes
+		sErr = HabitGroupDBHD.DeleteByHabitIDAndUID(db, habit.ID, "u2")
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		sErr = UserHabitConfigDBHD.DeleteByHabitIDAndUID(db, habit.ID, "u2")
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		hgs, sErr := HabitGroupDBHD.ListByHabitID(db, habit.ID)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(hgs) != 1 || hgs[0].UID != "u1" {
+			t.Fatalf("unexpected groups %+v", hgs)
+		}
+		_, total, sErr := HabitDBHD.ListUserJoinedHabits(db, "u2", &Pagination{Page: 1, PageSize: 10})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if total != 0 {
+			t.Fatal("quit habit should not be listed")
+		}
+		hgs, sErr = HabitGroupDBHD.ListByUIDUpdatedAfter(db, "u2", beforeQuit)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(hgs) != 1 || !hgs[0].DeleteAt.Valid {
+			t.Fatalf("expect the tombstone of the quit member, got %+v", hgs)
+		}
+		configs, sErr := UserHabitConfigDBHD.ListByUIDUpdatedAfter(db, "u2", beforeQuit)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(configs) != 1 || !configs[0].DeleteAt.Valid {
+			t.Fatalf("expect the tombstone of the config, got %+v", configs)
+		}
+
+		// the member joins back in place of the tombstone
+		sErr = HabitGroupDBHD.Add(db, &HabitGroup{HabitID: habit.ID, UID: "u2"})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		sErr = UserHabitConfigDBHD.Add(db, &UserHabitConfig{UID: "u2", HabitID: habit.ID})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		hgs, sErr = HabitGroupDBHD.ListByHabitIDsUpdatedAfter(db, []uint64{habit.ID}, time.Time{})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(hgs) != 2 || hgs[0].DeleteAt.Valid || hgs[1].DeleteAt.Valid {
+			t.Fatalf("unexpected groups %+v", hgs)
+		}
+
+		// the deleted habit is purged with the tombstones
+		sErr = HabitDBHD.DeleteByID(db, habit.ID)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		h, sErr := HabitDBHD.GetByID(db, habit.ID, false)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if h != nil {
+			t.Fatal("deleted habit should not be got")
+		}
+		habits, sErr := HabitDBHD.ListByIDsUpdatedAfter(db, []uint64{habit.ID}, beforeQuit)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(habits) != 1 || !habits[0].DeleteAt.Valid {
+			t.Fatalf("expect the tombstone of the habit, got %+v", habits)
+		}
+		sErr = PurgeTombstones(db, time.Now().Add(time.Second))
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		habits, sErr = HabitDBHD.ListByIDsUpdatedAfter(db, []uint64{habit.ID}, time.Time{})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(habits) != 0 {
+			t.Fatalf("tombstone should be purged, got %+v", habits)
+		}
+	})
+}
@@ -6,19 +6,76 @@ import (
 	"time"
 )
 
+// LogDayLayout the layout of HabitLogRecord.LogDay
+const LogDayLayout = "2006-01-02"
+
+const habitLogRecordTable = "habit_log_records"
+
 type HabitLogRecord struct {
 	ID      uint64    `json:"id"`
 	HabitID uint64    `json:"habit_id"`
 	UID     UID       `json:"uid"`
 	LogAt   time.Time `json:"log_at"`
+	// LogDay the day logged for in the zone of the client, a user logs a habit at most once a day
+	LogDay string `json:"log_day"`
+	// ClientID the id generated by the client of a check-in synced from offline, nil if logged online
+	ClientID *string   `json:"client_id,omitempty"`
+	UpdateAt time.Time `json:"update_at"`
+	// DeleteAt set for the tombstone of a deleted record, filtered out by the queries
+	DeleteAt gorm.DeletedAt `json:"delete_at"`
+}
+
+// BeforeSave store log_at and update_at in utc, mysql datetime and postgres timestamp keep no time zone,
+// the drivers write the wall clock of the time as is
+func (r *HabitLogRecord) BeforeSave(*gorm.DB) error {
+	r.LogAt = r.LogAt.UTC()
+	r.UpdateAt = r.UpdateAt.UTC()
+	return nil
+}
+
+// AfterFind keep log_at and update_at in utc, as every driver reads them in its own location
+func (r *HabitLogRecord) AfterFind(*gorm.DB) error {
+	r.LogAt = r.LogAt.UTC()
+	r.UpdateAt = r.UpdateAt.UTC()
+	return nil
+}
+
+// HabitLogRecordRepository the operations on the habit_log_record table, implemented by habitLogRecordDBHD
+type HabitLogRecordRepository interface {
+	Add(db *gorm.DB, r *HabitLogRecord) response.SError
+	AddMulti(db *gorm.DB, rs []*HabitLogRecord) response.SError
+	ListByUID(db *gorm.DB, uid UID, fromTime *time.Time, toTime *time.Time) ([]*HabitLogRecord, response.SError)
+	ListByUIDHabitIDs(db *gorm.DB, uid UID, habitIDs []uint64, fromTime *time.Time, toTime *time.Time) ([]*HabitLogRecord, response.SError)
+	ListByHabitIDAndLogDay(db *gorm.DB, habitID uint64, logDay string) ([]*HabitLogRecord, response.SError)
+	ListByUIDUpdatedAfter(db *gorm.DB, uid UID, after time.Time) ([]*HabitLogRecord, response.SError)
+	DeleteByHabitIDAndUID(db *gorm.DB, habitID uint64, uid UID) response.SError
+	EraseByUID(db *gorm.DB, uid UID) response.SError
+}
+
+// purgeLogRecordTombstones remove the tombstones in the table taking the unique keys of r, the log day of the habit and
+// the client id of the user, so that a user quit a habit can log again after joining back
+func purgeLogRecordTombstones(db *gorm.DB, table string, r *HabitLogRecord) error {
+	q := db.Table(table).Unscoped().Where("delete_at is not null")
+	if r.ClientID != nil {
+		q = q.Where("(habit_id = ? AND uid = ? AND log_day = ?) OR (uid = ? AND client_id = ?)", r.HabitID, r.UID, r.LogDay, r.UID, *r.ClientID)
+	} else {
+		q = q.Where("habit_id = ? AND uid = ? AND log_day = ?", r.HabitID, r.UID, r.LogDay)
+	}
+	return q.Delete(&HabitLogRecord{}).Error
 }
 
 type habitLogRecordDBHD struct{}
 
 var HabitLogRecordDBHD = &habitLogRecordDBHD{}
 
+var _ HabitLogRecordRepository = HabitLogRecordDBHD
+
 func (hd *habitLogRecordDBHD) Add(db *gorm.DB, r *HabitLogRecord) response.SError {
-	err := db.Create(r).Error
+	err := purgeLogRecordTombstones(db, habitLogRecordTable, r)
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "purge habit log record tombstones fail")
+	}
+	err = db.Create(r).Error
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "add one habit log record fail")
 	}
@@ -26,6 +83,12 @@ func (hd *habitLogRecordDBHD) Add(db *gorm.DB, r *HabitLogRecord) response.SErro
 }
 
 func (hd *habitLogRecordDBHD) AddMulti(db *gorm.DB, rs []*HabitLogRecord) response.SError {
+	for _, r := range rs {
+		err := purgeLogRecordTombstones(db, habitLogRecordTable, r)
+		if err != nil {
+			return response.ErrroCode_InternalUnknownError.Wrap(err, "purge habit log record tombstones fail")
+		}
+	}
 	err := db.CreateInBatches(rs, 10).Error
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "add multi habit log record fail")
@@ -66,10 +129,45 @@ func (hd *habitLogRecordDBHD) ListByUIDHabitIDs(db *gorm.DB, uid UID, habitIDs [
 	return results, nil
 }
 
+// ListByHabitIDAndLogDay list the records of all the members of a habit logged for a day
+func (hd *habitLogRecordDBHD) ListByHabitIDAndLogDay(db *gorm.DB, habitID uint64, logDay string) ([]*HabitLogRecord, response.SError) {
+	var results []*HabitLogRecord
+	err := db.Where("habit_id = ? AND log_day = ?", habitID, logDay).Find(&results).Error
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "list habit log records by habit id and log day fail")
+	}
+	return results, nil
+}
+
+// ListByUIDUpdatedAfter list the records of a user updated or deleted after a time, including the tombstones,
+// ordered by the update time, a zero time lists all of them
+func (hd *habitLogRecordDBHD) ListByUIDUpdatedAfter(db *gorm.DB, uid UID, after time.Time) ([]*HabitLogRecord, response.SError) {
+	q := db.Unscoped().Where("uid = ?", uid)
+	if !after.IsZero() {
+		q = q.Where("update_at > ?", after.UTC())
+	}
+	var results []*HabitLogRecord
+	err := q.Order("update_at, id").Find(&results).Error
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "list habit log records updated after fail")
+	}
+	return results, nil
+}
+
+// DeleteByHabitIDAndUID delete the records of a user on a habit, leaving their tombstones
 func (hd *habitLogRecordDBHD) DeleteByHabitIDAndUID(db *gorm.DB, habitID uint64, uid UID) response.SError {
-	err := db.Where("habit_id=? and uid=?", habitID, uid).Delete(&HabitLogRecord{}).Error
+	err := softDelete(db.Model(&HabitLogRecord{}).Where("habit_id=? and uid=?", habitID, uid))
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete habit log record fail")
 	}
 	return nil
 }
+
+// EraseByUID remove all the records of a user for good including the tombstones, when the user's account is erased
+func (hd *habitLogRecordDBHD) EraseByUID(db *gorm.DB, uid UID) response.SError {
+	err := db.Unscoped().Where("uid=?", uid).Delete(&HabitLogRecord{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "erase habit log records of user fail")
+	}
+	return nil
+}
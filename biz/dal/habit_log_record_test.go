@@ -0,0 +1,195 @@
+package dal
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/service"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func TestHabitLogRecordTime(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *gorm.DB) {
+		// the time is logged in the zone of the client, whole seconds as datetime of mysql has no fraction by default
+		zone := time.FixedZone("UTC+8", 8*60*60)
+		logAt := time.Date(2022, 10, 1, 1, 30, 0, 0, zone)
+		sErr := HabitLogRecordDBHD.Add(db, &HabitLogRecord{HabitID: 1, UID: "u1", LogAt: logAt})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		sErr = HabitLogRecordDBHD.AddMulti(db, []*HabitLogRecord{
+			{HabitID: 1, UID: "u2", LogAt: logAt.Add(time.Hour)},
+			{HabitID: 2, UID: "u1", LogAt: logAt.Add(-time.Hour)},
+		})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		sErr = UnconfirmedHabitLogRecordDBHD.Add(db, &HabitLogRecord{HabitID: 1, UID: "u1", LogAt: logAt})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+
+		// query in another zone, the range is inclusive
+		fromTime := logAt.In(time.FixedZone("UTC-5", -5*60*60))
+		toTime := fromTime.Add(time.Hour)
+		records, sErr := HabitLogRecordDBHD.ListByUIDHabitIDs(db, "u1", []uint64{1, 2}, &fromTime, &toTime)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(records) != 1 || records[0].HabitID != 1 {
+			t.Fatalf("unexpected records %+v", records)
+		}
+		if !records[0].LogAt.Equal(logAt) || records[0].LogAt.Location() != time.UTC {
+			t.Fatalf("expect log at %v in utc, got %v", logAt, records[0].LogAt)
+		}
+
+		records, sErr = UnconfirmedHabitLogRecordDBHD.ListByHabitID(db, 1, &fromTime, &toTime)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(records) != 1 || !records[0].LogAt.Equal(logAt) || records[0].LogAt.Location() != time.UTC {
+			t.Fatalf("unexpected unconfirmed records %+v", records)
+		}
+
+		beforeLog := logAt.Add(-time.Second)
+		records, sErr = UnconfirmedHabitLogRecordDBHD.ListByHabitID(db, 1, nil, &beforeLog)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(records) != 0 {
+			t.Fatalf("unexpected unconfirmed records %+v", records)
+		}
+	})
+}
+
+func TestHabitLogRecordUniqueDay(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *gorm.DB) {
+		logAt := time.Date(2022, 10, 1, 8, 0, 0, 0, time.UTC)
+		record := &HabitLogRecord{HabitID: 1, UID: "u1", LogAt: logAt, LogDay: logAt.Format(LogDayLayout)}
+		sErr := UnconfirmedHabitLogRecordDBHD.Add(db, record)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		sErr = UnconfirmedHabitLogRecordDBHD.Add(db, &HabitLogRecord{HabitID: 1, UID: "u1", LogAt: logAt.Add(time.Hour), LogDay: record.LogDay})
+		if sErr == nil || !service.IsDuplicateKeyError(sErr.Cause()) {
+			t.Fatal("should not log twice a day", sErr)
+		}
+
+		// another day, habit or user
+		sErr = UnconfirmedHabitLogRecordDBHD.Add(db, &HabitLogRecord{HabitID: 1, UID: "u1", LogAt: logAt.AddDate(0, 0, 1), LogDay: "2022-10-02"})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		sErr = UnconfirmedHabitLogRecordDBHD.Add(db, &HabitLogRecord{HabitID: 2, UID: "u1", LogAt: logAt, LogDay: record.LogDay})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		sErr = HabitLogRecordDBHD.AddMulti(db, []*HabitLogRecord{
+			{HabitID: 1, UID: "u2", LogAt: logAt, LogDay: record.LogDay},
+			{HabitID: 1, UID: "u2", LogAt: logAt, LogDay: record.LogDay},
+		})
+		if sErr == nil || !service.IsDuplicateKeyError(sErr.Cause()) {
+			t.Fatal("should not confirm twice a day", sErr)
+		}
+	})
+}
+
+func TestHabitLogRecordDuplicateInTx(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *gorm.DB) {
+		logAt := time.Date(2022, 10, 1, 8, 0, 0, 0, time.UTC)
+		logDay := logAt.Format(LogDayLayout)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			sErr := UnconfirmedHabitLogRecordDBHD.Add(tx, &HabitLogRecord{HabitID: 1, UID: "u1", LogAt: logAt, LogDay: logDay})
+			if sErr != nil {
+				return sErr
+			}
+			sErr = UnconfirmedHabitLogRecordDBHD.Add(tx, &HabitLogRecord{HabitID: 1, UID: "u1", LogAt: logAt.Add(time.Hour), LogDay: logDay})
+			if sErr == nil || !service.IsDuplicateKeyError(sErr.Cause()) {
+				t.Fatal("should not log twice a day", sErr)
+			}
+
+			// the transaction is still usable after the duplicate
+			sErr = UnconfirmedHabitLogRecordDBHD.Add(tx, &HabitLogRecord{HabitID: 1, UID: "u2", LogAt: logAt, LogDay: logDay})
+			if sErr != nil {
+				return sErr
+			}
+			records, sErr := UnconfirmedHabitLogRecordDBHD.ListByHabitID(tx, 1, nil, nil)
+			if sErr != nil {
+				return sErr
+			}
+			if len(records) != 2 {
+				t.Fatalf("unexpected unconfirmed records %+v", records)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		records, sErr := UnconfirmedHabitLogRecordDBHD.ListByHabitID(db, 1, nil, nil)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(records) != 2 {
+			t.Fatalf("expect the transaction committed, got %+v", records)
+		}
+	})
+}
+
+func TestHabitLogRecordTombstone(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *gorm.DB) {
+		logAt := time.Date(2022, 10, 1, 8, 0, 0, 0, time.UTC)
+		clientID := "c1"
+		newRecord := func() *HabitLogRecord {
+			return &HabitLogRecord{HabitID: 1, UID: "u1", LogAt: logAt, LogDay: logAt.Format(LogDayLayout),
+				ClientID: &clientID, UpdateAt: time.Now()}
+		}
+		sErr := UnconfirmedHabitLogRecordDBHD.Add(db, newRecord())
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		sErr = HabitLogRecordDBHD.Add(db, newRecord())
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		sErr = UnconfirmedHabitLogRecordDBHD.DeleteByHabitIDAndUID(db, 1, "u1")
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		sErr = HabitLogRecordDBHD.DeleteByHabitIDAndUID(db, 1, "u1")
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+
+		records, sErr := UnconfirmedHabitLogRecordDBHD.ListByHabitID(db, 1, nil, nil)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(records) != 0 {
+			t.Fatalf("deleted records should not be listed, got %+v", records)
+		}
+		records, sErr = HabitLogRecordDBHD.ListByUIDUpdatedAfter(db, "u1", time.Time{})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(records) != 1 || !records[0].DeleteAt.Valid {
+			t.Fatalf("expect the tombstone, got %+v", records)
+		}
+
+		// the day and the client id can be logged again after joining back
+		sErr = UnconfirmedHabitLogRecordDBHD.Add(db, newRecord())
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		sErr = HabitLogRecordDBHD.AddMulti(db, []*HabitLogRecord{newRecord()})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		records, sErr = UnconfirmedHabitLogRecordDBHD.ListByUIDUpdatedAfter(db, "u1", time.Time{})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(records) != 1 || records[0].DeleteAt.Valid {
+			t.Fatalf("expect the record in place of the tombstone, got %+v", records)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package dal
+
+import (
+	"github.com/glebarez/sqlite"
+	"github.com/swordandtea/lets-habit-server/biz/migration"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"os"
+	"path"
+	"testing"
+)
+
+// dialectDSNEnvs the env vars of the dsn of the databases to test besides sqlite, a dialect is skipped if not set,
+// the database should be an empty one only for test, as all the tables are dropped after every test
+var dialectDSNEnvs = map[string]string{
+	"mysql":    "TEST_MYSQL_DSN",
+	"postgres": "TEST_POSTGRES_DSN",
+}
+
+// openTestDB open the database of a dialect to test, return nil if the dialect is not configured
+func openTestDB(t *testing.T, dialect string) *gorm.DB {
+	var dialector gorm.Dialector
+	switch dialect {
+	case "sqlite":
+		dialector = sqlite.Open(path.Join(t.TempDir(), "test.db") + "?_pragma=busy_timeout(5000)")
+	case "mysql", "postgres":
+		dsn := os.Getenv(dialectDSNEnvs[dialect])
+		if dsn == "" {
+			return nil
+		}
+		if dialect == "mysql" {
+			dialector = mysql.Open(dsn)
+		} else {
+			dialector = postgres.Open(dsn)
+		}
+	default:
+		t.Fatalf("unknown dialect %s", dialect)
+	}
+	db, err := gorm.Open(dialector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// forEachDialect run the test against every supported dialect with all the migrations applied,
+// and revert the migrations after the test
+func forEachDialect(t *testing.T, test func(t *testing.T, db *gorm.DB)) {
+	for _, dialect := range migration.Dialects() {
+		t.Run(dialect, func(t *testing.T) {
+			db := openTestDB(t, dialect)
+			if db == nil {
+				t.Skipf("%s not set", dialectDSNEnvs[dialect])
+			}
+			migrator, err := migration.NewDefaultMigrator(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// clean up what a former broken run left
+			_, err = migrator.Down(len(migrator.Migrations()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = migrator.Up(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				_, err := migrator.Down(len(migrator.Migrations()))
+				if err != nil {
+					t.Error(err)
+				}
+			})
+			test(t, db)
+		})
+	}
+}
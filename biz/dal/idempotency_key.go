@@ -0,0 +1,109 @@
+package dal
+
+import (
+	"github.com/pkg/errors"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"time"
+)
+
+// IdempotencyKey the response of a request with an idempotency key, replayed to the retries of the request,
+// used when the keys are shared by all server instances
+type IdempotencyKey struct {
+	IdempotencyKey string `gorm:"primaryKey"`
+	RequestHash    string
+	Completed      bool // false while the request is in progress
+	StatusCode     int
+	Body           []byte // nil while the request is in progress
+	ExpireAt       time.Time
+}
+
+// IdempotencyKeyRepository the operations on the idempotency_keys table, implemented by idempotencyKeyDBHD
+type IdempotencyKeyRepository interface {
+	GetByKey(db *gorm.DB, key string) (*IdempotencyKey, response.SError)
+	Add(db *gorm.DB, k *IdempotencyKey) response.SError
+	ReplaceExpired(db *gorm.DB, k *IdempotencyKey, now time.Time) (bool, response.SError)
+	Complete(db *gorm.DB, key string, statusCode int, body []byte, expireAt time.Time) response.SError
+	DeleteByKey(db *gorm.DB, key string) response.SError
+	DeleteExpired(db *gorm.DB, before time.Time) response.SError
+}
+
+// idempotencyKeyDBHD the handler to operate the idempotency_keys table
+type idempotencyKeyDBHD struct{}
+
+// IdempotencyKeyDBHD the default idempotencyKeyDBHD
+var IdempotencyKeyDBHD = &idempotencyKeyDBHD{}
+
+var _ IdempotencyKeyRepository = IdempotencyKeyDBHD
+
+// GetByKey get an IdempotencyKey by key, return nil if no such key
+func (hd *idempotencyKeyDBHD) GetByKey(db *gorm.DB, key string) (*IdempotencyKey, response.SError) {
+	var k *IdempotencyKey
+	err := db.Where("idempotency_key=?", key).First(&k).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "get idempotency key fail")
+	}
+	return k, nil
+}
+
+// Add insert an IdempotencyKey record, fail with a duplicate key error if the key exists
+func (hd *idempotencyKeyDBHD) Add(db *gorm.DB, k *IdempotencyKey) response.SError {
+	k.ExpireAt = k.ExpireAt.UTC()
+	err := db.Create(k).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "add idempotency key fail")
+	}
+	return nil
+}
+
+// ReplaceExpired replace the IdempotencyKey of the same key with k if it expired before now,
+// return false if not replaced
+func (hd *idempotencyKeyDBHD) ReplaceExpired(db *gorm.DB, k *IdempotencyKey, now time.Time) (bool, response.SError) {
+	result := db.Model(&IdempotencyKey{}).Where("idempotency_key=? and expire_at <= ?", k.IdempotencyKey, now.UTC()).
+		Updates(map[string]interface{}{
+			"request_hash": k.RequestHash,
+			"completed":    k.Completed,
+			"status_code":  k.StatusCode,
+			"body":         k.Body,
+			"expire_at":    k.ExpireAt.UTC(),
+		})
+	if result.Error != nil {
+		return false, response.ErrroCode_InternalUnknownError.Wrap(result.Error, "replace expired idempotency key fail")
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Complete store the response of the request of an IdempotencyKey
+func (hd *idempotencyKeyDBHD) Complete(db *gorm.DB, key string, statusCode int, body []byte, expireAt time.Time) response.SError {
+	err := db.Model(&IdempotencyKey{}).Where("idempotency_key=?", key).Updates(map[string]interface{}{
+		"completed":   true,
+		"status_code": statusCode,
+		"body":        body,
+		"expire_at":   expireAt.UTC(),
+	}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "complete idempotency key fail")
+	}
+	return nil
+}
+
+// DeleteByKey delete an IdempotencyKey by key
+func (hd *idempotencyKeyDBHD) DeleteByKey(db *gorm.DB, key string) response.SError {
+	err := db.Where("idempotency_key=?", key).Delete(&IdempotencyKey{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete idempotency key fail")
+	}
+	return nil
+}
+
+// DeleteExpired delete all the IdempotencyKey records expired before the time
+func (hd *idempotencyKeyDBHD) DeleteExpired(db *gorm.DB, before time.Time) response.SError {
+	err := db.Where("expire_at < ?", before.UTC()).Delete(&IdempotencyKey{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete expired idempotency keys fail")
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package dal
+
+import (
+	"github.com/pkg/errors"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"time"
+)
+
+// LoginCode a passwordless login request, the user can login either by typing the one-time code
+// or by clicking the magic link sent to the email
+type LoginCode struct {
+	ID        uint64
+	UID       UID
+	CodeHash  string // keyed hash of the one-time code
+	LinkHash  string // hash of the magic link token
+	Attempts  uint32 // failed attempts to verify the one-time code
+	CreateAt  time.Time
+	ExpireAt  time.Time
+	ConsumeAt *time.Time // when the code or link is used to login, nil if not used yet
+}
+
+// IsActive whether the login code can still be used
+func (c *LoginCode) IsActive(now time.Time, maxAttempts uint32) bool {
+	return c.ConsumeAt == nil && now.Before(c.ExpireAt) && c.Attempts < maxAttempts
+}
+
+// LoginCodeRepository the operations on the login_code table, implemented by loginCodeDBHD
+type LoginCodeRepository interface {
+	Add(db *gorm.DB, c *LoginCode) response.SError
+	GetLatestByUID(db *gorm.DB, uid UID) (*LoginCode, response.SError)
+	GetByLinkHash(db *gorm.DB, linkHash string) (*LoginCode, response.SError)
+	IncreaseAttempts(db *gorm.DB, id uint64) response.SError
+	Consume(db *gorm.DB, id uint64, consumeAt time.Time) (bool, response.SError)
+	DeleteByUID(db *gorm.DB, uid UID) response.SError
+}
+
+// loginCodeDBHD the handler to operate the login_code table
+type loginCodeDBHD struct{}
+
+// LoginCodeDBHD the default loginCodeDBHD
+var LoginCodeDBHD = &loginCodeDBHD{}
+
+var _ LoginCodeRepository = LoginCodeDBHD
+
+// Add insert a LoginCode record
+func (hd *loginCodeDBHD) Add(db *gorm.DB, c *LoginCode) response.SError {
+	err := db.Create(c).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "add login code fail")
+	}
+	return nil
+}
+
+// GetLatestByUID get the latest LoginCode of a user, return nil if no login code found
+func (hd *loginCodeDBHD) GetLatestByUID(db *gorm.DB, uid UID) (*LoginCode, response.SError) {
+	var c *LoginCode
+	err := db.Where("uid=?", uid).Last(&c).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "get login code fail")
+	}
+	return c, nil
+}
+
+// GetByLinkHash get a LoginCode by the hash of its magic link token, return nil if no login code found
+func (hd *loginCodeDBHD) GetByLinkHash(db *gorm.DB, linkHash string) (*LoginCode, response.SError) {
+	var c *LoginCode
+	err := db.Where("link_hash=?", linkHash).First(&c).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "get login code fail")
+	}
+	return c, nil
+}
+
+// IncreaseAttempts increase the failed attempts of a LoginCode by one
+func (hd *loginCodeDBHD) IncreaseAttempts(db *gorm.DB, id uint64) response.SError {
+	err := db.Model(&LoginCode{}).Where("id=?", id).UpdateColumn("attempts", gorm.Expr("attempts + ?", 1)).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "increase login code attempts fail")
+	}
+	return nil
+}
+
+// Consume mark a LoginCode as used, return false if it has been used by someone else
+func (hd *loginCodeDBHD) Consume(db *gorm.DB, id uint64, consumeAt time.Time) (bool, response.SError) {
+	ret := db.Model(&LoginCode{}).Where("id=? and consume_at is null", id).Update("consume_at", consumeAt.UTC())
+	if ret.Error != nil {
+		return false, response.ErrroCode_InternalUnknownError.Wrap(ret.Error, "consume login code fail")
+	}
+	return ret.RowsAffected == 1, nil
+}
+
+// DeleteByUID delete all the LoginCodes of a user
+func (hd *loginCodeDBHD) DeleteByUID(db *gorm.DB, uid UID) response.SError {
+	err := db.Where("uid=?", uid).Delete(&LoginCode{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete login codes fail")
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package dal
+
+import (
+	"github.com/pkg/errors"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"time"
+)
+
+// OAuthState a pending OAuth authorization, created when the user is redirected to the provider
+// and consumed when the provider redirects back with the authorization code
+type OAuthState struct {
+	ID           uint64
+	StateHash    string // hash of the state param sent to the provider
+	Provider     IdentityProvider
+	CodeVerifier string // PKCE code verifier
+	Nonce        string
+	LinkUID      UID // the user to link the identity to, empty for login
+	CreateAt     time.Time
+	ExpireAt     time.Time
+}
+
+// TableName the default naming strategy of gorm names it o_auth_states
+func (OAuthState) TableName() string {
+	return "oauth_states"
+}
+
+// OAuthStateRepository the operations on the oauth_state table, implemented by oauthStateDBHD
+type OAuthStateRepository interface {
+	Add(db *gorm.DB, s *OAuthState) response.SError
+	Consume(db *gorm.DB, stateHash string) (*OAuthState, response.SError)
+	DeleteExpired(db *gorm.DB, before time.Time) response.SError
+}
+
+// oauthStateDBHD the handler to operate the oauth_state table
+type oauthStateDBHD struct{}
+
+// OAuthStateDBHD the default oauthStateDBHD
+var OAuthStateDBHD = &oauthStateDBHD{}
+
+var _ OAuthStateRepository = OAuthStateDBHD
+
+// Add insert an OAuthState record
+func (hd *oauthStateDBHD) Add(db *gorm.DB, s *OAuthState) response.SError {
+	err := db.Create(s).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "add oauth state fail")
+	}
+	return nil
+}
+
+// Consume get and delete an OAuthState by state hash, so that a state can only be used once,
+// return nil if no such state or it has been consumed by someone else
+func (hd *oauthStateDBHD) Consume(db *gorm.DB, stateHash string) (*OAuthState, response.SError) {
+	var s *OAuthState
+	err := db.Where("state_hash=?", stateHash).First(&s).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "get oauth state fail")
+	}
+	ret := db.Where("id=?", s.ID).Delete(&OAuthState{})
+	if ret.Error != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(ret.Error, "delete oauth state fail")
+	}
+	if ret.RowsAffected != 1 {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// DeleteExpired delete all the OAuthState records expired before the time
+func (hd *oauthStateDBHD) DeleteExpired(db *gorm.DB, before time.Time) response.SError {
+	err := db.Where("expire_at < ?", before.UTC()).Delete(&OAuthState{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete expired oauth states fail")
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package dal
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"time"
+)
+
+// OutboxMailStatus the delivery status of an OutboxMail
+type OutboxMailStatus string
+
+const (
+	OutboxMailStatusPending OutboxMailStatus = "pending" // waiting to be delivered or retried
+	OutboxMailStatusSent    OutboxMailStatus = "sent"    // delivered to the mail server
+	OutboxMailStatusDead    OutboxMailStatus = "dead"    // given up after too many failed attempts
+)
+
+// OutboxMail a mail waiting to be delivered by the mail queue worker, enqueued in the same transaction
+// as the data change that causes it, so it is sent if and only if the transaction is committed
+type OutboxMail struct {
+	ID            uint64
+	ToMail        string
+	Content       []byte // the whole MIME message, cleared once sent or dead as it may contain one-time tokens
+	Status        OutboxMailStatus
+	Attempts      uint32 // delivery attempts made, including the one in progress
+	LastError     string
+	NextAttemptAt time.Time
+	CreateAt      time.Time
+	SentAt        *time.Time
+}
+
+// OutboxMailRepository the operations on the outbox_mail table, implemented by outboxMailDBHD
+type OutboxMailRepository interface {
+	Add(db *gorm.DB, m *OutboxMail) response.SError
+	ListDue(db *gorm.DB, now time.Time, limit int) ([]*OutboxMail, response.SError)
+	Claim(db *gorm.DB, id uint64, attempts uint32, leaseUntil time.Time) (bool, response.SError)
+	MarkSent(db *gorm.DB, id uint64, sentAt time.Time) response.SError
+	MarkRetry(db *gorm.DB, id uint64, lastError string, nextAttemptAt time.Time) response.SError
+	MarkDead(db *gorm.DB, id uint64, lastError string) response.SError
+	CountByStatus(db *gorm.DB, status OutboxMailStatus) (int64, response.SError)
+	DeleteByToMail(db *gorm.DB, toMail string) response.SError
+	PurgeFinished(db *gorm.DB, before time.Time) (int64, response.SError)
+}
+
+// outboxMailDBHD the handler to operate the outbox_mail table
+type outboxMailDBHD struct{}
+
+// OutboxMailDBHD the default outboxMailDBHD
+var OutboxMailDBHD = &outboxMailDBHD{}
+
+var _ OutboxMailRepository = OutboxMailDBHD
+
+// Add insert an OutboxMail record
+func (hd *outboxMailDBHD) Add(db *gorm.DB, m *OutboxMail) response.SError {
+	err := db.Create(m).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "add outbox mail fail")
+	}
+	return nil
+}
+
+// ListDue list at most limit pending OutboxMails whose next attempt time is reached, the oldest first
+func (hd *outboxMailDBHD) ListDue(db *gorm.DB, now time.Time, limit int) ([]*OutboxMail, response.SError) {
+	var mails []*OutboxMail
+	err := db.Where("status=? and next_attempt_at<=?", OutboxMailStatusPending, now.UTC()).
+		Order("id").Limit(limit).Find(&mails).Error
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "list due outbox mails fail")
+	}
+	return mails, nil
+}
+
+// Claim take an OutboxMail for a delivery attempt, the attempts is increased and the next attempt is postponed
+// to leaseUntil in case the worker crashes during the delivery, return false if the mail is claimed by another worker
+func (hd *outboxMailDBHD) Claim(db *gorm.DB, id uint64, attempts uint32, leaseUntil time.Time) (bool, response.SError) {
+	result := db.Model(&OutboxMail{}).Where("id=? and status=? and attempts=?", id, OutboxMailStatusPending, attempts).
+		Updates(map[string]interface{}{
+			"attempts":        attempts + 1,
+			"next_attempt_at": leaseUntil.UTC(),
+		})
+	if result.Error != nil {
+		return false, response.ErrroCode_InternalUnknownError.Wrap(result.Error, "claim outbox mail fail")
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// MarkSent mark an OutboxMail as sent and clear its content
+func (hd *outboxMailDBHD) MarkSent(db *gorm.DB, id uint64, sentAt time.Time) response.SError {
+	err := db.Model(&OutboxMail{}).Where("id=?", id).Updates(map[string]interface{}{
+		"status":     OutboxMailStatusSent,
+		"content":    gorm.Expr("''"), // an empty []byte is bound as NULL by some drivers
+		"last_error": "",
+		"sent_at":    sentAt.UTC(),
+	}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "mark outbox mail sent fail")
+	}
+	return nil
+}
+
+// MarkRetry record the delivery error of an OutboxMail and when to retry it
+func (hd *outboxMailDBHD) MarkRetry(db *gorm.DB, id uint64, lastError string, nextAttemptAt time.Time) response.SError {
+	err := db.Model(&OutboxMail{}).Where("id=?", id).Updates(map[string]interface{}{
+		"last_error":      lastError,
+		"next_attempt_at": nextAttemptAt.UTC(),
+	}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "mark outbox mail retry fail")
+	}
+	return nil
+}
+
+// MarkDead record the delivery error of an OutboxMail and give it up, it is kept for inspection without its content
+func (hd *outboxMailDBHD) MarkDead(db *gorm.DB, id uint64, lastError string) response.SError {
+	err := db.Model(&OutboxMail{}).Where("id=?", id).Updates(map[string]interface{}{
+		"status":     OutboxMailStatusDead,
+		"content":    gorm.Expr("''"),
+		"last_error": lastError,
+	}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "mark outbox mail dead fail")
+	}
+	return nil
+}
+
+// CountByStatus count the OutboxMails of the status
+func (hd *outboxMailDBHD) CountByStatus(db *gorm.DB, status OutboxMailStatus) (int64, response.SError) {
+	var count int64
+	err := db.Model(&OutboxMail{}).Where("status=?", status).Count(&count).Error
+	if err != nil {
+		return 0, response.ErrroCode_InternalUnknownError.Wrap(err, "count outbox mails fail")
+	}
+	return count, nil
+}
+
+// DeleteByToMail delete all the OutboxMails to the email address
+func (hd *outboxMailDBHD) DeleteByToMail(db *gorm.DB, toMail string) response.SError {
+	err := db.Where("to_mail=?", toMail).Delete(&OutboxMail{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete outbox mails fail")
+	}
+	return nil
+}
+
+// PurgeFinished delete the sent and dead OutboxMails created before the time, return the number of mails deleted
+func (hd *outboxMailDBHD) PurgeFinished(db *gorm.DB, before time.Time) (int64, response.SError) {
+	ret := db.Where("status in ? and create_at<?", []OutboxMailStatus{OutboxMailStatusSent, OutboxMailStatusDead}, before.UTC()).
+		Delete(&OutboxMail{})
+	if ret.Error != nil {
+		return 0, response.ErrroCode_InternalUnknownError.Wrap(ret.Error, "purge finished outbox mails fail")
+	}
+	return ret.RowsAffected, nil
+}
@@ -0,0 +1,88 @@
+package dal
+
+import (
+	"github.com/pkg/errors"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// RateLimitCounter a fixed window rate limit counter, used when the counters are shared by all server instances
+type RateLimitCounter struct {
+	LimitKey string `gorm:"primaryKey"`
+	Count    int64
+	ExpireAt time.Time
+}
+
+// RateLimitCounterRepository the operations on the rate_limit_counter table, implemented by rateLimitCounterDBHD
+type RateLimitCounterRepository interface {
+	GetByKey(db *gorm.DB, key string, forUpdate bool) (*RateLimitCounter, response.SError)
+	Add(db *gorm.DB, c *RateLimitCounter) response.SError
+	Update(db *gorm.DB, key string, count int64, expireAt time.Time) response.SError
+	DeleteByKey(db *gorm.DB, key string) response.SError
+	DeleteExpired(db *gorm.DB, before time.Time) response.SError
+}
+
+// rateLimitCounterDBHD the handler to operate the rate_limit_counter table
+type rateLimitCounterDBHD struct{}
+
+// RateLimitCounterDBHD the default rateLimitCounterDBHD
+var RateLimitCounterDBHD = &rateLimitCounterDBHD{}
+
+var _ RateLimitCounterRepository = RateLimitCounterDBHD
+
+// GetByKey get a RateLimitCounter by key, lock the row if forUpdate, return nil if no such counter
+func (hd *rateLimitCounterDBHD) GetByKey(db *gorm.DB, key string, forUpdate bool) (*RateLimitCounter, response.SError) {
+	var c *RateLimitCounter
+	if forUpdate {
+		db = db.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	err := db.Where("limit_key=?", key).First(&c).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "get rate limit counter fail")
+	}
+	return c, nil
+}
+
+// Add insert a RateLimitCounter record
+func (hd *rateLimitCounterDBHD) Add(db *gorm.DB, c *RateLimitCounter) response.SError {
+	err := db.Create(c).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "add rate limit counter fail")
+	}
+	return nil
+}
+
+// Update set the count and expire time of a RateLimitCounter
+func (hd *rateLimitCounterDBHD) Update(db *gorm.DB, key string, count int64, expireAt time.Time) response.SError {
+	err := db.Model(&RateLimitCounter{}).Where("limit_key=?", key).Updates(map[string]interface{}{
+		"count":     count,
+		"expire_at": expireAt.UTC(),
+	}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "update rate limit counter fail")
+	}
+	return nil
+}
+
+// DeleteByKey delete a RateLimitCounter by key
+func (hd *rateLimitCounterDBHD) DeleteByKey(db *gorm.DB, key string) response.SError {
+	err := db.Where("limit_key=?", key).Delete(&RateLimitCounter{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete rate limit counter fail")
+	}
+	return nil
+}
+
+// DeleteExpired delete all the RateLimitCounter records expired before the time
+func (hd *rateLimitCounterDBHD) DeleteExpired(db *gorm.DB, before time.Time) response.SError {
+	err := db.Where("expire_at < ?", before.UTC()).Delete(&RateLimitCounter{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete expired rate limit counters fail")
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package dal
+
+import (
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"time"
+)
+
+// SecurityEventType the type of security-sensitive account event
+type SecurityEventType string
+
+const (
+	SecurityEventRegister       SecurityEventType = "register"        // account registered
+	SecurityEventActivate       SecurityEventType = "activate"        // email activated
+	SecurityEventLoginSuccess   SecurityEventType = "login_success"   // a new session started
+	SecurityEventLoginFailure   SecurityEventType = "login_failure"   // a login of the account rejected
+	SecurityEventTokenRefresh   SecurityEventType = "token_refresh"   // the refresh token of a session rotated
+	SecurityEventEmailBind      SecurityEventType = "email_bind"      // the email changed to a new one
+	SecurityEventPasswordChange SecurityEventType = "password_change" // password changed or reset
+	SecurityEventPortraitUpdate SecurityEventType = "portrait_update" // portrait uploaded
+)
+
+// SecurityEvent a security-sensitive event of a user account, the events are only appended and never updated
+type SecurityEvent struct {
+	ID        uint64            `json:"-"`
+	UID       UID               `json:"-"`
+	Event     SecurityEventType `json:"event"`
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"user_agent"`
+	Detail    string            `json:"detail"`
+	CreateAt  time.Time         `json:"create_at"`
+}
+
+// SecurityEventRepository the operations on the security_event table, implemented by securityEventDBHD
+type SecurityEventRepository interface {
+	Add(db *gorm.DB, e *SecurityEvent) response.SError
+	ListRecentByUID(db *gorm.DB, uid UID, limit int) ([]*SecurityEvent, response.SError)
+	DeleteByUID(db *gorm.DB, uid UID) response.SError
+}
+
+// securityEventDBHD the handler to operate the security_event table
+type securityEventDBHD struct{}
+
+// SecurityEventDBHD the default securityEventDBHD
+var SecurityEventDBHD = &securityEventDBHD{}
+
+var _ SecurityEventRepository = SecurityEventDBHD
+
+// Add insert a SecurityEvent record
+func (hd *securityEventDBHD) Add(db *gorm.DB, e *SecurityEvent) response.SError {
+	err := db.Create(e).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "add security event fail")
+	}
+	return nil
+}
+
+// ListRecentByUID list the latest SecurityEvents of a user, at most limit ones, the latest first
+func (hd *securityEventDBHD) ListRecentByUID(db *gorm.DB, uid UID, limit int) ([]*SecurityEvent, response.SError) {
+	var events []*SecurityEvent
+	err := db.Where("uid=?", uid).Order("id desc").Limit(limit).Find(&events).Error
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "list security events fail")
+	}
+	return events, nil
+}
+
+// DeleteByUID delete all the SecurityEvent records of a user
+func (hd *securityEventDBHD) DeleteByUID(db *gorm.DB, uid UID) response.SError {
+	err := db.Where("uid=?", uid).Delete(&SecurityEvent{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete user security events fail")
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package dal
+
+import (
+	"github.com/pkg/errors"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Session a login session of a user, each user token carries the sid of the session it belongs to,
+// the session can be kept alive by rotating its refresh token and can be revoked at any time
+type Session struct {
+	ID              uint64     `json:"-"`
+	SID             string     `json:"sid" gorm:"column:sid"`
+	UID             UID        `json:"-"`
+	RefreshHash     string     `json:"-"` // hash of the current refresh token
+	PrevRefreshHash string     `json:"-"` // hash of the refresh token rotated last time
+	DeviceName      string     `json:"device_name"`
+	UserAgent       string     `json:"user_agent"`
+	LastUsedIP      string     `json:"last_used_ip"`
+	LastUsedAt      time.Time  `json:"last_used_at"`
+	CreateAt        time.Time  `json:"create_at"`
+	ExpireAt        time.Time  `json:"expire_at"`
+	RevokeAt        *time.Time `json:"-"`
+}
+
+// IsActive check whether a session can still be used at the time
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokeAt == nil && now.Before(s.ExpireAt)
+}
+
+// SessionRepository the operations on the session table, implemented by sessionDBHD
+type SessionRepository interface {
+	Add(db *gorm.DB, s *Session) response.SError
+	GetBySID(db *gorm.DB, sid string) (*Session, response.SError)
+	ListActiveByUID(db *gorm.DB, uid UID, now time.Time) ([]*Session, response.SError)
+	Rotate(db *gorm.DB, sid string, oldRefreshHash string, fields *SessionRotateFields) (bool, response.SError)
+	RevokeBySID(db *gorm.DB, sid string, revokeAt time.Time) response.SError
+	RevokeByUID(db *gorm.DB, uid UID, exceptSID string, revokeAt time.Time) response.SError
+	DeleteByUID(db *gorm.DB, uid UID) response.SError
+}
+
+// sessionDBHD the handler to operate the session table
+type sessionDBHD struct{}
+
+// SessionDBHD the default sessionDBHD
+var SessionDBHD = &sessionDBHD{}
+
+var _ SessionRepository = SessionDBHD
+
+// Add insert a Session record
+func (hd *sessionDBHD) Add(db *gorm.DB, s *Session) response.SError {
+	err := db.Create(s).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "add session fail")
+	}
+	return nil
+}
+
+// GetBySID get a Session by sid
+func (hd *sessionDBHD) GetBySID(db *gorm.DB, sid string) (*Session, response.SError) {
+	var s *Session
+	err := db.Where("sid=?", sid).First(&s).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "get session by sid fail")
+	}
+	return s, nil
+}
+
+// ListActiveByUID list all the not revoked and not expired Sessions of a user
+func (hd *sessionDBHD) ListActiveByUID(db *gorm.DB, uid UID, now time.Time) ([]*Session, response.SError) {
+	var ss []*Session
+	err := db.Where("uid=? and revoke_at is null and expire_at > ?", uid, now.UTC()).
+		Order("last_used_at desc").Find(&ss).Error
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "list active sessions fail")
+	}
+	return ss, nil
+}
+
+type SessionRotateFields struct {
+	RefreshHash string
+	LastUsedIP  string
+	LastUsedAt  time.Time
+	ExpireAt    time.Time
+}
+
+// Rotate replace the refresh token hash of an active Session only if its current hash is oldRefreshHash,
+// and keep oldRefreshHash as the previous one, return false if no session updated,
+// which means the refresh token has been rotated by someone else
+func (hd *sessionDBHD) Rotate(db *gorm.DB, sid string, oldRefreshHash string, fields *SessionRotateFields) (bool, response.SError) {
+	ret := db.Model(&Session{}).Where("sid=? and refresh_hash=? and revoke_at is null", sid, oldRefreshHash).
+		Updates(map[string]interface{}{
+			"refresh_hash":      fields.RefreshHash,
+			"prev_refresh_hash": oldRefreshHash,
+			"last_used_ip":      fields.LastUsedIP,
+			"last_used_at":      fields.LastUsedAt.UTC(),
+			"expire_at":         fields.ExpireAt.UTC(),
+		})
+	if ret.Error != nil {
+		return false, response.ErrroCode_InternalUnknownError.Wrap(ret.Error, "rotate session refresh token fail")
+	}
+	return ret.RowsAffected == 1, nil
+}
+
+// RevokeBySID revoke a Session by sid
+func (hd *sessionDBHD) RevokeBySID(db *gorm.DB, sid string, revokeAt time.Time) response.SError {
+	err := db.Model(&Session{}).Where("sid=? and revoke_at is null", sid).Update("revoke_at", revokeAt.UTC()).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "revoke session fail")
+	}
+	return nil
+}
+
+// RevokeByUID revoke all the Sessions of a user except the one with sid exceptSID, pass empty exceptSID to revoke all
+func (hd *sessionDBHD) RevokeByUID(db *gorm.DB, uid UID, exceptSID string, revokeAt time.Time) response.SError {
+	q := db.Model(&Session{}).Where("uid=? and revoke_at is null", uid)
+	if exceptSID != "" {
+		q = q.Where("sid != ?", exceptSID)
+	}
+	err := q.Update("revoke_at", revokeAt.UTC()).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "revoke user sessions fail")
+	}
+	return nil
+}
+
+// DeleteByUID delete all the Session records of a user
+func (hd *sessionDBHD) DeleteByUID(db *gorm.DB, uid UID) response.SError {
+	err := db.Where("uid=?", uid).Delete(&Session{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete user sessions fail")
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package dal
+
+import (
+	"github.com/pkg/errors"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"time"
+)
+
+// UserTwoFactor the TOTP two-factor authentication setting of a user,
+// it is created when the user starts enrollment and takes effect after confirmed by a valid code
+type UserTwoFactor struct {
+	ID             uint64
+	UID            UID
+	Secret         string     // base32 encoded TOTP secret
+	ConfirmAt      *time.Time // when the enrollment is confirmed, nil if still pending
+	LastUsedStep   int64      // the time step of the last accepted code, to reject replayed codes
+	FailedAttempts uint32     // consecutive failed verifications
+	LastFailAt     *time.Time
+	CreateAt       time.Time
+}
+
+// IsEnabled whether the two-factor authentication takes effect
+func (tf *UserTwoFactor) IsEnabled() bool {
+	return tf.ConfirmAt != nil
+}
+
+// UserTwoFactorRepository the operations on the user_two_factor table, implemented by userTwoFactorDBHD
+type UserTwoFactorRepository interface {
+	Add(db *gorm.DB, tf *UserTwoFactor) response.SError
+	GetByUID(db *gorm.DB, uid UID) (*UserTwoFactor, response.SError)
+	Confirm(db *gorm.DB, id uint64, step int64, confirmAt time.Time) response.SError
+	UseStep(db *gorm.DB, id uint64, step int64) (bool, response.SError)
+	ResetFailedAttempts(db *gorm.DB, id uint64) response.SError
+	IncreaseFailedAttempts(db *gorm.DB, id uint64, failAt time.Time) response.SError
+	DeleteByUID(db *gorm.DB, uid UID) response.SError
+}
+
+// userTwoFactorDBHD the handler to operate the user_two_factor table
+type userTwoFactorDBHD struct{}
+
+// UserTwoFactorDBHD the default userTwoFactorDBHD
+var UserTwoFactorDBHD = &userTwoFactorDBHD{}
+
+var _ UserTwoFactorRepository = UserTwoFactorDBHD
+
+// Add insert a UserTwoFactor record
+func (hd *userTwoFactorDBHD) Add(db *gorm.DB, tf *UserTwoFactor) response.SError {
+	err := db.Create(tf).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "add user two factor fail")
+	}
+	return nil
+}
+
+// GetByUID get the UserTwoFactor of a user, return nil if the user never enrolled
+func (hd *userTwoFactorDBHD) GetByUID(db *gorm.DB, uid UID) (*UserTwoFactor, response.SError) {
+	var tf *UserTwoFactor
+	err := db.Where("uid=?", uid).First(&tf).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "get user two factor fail")
+	}
+	return tf, nil
+}
+
+// Confirm make a pending UserTwoFactor take effect
+func (hd *userTwoFactorDBHD) Confirm(db *gorm.DB, id uint64, step int64, confirmAt time.Time) response.SError {
+	err := db.Model(&UserTwoFactor{}).Where("id=?", id).Updates(map[string]interface{}{
+		"confirm_at":     confirmAt.UTC(),
+		"last_used_step": step,
+	}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "confirm user two factor fail")
+	}
+	return nil
+}
+
+// UseStep record a time step as used and reset the failed attempts,
+// return false if a code of the same or later step has been used
+func (hd *userTwoFactorDBHD) UseStep(db *gorm.DB, id uint64, step int64) (bool, response.SError) {
+	ret := db.Model(&UserTwoFactor{}).Where("id=? and last_used_step<?", id, step).Updates(map[string]interface{}{
+		"last_used_step":  step,
+		"failed_attempts": 0,
+	})
+	if ret.Error != nil {
+		return false, response.ErrroCode_InternalUnknownError.Wrap(ret.Error, "use user two factor step fail")
+	}
+	return ret.RowsAffected == 1, nil
+}
+
+// ResetFailedAttempts reset the failed attempts of a UserTwoFactor
+func (hd *userTwoFactorDBHD) ResetFailedAttempts(db *gorm.DB, id uint64) response.SError {
+	err := db.Model(&UserTwoFactor{}).Where("id=?", id).UpdateColumn("failed_attempts", 0).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "reset user two factor failed attempts fail")
+	}
+	return nil
+}
+
+// IncreaseFailedAttempts increase the failed attempts of a UserTwoFactor by one
+func (hd *userTwoFactorDBHD) IncreaseFailedAttempts(db *gorm.DB, id uint64, failAt time.Time) response.SError {
+	err := db.Model(&UserTwoFactor{}).Where("id=?", id).Updates(map[string]interface{}{
+		"failed_attempts": gorm.Expr("failed_attempts + ?", 1),
+		"last_fail_at":    failAt.UTC(),
+	}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "increase user two factor failed attempts fail")
+	}
+	return nil
+}
+
+// DeleteByUID delete the UserTwoFactor of a user
+func (hd *userTwoFactorDBHD) DeleteByUID(db *gorm.DB, uid UID) response.SError {
+	err := db.Where("uid=?", uid).Delete(&UserTwoFactor{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete user two factor fail")
+	}
+	return nil
+}
+
+// RecoveryCode a single-use code to pass the two-factor authentication when the authenticator is lost
+type RecoveryCode struct {
+	ID       uint64
+	UID      UID
+	CodeHash string
+	UseAt    *time.Time // when the code is used, nil if not used yet
+	CreateAt time.Time
+}
+
+// RecoveryCodeRepository the operations on the recovery_code table, implemented by recoveryCodeDBHD
+type RecoveryCodeRepository interface {
+	BatchAdd(db *gorm.DB, codes []*RecoveryCode) response.SError
+	Use(db *gorm.DB, uid UID, codeHash string, useAt time.Time) (bool, response.SError)
+	CountUnusedByUID(db *gorm.DB, uid UID) (int64, response.SError)
+	DeleteByUID(db *gorm.DB, uid UID) response.SError
+}
+
+// recoveryCodeDBHD the handler to operate the recovery_code table
+type recoveryCodeDBHD struct{}
+
+// RecoveryCodeDBHD the default recoveryCodeDBHD
+var RecoveryCodeDBHD = &recoveryCodeDBHD{}
+
+var _ RecoveryCodeRepository = RecoveryCodeDBHD
+
+// BatchAdd insert a batch of RecoveryCode records
+func (hd *recoveryCodeDBHD) BatchAdd(db *gorm.DB, codes []*RecoveryCode) response.SError {
+	err := db.Create(codes).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "add recovery codes fail")
+	}
+	return nil
+}
+
+// Use mark an unused RecoveryCode of a user as used, return false if no such unused code
+func (hd *recoveryCodeDBHD) Use(db *gorm.DB, uid UID, codeHash string, useAt time.Time) (bool, response.SError) {
+	ret := db.Model(&RecoveryCode{}).Where("uid=? and code_hash=? and use_at is null", uid, codeHash).
+		Update("use_at", useAt.UTC())
+	if ret.Error != nil {
+		return false, response.ErrroCode_InternalUnknownError.Wrap(ret.Error, "use recovery code fail")
+	}
+	return ret.RowsAffected == 1, nil
+}
+
+// CountUnusedByUID count the unused RecoveryCodes of a user
+func (hd *recoveryCodeDBHD) CountUnusedByUID(db *gorm.DB, uid UID) (int64, response.SError) {
+	var count int64
+	err := db.Model(&RecoveryCode{}).Where("uid=? and use_at is null", uid).Count(&count).Error
+	if err != nil {
+		return 0, response.ErrroCode_InternalUnknownError.Wrap(err, "count recovery codes fail")
+	}
+	return count, nil
+}
+
+// DeleteByUID delete all the RecoveryCodes of a user
+func (hd *recoveryCodeDBHD) DeleteByUID(db *gorm.DB, uid UID) response.SError {
+	err := db.Where("uid=?", uid).Delete(&RecoveryCode{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete recovery codes fail")
+	}
+	return nil
+}
@@ -8,12 +8,34 @@ import (
 
 const unconfirmedHabitLogRecordTable = "unconfirmed_habit_log_records"
 
+// UnconfirmedHabitLogRecordRepository the operations on the unconfirmed_habit_log_record table, implemented by unconfirmedHabitLogRecordDBHD
+type UnconfirmedHabitLogRecordRepository interface {
+	Add(db *gorm.DB, r *HabitLogRecord) response.SError
+	ListByHabitID(db *gorm.DB, habitID uint64, fromTime *time.Time, toTime *time.Time) ([]*HabitLogRecord, response.SError)
+	ListByUIDHabitIDs(db *gorm.DB, uid UID, habitIDs []uint64, fromTime *time.Time, toTime *time.Time) ([]*HabitLogRecord, response.SError)
+	GetByUIDAndClientID(db *gorm.DB, uid UID, clientID string) (*HabitLogRecord, response.SError)
+	ListByUIDUpdatedAfter(db *gorm.DB, uid UID, after time.Time) ([]*HabitLogRecord, response.SError)
+	DeleteByHabitIDAndUID(db *gorm.DB, habitID uint64, uid UID) response.SError
+	DeleteByHabitID(db *gorm.DB, habitID uint64, fromTime *time.Time, toTime *time.Time) response.SError
+	EraseByUID(db *gorm.DB, uid UID) response.SError
+}
+
 type unconfirmedHabitLogRecordDBHD struct{}
 
 var UnconfirmedHabitLogRecordDBHD = &unconfirmedHabitLogRecordDBHD{}
 
+var _ UnconfirmedHabitLogRecordRepository = UnconfirmedHabitLogRecordDBHD
+
 func (hd *unconfirmedHabitLogRecordDBHD) Add(db *gorm.DB, r *HabitLogRecord) response.SError {
-	err := db.Table(unconfirmedHabitLogRecordTable).Create(r).Error
+	err := purgeLogRecordTombstones(db, unconfirmedHabitLogRecordTable, r)
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "purge unconfirmed habit log record tombstones fail")
+	}
+	// insert under a savepoint, a duplicate log would otherwise abort the whole transaction of db on postgres,
+	// while the caller takes it as the user having logged already and goes on
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return tx.Table(unconfirmedHabitLogRecordTable).Create(r).Error
+	})
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "add one unconfirmed habit log record fail")
 	}
@@ -54,14 +76,44 @@ func (hd *unconfirmedHabitLogRecordDBHD) ListByUIDHabitIDs(db *gorm.DB, uid UID,
 	return results, nil
 }
 
+// GetByUIDAndClientID get the record of a check-in synced by a user, return nil if not found
+func (hd *unconfirmedHabitLogRecordDBHD) GetByUIDAndClientID(db *gorm.DB, uid UID, clientID string) (*HabitLogRecord, response.SError) {
+	var results []*HabitLogRecord
+	err := db.Table(unconfirmedHabitLogRecordTable).Where("uid = ? AND client_id = ?", uid, clientID).Limit(1).Find(&results).Error
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "get unconfirmed habit log record by client id fail")
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+	return results[0], nil
+}
+
+// ListByUIDUpdatedAfter list the records of a user updated or deleted after a time, including the tombstones,
+// ordered by the update time, a zero time lists all of them
+func (hd *unconfirmedHabitLogRecordDBHD) ListByUIDUpdatedAfter(db *gorm.DB, uid UID, after time.Time) ([]*HabitLogRecord, response.SError) {
+	q := db.Table(unconfirmedHabitLogRecordTable).Unscoped().Where("uid = ?", uid)
+	if !after.IsZero() {
+		q = q.Where("update_at > ?", after.UTC())
+	}
+	var results []*HabitLogRecord
+	err := q.Order("update_at, id").Find(&results).Error
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "list unconfirmed habit log records updated after fail")
+	}
+	return results, nil
+}
+
+// DeleteByHabitIDAndUID delete the records of a user on a habit, leaving their tombstones
 func (hd *unconfirmedHabitLogRecordDBHD) DeleteByHabitIDAndUID(db *gorm.DB, habitID uint64, uid UID) response.SError {
-	err := db.Table(unconfirmedHabitLogRecordTable).Where("habit_id=? and uid=?", habitID, uid).Delete(&HabitLogRecord{}).Error
+	err := softDelete(db.Table(unconfirmedHabitLogRecordTable).Model(&HabitLogRecord{}).Where("habit_id=? and uid=?", habitID, uid))
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete unconfirmed habit log record fail")
 	}
 	return nil
 }
 
+// DeleteByHabitID delete the records of a habit logged in the time range, leaving their tombstones
 func (hd *unconfirmedHabitLogRecordDBHD) DeleteByHabitID(db *gorm.DB, habitID uint64, fromTime *time.Time, toTime *time.Time) response.SError {
 	q := db.Table(unconfirmedHabitLogRecordTable).Where("habit_id=?", habitID)
 
@@ -72,9 +124,18 @@ func (hd *unconfirmedHabitLogRecordDBHD) DeleteByHabitID(db *gorm.DB, habitID ui
 		q = q.Where("log_at <= ?", toTime.UTC())
 	}
 
-	err := q.Delete(&HabitLogRecord{}).Error
+	err := softDelete(q.Model(&HabitLogRecord{}))
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete unconfirmed habit log record fail")
 	}
 	return nil
 }
+
+// EraseByUID remove all the records of a user for good including the tombstones, when the user's account is erased
+func (hd *unconfirmedHabitLogRecordDBHD) EraseByUID(db *gorm.DB, uid UID) response.SError {
+	err := db.Table(unconfirmedHabitLogRecordTable).Unscoped().Where("uid=?", uid).Delete(&HabitLogRecord{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "erase unconfirmed habit log records of user fail")
+	}
+	return nil
+}
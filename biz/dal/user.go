@@ -5,6 +5,7 @@ import (
 	"github.com/swordandtea/lets-habit-server/biz/response"
 	"github.com/swordandtea/lets-habit-server/biz/service"
 	"gorm.io/gorm"
+	"time"
 )
 
 // UserRegisterType indentify how user is registered
@@ -13,6 +14,15 @@ type UserRegisterType string
 const (
 	UserRegisterTypeEmail  UserRegisterType = "email"  // user registered directly with email
 	UserRegisterTypeWechat UserRegisterType = "wechat" // user registered with wechat oauth
+	UserRegisterTypeOAuth  UserRegisterType = "oauth"  // user registered with an OAuth / OpenID Connect provider
+)
+
+// UserRole what a user is allowed to do besides using the app
+type UserRole string
+
+const (
+	UserRoleNormal UserRole = ""      // a normal user
+	UserRoleAdmin  UserRole = "admin" // an operator allowed to use the admin api, only granted in db directly
 )
 
 // User the user registered
@@ -22,10 +32,15 @@ type User struct {
 	Name             *string          `json:"name"`
 	Email            *string          `json:"email"`
 	EmailActive      bool             `json:"email_active"`
+	EmailBind        bool             `json:"email_bind"` // whether the email is bound by the email bind flow
 	Password         *Password        `json:"-"`
 	Portrait         *string          `json:"-"` //portrait object storage Key
 	PortraitURL      string           `json:"portrait" gorm:"-"`
 	UserRegisterType UserRegisterType `json:"user_register_type"`
+	Language         string           `json:"language"` // the locale of the emails sent to the user, empty means the default one
+	Role             UserRole         `json:"role"`
+	Disabled         bool             `json:"disabled"`  // disabled by an admin, a disabled user can not login
+	DeleteAt         *time.Time       `json:"delete_at"` // when the account data will be erased, nil if deletion not requested
 }
 
 // postProcessUserField process some field after User data is fetched from db,
@@ -38,12 +53,31 @@ func postProcessUserField(users []*User) {
 	}
 }
 
+// UserRepository the operations on the user table, implemented by userDBHD
+type UserRepository interface {
+	Add(db *gorm.DB, user *User) response.SError
+	GetByUID(db *gorm.DB, uid UID) (*User, response.SError)
+	GetByEmail(db *gorm.DB, email string) (*User, response.SError)
+	ListByUIDs(db *gorm.DB, uids []UID) ([]*User, response.SError)
+	UpdateUser(db *gorm.DB, uid UID, updateFields *UserUpdatableFields) response.SError
+	SearchUserByNameOrUID(db *gorm.DB, text string, pagination *Pagination) ([]*User, response.SError)
+	SearchUserForAdmin(db *gorm.DB, text string, pagination *Pagination) ([]*User, uint, response.SError)
+	SetDisabled(db *gorm.DB, uid UID, disabled bool) response.SError
+	ClearName(db *gorm.DB, uid UID) response.SError
+	ClearPortrait(db *gorm.DB, uid UID) response.SError
+	SetDeleteAt(db *gorm.DB, uid UID, deleteAt *time.Time) response.SError
+	ListDeleteDue(db *gorm.DB, before time.Time, limit int) ([]*User, response.SError)
+	DeleteByUID(db *gorm.DB, uid UID) response.SError
+}
+
 // userDBHD the handler to operate the user table
 type userDBHD struct{}
 
 // UserDBHD the default userDBHD
 var UserDBHD = &userDBHD{}
 
+var _ UserRepository = UserDBHD
+
 // Add insert a user record
 func (hd *userDBHD) Add(db *gorm.DB, user *User) response.SError {
 	err := db.Create(user).Error
@@ -99,6 +133,7 @@ type UserUpdatableFields struct {
 	EmailBind   *bool
 	Password    *Password
 	Portrait    string
+	Language    *string
 }
 
 // UpdateUser update user field
@@ -117,11 +152,14 @@ func (hd *userDBHD) UpdateUser(db *gorm.DB, uid UID, updateFields *UserUpdatable
 		updates["email_bind"] = *updateFields.EmailBind
 	}
 	if updateFields.Password != nil {
-		updates["passport"] = updateFields.Password
+		updates["password"] = updateFields.Password
 	}
 	if updateFields.Portrait != "" {
 		updates["portrait"] = updateFields.Portrait
 	}
+	if updateFields.Language != nil {
+		updates["language"] = *updateFields.Language
+	}
 
 	if len(updates) == 0 {
 		return nil
@@ -136,8 +174,8 @@ func (hd *userDBHD) UpdateUser(db *gorm.DB, uid UID, updateFields *UserUpdatable
 func (hd *userDBHD) SearchUserByNameOrUID(db *gorm.DB, text string, pagination *Pagination) ([]*User, response.SError) {
 	var users []*User
 	offset := (pagination.Page - 1) * pagination.PageSize
-	queryText := "%" + text + "%"
-	err := db.Where("name Like ? or uid like ?", queryText, queryText).
+	condition, args := containsCondition(db, text, "name", "uid")
+	err := db.Where(condition, args...).Order("id").
 		Offset(int(offset)).Limit(int(pagination.PageSize)).
 		Find(&users).Error
 	if err != nil {
@@ -147,3 +185,79 @@ func (hd *userDBHD) SearchUserByNameOrUID(db *gorm.DB, text string, pagination *
 	postProcessUserField(users)
 	return users, nil
 }
+
+// SearchUserForAdmin search users whose uid, name or email contains the text, return the users and the total count
+func (hd *userDBHD) SearchUserForAdmin(db *gorm.DB, text string, pagination *Pagination) ([]*User, uint, response.SError) {
+	var users []*User
+	condition, args := containsCondition(db, text, "uid", "name", "email")
+	q := db.Model(&User{}).Where(condition, args...)
+	var count int64
+	err := q.Count(&count).Error
+	if err != nil {
+		return nil, 0, response.ErrroCode_InternalUnknownError.Wrap(err, "search user fail")
+	}
+
+	offset := (pagination.Page - 1) * pagination.PageSize
+	err = q.Order("id").Offset(int(offset)).Limit(int(pagination.PageSize)).Find(&users).Error
+	if err != nil {
+		return nil, 0, response.ErrroCode_InternalUnknownError.Wrap(err, "search user fail")
+	}
+	postProcessUserField(users)
+	return users, uint(count), nil
+}
+
+// SetDisabled disable or enable a user
+func (hd *userDBHD) SetDisabled(db *gorm.DB, uid UID, disabled bool) response.SError {
+	err := db.Model(&User{}).Where("uid=?", uid).Update("disabled", disabled).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "set user disabled fail")
+	}
+	return nil
+}
+
+// ClearName remove the name of a user
+func (hd *userDBHD) ClearName(db *gorm.DB, uid UID) response.SError {
+	err := db.Model(&User{}).Where("uid=?", uid).Update("name", nil).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "clear user name fail")
+	}
+	return nil
+}
+
+// ClearPortrait remove the portrait of a user
+func (hd *userDBHD) ClearPortrait(db *gorm.DB, uid UID) response.SError {
+	err := db.Model(&User{}).Where("uid=?", uid).Update("portrait", nil).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "clear user portrait fail")
+	}
+	return nil
+}
+
+// SetDeleteAt set or clear (when deleteAt is nil) the time when the user account will be erased
+func (hd *userDBHD) SetDeleteAt(db *gorm.DB, uid UID, deleteAt *time.Time) response.SError {
+	err := db.Model(&User{}).Where("uid=?", uid).Update("delete_at", deleteAt).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "set user delete time fail")
+	}
+	return nil
+}
+
+// ListDeleteDue list Users whose scheduled erasure time is not after the given time
+func (hd *userDBHD) ListDeleteDue(db *gorm.DB, before time.Time, limit int) ([]*User, response.SError) {
+	var users []*User
+	err := db.Where("delete_at is not null and delete_at <= ?", before.UTC()).Limit(limit).Find(&users).Error
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "list delete due users fail")
+	}
+	postProcessUserField(users)
+	return users, nil
+}
+
+// DeleteByUID delete a User record by uid
+func (hd *userDBHD) DeleteByUID(db *gorm.DB, uid UID) response.SError {
+	err := db.Where("uid=?", uid).Delete(&User{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete user fail")
+	}
+	return nil
+}
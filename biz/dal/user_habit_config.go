@@ -15,14 +15,38 @@ type UserHabitConfig struct {
 	StreakUpdateAt          *time.Time `json:"-"`
 	RemainRetroactiveChance uint8      `json:"remain_retroactive_chance"`
 	HeatmapColor            string     `json:"heatmap_color"`
+	UpdateAt                time.Time  `json:"update_at"`
+	// DeleteAt set for the tombstone of the config of a member quit, filtered out by the queries
+	DeleteAt gorm.DeletedAt `json:"delete_at"`
+}
+
+// UserHabitConfigRepository the operations on the user_habit_config table, implemented by userHabitConfigDBHD
+type UserHabitConfigRepository interface {
+	Add(db *gorm.DB, c *UserHabitConfig) response.SError
+	Update(db *gorm.DB, uid UID, habitID uint64, updateFields *UserHabitConfigUpdatableFields) response.SError
+	UpdateMany(db *gorm.DB, uid UID, habitIDs []uint64, updateFields *UserHabitConfigUpdatableFields) response.SError
+	IncreaseCurrentStreakByOne(db *gorm.DB, uids []UID, habitID uint64) response.SError
+	GetByUIDAndHabitID(db *gorm.DB, uid UID, habitID uint64) (*UserHabitConfig, response.SError)
+	ListUserHabitConfig(db *gorm.DB, uid UID, habits []uint64) ([]*UserHabitConfig, response.SError)
+	ListByUIDUpdatedAfter(db *gorm.DB, uid UID, after time.Time) ([]*UserHabitConfig, response.SError)
+	DeleteByHabitIDAndUID(db *gorm.DB, habitID uint64, uid UID) response.SError
+	EraseByUID(db *gorm.DB, uid UID) response.SError
 }
 
 type userHabitConfigDBHD struct{}
 
 var UserHabitConfigDBHD = &userHabitConfigDBHD{}
 
+var _ UserHabitConfigRepository = UserHabitConfigDBHD
+
+// Add insert a UserHabitConfig record, the tombstone of the config of the user quit the habit before is replaced
 func (hd *userHabitConfigDBHD) Add(db *gorm.DB, c *UserHabitConfig) response.SError {
-	err := db.Create(c).Error
+	err := db.Unscoped().Where("uid=? and habit_id=? and delete_at is not null", c.UID, c.HabitID).Delete(&UserHabitConfig{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "purge user habit config tombstone fail")
+	}
+	c.UpdateAt = time.Now().UTC()
+	err = db.Create(c).Error
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "add one user habit config fail")
 	}
@@ -54,6 +78,7 @@ func (hd *userHabitConfigDBHD) Update(db *gorm.DB, uid UID, habitID uint64, upda
 	if len(updates) == 0 {
 		return nil
 	}
+	updates["update_at"] = time.Now().UTC()
 	err := db.Model(&UserHabitConfig{}).Where("uid=? and habit_id=?", uid, habitID).Updates(updates).Error
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "update user habit config fail")
@@ -79,6 +104,7 @@ func (hd *userHabitConfigDBHD) UpdateMany(db *gorm.DB, uid UID, habitIDs []uint6
 	if len(updates) == 0 {
 		return nil
 	}
+	updates["update_at"] = time.Now().UTC()
 	err := db.Model(&UserHabitConfig{}).Where("uid=? and habit_id in (?)", uid, habitIDs).Updates(updates).Error
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "update user habit config fail")
@@ -86,14 +112,21 @@ func (hd *userHabitConfigDBHD) UpdateMany(db *gorm.DB, uid UID, habitIDs []uint6
 	return nil
 }
 
+// IncreaseCurrentStreakByOne increase the current streak of the users on the habit by one, and raise the longest streak
+// to the current one if exceeded, each update is a single statement to keep it portable among databases
 func (hd *userHabitConfigDBHD) IncreaseCurrentStreakByOne(db *gorm.DB, uids []UID, habitID uint64) response.SError {
+	now := time.Now().UTC()
 	err := db.Model(&UserHabitConfig{}).Where("uid in (?) and habit_id=?", uids, habitID).
-		UpdateColumn("current_streak", gorm.Expr("current_streak + ?", 1)).Error
+		UpdateColumns(map[string]interface{}{
+			"current_streak":   gorm.Expr("current_streak + ?", 1),
+			"streak_update_at": now,
+			"update_at":        now,
+		}).Error
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "increase current streak fail")
 	}
 	err = db.Model(&UserHabitConfig{}).Where("uid in (?) and habit_id=? and current_streak > longest_streak", uids, habitID).
-		UpdateColumn("longest_streak", gorm.Expr("current_streak")).UpdateColumn("streak_update_at", time.Now().UTC()).Error
+		UpdateColumn("longest_streak", gorm.Expr("current_streak")).Error
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "update longest streak fail")
 	}
@@ -121,10 +154,35 @@ func (hd *userHabitConfigDBHD) ListUserHabitConfig(db *gorm.DB, uid UID, habits
 	return uhcs, nil
 }
 
+// ListByUIDUpdatedAfter list the UserHabitConfigs of a user updated or deleted after a time, including the tombstones,
+// a zero time lists all of them
+func (hd *userHabitConfigDBHD) ListByUIDUpdatedAfter(db *gorm.DB, uid UID, after time.Time) ([]*UserHabitConfig, response.SError) {
+	q := db.Unscoped().Where("uid=?", uid)
+	if !after.IsZero() {
+		q = q.Where("update_at > ?", after.UTC())
+	}
+	var uhcs []*UserHabitConfig
+	err := q.Order("update_at").Find(&uhcs).Error
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "list user habit config updated after fail")
+	}
+	return uhcs, nil
+}
+
+// DeleteByHabitIDAndUID delete the config of a user on a habit, leaving its tombstone
 func (hd *userHabitConfigDBHD) DeleteByHabitIDAndUID(db *gorm.DB, habitID uint64, uid UID) response.SError {
-	err := db.Where("habit_id=? and uid=?", habitID, uid).Delete(&UserHabitConfig{}).Error
+	err := softDelete(db.Model(&UserHabitConfig{}).Where("habit_id=? and uid=?", habitID, uid))
 	if err != nil {
 		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete user habit config fail")
 	}
 	return nil
 }
+
+// EraseByUID remove all the configs of a user for good including the tombstones, when the user's account is erased
+func (hd *userHabitConfigDBHD) EraseByUID(db *gorm.DB, uid UID) response.SError {
+	err := db.Unscoped().Where("uid=?", uid).Delete(&UserHabitConfig{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "erase user habit configs fail")
+	}
+	return nil
+}
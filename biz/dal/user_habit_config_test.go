@@ -0,0 +1,39 @@
+package dal
+
+import (
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestIncreaseCurrentStreakByOne(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *gorm.DB) {
+		for _, c := range []*UserHabitConfig{
+			{UID: "u1", HabitID: 1, CurrentStreak: 3, LongestStreak: 3},
+			{UID: "u2", HabitID: 1, CurrentStreak: 1, LongestStreak: 5},
+			{UID: "u3", HabitID: 1, CurrentStreak: 7, LongestStreak: 7},
+		} {
+			sErr := UserHabitConfigDBHD.Add(db, c)
+			if sErr != nil {
+				t.Fatal(sErr)
+			}
+		}
+
+		sErr := UserHabitConfigDBHD.IncreaseCurrentStreakByOne(db, []UID{"u1", "u2"}, 1)
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		expected := map[UID][2]uint32{"u1": {4, 4}, "u2": {2, 5}, "u3": {7, 7}}
+		for uid, streak := range expected {
+			c, sErr := UserHabitConfigDBHD.GetByUIDAndHabitID(db, uid, 1)
+			if sErr != nil {
+				t.Fatal(sErr)
+			}
+			if c.CurrentStreak != streak[0] || c.LongestStreak != streak[1] {
+				t.Fatalf("unexpected streak of %s, current %d, longest %d", uid, c.CurrentStreak, c.LongestStreak)
+			}
+			if (uid == "u3") != (c.StreakUpdateAt == nil) {
+				t.Fatalf("unexpected streak update time of %s", uid)
+			}
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package dal
+
+import (
+	"github.com/pkg/errors"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"time"
+)
+
+// IdentityProvider the third party which an external identity comes from
+type IdentityProvider string
+
+const (
+	IdentityProviderWechat IdentityProvider = "wechat" // wechat mini-program, subject is the openid
+	// other providers are the configured OAuth providers, named by their config key
+)
+
+// UserIdentity an external identity linked to a user, a user can login by any of its linked identities
+type UserIdentity struct {
+	ID       uint64           `json:"-"`
+	UID      UID              `json:"-"`
+	Provider IdentityProvider `json:"provider"`
+	Subject  string           `json:"-"` // the unique id of the user inside the provider
+	CreateAt time.Time        `json:"create_at"`
+}
+
+// UserIdentityRepository the operations on the user_identity table, implemented by userIdentityDBHD
+type UserIdentityRepository interface {
+	Add(db *gorm.DB, i *UserIdentity) response.SError
+	GetByProviderAndSubject(db *gorm.DB, provider IdentityProvider, subject string) (*UserIdentity, response.SError)
+	ListByUID(db *gorm.DB, uid UID) ([]*UserIdentity, response.SError)
+	DeleteByUID(db *gorm.DB, uid UID) response.SError
+	DeleteByUIDAndProvider(db *gorm.DB, uid UID, provider IdentityProvider) response.SError
+}
+
+// userIdentityDBHD the handler to operate the user_identity table
+type userIdentityDBHD struct{}
+
+// UserIdentityDBHD the default userIdentityDBHD
+var UserIdentityDBHD = &userIdentityDBHD{}
+
+var _ UserIdentityRepository = UserIdentityDBHD
+
+// Add insert a UserIdentity record
+func (hd *userIdentityDBHD) Add(db *gorm.DB, i *UserIdentity) response.SError {
+	err := db.Create(i).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "add user identity fail")
+	}
+	return nil
+}
+
+// GetByProviderAndSubject get a UserIdentity by provider and subject
+func (hd *userIdentityDBHD) GetByProviderAndSubject(db *gorm.DB, provider IdentityProvider, subject string) (*UserIdentity, response.SError) {
+	var i *UserIdentity
+	err := db.Where("provider=? and subject=?", provider, subject).First(&i).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "get user identity fail")
+	}
+	return i, nil
+}
+
+// ListByUID list all the UserIdentity linked to a user
+func (hd *userIdentityDBHD) ListByUID(db *gorm.DB, uid UID) ([]*UserIdentity, response.SError) {
+	var is []*UserIdentity
+	err := db.Where("uid=?", uid).Find(&is).Error
+	if err != nil {
+		return nil, response.ErrroCode_InternalUnknownError.Wrap(err, "list user identities fail")
+	}
+	return is, nil
+}
+
+// DeleteByUID delete all the UserIdentity records of a user
+func (hd *userIdentityDBHD) DeleteByUID(db *gorm.DB, uid UID) response.SError {
+	err := db.Where("uid=?", uid).Delete(&UserIdentity{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete user identities fail")
+	}
+	return nil
+}
+
+// DeleteByUIDAndProvider delete the UserIdentity of a user from a provider
+func (hd *userIdentityDBHD) DeleteByUIDAndProvider(db *gorm.DB, uid UID, provider IdentityProvider) response.SError {
+	err := db.Where("uid=? and provider=?", uid, provider).Delete(&UserIdentity{}).Error
+	if err != nil {
+		return response.ErrroCode_InternalUnknownError.Wrap(err, "delete user identity fail")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package dal
+
+import (
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestSearchUserIgnoreCase(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *gorm.DB) {
+		for _, u := range []struct{ uid, name, email string }{
+			{"u1", "Alice", "alice@test.com"},
+			{"u2", "bob", "BOB@test.com"},
+			{"u3", "100%_sure!", "carol@test.com"},
+		} {
+			name, email := u.name, u.email
+			sErr := UserDBHD.Add(db, &User{UID: UID(u.uid), Name: &name, Email: &email, UserRegisterType: UserRegisterTypeEmail})
+			if sErr != nil {
+				t.Fatal(sErr)
+			}
+		}
+
+		users, sErr := UserDBHD.SearchUserByNameOrUID(db, "ALI", &Pagination{Page: 1, PageSize: 10})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if len(users) != 1 || users[0].UID != "u1" {
+			t.Fatalf("unexpected users %+v", users)
+		}
+
+		users, total, sErr := UserDBHD.SearchUserForAdmin(db, "bob@", &Pagination{Page: 1, PageSize: 10})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if total != 1 || len(users) != 1 || users[0].UID != "u2" {
+			t.Fatalf("unexpected users %+v", users)
+		}
+
+		users, total, sErr = UserDBHD.SearchUserForAdmin(db, "U", &Pagination{Page: 2, PageSize: 1})
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+		if total != 3 || len(users) != 1 || users[0].UID != "u2" {
+			t.Fatalf("unexpected users of page 2 %+v, total %d", users, total)
+		}
+
+		// the wildcards are matched literally
+		for _, text := range []string{"%", "_", "0%_s", "!"} {
+			users, sErr = UserDBHD.SearchUserByNameOrUID(db, text, &Pagination{Page: 1, PageSize: 10})
+			if sErr != nil {
+				t.Fatal(sErr)
+			}
+			if len(users) != 1 || users[0].UID != "u3" {
+				t.Fatalf("unexpected users of %q %+v", text, users)
+			}
+		}
+	})
+}
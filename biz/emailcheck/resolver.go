@@ -0,0 +1,88 @@
+package emailcheck
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MXResolver lookup the mail servers of a domain, implement it to use another dns server or for test
+type MXResolver interface {
+	// LookupMX return the mx records of the domain, a *net.DNSError with IsNotFound if the domain does not exist
+	LookupMX(ctx context.Context, domain string) ([]*net.MX, error)
+}
+
+// NetResolver a MXResolver using the system dns resolver
+type NetResolver struct{}
+
+func (r *NetResolver) LookupMX(ctx context.Context, domain string) ([]*net.MX, error) {
+	return net.DefaultResolver.LookupMX(ctx, domain)
+}
+
+// maxCachedDomains the max domains kept by a CachedResolver, the expired ones are removed when exceeded
+const maxCachedDomains = 10000
+
+type cachedMX struct {
+	mxs      []*net.MX
+	err      error
+	expireAt time.Time
+}
+
+// CachedResolver a MXResolver caches the results of another one, the found and not found results are cached
+// while the other errors are not as they are usually transient
+type CachedResolver struct {
+	resolver MXResolver
+	ttl      time.Duration
+	mutex    sync.Mutex
+	cache    map[string]*cachedMX
+	nowFunc  func() time.Time
+}
+
+// NewCachedResolver create a CachedResolver caching the results of resolver for ttl
+func NewCachedResolver(resolver MXResolver, ttl time.Duration) *CachedResolver {
+	return &CachedResolver{
+		resolver: resolver,
+		ttl:      ttl,
+		cache:    make(map[string]*cachedMX),
+		nowFunc:  time.Now,
+	}
+}
+
+func (r *CachedResolver) LookupMX(ctx context.Context, domain string) ([]*net.MX, error) {
+	domain = strings.ToLower(domain)
+	now := r.nowFunc()
+	r.mutex.Lock()
+	c, ok := r.cache[domain]
+	if ok && now.After(c.expireAt) {
+		delete(r.cache, domain)
+		ok = false
+	}
+	r.mutex.Unlock()
+	if ok {
+		return c.mxs, c.err
+	}
+
+	mxs, err := r.resolver.LookupMX(ctx, domain)
+	if err != nil {
+		dnsErr, isDNSErr := err.(*net.DNSError)
+		if !isDNSErr || !dnsErr.IsNotFound {
+			return nil, err
+		}
+	}
+	r.mutex.Lock()
+	if len(r.cache) >= maxCachedDomains {
+		for d, c := range r.cache {
+			if now.After(c.expireAt) {
+				delete(r.cache, d)
+			}
+		}
+		if len(r.cache) >= maxCachedDomains { // still full, start over
+			r.cache = make(map[string]*cachedMX)
+		}
+	}
+	r.cache[domain] = &cachedMX{mxs: mxs, err: err, expireAt: now.Add(r.ttl)}
+	r.mutex.Unlock()
+	return mxs, err
+}
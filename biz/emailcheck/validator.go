@@ -0,0 +1,177 @@
+package emailcheck
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"net"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Policy how strict an email address is validated, each policy includes the checks of the former ones
+type Policy string
+
+const (
+	PolicySyntax     Policy = "syntax"     // only check the address syntax
+	PolicyDisposable Policy = "disposable" // also reject the addresses of the bundled disposable email domains
+	PolicyMX         Policy = "mx"         // also require the domain to accept mails, needs dns lookup
+)
+
+var (
+	ErrInvalidSyntax = errors.New("invalid email syntax")
+	ErrDisposable    = errors.New("disposable email address is not allowed")
+	ErrNoMailServer  = errors.New("email domain does not accept mails")
+)
+
+// the max lengths of an email address and its local part, by RFC 5321
+const (
+	maxAddressLength   = 254
+	maxLocalPartLength = 64
+)
+
+// defaultLookupTimeout the timeout of a dns lookup when the ctx has no deadline
+const defaultLookupTimeout = 3 * time.Second
+
+//go:embed disposable_domains.txt
+var disposableDomainList string
+
+// Validator validate email addresses by layers according to the policy, syntax first, then the disposable domains,
+// then the mail servers of the domain
+type Validator struct {
+	policy     Policy
+	resolver   MXResolver
+	disposable map[string]bool
+}
+
+// NewValidator create a Validator of the policy, resolver is only used by PolicyMX and a NetResolver is used if nil
+func NewValidator(policy Policy, resolver MXResolver) (*Validator, error) {
+	switch policy {
+	case PolicySyntax, PolicyDisposable, PolicyMX:
+	default:
+		return nil, errors.New("unknown email validation policy " + string(policy))
+	}
+	if policy == PolicyMX && resolver == nil {
+		resolver = &NetResolver{}
+	}
+	v := &Validator{
+		policy:     policy,
+		resolver:   resolver,
+		disposable: make(map[string]bool),
+	}
+	scanner := bufio.NewScanner(strings.NewReader(disposableDomainList))
+	for scanner.Scan() {
+		domain := strings.TrimSpace(scanner.Text())
+		if domain == "" || strings.HasPrefix(domain, "#") {
+			continue
+		}
+		v.disposable[strings.ToLower(domain)] = true
+	}
+	return v, nil
+}
+
+// Validate validate an email address, return ErrInvalidSyntax, ErrDisposable or ErrNoMailServer if it is rejected,
+// a failed dns lookup other than the domain not found does not reject the address
+func (v *Validator) Validate(ctx context.Context, email string) error {
+	domain, err := checkSyntax(email)
+	if err != nil {
+		return err
+	}
+	if v.policy == PolicySyntax {
+		return nil
+	}
+
+	if v.IsDisposable(domain) {
+		return ErrDisposable
+	}
+	if v.policy == PolicyDisposable {
+		return nil
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultLookupTimeout)
+		defer cancel()
+	}
+	mxs, err := v.resolver.LookupMX(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return ErrNoMailServer
+		}
+		return nil // unable to tell, let the address pass rather than block the user
+	}
+	// a single "." mx record means the domain accepts no mail, by RFC 7505
+	if len(mxs) == 0 || (len(mxs) == 1 && mxs[0].Host == ".") {
+		return ErrNoMailServer
+	}
+	return nil
+}
+
+// IsDisposable whether the domain or one of its parent domains is a disposable email domain
+func (v *Validator) IsDisposable(domain string) bool {
+	domain = strings.ToLower(domain)
+	for {
+		if v.disposable[domain] {
+			return true
+		}
+		i := strings.Index(domain, ".")
+		if i < 0 {
+			return false
+		}
+		domain = domain[i+1:]
+	}
+}
+
+// checkSyntax check an email address is a bare addr-spec with a valid domain name, return the domain
+func checkSyntax(email string) (string, error) {
+	if len(email) > maxAddressLength {
+		return "", ErrInvalidSyntax
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "", ErrInvalidSyntax
+	}
+	at := strings.LastIndex(email, "@")
+	local, domain := email[:at], email[at+1:]
+	if len(local) == 0 || len(local) > maxLocalPartLength {
+		return "", ErrInvalidSyntax
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return "", ErrInvalidSyntax
+	}
+	for _, label := range labels {
+		if !isDomainLabel(label) {
+			return "", ErrInvalidSyntax
+		}
+	}
+	return domain, nil
+}
+
+// isDomainLabel whether s is a valid domain name label, letters, digits and hyphens not at the ends
+func isDomainLabel(s string) bool {
+	if len(s) == 0 || len(s) > 63 || s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+var defaultValidator, _ = NewValidator(PolicyDisposable, nil)
+
+// InitDefaultValidator set the validator used to validate the emails of users, default is of PolicyDisposable
+func InitDefaultValidator(v *Validator) {
+	defaultValidator = v
+}
+
+// DefaultValidator get the validator used to validate the emails of users
+func DefaultValidator() *Validator {
+	return defaultValidator
+}
@@ -0,0 +1,154 @@
+package emailcheck
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeResolver a MXResolver answers from a map, counting the lookups
+type fakeResolver struct {
+	records map[string][]*net.MX
+	err     error // returned for every domain if set
+	lookups int
+}
+
+func (r *fakeResolver) LookupMX(ctx context.Context, domain string) ([]*net.MX, error) {
+	r.lookups++
+	if r.err != nil {
+		return nil, r.err
+	}
+	mxs, ok := r.records[domain]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+	}
+	return mxs, nil
+}
+
+func TestValidateSyntax(t *testing.T) {
+	v, err := NewValidator(PolicySyntax, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for email, valid := range map[string]bool{
+		"u1@test.com":                 true,
+		"first.last+tag@sub.test.com": true,
+		"u1@mailinator.com":           true, // disposable is not checked by this policy
+		"":                            false,
+		"u1":                          false,
+		"u1@":                         false,
+		"@test.com":                   false,
+		"u1@test":                     false,
+		"u1@-test.com":                false,
+		"u1@test..com":                false,
+		"u1@te_st.com":                false,
+		"User <u1@test.com>":          false,
+		" u1@test.com":                false,
+		"u1@test.com\n":               false,
+	} {
+		err = v.Validate(context.Background(), email)
+		if valid && err != nil {
+			t.Fatalf("%q should be valid, got %v", email, err)
+		}
+		if !valid && !errors.Is(err, ErrInvalidSyntax) {
+			t.Fatalf("%q should be invalid syntax, got %v", email, err)
+		}
+	}
+}
+
+func TestValidateDisposable(t *testing.T) {
+	v, err := NewValidator(PolicyDisposable, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for email, disposable := range map[string]bool{
+		"u1@test.com":           false,
+		"u1@mailinator.com":     true,
+		"u1@MAILINATOR.com":     true,
+		"u1@abc.mailinator.com": true,
+		"u1@notmailinator.com":  false,
+	} {
+		err = v.Validate(context.Background(), email)
+		if disposable != errors.Is(err, ErrDisposable) {
+			t.Fatalf("%q disposable should be %v, got %v", email, disposable, err)
+		}
+	}
+
+	_, err = NewValidator("unknown", nil)
+	if err == nil {
+		t.Fatal("unknown policy should fail")
+	}
+}
+
+func TestValidateMX(t *testing.T) {
+	resolver := &fakeResolver{records: map[string][]*net.MX{
+		"test.com":   {{Host: "mx.test.com", Pref: 10}},
+		"nomail.com": {{Host: ".", Pref: 0}},
+	}}
+	v, err := NewValidator(PolicyMX, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err = v.Validate(ctx, "u1@test.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.Validate(ctx, "u1@nomail.com"); !errors.Is(err, ErrNoMailServer) {
+		t.Fatalf("null mx should be rejected, got %v", err)
+	}
+	if err = v.Validate(ctx, "u1@not-exist.com"); !errors.Is(err, ErrNoMailServer) {
+		t.Fatalf("not found domain should be rejected, got %v", err)
+	}
+	if err = v.Validate(ctx, "u1@mailinator.com"); !errors.Is(err, ErrDisposable) {
+		t.Fatalf("disposable should be rejected before mx lookup, got %v", err)
+	}
+	if resolver.lookups != 3 {
+		t.Fatalf("expect 3 lookups, got %d", resolver.lookups)
+	}
+
+	// a dns failure does not block the user
+	resolver.err = &net.DNSError{Err: "i/o timeout", Name: "test.com", IsTimeout: true}
+	if err = v.Validate(ctx, "u1@other.com"); err != nil {
+		t.Fatalf("dns failure should pass, got %v", err)
+	}
+}
+
+func TestCachedResolver(t *testing.T) {
+	resolver := &fakeResolver{records: map[string][]*net.MX{"test.com": {{Host: "mx.test.com", Pref: 10}}}}
+	cached := NewCachedResolver(resolver, time.Minute)
+	now := time.Now()
+	cached.nowFunc = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		mxs, err := cached.LookupMX(ctx, "Test.com")
+		if err != nil || len(mxs) != 1 {
+			t.Fatalf("unexpected lookup result %v, err=%v", mxs, err)
+		}
+		_, err = cached.LookupMX(ctx, "not-exist.com")
+		if err == nil {
+			t.Fatal("expect not found")
+		}
+	}
+	if resolver.lookups != 2 {
+		t.Fatalf("found and not found results should be cached, got %d lookups", resolver.lookups)
+	}
+
+	// transient errors are not cached
+	resolver.err = errors.New("network unreachable")
+	_, _ = cached.LookupMX(ctx, "other.com")
+	_, _ = cached.LookupMX(ctx, "other.com")
+	if resolver.lookups != 4 {
+		t.Fatalf("transient errors should not be cached, got %d lookups", resolver.lookups)
+	}
+
+	// expired
+	resolver.err = nil
+	now = now.Add(2 * time.Minute)
+	_, _ = cached.LookupMX(ctx, "test.com")
+	if resolver.lookups != 5 {
+		t.Fatalf("expired result should be looked up again, got %d lookups", resolver.lookups)
+	}
+}
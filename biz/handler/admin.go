@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"context"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/swordandtea/lets-habit-server/biz/controller"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+)
+
+type AdminRouter struct {
+	Ctrl *controller.AdminCtrl
+}
+
+func NewAdminRouter(repos *controller.Repositories) *AdminRouter {
+	return &AdminRouter{
+		Ctrl: controller.NewAdminCtrl(repos),
+	}
+}
+
+// maxAdminReasonLength the max length of the reason of an admin action
+const maxAdminReasonLength = 512
+
+// getAdminOperator get the admin who sends the request, should be used after AdminVerify
+func getAdminOperator(rc *app.RequestContext) *controller.AdminOperator {
+	return &controller.AdminOperator{
+		UID: dal.UID(rc.GetString(UIDKey)),
+		IP:  rc.ClientIP(),
+	}
+}
+
+// AdminUserActionRequest the common request of an admin action on a user
+type AdminUserActionRequest struct {
+	UID    string `path:"uid"`
+	Reason string `json:"reason"`
+}
+
+func (r *AdminUserActionRequest) validate() response.SError {
+	if r.UID == "" {
+		return response.ErrorCode_InvalidParam.New("empty uid")
+	}
+	if len(r.Reason) > maxAdminReasonLength {
+		return response.ErrorCode_InvalidParam.New("reason too long")
+	}
+	return nil
+}
+
+// handleUserAction bind the AdminUserActionRequest and take the action on the user
+func (r *AdminRouter) handleUserAction(ctx context.Context, rc *app.RequestContext,
+	action func(ctx context.Context, op *controller.AdminOperator, uid dal.UID, reason string) response.SError) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &AdminUserActionRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	sErr = action(ctx, getAdminOperator(rc), dal.UID(req.UID), req.Reason)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+}
+
+/*********************** Admin Router Search Users Handler ***********************/
+
+type AdminSearchUsersRequest struct {
+	Text     string `query:"text"`
+	Page     uint   `query:"page"`
+	PageSize uint   `query:"page_size"`
+}
+
+func (r *AdminSearchUsersRequest) validate() response.SError {
+	if r.Page == 0 {
+		return response.ErrorCode_InvalidParam.New("page mast greater than 0")
+	}
+	if r.PageSize == 0 || r.PageSize > 100 {
+		return response.ErrorCode_InvalidParam.New("page size must greater than 0 and less than 100")
+	}
+	return nil
+}
+
+type AdminSearchUsersResponse struct {
+	Users []*dal.User `json:"users"`
+	Total uint        `json:"total"`
+}
+
+func (r *AdminRouter) SearchUsers(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &AdminSearchUsersRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	users, total, sErr := r.Ctrl.SearchUsers(ctx, getAdminOperator(rc), req.Text, &dal.Pagination{
+		Page:     req.Page,
+		PageSize: req.PageSize,
+	})
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	resp.SetSuccessData(&AdminSearchUsersResponse{Users: users, Total: total})
+}
+
+/*********************** Admin Router List User Habits Handler ***********************/
+
+type AdminListUserHabitsRequest struct {
+	UID string `path:"uid"`
+	ListHabitsRequest
+}
+
+func (r *AdminListUserHabitsRequest) validate() response.SError {
+	if r.UID == "" {
+		return response.ErrorCode_InvalidParam.New("empty uid")
+	}
+	return r.ListHabitsRequest.validate()
+}
+
+func (r *AdminRouter) ListUserHabits(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &AdminListUserHabitsRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	habits, total, sErr := r.Ctrl.ListUserHabits(ctx, getAdminOperator(rc), dal.UID(req.UID), &dal.Pagination{
+		Page:     req.Page,
+		PageSize: req.PageSize,
+	}, req.FromTime, req.ToTime)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	resp.SetSuccessData(&ListHabitsResponse{Habits: habits, Total: total})
+}
+
+/*********************** Admin Router Disable User Handler ***********************/
+
+func (r *AdminRouter) DisableUser(ctx context.Context, rc *app.RequestContext) {
+	r.handleUserAction(ctx, rc, r.Ctrl.DisableUser)
+}
+
+/*********************** Admin Router Enable User Handler ***********************/
+
+func (r *AdminRouter) EnableUser(ctx context.Context, rc *app.RequestContext) {
+	r.handleUserAction(ctx, rc, r.Ctrl.EnableUser)
+}
+
+/*********************** Admin Router Activate Email Handler ***********************/
+
+func (r *AdminRouter) ActivateEmail(ctx context.Context, rc *app.RequestContext) {
+	r.handleUserAction(ctx, rc, r.Ctrl.ForceActivateEmail)
+}
+
+/*********************** Admin Router Remove Name Handler ***********************/
+
+func (r *AdminRouter) RemoveName(ctx context.Context, rc *app.RequestContext) {
+	r.handleUserAction(ctx, rc, r.Ctrl.RemoveName)
+}
+
+/*********************** Admin Router Remove Portrait Handler ***********************/
+
+func (r *AdminRouter) RemovePortrait(ctx context.Context, rc *app.RequestContext) {
+	r.handleUserAction(ctx, rc, r.Ctrl.RemovePortrait)
+}
+
+/*********************** Admin Router Reset Streak Handler ***********************/
+
+type AdminResetStreakRequest struct {
+	AdminUserActionRequest
+	HabitID uint64 `path:"habit_id"`
+}
+
+func (r *AdminResetStreakRequest) validate() response.SError {
+	if r.HabitID == 0 {
+		return response.ErrorCode_InvalidParam.New("invalid habit id")
+	}
+	return r.AdminUserActionRequest.validate()
+}
+
+func (r *AdminRouter) ResetStreak(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &AdminResetStreakRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	sErr = r.Ctrl.ResetStreak(ctx, getAdminOperator(rc), dal.UID(req.UID), req.HabitID, req.Reason)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+}
+
+/*********************** Admin Router List Audit Logs Handler ***********************/
+
+type AdminListAuditLogsRequest struct {
+	TargetUID string `query:"target_uid"`
+	Page      uint   `query:"page"`
+	PageSize  uint   `query:"page_size"`
+}
+
+func (r *AdminListAuditLogsRequest) validate() response.SError {
+	if r.Page == 0 {
+		return response.ErrorCode_InvalidParam.New("page mast greater than 0")
+	}
+	if r.PageSize == 0 || r.PageSize > 100 {
+		return response.ErrorCode_InvalidParam.New("page size must greater than 0 and less than 100")
+	}
+	return nil
+}
+
+type AdminListAuditLogsResponse struct {
+	Logs  []*dal.AdminAuditLog `json:"logs"`
+	Total uint                 `json:"total"`
+}
+
+func (r *AdminRouter) ListAuditLogs(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &AdminListAuditLogsRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	logs, total, sErr := r.Ctrl.ListAuditLogs(ctx, getAdminOperator(rc), dal.UID(req.TargetUID), &dal.Pagination{
+		Page:     req.Page,
+		PageSize: req.PageSize,
+	})
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	resp.SetSuccessData(&AdminListAuditLogsResponse{Logs: logs, Total: total})
+}
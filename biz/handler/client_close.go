@@ -0,0 +1,74 @@
+//go:build !windows
+
+package handler
+
+import (
+	"context"
+	"github.com/cloudwego/hertz/pkg/app"
+	hznetpoll "github.com/cloudwego/hertz/pkg/network/netpoll"
+	"github.com/cloudwego/netpoll"
+	"sync"
+)
+
+// connCancel the cancel func of the request being handled on a connection, a connection handles
+// one request at a time, so one close callback is registered for each connection and shared by its requests
+type connCancel struct {
+	mutex  sync.Mutex
+	closed bool
+	cancel context.CancelFunc
+}
+
+// set replace the cancel func of the current request, nil after the request is done,
+// cancel at once if the connection is already closed
+func (c *connCancel) set(cancel context.CancelFunc) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.cancel = cancel
+	if c.closed && cancel != nil {
+		cancel()
+	}
+}
+
+// close mark the connection closed and cancel the current request
+func (c *connCancel) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// connCancels the connCancel of every open connection, removed when the connection is closed
+var connCancels sync.Map
+
+// cancelOnClientClose call cancel when the client closes the connection of the request, only works with the netpoll
+// transport, return the func to call when the request is done
+func cancelOnClientClose(rc *app.RequestContext, cancel context.CancelFunc) (stop func()) {
+	hzConn, ok := rc.GetConn().(*hznetpoll.Conn)
+	if !ok {
+		return func() {}
+	}
+	conn, ok := hzConn.Conn.(netpoll.Connection)
+	if !ok {
+		return func() {}
+	}
+
+	v, loaded := connCancels.LoadOrStore(conn, &connCancel{})
+	cc := v.(*connCancel)
+	if !loaded {
+		_ = conn.AddCloseCallback(func(netpoll.Connection) error {
+			connCancels.Delete(conn)
+			cc.close()
+			return nil
+		})
+		if !conn.IsActive() { // closed before the callback is added
+			connCancels.Delete(conn)
+			cc.close()
+		}
+	}
+	cc.set(cancel)
+	return func() {
+		cc.set(nil)
+	}
+}
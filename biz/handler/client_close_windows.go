@@ -0,0 +1,11 @@
+package handler
+
+import (
+	"context"
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// cancelOnClientClose the netpoll transport is not supported on windows, the request is only canceled by its deadline
+func cancelOnClientClose(rc *app.RequestContext, cancel context.CancelFunc) (stop func()) {
+	return func() {}
+}
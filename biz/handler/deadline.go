@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"context"
+	"github.com/cloudwego/hertz/pkg/app"
+	"time"
+)
+
+// RouteKey the key of a route in the route timeouts, the method and the path as registered,
+// like "POST /api/v1/habit/log/:id"
+func RouteKey(method string, path string) string {
+	return method + " " + path
+}
+
+// Deadline bound the context of every request with a deadline, so that the db queries and outbound calls
+// of the request are canceled when it passes, the timeout of a route is looked up in routeTimeouts by RouteKey,
+// and defaultTimeout is used if not found, zero means no deadline.
+// The context is canceled as well when the client closes the connection before the response is sent
+func Deadline(defaultTimeout time.Duration, routeTimeouts map[string]time.Duration) app.HandlerFunc {
+	return func(ctx context.Context, rc *app.RequestContext) {
+		timeout, ok := routeTimeouts[RouteKey(string(rc.Method()), rc.FullPath())]
+		if !ok {
+			timeout = defaultTimeout
+		}
+		var cancel context.CancelFunc
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		} else {
+			ctx, cancel = context.WithCancel(ctx)
+		}
+		defer cancel()
+
+		stop := cancelOnClientClose(rc, cancel)
+		defer stop()
+		rc.Next(ctx)
+	}
+}
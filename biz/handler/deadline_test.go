@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"context"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/config"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/cloudwego/hertz/pkg/route"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDeadline(t *testing.T) {
+	engine := route.NewEngine(config.NewOptions(nil))
+	engine.Use(Deadline(time.Hour, map[string]time.Duration{
+		RouteKey(http.MethodGet, "/item/:id"): time.Millisecond,
+		RouteKey(http.MethodGet, "/stream"):   0,
+	}))
+	deadlines := make(map[string]time.Duration)
+	recordDeadline := func(ctx context.Context, rc *app.RequestContext) {
+		if deadline, ok := ctx.Deadline(); ok {
+			deadlines[rc.FullPath()] = time.Until(deadline)
+		} else {
+			deadlines[rc.FullPath()] = 0
+		}
+		rc.Status(http.StatusOK)
+	}
+	engine.GET("/item/:id", func(ctx context.Context, rc *app.RequestContext) {
+		resp := response.NewHTTPResponse(rc)
+		defer resp.ReturnWithLog(ctx, rc)
+		<-ctx.Done() // like a db query canceled by the deadline
+		resp.SetError(response.ErrroCode_InternalUnknownError.Wrap(ctx.Err(), "query fail"))
+	})
+	engine.GET("/list", recordDeadline)
+	engine.GET("/stream", recordDeadline)
+
+	w := ut.PerformRequest(engine, http.MethodGet, "/item/1", nil)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("request out of deadline should get 503, got %d", w.Code)
+	}
+
+	ut.PerformRequest(engine, http.MethodGet, "/list", nil)
+	if d := deadlines["/list"]; d < time.Hour-time.Minute || d > time.Hour {
+		t.Fatalf("route without its own timeout should use the default one, got %v", d)
+	}
+	ut.PerformRequest(engine, http.MethodGet, "/stream", nil)
+	if d, ok := deadlines["/stream"]; !ok || d != 0 {
+		t.Fatalf("route with zero timeout should have no deadline, got %v", d)
+	}
+}
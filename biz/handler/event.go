@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/swordandtea/lets-habit-server/biz/controller"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/realtime"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"io"
+	"time"
+)
+
+// eventHeartbeatInterval how often a comment is sent on an idle event stream, so that the proxies keep it open,
+// and the session of the stream is verified again
+const eventHeartbeatInterval = time.Second * 30
+
+// eventSessionVerifyTimeout the timeout of verifying the session of an event stream
+const eventSessionVerifyTimeout = time.Second * 5
+
+type EventRouter struct {
+	sessionCtrl *controller.SessionCtrl
+}
+
+func NewEventRouter(repos *controller.Repositories) *EventRouter {
+	return &EventRouter{
+		sessionCtrl: controller.NewSessionCtrl(repos),
+	}
+}
+
+/*********************** Event Router Stream Handler ***********************/
+
+// Stream push the events of the habits the user joined as server-sent events, until the client disconnects or
+// the session is revoked. The stream also ends when the client falls too far behind, the client should pull
+// the change feed and connect again then
+func (r *EventRouter) Stream(ctx context.Context, rc *app.RequestContext) {
+	uid := dal.UID(rc.GetString(UIDKey))
+	sid := rc.GetString(SessionIDKey)
+	sub := realtime.DefaultHub().Subscribe(uid)
+
+	// the body is read from the pipe while the response is written, a write fails once the client disconnects
+	pr, pw := io.Pipe()
+	rc.SetContentType("text/event-stream")
+	rc.Response.Header.Set("Cache-Control", "no-cache")
+	rc.Response.Header.Set("X-Accel-Buffering", "no") // keep nginx from buffering the events
+	rc.SetBodyStream(pr, -1)
+	go r.writeEvents(pw, sub, uid, sid)
+}
+
+// writeEvents write the events of sub to w in the format of server-sent events, the context of the request
+// is done once the handler returns, so the goroutine verifies the session with its own
+func (r *EventRouter) writeEvents(w *io.PipeWriter, sub *realtime.Subscriber, uid dal.UID, sid string) {
+	defer sub.Close()
+	defer w.Close()
+
+	// a comment first, so that the client knows the stream is open before any event
+	if _, err := io.WriteString(w, ": connected\n\n"); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(eventHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				hlog.Errorf("marshal event %s of habit %d fail, err=%v", e.Type, e.HabitID, err)
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return // the client disconnected
+			}
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), eventSessionVerifyTimeout)
+			sErr := r.sessionCtrl.VerifySession(ctx, uid, sid)
+			cancel()
+			if sErr != nil && sErr.ErrorCode() == response.ErrorCode_UserAuthFail {
+				return
+			}
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
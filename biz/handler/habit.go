@@ -13,8 +13,8 @@ type HabitRouter struct {
 	Ctrl *controller.HabitCtrl
 }
 
-func NewHabitRouter() *HabitRouter {
-	return &HabitRouter{Ctrl: &controller.HabitCtrl{}}
+func NewHabitRouter(repos *controller.Repositories) *HabitRouter {
+	return &HabitRouter{Ctrl: controller.NewHabitCtrl(repos)}
 }
 
 /*********************** Habit Router Create Habit Handler ***********************/
@@ -67,7 +67,7 @@ func (r *HabitRouter) CreateHabit(ctx context.Context, rc *app.RequestContext) {
 		LogDays:  req.CheckDays,
 	}
 
-	detailHabits, sErr := r.Ctrl.AddHabit(habit, dal.UID(uid), req.Cooperators, req.CustomConfig)
+	detailHabits, sErr := r.Ctrl.AddHabit(ctx, habit, dal.UID(uid), req.Cooperators, req.CustomConfig)
 	if sErr != nil {
 		resp.SetError(sErr)
 		return
@@ -110,7 +110,7 @@ func (r *HabitRouter) GetHabit(ctx context.Context, rc *app.RequestContext) {
 	}
 
 	uid := rc.GetString(UIDKey)
-	habit, sErr := r.Ctrl.GetHabitByID(req.ID, dal.UID(uid))
+	habit, sErr := r.Ctrl.GetHabitByID(ctx, req.ID, dal.UID(uid))
 	if sErr != nil {
 		resp.SetError(sErr)
 		return
@@ -183,7 +183,7 @@ func (r *HabitRouter) ListHabits(ctx context.Context, rc *app.RequestContext) {
 	}
 
 	uid := rc.GetString(UIDKey)
-	habits, total, sErr := r.Ctrl.ListHabitsByUID(dal.UID(uid), &dal.Pagination{
+	habits, total, sErr := r.Ctrl.ListHabitsByUID(ctx, dal.UID(uid), &dal.Pagination{
 		Page:     req.Page,
 		PageSize: req.PageSize,
 	}, req.FromTime, req.ToTime)
@@ -236,7 +236,7 @@ func (r *HabitRouter) UpdateHabit(ctx context.Context, rc *app.RequestContext) {
 
 	uid := rc.GetString(UIDKey)
 
-	sErr = r.Ctrl.UpdateHabit(dal.UID(uid), req.HabitID, &req.BasicInfo, &req.CustomInfo)
+	sErr = r.Ctrl.UpdateHabit(ctx, dal.UID(uid), req.HabitID, &req.BasicInfo, &req.CustomInfo)
 	if sErr != nil {
 		resp.SetError(sErr)
 		return
@@ -285,7 +285,7 @@ func (r *HabitRouter) LogHabit(ctx context.Context, rc *app.RequestContext) {
 	}
 
 	uid := rc.GetString(UIDKey)
-	logRecord, sErr := r.Ctrl.LogHabit(dal.UID(uid), req.HabitID, req.LogTime)
+	logRecord, sErr := r.Ctrl.LogHabit(ctx, dal.UID(uid), req.HabitID, req.LogTime)
 	if sErr != nil {
 		resp.SetError(sErr)
 		return
@@ -295,3 +295,89 @@ func (r *HabitRouter) LogHabit(ctx context.Context, rc *app.RequestContext) {
 		LogRecord: logRecord,
 	})
 }
+
+/*********************** Habit Router Sync Handler ***********************/
+
+// maxSyncCheckIns the max number of check-ins synced in a request
+const maxSyncCheckIns = 100
+
+type SyncHabitRequest struct {
+	CheckIns []*controller.CheckIn `json:"check_ins"`
+	Cursor   string                `json:"cursor"` // the cursor returned by the last sync, empty for the first sync, which pulls the recent changes only
+}
+
+func (r *SyncHabitRequest) validate() response.SError {
+	if len(r.CheckIns) > maxSyncCheckIns {
+		return response.ErrorCode_InvalidParam.New("too many check-ins, at most %d", maxSyncCheckIns)
+	}
+	for _, checkIn := range r.CheckIns {
+		if checkIn == nil {
+			return response.ErrorCode_InvalidParam.New("invalid check-in")
+		}
+	}
+	return nil
+}
+
+type SyncHabitResponse struct {
+	Results []*controller.CheckInResult   `json:"results"`
+	Changes []*controller.LogRecordChange `json:"changes"`
+	Cursor  string                        `json:"cursor"`
+}
+
+func (r *HabitRouter) Sync(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &SyncHabitRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	uid := rc.GetString(UIDKey)
+	results, changes, cursor, sErr := r.Ctrl.SyncCheckIns(ctx, dal.UID(uid), req.CheckIns, req.Cursor)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	resp.SetSuccessData(&SyncHabitResponse{
+		Results: results,
+		Changes: changes,
+		Cursor:  cursor,
+	})
+}
+
+/*********************** Habit Router List Changes Handler ***********************/
+
+type ListChangesRequest struct {
+	Since string `query:"since"` // the cursor returned by the last pull, empty for the first pull
+}
+
+func (r *HabitRouter) ListChanges(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &ListChangesRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	uid := rc.GetString(UIDKey)
+	changes, sErr := r.Ctrl.ListChanges(ctx, dal.UID(uid), req.Since)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	resp.SetSuccessData(changes)
+}
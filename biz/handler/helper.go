@@ -1,52 +1,86 @@
 package handler
 
 import (
-	emailverify "github.com/AfterShip/email-verifier"
+	"context"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
 	"github.com/swordandtea/lets-habit-server/biz/config"
 	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/emailcheck"
 	"github.com/swordandtea/lets-habit-server/biz/response"
 	"time"
+	"unicode"
 )
 
 func BindAndValidateErr(err error) response.SError {
 	return response.ErrorCode_InvalidParam.Wrap(err, "bind req fail")
 }
 
-var verifier = emailverify.NewVerifier().EnableSMTPCheck().EnableAutoUpdateDisposable()
-
-// ValidateEmail validate whether an email is reachable
-func ValidateEmail(e string) response.SError {
-	ret, err := verifier.Verify(e)
+// ValidateEmail validate an email address with the default validator, the policy is selected in config,
+// the mx lookup is canceled with ctx
+func ValidateEmail(ctx context.Context, e string) response.SError {
+	err := emailcheck.DefaultValidator().Validate(ctx, e)
 	if err != nil {
-		return response.ErrroCode_InternalUnknownError.Wrap(err, "verify email fail")
-	}
-	if !ret.Syntax.Valid {
-		return response.ErrorCode_InvalidParam.New("invalid email syntax")
-	}
-	if !ret.SMTP.Deliverable && !ret.SMTP.CatchAll {
-		return response.ErrorCode_InvalidParam.New("can not send to this email address")
+		return response.ErrorCode_InvalidParam.Wrap(err, err.Error())
 	}
-
 	return nil
 }
 
-// ValidatePassword validate whether a password is valid passport,
-// currently the only limitation is that the length of a password should more than eight
-// TODO: add character check
+// passwordMinLength the min length of a password
+const passwordMinLength = 8
+
+// passwordMaxLength the max length of a password, bcrypt only uses the first 72 bytes
+const passwordMaxLength = 72
+
+// ValidatePassword validate whether a password is strong enough,
+// a valid password should be 8 to 72 bytes long, contain at least one letter and one digit and no control character
 func ValidatePassword(p string) response.SError {
-	if len(p) < 8 {
+	if len(p) < passwordMinLength {
 		return response.ErrorCode_InvalidParam.New("password length is less than eight")
 	}
+	if len(p) > passwordMaxLength {
+		return response.ErrorCode_InvalidParam.New("password length is more than seventy-two")
+	}
+	hasLetter, hasDigit := false, false
+	for _, r := range p {
+		switch {
+		case unicode.IsControl(r):
+			return response.ErrorCode_InvalidParam.New("password contains control character")
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return response.ErrorCode_InvalidParam.New("password should contain both letters and digits")
+	}
 	return nil
 }
 
-const UserTokenExpireTime = time.Hour * 24 * 7 // one week
+// UserTokenExpireTime the expire time of a user token, a new one can be obtained by the session refresh token
+const UserTokenExpireTime = time.Minute * 15
 
-func GenerateUserToken(uid dal.UID) (string, response.SError) {
-	claims := &jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(UserTokenExpireTime)),
-		ID:        string(uid),
+// userTokenSubject the subject of a user token, to distinguish it from other tokens signed by the same key
+const userTokenSubject = "user"
+
+// UserTokenClaims the claims of a user token
+type UserTokenClaims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid"`
+}
+
+// GenerateUserToken generate a user token of a user session
+func GenerateUserToken(uid dal.UID, sid string) (string, response.SError) {
+	now := time.Now().UTC()
+	claims := &UserTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userTokenSubject,
+			ExpiresAt: jwt.NewNumericDate(now.Add(UserTokenExpireTime)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        string(uid),
+		},
+		SessionID: sid,
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
@@ -57,24 +91,80 @@ func GenerateUserToken(uid dal.UID) (string, response.SError) {
 	return tokenStr, nil
 }
 
-func ExtractUserToken(token string) (dal.UID, response.SError) {
-	claims := &jwt.RegisteredClaims{}
+// ExtractUserToken verify a user token, return the uid and the session id inside it
+func ExtractUserToken(token string) (dal.UID, string, response.SError) {
+	claims := &UserTokenClaims{}
 	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
 		return []byte(config.GlobalConfig.JWT.Cypher), nil
 	})
 
 	if err != nil {
-		return "", response.ErrorCode_UserAuthFail.Wrap(err, "verify user token fail")
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return "", "", response.ErrorCode_UserAuthFail.New("user token expired")
+		}
+		return "", "", response.ErrorCode_UserAuthFail.Wrap(err, "verify user token fail")
+	}
+	if claims.Subject != userTokenSubject {
+		return "", "", response.ErrorCode_UserAuthFail.New("invalid user token, not a user token")
+	}
+
+	if claims.ID == "" {
+		return "", "", response.ErrorCode_UserAuthFail.New("invalid user token, no user id found")
+	}
+	if claims.SessionID == "" {
+		return "", "", response.ErrorCode_UserAuthFail.New("invalid user token, no session id found")
+	}
+	return dal.UID(claims.ID), claims.SessionID, nil
+}
+
+// TwoFactorChallengeExpireTime the expire time of a two-factor challenge token
+const TwoFactorChallengeExpireTime = time.Minute * 5
+
+// twoFactorChallengeSubject the subject of a two-factor challenge token, to distinguish it from other tokens signed by the same key
+const twoFactorChallengeSubject = "two_factor_challenge"
+
+// GenerateTwoFactorChallengeToken generate a token proving the user has passed the first login factor,
+// it can only be exchanged for a user token together with a two-factor code
+func GenerateTwoFactorChallengeToken(uid dal.UID) (string, response.SError) {
+	now := time.Now().UTC()
+	claims := &jwt.RegisteredClaims{
+		Subject:   twoFactorChallengeSubject,
+		ExpiresAt: jwt.NewNumericDate(now.Add(TwoFactorChallengeExpireTime)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        string(uid),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenStr, err := token.SignedString([]byte(config.GlobalConfig.JWT.Cypher))
+	if err != nil {
+		return "", response.ErrroCode_InternalUnknownError.Wrap(err, "generate two-factor challenge token fail")
 	}
+	return tokenStr, nil
+}
 
+// ExtractTwoFactorChallengeToken verify a two-factor challenge token, return the uid inside it
+func ExtractTwoFactorChallengeToken(token string) (dal.UID, response.SError) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(config.GlobalConfig.JWT.Cypher), nil
+	})
+	if err != nil {
+		return "", response.ErrorCode_UserAuthFail.Wrap(err, "invalid two-factor challenge token")
+	}
+	if claims.Subject != twoFactorChallengeSubject {
+		return "", response.ErrorCode_UserAuthFail.New("invalid two-factor challenge token, not a challenge token")
+	}
 	if claims.ID == "" {
-		return "", response.ErrorCode_UserAuthFail.Wrap(err, "invalid user token, no user id found")
+		return "", response.ErrorCode_UserAuthFail.New("invalid two-factor challenge token, no user id found")
 	}
 	return dal.UID(claims.ID), nil
 }
 
+// pollTokenSubject the subject of a poll token, to distinguish it from other tokens signed by the same key
+const pollTokenSubject = "poll"
+
 func GeneratePollToken(uid dal.UID) (string, response.SError) {
 	claims := &jwt.RegisteredClaims{
+		Subject:   pollTokenSubject,
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(time.Minute * 30)),
 		NotBefore: nil,
 		IssuedAt:  nil,
@@ -96,6 +186,9 @@ func ExtractPollToken(token string) (dal.UID, response.SError) {
 	if err != nil {
 		return "", response.ErrorCode_UserNoPermission.Wrap(err, "invalid poll token")
 	}
+	if claims.Subject != pollTokenSubject {
+		return "", response.ErrorCode_UserNoPermission.New("invalid poll token, not a poll token")
+	}
 	if claims.ID == "" {
 		return "", response.ErrorCode_UserNoPermission.New("invalid poll token, no user id found")
 	}
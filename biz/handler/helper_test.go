@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"github.com/swordandtea/lets-habit-server/biz/config"
 	"github.com/swordandtea/lets-habit-server/biz/dal"
 	"testing"
@@ -11,12 +12,13 @@ func TestTokenGenerateExtract(t *testing.T) {
 		JWT: config.JWTConfig{Cypher: "test_cypher"},
 	}
 	uid := dal.UID("1")
-	tokenStr, err := GenerateUserToken(uid)
+	sid := "s1"
+	tokenStr, err := GenerateUserToken(uid, sid)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	uid2, err := ExtractUserToken(tokenStr)
+	uid2, sid2, err := ExtractUserToken(tokenStr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -24,12 +26,20 @@ func TestTokenGenerateExtract(t *testing.T) {
 	if uid2 != uid {
 		t.Fatal("uid incorrect")
 	}
+	if sid2 != sid {
+		t.Fatal("sid incorrect")
+	}
 
 	tokenStr, err = GeneratePollToken(uid)
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	_, _, err = ExtractUserToken(tokenStr)
+	if err == nil {
+		t.Fatal("poll token should not be accepted as user token")
+	}
+
 	uid2, err = ExtractPollToken(tokenStr)
 	if err != nil {
 		t.Fatal(err)
@@ -38,4 +48,86 @@ func TestTokenGenerateExtract(t *testing.T) {
 	if uid2 != uid {
 		t.Fatal("uid incorrect")
 	}
+
+	challenge, err := GenerateTwoFactorChallengeToken(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ExtractPollToken(challenge)
+	if err == nil {
+		t.Fatal("challenge token should not be accepted as poll token")
+	}
+	userToken, err := GenerateUserToken(uid, sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ExtractPollToken(userToken)
+	if err == nil {
+		t.Fatal("user token should not be accepted as poll token")
+	}
+}
+
+func TestTwoFactorChallengeToken(t *testing.T) {
+	config.GlobalConfig = &config.RuntimeConfig{
+		JWT: config.JWTConfig{Cypher: "test_cypher"},
+	}
+	uid := dal.UID("1")
+	challenge, err := GenerateTwoFactorChallengeToken(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = ExtractUserToken(challenge)
+	if err == nil {
+		t.Fatal("challenge token should not be accepted as user token")
+	}
+
+	uid2, err := ExtractTwoFactorChallengeToken(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid2 != uid {
+		t.Fatal("uid incorrect")
+	}
+
+	userToken, err := GenerateUserToken(uid, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ExtractTwoFactorChallengeToken(userToken)
+	if err == nil {
+		t.Fatal("user token should not be accepted as challenge token")
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	for p, valid := range map[string]bool{
+		"":                         false,
+		"abc123":                   false,
+		"abcdefgh":                 false,
+		"12345678":                 false,
+		"abcd1234":                 true,
+		"密码密码1234":                 true,
+		"abcd\n1234":               false,
+		string(make([]byte, 73)):   false,
+		"correct horse 9 battery!": true,
+	} {
+		if sErr := ValidatePassword(p); (sErr == nil) != valid {
+			t.Fatalf("validate password %q, expect valid=%v, got err=%v", p, valid, sErr)
+		}
+	}
+}
+
+func TestUserRegisterRequestValidate(t *testing.T) {
+	// validated by the default validator without network access
+	for email, valid := range map[string]bool{
+		"u1@test.com":       true,
+		"u1@mailinator.com": false,
+		"not-an-email":      false,
+	} {
+		req := &UserRegisterRequest{Email: email, Password: "passw0rd1"}
+		if sErr := req.validate(context.Background()); (sErr == nil) != valid {
+			t.Fatalf("%s valid should be %v, got %v", email, valid, sErr)
+		}
+	}
 }
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/swordandtea/lets-habit-server/biz/idempotency"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"time"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// maxIdempotencyKeyLength the max length of an idempotency key, long enough for a uuid or a ulid
+const maxIdempotencyKeyLength = 64
+
+// defaultIdempotencyTTL how long a response is replayed if the ttl is not configured
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// idempotencyLease how long a key is held by a request in progress, longer than any request deadline,
+// so that the key of a request lost with its server instance is released after it
+const idempotencyLease = time.Minute * 5
+
+// idempotencyStoreTimeout the deadline of storing or releasing a key after the request is handled,
+// not bound to the request context which may be canceled already
+const idempotencyStoreTimeout = time.Second * 5
+
+// requestHash the hash of the method, uri and body of a request, to tell whether a key is reused by another request
+func requestHash(rc *app.RequestContext) string {
+	h := sha256.New()
+	h.Write(rc.Method())
+	h.Write([]byte(" "))
+	h.Write(rc.Request.URI().RequestURI())
+	h.Write([]byte("\n"))
+	h.Write(rc.Request.Body())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Idempotent replay the response of a POST or PUT request to its retries with the same Idempotency-Key header
+// inside ttl, zero ttl means the default 24 hours. The keys are scoped by the user, so it must be used after
+// UserTokenVerify, and the requests without the header are handled as usual.
+// A retry of a request in progress is rejected with request in progress error, and a key reused by another request
+// with invalid param error. The responses of server errors are not stored, so that their retries are handled again
+func Idempotent(ttl time.Duration) app.HandlerFunc {
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+	return func(ctx context.Context, rc *app.RequestContext) {
+		idempotencyKey := string(rc.GetHeader(IdempotencyKeyHeader))
+		method := string(rc.Method())
+		if idempotencyKey == "" || (method != consts.MethodPost && method != consts.MethodPut) {
+			return
+		}
+		resp := response.NewHTTPResponse(rc)
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			resp.SetError(response.ErrorCode_InvalidParam.New("idempotency key too long, at most %d chars", maxIdempotencyKeyLength))
+			resp.Abort(ctx, rc)
+			return
+		}
+
+		store := idempotency.DefaultStore()
+		key := "idempotency:" + rc.GetString(UIDKey) + ":" + idempotencyKey
+		hash := requestHash(rc)
+		record, err := store.Reserve(ctx, key, hash, idempotencyLease)
+		if err != nil {
+			// let the request through like the rate limit, to not take the service down with the store
+			hlog.Errorf("reserve idempotency key fail, err=%v", err)
+			return
+		}
+		if record != nil {
+			switch {
+			case record.RequestHash != hash:
+				resp.SetError(response.ErrorCode_InvalidParam.New("idempotency key already used by another request"))
+				resp.Abort(ctx, rc)
+			case !record.Completed:
+				resp.SetError(response.ErrorCode_RequestInProgress.New("request of the same idempotency key in progress"))
+				resp.Abort(ctx, rc)
+			default:
+				rc.Response.Header.Set(IdempotentReplayedHeader, "true")
+				rc.Data(record.StatusCode, "application/json; charset=utf-8", record.Body)
+				rc.Abort()
+				hlog.Infof("%s %s %d, replayed", method, rc.Request.URI().Path(), record.StatusCode)
+			}
+			return
+		}
+
+		release := true
+		defer func() {
+			// release the key if the request fails with a server error or panics
+			if release {
+				storeCtx, cancel := context.WithTimeout(context.Background(), idempotencyStoreTimeout)
+				defer cancel()
+				if err := store.Release(storeCtx, key); err != nil {
+					hlog.Errorf("release idempotency key fail, err=%v", err)
+				}
+			}
+		}()
+		rc.Next(ctx)
+
+		statusCode := rc.Response.StatusCode()
+		if statusCode >= consts.StatusInternalServerError {
+			return
+		}
+		// keep the key reserved until the lease passes if fail to store the response,
+		// rather than let the retries repeat the request at once
+		release = false
+		storeCtx, cancel := context.WithTimeout(context.Background(), idempotencyStoreTimeout)
+		defer cancel()
+		err = store.Complete(storeCtx, key, statusCode, rc.Response.Body(), ttl)
+		if err != nil {
+			hlog.Errorf("complete idempotency key fail, err=%v", err)
+		}
+	}
+}
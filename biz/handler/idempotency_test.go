@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"context"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/config"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/cloudwego/hertz/pkg/route"
+	"github.com/swordandtea/lets-habit-server/biz/idempotency"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotent(t *testing.T) {
+	store := idempotency.NewMemoryStore()
+	idempotency.InitDefaultStore(store)
+	engine := route.NewEngine(config.NewOptions(nil))
+	setUID := func(ctx context.Context, rc *app.RequestContext) {
+		rc.Set(UIDKey, string(rc.GetHeader("uid"))) // like UserTokenVerify
+	}
+	handled := 0
+	failing := true
+	engine.POST("/habit", setUID, Idempotent(time.Hour), func(ctx context.Context, rc *app.RequestContext) {
+		resp := response.NewHTTPResponse(rc)
+		defer resp.ReturnWithLog(ctx, rc)
+		handled++
+		resp.SetSuccessData(handled)
+	})
+	engine.POST("/flaky", setUID, Idempotent(time.Hour), func(ctx context.Context, rc *app.RequestContext) {
+		resp := response.NewHTTPResponse(rc)
+		defer resp.ReturnWithLog(ctx, rc)
+		handled++
+		if failing {
+			resp.SetError(response.ErrroCode_InternalUnknownError.New("db down"))
+			return
+		}
+		resp.SetSuccessData(handled)
+	})
+
+	post := func(path string, uid string, key string, body string) *ut.ResponseRecorder {
+		headers := []ut.Header{{Key: "Content-Type", Value: "application/json"}, {Key: "uid", Value: uid}}
+		if key != "" {
+			headers = append(headers, ut.Header{Key: IdempotencyKeyHeader, Value: key})
+		}
+		return ut.PerformRequest(engine, http.MethodPost, path, &ut.Body{Body: strings.NewReader(body), Len: len(body)}, headers...)
+	}
+
+	first := post("/habit", "u1", "k1", `{"name":"read"}`)
+	if first.Code != http.StatusOK || handled != 1 {
+		t.Fatalf("first request should be handled, got %d", first.Code)
+	}
+	retry := post("/habit", "u1", "k1", `{"name":"read"}`)
+	if retry.Code != http.StatusOK || handled != 1 {
+		t.Fatalf("retry should not be handled again, got %d, handled %d", retry.Code, handled)
+	}
+	if retry.Body.String() != first.Body.String() || retry.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Fatalf("retry should get the replayed response, got %s", retry.Body.String())
+	}
+
+	if code := post("/habit", "u1", "k1", `{"name":"write"}`).Code; code != http.StatusBadRequest || handled != 1 {
+		t.Fatalf("key reused by another request should get 400, got %d", code)
+	}
+	if code := post("/habit", "u2", "k1", `{"name":"read"}`).Code; code != http.StatusOK || handled != 2 {
+		t.Fatalf("keys of other users should be handled separately, got %d", code)
+	}
+	post("/habit", "u1", "", `{"name":"read"}`)
+	post("/habit", "u1", "", `{"name":"read"}`)
+	if handled != 4 {
+		t.Fatalf("requests without key should be handled every time, handled %d", handled)
+	}
+	if code := post("/habit", "u1", strings.Repeat("k", maxIdempotencyKeyLength+1), `{}`).Code; code != http.StatusBadRequest {
+		t.Fatalf("too long key should get 400, got %d", code)
+	}
+
+	// the response of a server error is not stored
+	if code := post("/flaky", "u1", "k2", `{}`).Code; code != http.StatusInternalServerError || handled != 5 {
+		t.Fatalf("expect server error, got %d", code)
+	}
+	failing = false
+	if code := post("/flaky", "u1", "k2", `{}`).Code; code != http.StatusOK || handled != 6 {
+		t.Fatalf("retry of server error should be handled again, got %d", code)
+	}
+
+	// a request of the same key in progress
+	inProgress := &app.RequestContext{}
+	inProgress.Request.SetMethod(http.MethodPost)
+	inProgress.Request.SetRequestURI("/habit")
+	inProgress.Request.SetBodyString(`{}`)
+	_, err := store.Reserve(context.Background(), "idempotency:u1:k3", requestHash(inProgress), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := post("/habit", "u1", "k3", `{}`).Code; code != http.StatusConflict {
+		t.Fatalf("retry of request in progress should get 409, got %d", code)
+	}
+}
@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"fmt"
+	"github.com/cloudwego/hertz/pkg/app"
+	"net/http"
+)
+
+// Metrics serve all the expvar variables, like the mail queue counters, in the same format as expvar.Handler
+func Metrics(ctx context.Context, rc *app.RequestContext) {
+	buf := &bytes.Buffer{}
+	buf.WriteString("{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !first {
+			buf.WriteString(",\n")
+		}
+		first = false
+		fmt.Fprintf(buf, "%q: %s", kv.Key, kv.Value)
+	})
+	buf.WriteString("\n}\n")
+	rc.Data(http.StatusOK, "application/json; charset=utf-8", buf.Bytes())
+}
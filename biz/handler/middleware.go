@@ -3,17 +3,22 @@ package handler
 import (
 	"context"
 	"github.com/cloudwego/hertz/pkg/app"
-	"github.com/golang-jwt/jwt/v4"
-	"github.com/pkg/errors"
-	"github.com/swordandtea/lets-habit-server/biz/config"
+	"github.com/swordandtea/lets-habit-server/biz/controller"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
 	"github.com/swordandtea/lets-habit-server/biz/response"
+	"net"
 )
 
 const UIDKey = "uid"
+const SessionIDKey = "sid"
 const UserTokenHeader = "x-lh-auth"
+const RefreshTokenHeader = "x-lh-refresh"
+const DeviceNameHeader = "x-lh-device"
 
-// UserTokenVerify verify user token, return user auth fail error if verify fail, set uid to RequestContext if success
-func UserTokenVerify() app.HandlerFunc {
+// UserTokenVerify verify user token and the session it belongs to, return user auth fail error if verify fail,
+// set uid and session id to RequestContext if success
+func UserTokenVerify(repos *controller.Repositories) app.HandlerFunc {
+	sessionCtrl := controller.NewSessionCtrl(repos)
 	return func(ctx context.Context, rc *app.RequestContext) {
 		resp := response.NewHTTPResponse(rc)
 		userToken := string(rc.GetHeader(UserTokenHeader))
@@ -23,28 +28,61 @@ func UserTokenVerify() app.HandlerFunc {
 			return
 		}
 
-		claims := &jwt.RegisteredClaims{}
-		_, err := jwt.ParseWithClaims(userToken, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(config.GlobalConfig.JWT.Cypher), nil
-		})
-
-		if err != nil {
-			if errors.Is(err, jwt.ErrTokenExpired) {
-				resp.SetError(response.ErrorCode_UserAuthFail.New("user token expired"))
-				resp.Abort(ctx, rc)
-				return
-			}
-			resp.SetError(response.ErrorCode_UserAuthFail.Wrap(err, "verify user token fail"))
+		uid, sid, sErr := ExtractUserToken(userToken)
+		if sErr != nil {
+			resp.SetError(sErr)
 			resp.Abort(ctx, rc)
 			return
 		}
 
-		if claims.ID == "" {
-			resp.SetError(response.ErrorCode_UserAuthFail.Wrap(err, "invalid user token, no user id found"))
+		sErr = sessionCtrl.VerifySession(ctx, uid, sid)
+		if sErr != nil {
+			resp.SetError(sErr)
 			resp.Abort(ctx, rc)
 			return
 		}
 
-		rc.Set(UIDKey, claims.ID)
+		rc.Set(UIDKey, string(uid))
+		rc.Set(SessionIDKey, sid)
+	}
+}
+
+// AdminVerify verify the user token like UserTokenVerify, and then check the user is an admin,
+// return user no permission error if not
+func AdminVerify(repos *controller.Repositories) app.HandlerFunc {
+	userTokenVerify := UserTokenVerify(repos)
+	adminCtrl := controller.NewAdminCtrl(repos)
+	return func(ctx context.Context, rc *app.RequestContext) {
+		userTokenVerify(ctx, rc)
+		if rc.IsAborted() {
+			return
+		}
+
+		resp := response.NewHTTPResponse(rc)
+		isAdmin, sErr := adminCtrl.IsAdmin(ctx, dal.UID(rc.GetString(UIDKey)))
+		if sErr != nil {
+			resp.SetError(sErr)
+			resp.Abort(ctx, rc)
+			return
+		}
+		if !isAdmin {
+			resp.SetError(response.ErrorCode_UserNoPermission.New("admin only"))
+			resp.Abort(ctx, rc)
+			return
+		}
+	}
+}
+
+// LoopbackOnly reject the requests not from the loopback address, checked with the connection address
+// as the forwarded headers can be forged, for the endpoints only exposed to the local host like metrics
+func LoopbackOnly() app.HandlerFunc {
+	return func(ctx context.Context, rc *app.RequestContext) {
+		host, _, err := net.SplitHostPort(rc.RemoteAddr().String())
+		if err != nil || !net.ParseIP(host).IsLoopback() {
+			resp := response.NewHTTPResponse(rc)
+			resp.SetError(response.ErrorCode_UserNoPermission.New("only allowed from loopback address"))
+			resp.Abort(ctx, rc)
+			return
+		}
 	}
 }
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"context"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/swordandtea/lets-habit-server/biz/controller"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+)
+
+type OAuthRouter struct {
+	Ctrl              *controller.OAuthCtrl
+	SessionCtrl       *controller.SessionCtrl
+	TwoFactorCtrl     *controller.TwoFactorCtrl
+	SecurityEventCtrl *controller.SecurityEventCtrl
+}
+
+func NewOAuthRouter(repos *controller.Repositories) *OAuthRouter {
+	return &OAuthRouter{
+		Ctrl:              controller.NewOAuthCtrl(repos),
+		SessionCtrl:       controller.NewSessionCtrl(repos),
+		TwoFactorCtrl:     controller.NewTwoFactorCtrl(repos),
+		SecurityEventCtrl: controller.NewSecurityEventCtrl(repos),
+	}
+}
+
+/*********************** OAuth Router Authorize Handler ***********************/
+
+type OAuthAuthorizeRequest struct {
+	Provider string `path:"provider"`
+}
+
+func (r *OAuthAuthorizeRequest) validate() response.SError {
+	if r.Provider == "" {
+		return response.ErrorCode_InvalidParam.New("empty provider")
+	}
+	return nil
+}
+
+type OAuthAuthorizeResponse struct {
+	AuthURL string `json:"auth_url"`
+}
+
+// Authorize start an oauth login, the client should redirect the user to the returned url
+func (r *OAuthRouter) Authorize(ctx context.Context, rc *app.RequestContext) {
+	r.authorize(ctx, rc, "")
+}
+
+// Link start an oauth flow to link an external identity to the current user
+func (r *OAuthRouter) Link(ctx context.Context, rc *app.RequestContext) {
+	r.authorize(ctx, rc, dal.UID(rc.GetString(UIDKey)))
+}
+
+func (r *OAuthRouter) authorize(ctx context.Context, rc *app.RequestContext, linkUID dal.UID) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &OAuthAuthorizeRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	authURL, sErr := r.Ctrl.StartOAuth(ctx, dal.IdentityProvider(req.Provider), linkUID)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	resp.SetSuccessData(&OAuthAuthorizeResponse{AuthURL: authURL})
+}
+
+/*********************** OAuth Router Callback Handler ***********************/
+
+type OAuthCallbackRequest struct {
+	Provider string `path:"provider"`
+	Code     string `json:"code"`
+	State    string `json:"state"`
+}
+
+func (r *OAuthCallbackRequest) validate() response.SError {
+	if r.Provider == "" {
+		return response.ErrorCode_InvalidParam.New("empty provider")
+	}
+	if r.Code == "" {
+		return response.ErrorCode_InvalidParam.New("empty code")
+	}
+	if r.State == "" {
+		return response.ErrorCode_InvalidParam.New("empty state")
+	}
+	return nil
+}
+
+type OAuthCallbackResponse struct {
+	User    *dal.User `json:"user"`
+	NewUser bool      `json:"new_user"`
+	Linked  bool      `json:"linked"`
+}
+
+// Callback finish an oauth login flow, a new session is started
+func (r *OAuthRouter) Callback(ctx context.Context, rc *app.RequestContext) {
+	r.callback(ctx, rc, "")
+}
+
+// LinkCallback finish an oauth flow linking an external identity to the current user,
+// which should be the user started the flow
+func (r *OAuthRouter) LinkCallback(ctx context.Context, rc *app.RequestContext) {
+	r.callback(ctx, rc, dal.UID(rc.GetString(UIDKey)))
+}
+
+func (r *OAuthRouter) callback(ctx context.Context, rc *app.RequestContext, callerUID dal.UID) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &OAuthCallbackRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	result, sErr := r.Ctrl.FinishOAuth(ctx, dal.IdentityProvider(req.Provider), req.State, req.Code, callerUID)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	if result.NewUser {
+		recordSecurityEvent(ctx, rc, r.SecurityEventCtrl, result.User.UID, dal.SecurityEventRegister, req.Provider)
+	}
+
+	if !result.Linked {
+		challenge, sErr := completeUserLogin(ctx, rc, r.SessionCtrl, r.TwoFactorCtrl, r.SecurityEventCtrl, result.User.UID)
+		if sErr != nil {
+			resp.SetError(sErr)
+			return
+		}
+		if challenge != "" {
+			resp.SetSuccessData(&TwoFactorChallengeResponse{TwoFactorChallenge: challenge})
+			return
+		}
+	}
+	resp.SetSuccessData(&OAuthCallbackResponse{
+		User:    result.User,
+		NewUser: result.NewUser,
+		Linked:  result.Linked,
+	})
+}
+
+/*********************** OAuth Router List Identities Handler ***********************/
+
+type ListIdentitiesResponse struct {
+	Identities []*dal.UserIdentity `json:"identities"`
+}
+
+func (r *OAuthRouter) ListIdentities(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	uid := rc.GetString(UIDKey)
+	identities, sErr := r.Ctrl.ListIdentities(ctx, dal.UID(uid))
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	resp.SetSuccessData(&ListIdentitiesResponse{Identities: identities})
+}
+
+/*********************** OAuth Router Unlink Identity Handler ***********************/
+
+type UnlinkIdentityRequest struct {
+	Provider string `path:"provider"`
+}
+
+func (r *UnlinkIdentityRequest) validate() response.SError {
+	if r.Provider == "" {
+		return response.ErrorCode_InvalidParam.New("empty provider")
+	}
+	return nil
+}
+
+func (r *OAuthRouter) UnlinkIdentity(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &UnlinkIdentityRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	uid := rc.GetString(UIDKey)
+	sErr = r.Ctrl.UnlinkIdentity(ctx, dal.UID(uid), dal.IdentityProvider(req.Provider))
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+}
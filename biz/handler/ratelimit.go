@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/swordandtea/lets-habit-server/biz/ratelimit"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"strings"
+	"time"
+)
+
+// LoginIPPolicy limit the login attempts from a client ip
+var LoginIPPolicy = &ratelimit.Policy{Name: "login_ip", Limit: 30, Window: time.Minute * 10, Key: ratelimit.ByIP}
+
+// RegisterIPPolicy limit the registrations from a client ip
+var RegisterIPPolicy = &ratelimit.Policy{Name: "register_ip", Limit: 10, Window: time.Hour, Key: ratelimit.ByIP}
+
+// EmailSendIPPolicy limit the emails triggered from a client ip
+var EmailSendIPPolicy = &ratelimit.Policy{Name: "email_send_ip", Limit: 20, Window: time.Hour, Key: ratelimit.ByIP}
+
+// EmailSendTargetPolicy limit the emails sent to the same address, the address is the email field of the request body
+var EmailSendTargetPolicy = &ratelimit.Policy{Name: "email_send_target", Limit: 5, Window: time.Hour, Key: byEmailField}
+
+// byEmailField key a request by the email field of its body, case and surrounding spaces ignored,
+// so that the variants of an address share the same limit
+func byEmailField(ctx context.Context, rc *app.RequestContext) string {
+	return strings.ToLower(strings.TrimSpace(ratelimit.ByJSONField("email")(ctx, rc)))
+}
+
+// EmailSendUserPolicy limit the emails triggered by a logged-in user, must be used after UserTokenVerify
+var EmailSendUserPolicy = &ratelimit.Policy{Name: "email_send_user", Limit: 10, Window: time.Hour, Key: ratelimit.ByContextValue(UIDKey)}
+
+// passwordLockout lock an account out of password check after consecutive failures, keyed by the email
+// for login and restore and by the uid for the re-authentication of a logged-in user,
+// locked for one minute after 5 failures and doubled by each failure after, at most one hour
+var passwordLockout = &ratelimit.Lockout{
+	Name:          "password",
+	Threshold:     5,
+	BaseDelay:     time.Minute,
+	MaxDelay:      time.Hour,
+	FailureWindow: time.Hour * 24,
+}
+
+// tooManyRequestsError build the too many requests error and set the Retry-After header
+func tooManyRequestsError(rc *app.RequestContext, retryAfter time.Duration, msg string) response.SError {
+	seconds := int((retryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	rc.Response.Header.Set("Retry-After", fmt.Sprint(seconds))
+	return response.ErrorCode_TooManyRequests.New("%s, retry after %d seconds", msg, seconds)
+}
+
+// RateLimit limit the requests by the policies, a request over any of the limits is rejected with too many requests error,
+// the requests are let through if the rate limit store fails, to not take the service down with it
+func RateLimit(policies ...*ratelimit.Policy) app.HandlerFunc {
+	return func(ctx context.Context, rc *app.RequestContext) {
+		for _, p := range policies {
+			key := p.Key(ctx, rc)
+			if key == "" {
+				continue
+			}
+			result, err := ratelimit.Allow(ctx, ratelimit.DefaultStore(), "ratelimit:"+p.Name+":"+key, p.Limit, p.Window)
+			if err != nil {
+				hlog.Errorf("rate limit policy %s fail, err=%v", p.Name, err)
+				continue
+			}
+			if !result.Allowed {
+				resp := response.NewHTTPResponse(rc)
+				resp.SetError(tooManyRequestsError(rc, result.RetryAfter, "too many requests"))
+				resp.Abort(ctx, rc)
+				return
+			}
+		}
+	}
+}
+
+// checkLockout check whether an account is locked out
+func checkLockout(ctx context.Context, rc *app.RequestContext, l *ratelimit.Lockout, account string) response.SError {
+	lockTime, err := l.Check(ctx, strings.ToLower(account))
+	if err != nil {
+		hlog.Errorf("check lockout %s fail, err=%v", l.Name, err)
+		return nil
+	}
+	if lockTime > 0 {
+		return tooManyRequestsError(rc, lockTime, "too many failed attempts")
+	}
+	return nil
+}
+
+// recordLockoutResult record the authentication result of an account,
+// only wrong credentials are counted as failures, not internal errors or a forbidden account
+func recordLockoutResult(ctx context.Context, l *ratelimit.Lockout, account string, sErr response.SError) {
+	var err error
+	account = strings.ToLower(account)
+	if sErr == nil {
+		err = l.Succeed(ctx, account)
+	} else if sErr.ErrorCode() == response.ErrorCode_InvalidParam || sErr.ErrorCode() == response.ErrorCode_UserAuthFail {
+		_, err = l.Fail(ctx, account)
+	}
+	if err != nil {
+		hlog.Errorf("record lockout %s fail, err=%v", l.Name, err)
+	}
+}
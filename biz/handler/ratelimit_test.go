@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/config"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/cloudwego/hertz/pkg/route"
+	"github.com/swordandtea/lets-habit-server/biz/ratelimit"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	ratelimit.InitDefaultStore(ratelimit.NewMemoryStore())
+	policy := &ratelimit.Policy{Name: "test", Limit: 2, Window: time.Minute, Key: ratelimit.ByJSONField("email")}
+	engine := route.NewEngine(config.NewOptions(nil))
+	engine.POST("/test", RateLimit(policy), func(ctx context.Context, rc *app.RequestContext) {
+		rc.Status(http.StatusOK)
+	})
+
+	post := func(body string) *ut.ResponseRecorder {
+		return ut.PerformRequest(engine, http.MethodPost, "/test", &ut.Body{Body: strings.NewReader(body), Len: len(body)},
+			ut.Header{Key: "Content-Type", Value: "application/json"})
+	}
+	for i := 0; i < 2; i++ {
+		if code := post(`{"email":"a@test.com"}`).Code; code != http.StatusOK {
+			t.Fatalf("request %d should be allowed, got %d", i+1, code)
+		}
+	}
+	w := post(`{"email":"a@test.com"}`)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over limit should get 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("no Retry-After header")
+	}
+	if code := post(`{"email":"b@test.com"}`).Code; code != http.StatusOK {
+		t.Fatalf("request of other email should be allowed, got %d", code)
+	}
+	if code := post(`{}`).Code; code != http.StatusOK {
+		t.Fatalf("request without key should not be limited, got %d", code)
+	}
+}
+
+func TestEmailSendTargetPolicy(t *testing.T) {
+	ratelimit.InitDefaultStore(ratelimit.NewMemoryStore())
+	engine := route.NewEngine(config.NewOptions(nil))
+	engine.POST("/test", RateLimit(EmailSendTargetPolicy), func(ctx context.Context, rc *app.RequestContext) {
+		rc.Status(http.StatusOK)
+	})
+
+	post := func(body string) int {
+		return ut.PerformRequest(engine, http.MethodPost, "/test", &ut.Body{Body: strings.NewReader(body), Len: len(body)},
+			ut.Header{Key: "Content-Type", Value: "application/json"}).Code
+	}
+	// the variants of an address share the limit
+	variants := []string{"a@test.com", "A@test.com", " a@TEST.com", "a@test.com ", "A@Test.Com"}
+	for i := int64(0); i < EmailSendTargetPolicy.Limit; i++ {
+		if code := post(`{"email":"` + variants[i%int64(len(variants))] + `"}`); code != http.StatusOK {
+			t.Fatalf("request %d should be allowed, got %d", i+1, code)
+		}
+	}
+	if code := post(`{"email":"\tA@TEST.COM"}`); code != http.StatusTooManyRequests {
+		t.Fatalf("variant over limit should get 429, got %d", code)
+	}
+	if code := post(`{"email":"b@test.com"}`); code != http.StatusOK {
+		t.Fatalf("request of other email should be allowed, got %d", code)
+	}
+}
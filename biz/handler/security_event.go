@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/swordandtea/lets-habit-server/biz/controller"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+)
+
+// recordSecurityEvent record a security event of the user caused by the request, a failure is only logged
+// to not fail the request
+func recordSecurityEvent(ctx context.Context, rc *app.RequestContext, ctrl *controller.SecurityEventCtrl, uid dal.UID, event dal.SecurityEventType, detail string) {
+	sErr := ctrl.Record(ctx, uid, event, getSessionClient(rc), detail)
+	if sErr != nil {
+		hlog.Errorf("record security event %s of %s fail, err=%v", event, uid, sErr)
+	}
+}
+
+// recordLoginFailure record a login failure of the user registered with the email, the internal errors
+// are not counted as the user is not rejected
+func recordLoginFailure(ctx context.Context, rc *app.RequestContext, ctrl *controller.SecurityEventCtrl, email string, loginErr response.SError, method string) {
+	if loginErr.ErrorCode() == response.ErrroCode_InternalUnknownError {
+		return
+	}
+	sErr := ctrl.RecordByEmail(ctx, email, dal.SecurityEventLoginFailure, getSessionClient(rc), method)
+	if sErr != nil {
+		hlog.Errorf("record login failure of %s fail, err=%v", email, sErr)
+	}
+}
+
+type SecurityEventRouter struct {
+	Ctrl *controller.SecurityEventCtrl
+}
+
+func NewSecurityEventRouter(repos *controller.Repositories) *SecurityEventRouter {
+	return &SecurityEventRouter{
+		Ctrl: controller.NewSecurityEventCtrl(repos),
+	}
+}
+
+/*********************** Security Event Router List Events Handler ***********************/
+
+// defaultSecurityEventLimit and maxSecurityEventLimit the default and max count of the events listed
+const (
+	defaultSecurityEventLimit = 20
+	maxSecurityEventLimit     = 100
+)
+
+type ListSecurityEventsRequest struct {
+	Limit int `query:"limit"`
+}
+
+func (r *ListSecurityEventsRequest) validate() response.SError {
+	if r.Limit < 0 || r.Limit > maxSecurityEventLimit {
+		return response.ErrorCode_InvalidParam.New("limit must between 0 and %d", maxSecurityEventLimit)
+	}
+	if r.Limit == 0 {
+		r.Limit = defaultSecurityEventLimit
+	}
+	return nil
+}
+
+type ListSecurityEventsResponse struct {
+	Events []*dal.SecurityEvent `json:"events"`
+}
+
+// ListEvents list the recent security events of the user
+func (r *SecurityEventRouter) ListEvents(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &ListSecurityEventsRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	uid := rc.GetString(UIDKey)
+	events, sErr := r.Ctrl.ListRecentEvents(ctx, dal.UID(uid), req.Limit)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	resp.SetSuccessData(&ListSecurityEventsResponse{Events: events})
+}
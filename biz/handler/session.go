@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"context"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/swordandtea/lets-habit-server/biz/controller"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"time"
+)
+
+type SessionRouter struct {
+	Ctrl              *controller.SessionCtrl
+	SecurityEventCtrl *controller.SecurityEventCtrl
+}
+
+func NewSessionRouter(repos *controller.Repositories) *SessionRouter {
+	return &SessionRouter{
+		Ctrl:              controller.NewSessionCtrl(repos),
+		SecurityEventCtrl: controller.NewSecurityEventCtrl(repos),
+	}
+}
+
+// getSessionClient get the client info of a request
+func getSessionClient(rc *app.RequestContext) *controller.SessionClient {
+	return &controller.SessionClient{
+		DeviceName: string(rc.GetHeader(DeviceNameHeader)),
+		UserAgent:  string(rc.UserAgent()),
+		IP:         rc.ClientIP(),
+	}
+}
+
+// startUserSession create a new session for the user and set the user token and refresh token to response header
+func startUserSession(ctx context.Context, rc *app.RequestContext, ctrl *controller.SessionCtrl, uid dal.UID) response.SError {
+	session, refreshToken, sErr := ctrl.CreateSession(ctx, uid, getSessionClient(rc))
+	if sErr != nil {
+		return sErr
+	}
+	userToken, sErr := GenerateUserToken(uid, session.SID)
+	if sErr != nil {
+		return sErr
+	}
+	rc.Response.Header.Set(UserTokenHeader, userToken)
+	rc.Response.Header.Set(RefreshTokenHeader, refreshToken)
+	return nil
+}
+
+// completeUserLogin finish a login after the user passes the first factor, if the user has enabled two-factor
+// authentication, no session is started and a challenge token is returned, which should be exchanged
+// for the user token together with a two-factor code, otherwise a new session is started and empty string is returned
+func completeUserLogin(ctx context.Context, rc *app.RequestContext, ctrl *controller.SessionCtrl, tfCtrl *controller.TwoFactorCtrl,
+	eventCtrl *controller.SecurityEventCtrl, uid dal.UID) (string, response.SError) {
+	enabled, sErr := tfCtrl.IsEnabled(ctx, uid)
+	if sErr != nil {
+		return "", sErr
+	}
+	if enabled {
+		return GenerateTwoFactorChallengeToken(uid)
+	}
+	sErr = startUserSession(ctx, rc, ctrl, uid)
+	if sErr != nil {
+		return "", sErr
+	}
+	recordSecurityEvent(ctx, rc, eventCtrl, uid, dal.SecurityEventLoginSuccess, "")
+	return "", nil
+}
+
+/*********************** Session Router Refresh Token Handler ***********************/
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (r *RefreshTokenRequest) validate() response.SError {
+	if r.RefreshToken == "" {
+		return response.ErrorCode_InvalidParam.New("empty refresh token")
+	}
+	return nil
+}
+
+func (r *SessionRouter) RefreshToken(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &RefreshTokenRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	session, refreshToken, sErr := r.Ctrl.RefreshSession(ctx, req.RefreshToken, getSessionClient(rc))
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	recordSecurityEvent(ctx, rc, r.SecurityEventCtrl, session.UID, dal.SecurityEventTokenRefresh, "")
+
+	userToken, sErr := GenerateUserToken(session.UID, session.SID)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	rc.Response.Header.Set(UserTokenHeader, userToken)
+	rc.Response.Header.Set(RefreshTokenHeader, refreshToken)
+}
+
+/*********************** Session Router Logout Handler ***********************/
+
+func (r *SessionRouter) Logout(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	uid := rc.GetString(UIDKey)
+	sid := rc.GetString(SessionIDKey)
+	sErr := r.Ctrl.RevokeSession(ctx, dal.UID(uid), sid)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+}
+
+/*********************** Session Router List Sessions Handler ***********************/
+
+type SessionInfo struct {
+	SID        string    `json:"sid"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	LastUsedIP string    `json:"last_used_ip"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	CreateAt   time.Time `json:"create_at"`
+	Current    bool      `json:"current"`
+}
+
+type ListSessionsResponse struct {
+	Sessions []*SessionInfo `json:"sessions"`
+}
+
+func (r *SessionRouter) ListSessions(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	uid := rc.GetString(UIDKey)
+	sid := rc.GetString(SessionIDKey)
+	sessions, sErr := r.Ctrl.ListActiveSessions(ctx, dal.UID(uid))
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	infos := make([]*SessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, &SessionInfo{
+			SID:        s.SID,
+			DeviceName: s.DeviceName,
+			UserAgent:  s.UserAgent,
+			LastUsedIP: s.LastUsedIP,
+			LastUsedAt: s.LastUsedAt,
+			CreateAt:   s.CreateAt,
+			Current:    s.SID == sid,
+		})
+	}
+	resp.SetSuccessData(&ListSessionsResponse{Sessions: infos})
+}
+
+/*********************** Session Router Revoke Session Handler ***********************/
+
+type RevokeSessionRequest struct {
+	SID string `path:"sid"`
+}
+
+func (r *RevokeSessionRequest) validate() response.SError {
+	if r.SID == "" {
+		return response.ErrorCode_InvalidParam.New("empty session id")
+	}
+	return nil
+}
+
+func (r *SessionRouter) RevokeSession(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &RevokeSessionRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	uid := rc.GetString(UIDKey)
+	sErr = r.Ctrl.RevokeSession(ctx, dal.UID(uid), req.SID)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"context"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/swordandtea/lets-habit-server/biz/controller"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+)
+
+type TwoFactorRouter struct {
+	Ctrl              *controller.TwoFactorCtrl
+	UserCtrl          *controller.UserCtrl
+	SessionCtrl       *controller.SessionCtrl
+	SecurityEventCtrl *controller.SecurityEventCtrl
+}
+
+func NewTwoFactorRouter(repos *controller.Repositories) *TwoFactorRouter {
+	return &TwoFactorRouter{
+		Ctrl:              controller.NewTwoFactorCtrl(repos),
+		UserCtrl:          controller.NewUserCtrl(repos),
+		SessionCtrl:       controller.NewSessionCtrl(repos),
+		SecurityEventCtrl: controller.NewSecurityEventCtrl(repos),
+	}
+}
+
+// TwoFactorChallengeResponse the response of a login when the user has enabled two-factor authentication,
+// the challenge should be exchanged for the user token together with a two-factor code
+type TwoFactorChallengeResponse struct {
+	TwoFactorChallenge string `json:"two_factor_challenge"`
+}
+
+// TwoFactorCodeRequest the common request carrying a two-factor code
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func (r *TwoFactorCodeRequest) validate() response.SError {
+	if r.Code == "" {
+		return response.ErrorCode_InvalidParam.New("empty two-factor code")
+	}
+	return nil
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+/*********************** Two Factor Router Start Enrollment Handler ***********************/
+
+type StartTwoFactorEnrollmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+func (r *TwoFactorRouter) StartEnrollment(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	uid := rc.GetString(UIDKey)
+	secret, uri, sErr := r.Ctrl.StartEnrollment(ctx, dal.UID(uid))
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	resp.SetSuccessData(&StartTwoFactorEnrollmentResponse{Secret: secret, ProvisioningURI: uri})
+}
+
+/*********************** Two Factor Router Confirm Enrollment Handler ***********************/
+
+func (r *TwoFactorRouter) ConfirmEnrollment(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &TwoFactorCodeRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	uid := rc.GetString(UIDKey)
+	codes, sErr := r.Ctrl.ConfirmEnrollment(ctx, dal.UID(uid), req.Code)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	resp.SetSuccessData(&RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+/*********************** Two Factor Router Regenerate Recovery Codes Handler ***********************/
+
+func (r *TwoFactorRouter) RegenerateRecoveryCodes(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &TwoFactorCodeRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	uid := rc.GetString(UIDKey)
+	codes, sErr := r.Ctrl.RegenerateRecoveryCodes(ctx, dal.UID(uid), req.Code)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	resp.SetSuccessData(&RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+/*********************** Two Factor Router Disable Handler ***********************/
+
+func (r *TwoFactorRouter) Disable(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &TwoFactorCodeRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	uid := rc.GetString(UIDKey)
+	sErr = r.Ctrl.Disable(ctx, dal.UID(uid), req.Code)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+}
+
+/*********************** Two Factor Router Login Handler ***********************/
+
+type TwoFactorLoginRequest struct {
+	Challenge string `json:"two_factor_challenge"`
+	Code      string `json:"code"`
+}
+
+func (r *TwoFactorLoginRequest) validate() response.SError {
+	if r.Challenge == "" {
+		return response.ErrorCode_InvalidParam.New("empty two-factor challenge")
+	}
+	if r.Code == "" {
+		return response.ErrorCode_InvalidParam.New("empty two-factor code")
+	}
+	return nil
+}
+
+// Login exchange a two-factor challenge token and a TOTP code or recovery code for the user token
+func (r *TwoFactorRouter) Login(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &TwoFactorLoginRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	uid, sErr := ExtractTwoFactorChallengeToken(req.Challenge)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	sErr = r.Ctrl.Verify(ctx, uid, req.Code)
+	if sErr != nil {
+		if sErr.ErrorCode() != response.ErrroCode_InternalUnknownError {
+			recordSecurityEvent(ctx, rc, r.SecurityEventCtrl, uid, dal.SecurityEventLoginFailure, "two-factor")
+		}
+		resp.SetError(sErr)
+		return
+	}
+
+	user, sErr := r.UserCtrl.GetUserByUID(ctx, uid)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	sErr = startUserSession(ctx, rc, r.SessionCtrl, uid)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	recordSecurityEvent(ctx, rc, r.SecurityEventCtrl, uid, dal.SecurityEventLoginSuccess, "two-factor")
+	resp.SetSuccessData(&UserLoginResponse{
+		User: user,
+	})
+}
@@ -5,16 +5,26 @@ import (
 	"github.com/cloudwego/hertz/pkg/app"
 	"github.com/swordandtea/lets-habit-server/biz/controller"
 	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/mailtemplate"
 	"github.com/swordandtea/lets-habit-server/biz/response"
 	"mime/multipart"
+	"time"
 )
 
 type UserRouter struct {
-	Ctrl *controller.UserCtrl
+	Ctrl              *controller.UserCtrl
+	SessionCtrl       *controller.SessionCtrl
+	TwoFactorCtrl     *controller.TwoFactorCtrl
+	SecurityEventCtrl *controller.SecurityEventCtrl
 }
 
-func NewUserRouter() *UserRouter {
-	return &UserRouter{Ctrl: &controller.UserCtrl{}}
+func NewUserRouter(repos *controller.Repositories) *UserRouter {
+	return &UserRouter{
+		Ctrl:              controller.NewUserCtrl(repos),
+		SessionCtrl:       controller.NewSessionCtrl(repos),
+		TwoFactorCtrl:     controller.NewTwoFactorCtrl(repos),
+		SecurityEventCtrl: controller.NewSecurityEventCtrl(repos),
+	}
 }
 
 /*********************** User Router User Auth Check ***********************/
@@ -27,17 +37,11 @@ func (r *UserRouter) GetUserInfoByAuth(ctx context.Context, rc *app.RequestConte
 	resp := response.NewHTTPResponse(rc)
 	defer resp.ReturnWithLog(ctx, rc)
 	uid := rc.GetString(UIDKey)
-	user, sErr := r.Ctrl.GetUserByUID(dal.UID(uid))
+	user, sErr := r.Ctrl.GetUserByUID(ctx, dal.UID(uid))
 	if sErr != nil {
 		resp.SetError(sErr)
 		return
 	}
-	userToken, sErr := GenerateUserToken(dal.UID(uid))
-	if sErr != nil {
-		resp.SetError(sErr)
-		return
-	}
-	rc.Response.Header.Set(UserTokenHeader, userToken)
 	resp.SetSuccessData(&GetUserInfoByAuthResponse{User: user})
 }
 
@@ -46,10 +50,15 @@ func (r *UserRouter) GetUserInfoByAuth(ctx context.Context, rc *app.RequestConte
 type UserRegisterRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Language string `json:"language"` // optional, the Accept-Language header is used if empty
 }
 
-func (r *UserRegisterRequest) validate() response.SError {
-	sErr := ValidateEmail(r.Email)
+func (r *UserRegisterRequest) validate(ctx context.Context) response.SError {
+	sErr := ValidateEmail(ctx, r.Email)
+	if sErr != nil {
+		return sErr
+	}
+	sErr = ValidatePassword(r.Password)
 	if sErr != nil {
 		return sErr
 	}
@@ -71,25 +80,28 @@ func (r *UserRouter) RegisterByEmail(ctx context.Context, rc *app.RequestContext
 		return
 	}
 
-	sErr := req.validate()
+	sErr := req.validate(ctx)
 	if sErr != nil {
 		resp.SetError(sErr)
 		return
 	}
 
-	user, sErr := r.Ctrl.EmailRegister(req.Email, dal.NewRawPassword(req.Password))
+	language := mailtemplate.MatchLocale(req.Language)
+	if language == "" {
+		language = mailtemplate.MatchLocale(string(rc.GetHeader("Accept-Language")))
+	}
+	user, sErr := r.Ctrl.EmailRegister(ctx, req.Email, dal.NewRawPassword(req.Password), language)
 	if sErr != nil {
 		resp.SetError(sErr)
 		return
 	}
+	recordSecurityEvent(ctx, rc, r.SecurityEventCtrl, user.UID, dal.SecurityEventRegister, string(dal.UserRegisterTypeEmail))
 
-	userToken, sErr := GenerateUserToken(user.UID)
+	sErr = startUserSession(ctx, rc, r.SessionCtrl, user.UID)
 	if sErr != nil {
 		resp.SetError(sErr)
 		return
 	}
-
-	rc.Response.Header.Set(UserTokenHeader, userToken)
 	resp.SetSuccessData(&UserRegisterResponse{
 		User: user,
 	})
@@ -139,7 +151,7 @@ func (r *UserRouter) ResendActivateEmail(ctx context.Context, rc *app.RequestCon
 
 	uid := rc.GetString(UIDKey)
 
-	sErr := r.Ctrl.ResendActivateEmail(dal.UID(uid))
+	sErr := r.Ctrl.ResendActivateEmail(ctx, dal.UID(uid))
 	if sErr != nil {
 		resp.SetError(sErr)
 		return
@@ -180,13 +192,22 @@ func (r *UserRouter) ActivateEmail(ctx context.Context, rc *app.RequestContext)
 		return
 	}
 
-	user, userToken, sErr := r.Ctrl.EmailActivate(req.ActivateCode)
+	user, sErr := r.Ctrl.EmailActivate(ctx, req.ActivateCode)
 	if sErr != nil {
 		resp.SetError(sErr)
 		return
 	}
+	recordSecurityEvent(ctx, rc, r.SecurityEventCtrl, user.UID, dal.SecurityEventActivate, "")
 
-	rc.Response.Header.Set(UserTokenHeader, userToken)
+	challenge, sErr := completeUserLogin(ctx, rc, r.SessionCtrl, r.TwoFactorCtrl, r.SecurityEventCtrl, user.UID)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	if challenge != "" {
+		resp.SetSuccessData(&TwoFactorChallengeResponse{TwoFactorChallenge: challenge})
+		return
+	}
 	resp.SetSuccessData(&EmailActivateResponse{User: user})
 }
 
@@ -196,8 +217,8 @@ type SubmitBindEmailRequest struct {
 	Email string `json:"email"`
 }
 
-func (r *SubmitBindEmailRequest) validate() response.SError {
-	return ValidateEmail(r.Email)
+func (r *SubmitBindEmailRequest) validate(ctx context.Context) response.SError {
+	return ValidateEmail(ctx, r.Email)
 }
 
 func (r *UserRouter) SubmitBindEmail(ctx context.Context, rc *app.RequestContext) {
@@ -211,14 +232,14 @@ func (r *UserRouter) SubmitBindEmail(ctx context.Context, rc *app.RequestContext
 		return
 	}
 
-	sErr := req.validate()
+	sErr := req.validate(ctx)
 	if sErr != nil {
 		resp.SetError(sErr)
 		return
 	}
 
 	uid := rc.GetString(UIDKey)
-	sErr = r.Ctrl.StartEmailBinding(dal.UID(uid), req.Email)
+	sErr = r.Ctrl.StartEmailBinding(ctx, dal.UID(uid), rc.GetString(SessionIDKey), req.Email)
 	if sErr != nil {
 		resp.SetError(sErr)
 		return
@@ -228,7 +249,7 @@ func (r *UserRouter) SubmitBindEmail(ctx context.Context, rc *app.RequestContext
 /*********************** User Router User Confirm Bind Email Handler ***********************/
 
 type ConfirmBindEmailRequest struct {
-	BindCode string `json:"bind_code"`
+	BindCode string `json:"bind_code" query:"bind_code"`
 }
 
 func (r *ConfirmBindEmailRequest) validate() response.SError {
@@ -255,7 +276,45 @@ func (r *UserRouter) ConfirmBindEmail(ctx context.Context, rc *app.RequestContex
 		return
 	}
 
-	sErr = r.Ctrl.ConfirmBindEmail(req.BindCode)
+	bindReq, sErr := r.Ctrl.ConfirmBindEmail(ctx, req.BindCode)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	recordSecurityEvent(ctx, rc, r.SecurityEventCtrl, bindReq.UID, dal.SecurityEventEmailBind, bindReq.NewEmail)
+}
+
+/*********************** User Router User Cancel Bind Email Handler ***********************/
+
+type CancelBindEmailRequest struct {
+	CancelCode string `json:"cancel_code"`
+}
+
+func (r *CancelBindEmailRequest) validate() response.SError {
+	if r.CancelCode == "" {
+		return response.ErrorCode_InvalidParam.New("missing cancel code")
+	}
+	return nil
+}
+
+func (r *UserRouter) CancelBindEmail(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &CancelBindEmailRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	sErr = r.Ctrl.CancelBindEmail(ctx, req.CancelCode)
 	if sErr != nil {
 		resp.SetError(sErr)
 		return
@@ -300,18 +359,171 @@ func (r *UserRouter) LoginByEmail(ctx context.Context, rc *app.RequestContext) {
 		return
 	}
 
-	user, sErr := r.Ctrl.LoginByEmail(req.Email, dal.NewRawPassword(req.Password))
+	sErr = checkLockout(ctx, rc, passwordLockout, req.Email)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	user, sErr := r.Ctrl.LoginByEmail(ctx, req.Email, dal.NewRawPassword(req.Password))
+	recordLockoutResult(ctx, passwordLockout, req.Email, sErr)
 	if sErr != nil {
+		recordLoginFailure(ctx, rc, r.SecurityEventCtrl, req.Email, sErr, "password")
 		resp.SetError(sErr)
 		return
 	}
 
-	userTokenStr, sErr := GenerateUserToken(user.UID)
+	challenge, sErr := completeUserLogin(ctx, rc, r.SessionCtrl, r.TwoFactorCtrl, r.SecurityEventCtrl, user.UID)
 	if sErr != nil {
 		resp.SetError(sErr)
 		return
 	}
-	rc.Response.Header.Set(UserTokenHeader, userTokenStr)
+	if challenge != "" {
+		resp.SetSuccessData(&TwoFactorChallengeResponse{TwoFactorChallenge: challenge})
+		return
+	}
+	resp.SetSuccessData(&UserLoginResponse{
+		User: user,
+	})
+}
+
+/*********************** User Router Send Login Code Handler ***********************/
+
+type SendLoginCodeRequest struct {
+	Email string `json:"email"`
+}
+
+func (r *SendLoginCodeRequest) validate() response.SError {
+	if r.Email == "" {
+		return response.ErrorCode_InvalidParam.New("empty email")
+	}
+	return nil
+}
+
+func (r *UserRouter) SendLoginCode(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &SendLoginCodeRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	sErr = r.Ctrl.StartEmailLogin(ctx, req.Email)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+}
+
+/*********************** User Router Login By Email Code Handler ***********************/
+
+type LoginByEmailCodeRequest struct {
+	Email string `json:"email"`
+	Code  string `json:"code"`
+}
+
+func (r *LoginByEmailCodeRequest) validate() response.SError {
+	if r.Email == "" {
+		return response.ErrorCode_InvalidParam.New("empty email")
+	}
+	if r.Code == "" {
+		return response.ErrorCode_InvalidParam.New("empty login code")
+	}
+	return nil
+}
+
+func (r *UserRouter) LoginByEmailCode(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &LoginByEmailCodeRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	user, sErr := r.Ctrl.LoginByEmailCode(ctx, req.Email, req.Code)
+	if sErr != nil {
+		recordLoginFailure(ctx, rc, r.SecurityEventCtrl, req.Email, sErr, "email code")
+		resp.SetError(sErr)
+		return
+	}
+
+	challenge, sErr := completeUserLogin(ctx, rc, r.SessionCtrl, r.TwoFactorCtrl, r.SecurityEventCtrl, user.UID)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	if challenge != "" {
+		resp.SetSuccessData(&TwoFactorChallengeResponse{TwoFactorChallenge: challenge})
+		return
+	}
+	resp.SetSuccessData(&UserLoginResponse{
+		User: user,
+	})
+}
+
+/*********************** User Router Login By Email Link Handler ***********************/
+
+type LoginByEmailLinkRequest struct {
+	Token string `json:"token"`
+}
+
+func (r *LoginByEmailLinkRequest) validate() response.SError {
+	if r.Token == "" {
+		return response.ErrorCode_InvalidParam.New("empty login link token")
+	}
+	return nil
+}
+
+func (r *UserRouter) LoginByEmailLink(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &LoginByEmailLinkRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	user, sErr := r.Ctrl.LoginByEmailLink(ctx, req.Token)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	challenge, sErr := completeUserLogin(ctx, rc, r.SessionCtrl, r.TwoFactorCtrl, r.SecurityEventCtrl, user.UID)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	if challenge != "" {
+		resp.SetSuccessData(&TwoFactorChallengeResponse{TwoFactorChallenge: challenge})
+		return
+	}
 	resp.SetSuccessData(&UserLoginResponse{
 		User: user,
 	})
@@ -353,7 +565,7 @@ func (r *UserRouter) UpdateUserBaseInfo(ctx context.Context, rc *app.RequestCont
 	}
 
 	uid := rc.GetString(UIDKey)
-	user, sErr := r.Ctrl.UpdateUserBaseInfo(dal.UID(uid), &controller.UpdateUserBaseInfoFields{
+	user, sErr := r.Ctrl.UpdateUserBaseInfo(ctx, dal.UID(uid), &controller.UpdateUserBaseInfoFields{
 		Name:     req.Name,
 		Portrait: req.Portrait,
 	})
@@ -361,9 +573,55 @@ func (r *UserRouter) UpdateUserBaseInfo(ctx context.Context, rc *app.RequestCont
 		resp.SetError(sErr)
 		return
 	}
+	if req.Portrait != nil {
+		recordSecurityEvent(ctx, rc, r.SecurityEventCtrl, user.UID, dal.SecurityEventPortraitUpdate, "")
+	}
 	resp.SetSuccessData(&UpdateUserBaseInfoResponse{User: user})
 }
 
+/*********************** User Router Update User Language Handler ***********************/
+
+type UpdateLanguageRequest struct {
+	Language string `json:"language"`
+}
+
+func (r *UpdateLanguageRequest) validate() response.SError {
+	if mailtemplate.MatchLocale(r.Language) == "" {
+		return response.ErrorCode_InvalidParam.New("unsupported language %s", r.Language)
+	}
+	return nil
+}
+
+type UpdateLanguageResponse struct {
+	User *dal.User `json:"user"`
+}
+
+func (r *UserRouter) UpdateLanguage(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &UpdateLanguageRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	uid := rc.GetString(UIDKey)
+	user, sErr := r.Ctrl.UpdateLanguage(ctx, dal.UID(uid), mailtemplate.MatchLocale(req.Language))
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	resp.SetSuccessData(&UpdateLanguageResponse{User: user})
+}
+
 /*********************** User Router Update User Base Info Handler ***********************/
 
 type UserSearchRequest struct {
@@ -398,7 +656,7 @@ func (r *UserRouter) UserSearch(ctx context.Context, rc *app.RequestContext) {
 		return
 	}
 
-	users, sErr := r.Ctrl.SearchUserByNameOrUID(req.NameOrUID)
+	users, sErr := r.Ctrl.SearchUserByNameOrUID(ctx, req.NameOrUID)
 	if sErr != nil {
 		resp.SetError(sErr)
 		return
@@ -406,3 +664,380 @@ func (r *UserRouter) UserSearch(ctx context.Context, rc *app.RequestContext) {
 
 	resp.SetSuccessData(&UserSearchResponse{Users: users})
 }
+
+/*********************** User Router Delete Account Handler ***********************/
+
+// DeleteAccountRequest the user is re-authenticated by the password or the login code,
+// or by the session itself if it logged in recently when neither is given
+type DeleteAccountRequest struct {
+	Password  string `json:"password"`
+	LoginCode string `json:"login_code"`
+}
+
+// reauth build the re-authentication factors of the request
+func (r *DeleteAccountRequest) reauth(sid string) *controller.Reauth {
+	reauth := &controller.Reauth{LoginCode: r.LoginCode, SID: sid}
+	if r.Password != "" {
+		reauth.Password = dal.NewRawPassword(r.Password)
+	}
+	return reauth
+}
+
+type DeleteAccountResponse struct {
+	DeleteAt *time.Time `json:"delete_at"` // nil means the account has already been erased
+}
+
+func (r *UserRouter) DeleteAccount(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &DeleteAccountRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	uid := rc.GetString(UIDKey)
+	sErr := checkLockout(ctx, rc, passwordLockout, uid)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	deleteAt, sErr := r.Ctrl.DeleteAccount(ctx, dal.UID(uid), req.reauth(rc.GetString(SessionIDKey)))
+	if req.Password != "" || req.LoginCode != "" {
+		recordLockoutResult(ctx, passwordLockout, uid, sErr)
+	}
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	resp.SetSuccessData(&DeleteAccountResponse{DeleteAt: deleteAt})
+}
+
+/*********************** User Router Restore Account Handler ***********************/
+
+// RestoreAccountRequest the user is re-authenticated by the password or the login code sent to the email
+type RestoreAccountRequest struct {
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	LoginCode string `json:"login_code"`
+}
+
+func (r *RestoreAccountRequest) validate() response.SError {
+	if r.Email == "" {
+		return response.ErrorCode_InvalidParam.New("empty email")
+	}
+	if r.Password == "" && r.LoginCode == "" {
+		return response.ErrorCode_InvalidParam.New("empty password and login code")
+	}
+	return nil
+}
+
+// reauth build the re-authentication factors of the request
+func (r *RestoreAccountRequest) reauth() *controller.Reauth {
+	reauth := &controller.Reauth{LoginCode: r.LoginCode}
+	if r.Password != "" {
+		reauth.Password = dal.NewRawPassword(r.Password)
+	}
+	return reauth
+}
+
+type RestoreAccountResponse struct {
+	User *dal.User `json:"user"`
+}
+
+func (r *UserRouter) RestoreAccount(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &RestoreAccountRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	sErr = checkLockout(ctx, rc, passwordLockout, req.Email)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	user, sErr := r.Ctrl.RestoreAccount(ctx, req.Email, req.reauth())
+	recordLockoutResult(ctx, passwordLockout, req.Email, sErr)
+	if sErr != nil {
+		method := "password"
+		if req.Password == "" {
+			method = "email code"
+		}
+		recordLoginFailure(ctx, rc, r.SecurityEventCtrl, req.Email, sErr, method)
+		resp.SetError(sErr)
+		return
+	}
+
+	challenge, sErr := completeUserLogin(ctx, rc, r.SessionCtrl, r.TwoFactorCtrl, r.SecurityEventCtrl, user.UID)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	if challenge != "" {
+		resp.SetSuccessData(&TwoFactorChallengeResponse{TwoFactorChallenge: challenge})
+		return
+	}
+	resp.SetSuccessData(&RestoreAccountResponse{User: user})
+}
+
+/*********************** User Router Forgot Password Handler ***********************/
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+func (r *ForgotPasswordRequest) validate() response.SError {
+	if r.Email == "" {
+		return response.ErrorCode_InvalidParam.New("empty email")
+	}
+	return nil
+}
+
+func (r *UserRouter) ForgotPassword(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &ForgotPasswordRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	sErr = r.Ctrl.StartPasswordReset(ctx, req.Email)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+}
+
+/*********************** User Router Reset Password Handler ***********************/
+
+type ResetPasswordRequest struct {
+	ResetCode string `json:"reset_code"`
+	Password  string `json:"password"`
+}
+
+func (r *ResetPasswordRequest) validate() response.SError {
+	if r.ResetCode == "" {
+		return response.ErrorCode_InvalidParam.New("empty reset code")
+	}
+	return ValidatePassword(r.Password)
+}
+
+type ResetPasswordResponse struct {
+	User *dal.User `json:"user"`
+}
+
+func (r *UserRouter) ResetPassword(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &ResetPasswordRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	user, sErr := r.Ctrl.ResetPassword(ctx, req.ResetCode, dal.NewRawPassword(req.Password))
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	recordSecurityEvent(ctx, rc, r.SecurityEventCtrl, user.UID, dal.SecurityEventPasswordChange, "reset")
+
+	challenge, sErr := completeUserLogin(ctx, rc, r.SessionCtrl, r.TwoFactorCtrl, r.SecurityEventCtrl, user.UID)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	if challenge != "" {
+		resp.SetSuccessData(&TwoFactorChallengeResponse{TwoFactorChallenge: challenge})
+		return
+	}
+	resp.SetSuccessData(&ResetPasswordResponse{User: user})
+}
+
+/*********************** User Router Change Password Handler ***********************/
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+func (r *ChangePasswordRequest) validate() response.SError {
+	if r.OldPassword == "" {
+		return response.ErrorCode_InvalidParam.New("empty old password")
+	}
+	return ValidatePassword(r.NewPassword)
+}
+
+func (r *UserRouter) ChangePassword(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &ChangePasswordRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	uid := rc.GetString(UIDKey)
+	sErr = checkLockout(ctx, rc, passwordLockout, uid)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	sid := rc.GetString(SessionIDKey)
+	sErr = r.Ctrl.ChangePassword(ctx, dal.UID(uid), sid, dal.NewRawPassword(req.OldPassword), dal.NewRawPassword(req.NewPassword))
+	recordLockoutResult(ctx, passwordLockout, uid, sErr)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	recordSecurityEvent(ctx, rc, r.SecurityEventCtrl, dal.UID(uid), dal.SecurityEventPasswordChange, "change")
+}
+
+/*********************** User Router Login By Wechat Handler ***********************/
+
+type WechatLoginRequest struct {
+	Code string `json:"code"`
+}
+
+func (r *WechatLoginRequest) validate() response.SError {
+	if r.Code == "" {
+		return response.ErrorCode_InvalidParam.New("empty wechat login code")
+	}
+	return nil
+}
+
+type WechatLoginResponse struct {
+	User    *dal.User `json:"user"`
+	NewUser bool      `json:"new_user"`
+}
+
+func (r *UserRouter) LoginByWechat(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &WechatLoginRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	user, newUser, sErr := r.Ctrl.LoginByWechat(ctx, req.Code)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	if newUser {
+		recordSecurityEvent(ctx, rc, r.SecurityEventCtrl, user.UID, dal.SecurityEventRegister, string(dal.UserRegisterTypeWechat))
+	}
+
+	challenge, sErr := completeUserLogin(ctx, rc, r.SessionCtrl, r.TwoFactorCtrl, r.SecurityEventCtrl, user.UID)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	if challenge != "" {
+		resp.SetSuccessData(&TwoFactorChallengeResponse{TwoFactorChallenge: challenge})
+		return
+	}
+	resp.SetSuccessData(&WechatLoginResponse{User: user, NewUser: newUser})
+}
+
+/*********************** User Router Preview Email Handler ***********************/
+
+type PreviewEmailRequest struct {
+	Name   string `path:"name"`
+	Locale string `query:"locale"`
+}
+
+func (r *PreviewEmailRequest) validate() response.SError {
+	if r.Name == "" {
+		return response.ErrorCode_InvalidParam.New("empty template name")
+	}
+	return nil
+}
+
+type PreviewEmailResponse struct {
+	Subject string   `json:"subject"`
+	Text    string   `json:"text"`
+	HTML    string   `json:"html"`
+	Locales []string `json:"locales"` // all the locales the template has variants for
+}
+
+// PreviewEmail render an email template with sample data, only registered in local run mode
+func (r *UserRouter) PreviewEmail(ctx context.Context, rc *app.RequestContext) {
+	resp := response.NewHTTPResponse(rc)
+	defer resp.ReturnWithLog(ctx, rc)
+
+	req := &PreviewEmailRequest{}
+	err := rc.BindAndValidate(req)
+	if err != nil {
+		resp.SetError(BindAndValidateErr(err))
+		return
+	}
+
+	sErr := req.validate()
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+
+	msg, sErr := r.Ctrl.PreviewEmail(req.Name, req.Locale)
+	if sErr != nil {
+		resp.SetError(sErr)
+		return
+	}
+	resp.SetSuccessData(&PreviewEmailResponse{
+		Subject: msg.Subject,
+		Text:    msg.Text,
+		HTML:    msg.HTML,
+		Locales: mailtemplate.DefaultRenderer().Locales(req.Name),
+	})
+}
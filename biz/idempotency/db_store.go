@@ -0,0 +1,79 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/service"
+	"gorm.io/gorm"
+	"time"
+)
+
+// DBStore a Store keeps the keys in the database, the keys are shared by all server instances
+type DBStore struct {
+	db *gorm.DB
+}
+
+// NewDBStore create a DBStore on a database
+func NewDBStore(db *gorm.DB) *DBStore {
+	return &DBStore{db: db}
+}
+
+func (s *DBStore) Reserve(ctx context.Context, key string, requestHash string, lease time.Duration) (*Record, error) {
+	db := s.db.WithContext(ctx)
+	now := time.Now().UTC()
+	k := &dal.IdempotencyKey{IdempotencyKey: key, RequestHash: requestHash, ExpireAt: now.Add(lease)}
+	// the key is inserted by the first request, and the concurrent ones fail with duplicate key
+	sErr := dal.IdempotencyKeyDBHD.Add(db, k)
+	if sErr == nil {
+		return nil, nil
+	}
+	if !service.IsDuplicateKeyError(sErr.Cause()) {
+		return nil, sErr
+	}
+	replaced, sErr := dal.IdempotencyKeyDBHD.ReplaceExpired(db, k, now)
+	if sErr != nil {
+		return nil, sErr
+	}
+	if replaced {
+		return nil, nil
+	}
+	existing, sErr := dal.IdempotencyKeyDBHD.GetByKey(db, key)
+	if sErr != nil {
+		return nil, sErr
+	}
+	if existing == nil {
+		return nil, errors.New("idempotency key released concurrently")
+	}
+	return &Record{
+		RequestHash: existing.RequestHash,
+		Completed:   existing.Completed,
+		StatusCode:  existing.StatusCode,
+		Body:        existing.Body,
+	}, nil
+}
+
+func (s *DBStore) Complete(ctx context.Context, key string, statusCode int, body []byte, ttl time.Duration) error {
+	sErr := dal.IdempotencyKeyDBHD.Complete(s.db.WithContext(ctx), key, statusCode, body, time.Now().Add(ttl))
+	if sErr != nil {
+		return sErr
+	}
+	return nil
+}
+
+func (s *DBStore) Release(ctx context.Context, key string) error {
+	sErr := dal.IdempotencyKeyDBHD.DeleteByKey(s.db.WithContext(ctx), key)
+	if sErr != nil {
+		return sErr
+	}
+	return nil
+}
+
+// DeleteExpired remove the expired keys, should be called periodically to keep the table small
+func (s *DBStore) DeleteExpired(ctx context.Context) error {
+	sErr := dal.IdempotencyKeyDBHD.DeleteExpired(s.db.WithContext(ctx), time.Now())
+	if sErr != nil {
+		return sErr
+	}
+	return nil
+}
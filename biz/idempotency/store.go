@@ -0,0 +1,112 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Record a request with an idempotency key, and its response once the request completed
+type Record struct {
+	RequestHash string
+	Completed   bool
+	StatusCode  int
+	Body        []byte
+}
+
+// Store the storage of the idempotency keys, implement it with a shared storage
+// so that a retry is replayed whichever server instance it reaches
+type Store interface {
+	// Reserve reserve key for a request in progress until lease passes, return nil if reserved,
+	// or the record of the request holding the key if it is reserved or completed and not expired
+	Reserve(ctx context.Context, key string, requestHash string, lease time.Duration) (*Record, error)
+	// Complete store the response of the request holding key, kept until ttl passes
+	Complete(ctx context.Context, key string, statusCode int, body []byte, ttl time.Duration) error
+	// Release delete key, so that the next request with it is handled again
+	Release(ctx context.Context, key string) error
+}
+
+var defaultStore Store = NewMemoryStore()
+
+// InitDefaultStore set the store used by the idempotency middleware, default is a MemoryStore
+func InitDefaultStore(s Store) {
+	defaultStore = s
+}
+
+// DefaultStore get the store used by the idempotency middleware
+func DefaultStore() Store {
+	return defaultStore
+}
+
+/*********************** Memory Store ***********************/
+
+// memorySweepInterval how often the expired keys are removed from a MemoryStore
+const memorySweepInterval = time.Minute
+
+type memoryRecord struct {
+	Record
+	expireAt time.Time
+}
+
+// MemoryStore a Store keeps the keys in process memory, a retry is only replayed by the same server instance
+type MemoryStore struct {
+	mutex   sync.Mutex
+	records map[string]*memoryRecord
+	sweptAt time.Time
+	nowFunc func() time.Time
+}
+
+// NewMemoryStore create an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: make(map[string]*memoryRecord),
+		nowFunc: time.Now,
+	}
+}
+
+// sweep remove the expired keys, must be called with the mutex held
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.sweptAt) < memorySweepInterval {
+		return
+	}
+	for k, r := range s.records {
+		if !now.Before(r.expireAt) {
+			delete(s.records, k)
+		}
+	}
+	s.sweptAt = now
+}
+
+func (s *MemoryStore) Reserve(ctx context.Context, key string, requestHash string, lease time.Duration) (*Record, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.nowFunc()
+	s.sweep(now)
+	if r, ok := s.records[key]; ok && now.Before(r.expireAt) {
+		record := r.Record
+		return &record, nil
+	}
+	s.records[key] = &memoryRecord{Record: Record{RequestHash: requestHash}, expireAt: now.Add(lease)}
+	return nil, nil
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, key string, statusCode int, body []byte, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r, ok := s.records[key]
+	if !ok {
+		return nil // released already
+	}
+	r.Completed = true
+	r.StatusCode = statusCode
+	r.Body = append([]byte(nil), body...)
+	r.expireAt = s.nowFunc().Add(ttl)
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.records, key)
+	return nil
+}
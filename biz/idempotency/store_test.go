@@ -0,0 +1,111 @@
+package idempotency
+
+import (
+	"context"
+	"github.com/glebarez/sqlite"
+	"github.com/swordandtea/lets-habit-server/biz/migration"
+	"gorm.io/gorm"
+	"path"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	record, err := store.Reserve(ctx, "k", "h1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record != nil {
+		t.Fatalf("new key should be reserved, got %+v", record)
+	}
+
+	// a retry while the first request in progress
+	record, err = store.Reserve(ctx, "k", "h1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record == nil || record.Completed || record.RequestHash != "h1" {
+		t.Fatalf("expect the record in progress, got %+v", record)
+	}
+
+	err = store.Complete(ctx, "k", 200, []byte(`{"data":1}`), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = store.Reserve(ctx, "k", "h2", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record == nil || !record.Completed || record.RequestHash != "h1" || record.StatusCode != 200 || string(record.Body) != `{"data":1}` {
+		t.Fatalf("expect the completed record, got %+v", record)
+	}
+
+	// a released key is reserved again
+	err = store.Release(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = store.Reserve(ctx, "k", "h2", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record != nil {
+		t.Fatalf("released key should be reserved again, got %+v", record)
+	}
+
+	// an expired key is reserved again
+	_, err = store.Reserve(ctx, "expired", "h1", -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = store.Reserve(ctx, "expired", "h2", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record != nil {
+		t.Fatalf("expired key should be reserved again, got %+v", record)
+	}
+	record, err = store.Reserve(ctx, "expired", "h3", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record == nil || record.RequestHash != "h2" {
+		t.Fatalf("expect the record replacing the expired one, got %+v", record)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+
+	now := time.Now()
+	store := NewMemoryStore()
+	store.nowFunc = func() time.Time { return now }
+	ctx := context.Background()
+	_, _ = store.Reserve(ctx, "k", "h", time.Minute)
+	now = now.Add(time.Hour)
+	_, _ = store.Reserve(ctx, "other", "h", time.Minute)
+	if _, ok := store.records["k"]; ok {
+		t.Fatal("expired key should be swept")
+	}
+}
+
+func TestDBStore(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(path.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator, err := migration.NewDefaultMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migrator.Up(0); err != nil {
+		t.Fatal(err)
+	}
+	store := NewDBStore(db)
+	testStore(t, store)
+	err = store.DeleteExpired(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
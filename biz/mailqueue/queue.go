@@ -0,0 +1,46 @@
+package mailqueue
+
+import (
+	"expvar"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"time"
+)
+
+// metrics the counters of the mail queue, published as the "mail_queue" expvar
+var metrics = expvar.NewMap("mail_queue")
+
+// the keys of the metrics
+const (
+	MetricEnqueued = "enqueued" // mails added into the outbox
+	MetricSent     = "sent"     // mails delivered
+	MetricFailed   = "failed"   // failed delivery attempts, including the retried ones
+	MetricDead     = "dead"     // mails given up after too many failed attempts
+)
+
+// Metric get the current value of a metric counter
+func Metric(key string) int64 {
+	if v, ok := metrics.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// Enqueue add a mail into the outbox, pass the transaction that causes the mail as db
+// so that the mail is sent if and only if the transaction is committed
+func Enqueue(db *gorm.DB, toMail string, content []byte) response.SError {
+	now := time.Now().UTC()
+	sErr := dal.OutboxMailDBHD.Add(db, &dal.OutboxMail{
+		ToMail:        toMail,
+		Content:       content,
+		Status:        dal.OutboxMailStatusPending,
+		NextAttemptAt: now,
+		CreateAt:      now,
+	})
+	if sErr != nil {
+		return sErr
+	}
+	metrics.Add(MetricEnqueued, 1)
+	return nil
+}
@@ -0,0 +1,158 @@
+package mailqueue
+
+import (
+	"context"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/service"
+	"gorm.io/gorm"
+	"time"
+)
+
+// the default options of a Worker
+const (
+	DefaultPollInterval = 5 * time.Second
+	DefaultBatchSize    = 50
+	DefaultMaxAttempts  = 8
+	DefaultBaseDelay    = 30 * time.Second
+	DefaultMaxDelay     = time.Hour
+	DefaultLease        = 5 * time.Minute
+)
+
+// maxLastErrorLength the max length of the delivery error kept in the outbox
+const maxLastErrorLength = 1024
+
+// FinishedRetention how long the sent and dead mails are kept in the outbox for inspection before purged
+const FinishedRetention = time.Hour * 24 * 7
+
+// WorkerOption the options of a Worker, zero values are replaced by the defaults
+type WorkerOption struct {
+	PollInterval time.Duration // how often to look for the due mails
+	BatchSize    int           // max mails delivered in one poll
+	MaxAttempts  uint32        // a mail is dead after so many failed attempts
+	BaseDelay    time.Duration // delay before the first retry, doubled for each following one
+	MaxDelay     time.Duration // the max delay between retries
+	Lease        time.Duration // a claimed mail is retried after the lease if the worker crashes during delivery
+}
+
+// Worker deliver the mails in the outbox through a service.MailService, multiple workers can run
+// on different server instances as a mail is claimed before delivered
+type Worker struct {
+	db      *gorm.DB
+	mailer  service.MailService
+	option  WorkerOption
+	nowFunc func() time.Time
+}
+
+// NewWorker create a Worker delivering the mails in db through mailer
+func NewWorker(db *gorm.DB, mailer service.MailService, option WorkerOption) *Worker {
+	if option.PollInterval <= 0 {
+		option.PollInterval = DefaultPollInterval
+	}
+	if option.BatchSize <= 0 {
+		option.BatchSize = DefaultBatchSize
+	}
+	if option.MaxAttempts == 0 {
+		option.MaxAttempts = DefaultMaxAttempts
+	}
+	if option.BaseDelay <= 0 {
+		option.BaseDelay = DefaultBaseDelay
+	}
+	if option.MaxDelay <= 0 {
+		option.MaxDelay = DefaultMaxDelay
+	}
+	if option.Lease <= 0 {
+		option.Lease = DefaultLease
+	}
+	return &Worker{
+		db:      db,
+		mailer:  mailer,
+		option:  option,
+		nowFunc: time.Now,
+	}
+}
+
+// retryDelay get the delay before the next attempt after the attempts failed, grows exponentially
+func (w *Worker) retryDelay(attempts uint32) time.Duration {
+	delay := w.option.BaseDelay
+	for i := uint32(1); i < attempts && delay < w.option.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > w.option.MaxDelay {
+		delay = w.option.MaxDelay
+	}
+	return delay
+}
+
+// RunOnce deliver the due mails once, return how many mails are delivered
+func (w *Worker) RunOnce(ctx context.Context) (int, error) {
+	db := w.db.WithContext(ctx)
+	now := w.nowFunc().UTC()
+	mails, sErr := dal.OutboxMailDBHD.ListDue(db, now, w.option.BatchSize)
+	if sErr != nil {
+		return 0, sErr
+	}
+
+	sent := 0
+	for _, m := range mails {
+		claimed, sErr := dal.OutboxMailDBHD.Claim(db, m.ID, m.Attempts, now.Add(w.option.Lease))
+		if sErr != nil {
+			return sent, sErr
+		}
+		if !claimed { // taken by another worker
+			continue
+		}
+		attempts := m.Attempts + 1
+
+		// give up before the lease ends, or the mail may be delivered twice by another worker
+		sendCtx, cancel := context.WithDeadline(ctx, now.Add(w.option.Lease))
+		err := w.mailer.SendMail(sendCtx, []string{m.ToMail}, m.Content)
+		cancel()
+		if err == nil {
+			sErr = dal.OutboxMailDBHD.MarkSent(db, m.ID, w.nowFunc())
+			if sErr != nil {
+				return sent, sErr
+			}
+			metrics.Add(MetricSent, 1)
+			sent++
+			continue
+		}
+
+		metrics.Add(MetricFailed, 1)
+		lastError := err.Error()
+		if len(lastError) > maxLastErrorLength {
+			lastError = lastError[:maxLastErrorLength]
+		}
+		if attempts >= w.option.MaxAttempts {
+			hlog.Errorf("give up outbox mail %d after %d attempts, err=%v", m.ID, attempts, err)
+			sErr = dal.OutboxMailDBHD.MarkDead(db, m.ID, lastError)
+			if sErr != nil {
+				return sent, sErr
+			}
+			metrics.Add(MetricDead, 1)
+			continue
+		}
+		hlog.Warnf("deliver outbox mail %d fail, attempts: %d, err=%v", m.ID, attempts, err)
+		sErr = dal.OutboxMailDBHD.MarkRetry(db, m.ID, lastError, w.nowFunc().Add(w.retryDelay(attempts)))
+		if sErr != nil {
+			return sent, sErr
+		}
+	}
+	return sent, nil
+}
+
+// Run deliver the due mails every poll interval until ctx is done
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.option.PollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := w.RunOnce(ctx); err != nil {
+			hlog.Errorf("deliver outbox mails fail, err=%v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
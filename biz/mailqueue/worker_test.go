@@ -0,0 +1,200 @@
+package mailqueue
+
+import (
+	"context"
+	"errors"
+	"github.com/glebarez/sqlite"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/response"
+	"gorm.io/gorm"
+	"path"
+	"testing"
+	"time"
+)
+
+// flakyMailService a MailService fails the first failures mails
+type flakyMailService struct {
+	failures int
+	sent     []string
+}
+
+func (m *flakyMailService) Sender() string {
+	return "noreply@test.com"
+}
+
+func (m *flakyMailService) SendMail(ctx context.Context, toMail []string, content []byte) error {
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("smtp server unavailable")
+	}
+	m.sent = append(m.sent, string(content))
+	return nil
+}
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(path.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&dal.OutboxMail{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func getOutboxMail(t *testing.T, db *gorm.DB, id uint64) *dal.OutboxMail {
+	m := &dal.OutboxMail{}
+	err := db.First(m, id).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestWorkerRetry(t *testing.T) {
+	db := setupTestDB(t)
+	mailer := &flakyMailService{failures: 2}
+	w := NewWorker(db, mailer, WorkerOption{BaseDelay: time.Minute, MaxDelay: time.Hour})
+	ctx := context.Background()
+	sentBefore, failedBefore := Metric(MetricSent), Metric(MetricFailed)
+
+	sErr := Enqueue(db, "u1@test.com", []byte("hello"))
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	now := time.Now()
+	w.nowFunc = func() time.Time { return now }
+
+	// first attempt fails, retry after the base delay
+	sent, err := w.RunOnce(ctx)
+	if err != nil || sent != 0 {
+		t.Fatalf("expect no mail sent, got %d, err=%v", sent, err)
+	}
+	m := getOutboxMail(t, db, 1)
+	if m.Status != dal.OutboxMailStatusPending || m.Attempts != 1 || m.LastError == "" {
+		t.Fatalf("unexpected outbox mail after failed %+v", m)
+	}
+	if m.NextAttemptAt.Sub(now) != time.Minute {
+		t.Fatalf("expect retry after 1m, got %v", m.NextAttemptAt.Sub(now))
+	}
+	_, err = w.RunOnce(ctx)
+	if err != nil || getOutboxMail(t, db, 1).Attempts != 1 {
+		t.Fatal("should not retry before the next attempt time")
+	}
+
+	// second attempt fails, the delay doubled
+	now = now.Add(time.Minute)
+	_, err = w.RunOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = getOutboxMail(t, db, 1)
+	if m.Attempts != 2 || m.NextAttemptAt.Sub(now) != 2*time.Minute {
+		t.Fatalf("unexpected outbox mail after failed twice %+v", m)
+	}
+
+	// third attempt succeeds, the content is cleared
+	now = now.Add(2 * time.Minute)
+	sent, err = w.RunOnce(ctx)
+	if err != nil || sent != 1 {
+		t.Fatalf("expect 1 mail sent, got %d, err=%v", sent, err)
+	}
+	m = getOutboxMail(t, db, 1)
+	if m.Status != dal.OutboxMailStatusSent || m.SentAt == nil || len(m.Content) != 0 || m.LastError != "" {
+		t.Fatalf("unexpected outbox mail after sent %+v", m)
+	}
+	if len(mailer.sent) != 1 || mailer.sent[0] != "hello" {
+		t.Fatalf("unexpected sent mails %v", mailer.sent)
+	}
+	if Metric(MetricSent)-sentBefore != 1 || Metric(MetricFailed)-failedBefore != 2 {
+		t.Fatal("unexpected metrics")
+	}
+}
+
+func TestWorkerDeadLetter(t *testing.T) {
+	db := setupTestDB(t)
+	mailer := &flakyMailService{failures: 100}
+	w := NewWorker(db, mailer, WorkerOption{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: 90 * time.Second})
+	deadBefore := Metric(MetricDead)
+
+	sErr := Enqueue(db, "u1@test.com", []byte("hello"))
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	now := time.Now()
+	w.nowFunc = func() time.Time { return now }
+	for i := 0; i < 5; i++ {
+		_, err := w.RunOnce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Hour)
+	}
+	m := getOutboxMail(t, db, 1)
+	if m.Status != dal.OutboxMailStatusDead || m.Attempts != 3 || len(m.Content) != 0 || m.LastError == "" {
+		t.Fatalf("unexpected outbox mail after given up %+v", m)
+	}
+	if Metric(MetricDead)-deadBefore != 1 {
+		t.Fatal("unexpected dead metric")
+	}
+	count, sErr := dal.OutboxMailDBHD.CountByStatus(db, dal.OutboxMailStatusDead)
+	if sErr != nil || count != 1 {
+		t.Fatalf("expect 1 dead mail, got %d, err=%v", count, sErr)
+	}
+
+	if w.retryDelay(1) != time.Minute || w.retryDelay(2) != 90*time.Second || w.retryDelay(20) != 90*time.Second {
+		t.Fatal("unexpected retry delay")
+	}
+
+	// the dead mail is purged after the retention while a pending one is kept
+	sErr = Enqueue(db, "u2@test.com", []byte("hello"))
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	purged, sErr := dal.OutboxMailDBHD.PurgeFinished(db, time.Now().Add(time.Minute))
+	if sErr != nil || purged != 1 {
+		t.Fatalf("expect 1 mail purged, got %d, err=%v", purged, sErr)
+	}
+	count, sErr = dal.OutboxMailDBHD.CountByStatus(db, dal.OutboxMailStatusPending)
+	if sErr != nil || count != 1 {
+		t.Fatalf("expect the pending mail kept, got %d, err=%v", count, sErr)
+	}
+}
+
+func TestEnqueueInTransaction(t *testing.T) {
+	db := setupTestDB(t)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		sErr := Enqueue(tx, "u1@test.com", []byte("hello"))
+		if sErr != nil {
+			return sErr
+		}
+		return response.ErrorCode_InvalidParam.New("rollback")
+	})
+	if err == nil {
+		t.Fatal("expect transaction fail")
+	}
+	count, sErr := dal.OutboxMailDBHD.CountByStatus(db, dal.OutboxMailStatusPending)
+	if sErr != nil || count != 0 {
+		t.Fatalf("mail should be rolled back with the transaction, got %d, err=%v", count, sErr)
+	}
+
+	// a mail claimed by one worker is skipped by the others
+	sErr = Enqueue(db, "u1@test.com", []byte("hello"))
+	if sErr != nil {
+		t.Fatal(sErr)
+	}
+	mails, sErr := dal.OutboxMailDBHD.ListDue(db, time.Now(), 10)
+	if sErr != nil || len(mails) != 1 {
+		t.Fatalf("expect 1 due mail, got %d, err=%v", len(mails), sErr)
+	}
+	leaseUntil := time.Now().Add(DefaultLease)
+	claimed, sErr := dal.OutboxMailDBHD.Claim(db, mails[0].ID, 0, leaseUntil)
+	if sErr != nil || !claimed {
+		t.Fatal("first claim should succeed")
+	}
+	claimed, sErr = dal.OutboxMailDBHD.Claim(db, mails[0].ID, 0, leaseUntil)
+	if sErr != nil || claimed {
+		t.Fatal("second claim should fail")
+	}
+}
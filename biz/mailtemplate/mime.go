@@ -0,0 +1,75 @@
+package mailtemplate
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/swordandtea/lets-habit-server/util"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// SenderName the display name in the From header of all the emails
+const SenderName = "lets-habits"
+
+// BuildMIME build a multipart/alternative MIME message with both the plain text and html bodies,
+// the non-ASCII header values are encoded as RFC 2047 encoded-words
+func BuildMIME(from string, to string, msg *Message) ([]byte, error) {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{contentType: "text/plain; charset=utf-8", content: msg.Text},
+		{contentType: "text/html; charset=utf-8", content: msg.HTML},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qw := quotedprintable.NewWriter(pw)
+		if _, err = qw.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err = qw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	messageID, err := util.RandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+
+	data := &bytes.Buffer{}
+	headers := [][2]string{
+		{"From", (&mail.Address{Name: SenderName, Address: from}).String()},
+		{"To", to},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@%s>", messageID, domain)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": mw.Boundary()})},
+	}
+	for _, h := range headers {
+		data.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	data.WriteString("\r\n")
+	data.Write(body.Bytes())
+	return data.Bytes(), nil
+}
@@ -0,0 +1,211 @@
+package mailtemplate
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+)
+
+// the names of the email templates
+const (
+	NameActivate      = "activate"       // email activate after registering by email
+	NameBind          = "bind"           // confirm binding a new email address
+	NameBindNotice    = "bind_notice"    // notify the old email address of an email change
+	NameResetPassword = "reset_password" // reset a forgotten password
+	NameLogin         = "login"          // passwordless login code and link
+)
+
+// DefaultLocale the locale used when the user has no language preference or the template has no variant for it
+const DefaultLocale = "en"
+
+// textTmplSuffix and htmlTmplSuffix the file name suffixes of the plain text and html variants of a template,
+// the plain text one must also define a "subject" template
+const (
+	textTmplSuffix = ".txt.tmpl"
+	htmlTmplSuffix = ".html.tmpl"
+)
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// Message a rendered email
+type Message struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type localizedTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Renderer render the email templates by name and locale, the templates are organized as
+// <locale>/<name>.txt.tmpl and <locale>/<name>.html.tmpl
+type Renderer struct {
+	defaultLocale string
+	// templates template name -> locale -> template
+	templates map[string]map[string]*localizedTemplate
+	locales   map[string]bool
+}
+
+// NewRenderer load all the templates inside fsys, every template must have both the plain text and html variants
+// and have a variant for the defaultLocale
+func NewRenderer(fsys fs.FS, defaultLocale string) (*Renderer, error) {
+	r := &Renderer{
+		defaultLocale: defaultLocale,
+		templates:     make(map[string]map[string]*localizedTemplate),
+		locales:       make(map[string]bool),
+	}
+	localeDirs, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	for _, localeDir := range localeDirs {
+		if !localeDir.IsDir() {
+			continue
+		}
+		locale := localeDir.Name()
+		files, err := fs.Glob(fsys, locale+"/*"+textTmplSuffix)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			name := strings.TrimSuffix(strings.TrimPrefix(file, locale+"/"), textTmplSuffix)
+			textTmpl, err := texttemplate.ParseFS(fsys, file)
+			if err != nil {
+				return nil, fmt.Errorf("parse template %s fail, %w", file, err)
+			}
+			if textTmpl.Lookup("subject") == nil {
+				return nil, fmt.Errorf("template %s has no subject", file)
+			}
+			htmlTmpl, err := htmltemplate.ParseFS(fsys, locale+"/"+name+htmlTmplSuffix)
+			if err != nil {
+				return nil, fmt.Errorf("parse html template of %s/%s fail, %w", locale, name, err)
+			}
+			if r.templates[name] == nil {
+				r.templates[name] = make(map[string]*localizedTemplate)
+			}
+			r.templates[name][locale] = &localizedTemplate{text: textTmpl, html: htmlTmpl}
+			r.locales[locale] = true
+		}
+	}
+	for name, variants := range r.templates {
+		if variants[defaultLocale] == nil {
+			return nil, fmt.Errorf("template %s has no variant for default locale %s", name, defaultLocale)
+		}
+	}
+	return r, nil
+}
+
+// Names list the names of all the loaded templates
+func (r *Renderer) Names() []string {
+	names := make([]string, 0, len(r.templates))
+	for name := range r.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Locales list the locales the template has variants for
+func (r *Renderer) Locales(name string) []string {
+	locales := make([]string, 0, len(r.templates[name]))
+	for locale := range r.templates[name] {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Render render the template with data in the locale, fall back to the default locale if the template
+// has no variant for it
+func (r *Renderer) Render(name string, locale string, data interface{}) (*Message, error) {
+	variants, ok := r.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown email template %s", name)
+	}
+	tmpl, ok := variants[locale]
+	if !ok {
+		tmpl = variants[r.defaultLocale]
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.text.ExecuteTemplate(buf, "subject", data); err != nil {
+		return nil, err
+	}
+	msg := &Message{Subject: strings.TrimSpace(buf.String())}
+	buf.Reset()
+	if err := tmpl.text.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	msg.Text = strings.TrimLeft(buf.String(), "\n")
+	buf.Reset()
+	if err := tmpl.html.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	msg.HTML = buf.String()
+	return msg, nil
+}
+
+var defaultRenderer *Renderer
+var onceDefaultRenderer = &sync.Once{}
+
+// InitDefaultRenderer load the templates from dir, or from the embedded ones if dir is empty
+func InitDefaultRenderer(dir string, defaultLocale string) error {
+	if defaultLocale == "" {
+		defaultLocale = DefaultLocale
+	}
+	var fsys fs.FS
+	if dir != "" {
+		fsys = os.DirFS(dir)
+	} else {
+		fsys, _ = fs.Sub(embeddedTemplates, "templates")
+	}
+	r, err := NewRenderer(fsys, defaultLocale)
+	if err != nil {
+		return err
+	}
+	onceDefaultRenderer.Do(func() {}) // the embedded ones are no longer needed
+	defaultRenderer = r
+	return nil
+}
+
+// DefaultRenderer get the renderer initialized by InitDefaultRenderer, lazy load the embedded templates
+// if not initialized
+func DefaultRenderer() *Renderer {
+	onceDefaultRenderer.Do(func() {
+		fsys, _ := fs.Sub(embeddedTemplates, "templates")
+		r, err := NewRenderer(fsys, DefaultLocale)
+		if err != nil {
+			panic(err) // the embedded templates are broken, should be caught by test
+		}
+		defaultRenderer = r
+	})
+	return defaultRenderer
+}
+
+// MatchLocale find the supported locale for a language preference, like "zh-CN" or an Accept-Language header
+// value "zh-CN,zh;q=0.9,en;q=0.8", return empty string if none of the languages is supported
+func (r *Renderer) MatchLocale(language string) string {
+	for _, tag := range strings.Split(language, ",") {
+		tag = strings.TrimSpace(strings.SplitN(tag, ";", 2)[0])
+		tag = strings.ToLower(strings.SplitN(strings.ReplaceAll(tag, "_", "-"), "-", 2)[0])
+		if r.locales[tag] {
+			return tag
+		}
+	}
+	return ""
+}
+
+// MatchLocale find the supported locale for a language preference with the default renderer
+func MatchLocale(language string) string {
+	return DefaultRenderer().MatchLocale(language)
+}
@@ -0,0 +1,147 @@
+package mailtemplate
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRender(t *testing.T) {
+	r := DefaultRenderer()
+	data := map[string]interface{}{"Code": "123456", "LoginLink": "https://test/login?token=a&b", "ExpireMinutes": 10}
+
+	msg, err := r.Render(NameLogin, "zh", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg.Subject, "登录验证码") || !strings.Contains(msg.Text, "123456") {
+		t.Fatalf("unexpected zh message %+v", msg)
+	}
+	// the link is escaped in html but not in plain text
+	if !strings.Contains(msg.Text, "token=a&b") || !strings.Contains(msg.HTML, "token=a&amp;b") {
+		t.Fatalf("unexpected link escaping %+v", msg)
+	}
+
+	// fall back to the default locale
+	msg, err = r.Render(NameLogin, "fr", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg.Text, "Your login code is 123456") {
+		t.Fatalf("not fall back to default locale %+v", msg)
+	}
+
+	_, err = r.Render("not_exist", DefaultLocale, data)
+	if err == nil {
+		t.Fatal("render an unknown template should fail")
+	}
+}
+
+func TestNewRenderer(t *testing.T) {
+	fsys := fstest.MapFS{
+		"en/hello.txt.tmpl":  {Data: []byte(`{{define "subject"}}Hello{{end}}Hello {{.Name}}`)},
+		"en/hello.html.tmpl": {Data: []byte(`<p>Hello {{.Name}}</p>`)},
+		"zh/hello.txt.tmpl":  {Data: []byte(`{{define "subject"}}你好{{end}}你好 {{.Name}}`)},
+		"zh/hello.html.tmpl": {Data: []byte(`<p>你好 {{.Name}}</p>`)},
+	}
+	r, err := NewRenderer(fsys, "zh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := r.Render("hello", "de", map[string]string{"Name": "<b>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "你好" || msg.Text != "你好 <b>" || msg.HTML != "<p>你好 &lt;b&gt;</p>" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+
+	// no variant for the default locale
+	_, err = NewRenderer(fsys, "fr")
+	if err == nil {
+		t.Fatal("should fail without default locale variant")
+	}
+
+	// no subject defined
+	fsys["en/bye.txt.tmpl"] = &fstest.MapFile{Data: []byte(`Bye`)}
+	fsys["en/bye.html.tmpl"] = &fstest.MapFile{Data: []byte(`<p>Bye</p>`)}
+	_, err = NewRenderer(fsys, "en")
+	if err == nil {
+		t.Fatal("should fail without subject")
+	}
+}
+
+func TestMatchLocale(t *testing.T) {
+	for language, expect := range map[string]string{
+		"zh":                         "zh",
+		"zh-CN":                      "zh",
+		"zh_TW":                      "zh",
+		"EN-us":                      "en",
+		"fr-FR,zh-CN;q=0.9,en;q=0.8": "zh",
+		"fr":                         "",
+		"":                           "",
+	} {
+		if locale := MatchLocale(language); locale != expect {
+			t.Fatalf("match %q expect %q, got %q", language, expect, locale)
+		}
+	}
+}
+
+func TestBuildMIME(t *testing.T) {
+	content, err := BuildMIME("noreply@test.com", "u1@test.com", &Message{
+		Subject: "[lets-habits] 登录验证码",
+		Text:    "你的登录验证码是 123456\nhttps://test/login?token=abc",
+		HTML:    "<p>你的登录验证码是 <b>123456</b></p>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject := msg.Header.Get("Subject")
+	if strings.Contains(subject, "登录") {
+		t.Fatal("subject not encoded")
+	}
+	subject, err = (&mime.WordDecoder{}).DecodeHeader(subject)
+	if err != nil || subject != "[lets-habits] 登录验证码" {
+		t.Fatalf("unexpected subject %q, err=%v", subject, err)
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil || from.Name != SenderName || from.Address != "noreply@test.com" {
+		t.Fatalf("unexpected from %+v, err=%v", from, err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("unexpected content type %s, err=%v", mediaType, err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var parts []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, string(data))
+	}
+	if len(parts) != 2 {
+		t.Fatalf("expect 2 parts, got %d", len(parts))
+	}
+	if parts[0] != "你的登录验证码是 123456\r\nhttps://test/login?token=abc" || !strings.Contains(parts[1], "<b>123456</b>") {
+		t.Fatalf("unexpected parts %q", parts)
+	}
+}
@@ -0,0 +1,317 @@
+package migration
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the migrations of each database dialect are inside the directory named by the dialect, and are named as
+// <version>_<name>.up.sql and <version>_<name>.down.sql, the version is a positive integer and the migrations
+// are applied in the order of it, every dialect should have the same versions to keep the schemas in line
+var migrationFileRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+//go:embed sql
+var embeddedMigrations embed.FS
+
+// schemaVersionTable the table records the applied migrations
+const schemaVersionTable = "schema_version"
+
+// Migration a versioned schema change
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string // the statements to apply the change
+	Down    string // the statements to revert the change
+}
+
+// Load load all the migrations inside fsys, every migration must have both the up and down files
+// and the versions must not be duplicated, return the migrations sorted by version
+func Load(fsys fs.FS) ([]*Migration, error) {
+	files, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	migrations := make(map[uint]*Migration)
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		match := migrationFileRegexp.FindStringSubmatch(file.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", file.Name())
+		}
+		version, err := strconv.ParseUint(match[1], 10, 32)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("invalid migration version of %s", file.Name())
+		}
+		content, err := fs.ReadFile(fsys, file.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := migrations[uint(version)]
+		if !ok {
+			m = &Migration{Version: uint(version), Name: match[2]}
+			migrations[uint(version)] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("duplicated migration version %d", version)
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	sorted := make([]*Migration, 0, len(migrations))
+	for _, m := range migrations {
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up or down statements", m.Version, m.Name)
+		}
+		sorted = append(sorted, m)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return sorted, nil
+}
+
+// SplitStatements split the content of a migration file into statements by the semicolons
+// outside the quotes and comments
+func SplitStatements(content string) []string {
+	var statements []string
+	var quote rune
+	var lineComment bool
+	begin := 0
+	runes := []rune(content)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case lineComment:
+			if c == '\n' {
+				lineComment = false
+			}
+		case quote != 0:
+			if c == '\\' {
+				i++ // skip the escaped char
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			lineComment = true
+		case c == ';':
+			if s := strings.TrimSpace(string(runes[begin:i])); s != "" {
+				statements = append(statements, s)
+			}
+			begin = i + 1
+		}
+	}
+	if s := strings.TrimSpace(string(runes[begin:])); s != "" && !isComment(s) {
+		statements = append(statements, s)
+	}
+	return statements
+}
+
+// isComment whether all the lines of s are line comments
+func isComment(s string) bool {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
+
+// SchemaVersion a record of an applied migration
+type SchemaVersion struct {
+	Version   uint
+	Name      string
+	AppliedAt time.Time
+}
+
+// Migrator apply or revert the migrations on a database, the applied ones are recorded in the schema_version table
+type Migrator struct {
+	db         *gorm.DB
+	migrations []*Migration
+}
+
+// NewMigrator create a Migrator with the migrations inside fsys
+func NewMigrator(db *gorm.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Dialects list the database dialects which have embedded migrations
+func Dialects() []string {
+	dirs, _ := fs.ReadDir(embeddedMigrations, "sql")
+	dialects := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		dialects = append(dialects, dir.Name())
+	}
+	return dialects
+}
+
+// EmbeddedMigrations get the migrations embedded in the binary of a database dialect, like mysql, postgres or sqlite
+func EmbeddedMigrations(dialect string) (fs.FS, error) {
+	if _, err := fs.Stat(embeddedMigrations, "sql/"+dialect); err != nil {
+		return nil, fmt.Errorf("no migrations for database dialect %s", dialect)
+	}
+	return fs.Sub(embeddedMigrations, "sql/"+dialect)
+}
+
+// NewDefaultMigrator create a Migrator with the migrations embedded in the binary of the dialect of db
+func NewDefaultMigrator(db *gorm.DB) (*Migrator, error) {
+	fsys, err := EmbeddedMigrations(db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	return NewMigrator(db, fsys)
+}
+
+// Migrations list all the known migrations sorted by version
+func (m *Migrator) Migrations() []*Migration {
+	return m.migrations
+}
+
+// ensureVersionTable create the schema_version table if not exists
+func (m *Migrator) ensureVersionTable() error {
+	timeType := "datetime"
+	if m.db.Dialector.Name() == "postgres" { // postgres has no datetime type
+		timeType = "timestamptz"
+	}
+	return m.db.Exec("CREATE TABLE IF NOT EXISTS " + schemaVersionTable + " (" +
+		"version bigint NOT NULL PRIMARY KEY, " +
+		"name varchar(255) NOT NULL, " +
+		"applied_at " + timeType + " NOT NULL)").Error
+}
+
+// Applied list the applied migrations sorted by version
+func (m *Migrator) Applied() ([]*SchemaVersion, error) {
+	if err := m.ensureVersionTable(); err != nil {
+		return nil, err
+	}
+	var versions []*SchemaVersion
+	err := m.db.Table(schemaVersionTable).Order("version").Find(&versions).Error
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// Current get the version of the latest applied migration, 0 if none is applied
+func (m *Migrator) Current() (uint, error) {
+	versions, err := m.Applied()
+	if err != nil {
+		return 0, err
+	}
+	if len(versions) == 0 {
+		return 0, nil
+	}
+	return versions[len(versions)-1].Version, nil
+}
+
+// Pending list the migrations not applied yet
+func (m *Migrator) Pending() ([]*Migration, error) {
+	versions, err := m.Applied()
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[uint]bool, len(versions))
+	for _, v := range versions {
+		applied[v.Version] = true
+	}
+	var pending []*Migration
+	for _, migration := range m.migrations {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up apply the pending migrations in order until the target version, 0 to apply all, return the applied ones,
+// stop at the first failed one
+func (m *Migrator) Up(target uint) ([]*Migration, error) {
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	var applied []*Migration
+	for _, migration := range pending {
+		if target != 0 && migration.Version > target {
+			break
+		}
+		err = m.db.Transaction(func(tx *gorm.DB) error {
+			if err := execStatements(tx, migration.Up); err != nil {
+				return err
+			}
+			return tx.Table(schemaVersionTable).Create(&SchemaVersion{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now().UTC(),
+			}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("apply migration %d_%s fail, %w", migration.Version, migration.Name, err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// Down revert the latest steps applied migrations in reverse order, return the reverted ones,
+// stop at the first failed one
+func (m *Migrator) Down(steps int) ([]*Migration, error) {
+	versions, err := m.Applied()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[uint]*Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	var reverted []*Migration
+	for i := len(versions) - 1; i >= 0 && len(reverted) < steps; i-- {
+		migration, ok := known[versions[i].Version]
+		if !ok {
+			return reverted, errors.New("unknown applied migration version " + strconv.Itoa(int(versions[i].Version)))
+		}
+		err = m.db.Transaction(func(tx *gorm.DB) error {
+			if err := execStatements(tx, migration.Down); err != nil {
+				return err
+			}
+			return tx.Table(schemaVersionTable).Where("version=?", migration.Version).Delete(&SchemaVersion{}).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("revert migration %d_%s fail, %w", migration.Version, migration.Name, err)
+		}
+		reverted = append(reverted, migration)
+	}
+	return reverted, nil
+}
+
+// execStatements execute the statements of a migration one by one, as not every driver allows multiple statements
+// in one query
+func execStatements(db *gorm.DB, content string) error {
+	for _, statement := range SplitStatements(content) {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package migration
+
+import (
+	"github.com/glebarez/sqlite"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"gorm.io/gorm"
+	"path"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoadEmbedded(t *testing.T) {
+	var expected []*Migration
+	for _, dialect := range Dialects() {
+		fsys, err := EmbeddedMigrations(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		migrations, err := Load(fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, m := range migrations {
+			if m.Version != uint(i+1) {
+				t.Fatalf("%s: migration versions should be continuous, got %d at %d", dialect, m.Version, i)
+			}
+			if len(SplitStatements(m.Up)) == 0 || len(SplitStatements(m.Down)) == 0 {
+				t.Fatalf("%s: migration %d_%s has no statements", dialect, m.Version, m.Name)
+			}
+		}
+
+		// every dialect has the same migrations
+		if expected == nil {
+			expected = migrations
+			continue
+		}
+		if len(migrations) != len(expected) {
+			t.Fatalf("%s: expect %d migrations, got %d", dialect, len(expected), len(migrations))
+		}
+		for i, m := range migrations {
+			if m.Name != expected[i].Name {
+				t.Fatalf("%s: expect migration %d to be %s, got %s", dialect, m.Version, expected[i].Name, m.Name)
+			}
+		}
+	}
+	if len(expected) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+}
+
+// TestSchemaMatchModels apply the embedded migrations to sqlite and check every field of the models has its column
+func TestSchemaMatchModels(t *testing.T) {
+	db := setupTestDB(t)
+	migrator, err := NewDefaultMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migrator.Up(0); err != nil {
+		t.Fatal(err)
+	}
+
+	models := []interface{}{&dal.User{}, &dal.Habit{}, &dal.HabitGroup{}, &dal.UserHabitConfig{}, &dal.HabitLogRecord{},
+		&dal.Session{}, &dal.UserIdentity{}, &dal.OAuthState{}, &dal.LoginCode{}, &dal.UserTwoFactor{}, &dal.RecoveryCode{},
+		&dal.RateLimitCounter{}, &dal.EmailBindRequest{}, &dal.OutboxMail{}, &dal.AdminAuditLog{}, &dal.SecurityEvent{},
+		&dal.IdempotencyKey{}}
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err = stmt.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+		tables := []string{stmt.Schema.Table}
+		if stmt.Schema.Table == "habit_log_records" {
+			tables = append(tables, "unconfirmed_habit_log_records")
+		}
+		for _, table := range tables {
+			if !hasTable(db, table) {
+				t.Fatalf("table %s not created", table)
+			}
+			for _, field := range stmt.Schema.Fields {
+				if field.DBName != "" && !db.Migrator().HasColumn(table, field.DBName) {
+					t.Fatalf("column %s.%s not created", table, field.DBName)
+				}
+			}
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"no down":       {"0001_a.up.sql": {Data: []byte("select 1;")}},
+		"bad name":      {"a.up.sql": {Data: []byte("select 1;")}, "a.down.sql": {Data: []byte("select 1;")}},
+		"zero version":  {"0000_a.up.sql": {Data: []byte("select 1;")}, "0000_a.down.sql": {Data: []byte("select 1;")}},
+		"dup version":   {"0001_a.up.sql": {Data: []byte("select 1;")}, "0001_b.down.sql": {Data: []byte("select 1;")}},
+		"empty content": {"0001_a.up.sql": {Data: []byte("-- nothing")}, "0001_a.down.sql": {Data: []byte(" ")}},
+	} {
+		if _, err := Load(fsys); err == nil {
+			t.Fatalf("%s: expect load fail", name)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	content := "CREATE TABLE a (`x;y` int COMMENT 'a;b', z varchar(8) DEFAULT 'it\\'s;');\n" +
+		"-- a comment; with semicolon\n" +
+		"INSERT INTO a VALUES (1, \"c;d\");\n" +
+		"-- trailing comment"
+	expected := []string{
+		"CREATE TABLE a (`x;y` int COMMENT 'a;b', z varchar(8) DEFAULT 'it\\'s;')",
+		"-- a comment; with semicolon\nINSERT INTO a VALUES (1, \"c;d\")",
+	}
+	if statements := SplitStatements(content); !reflect.DeepEqual(statements, expected) {
+		t.Fatalf("unexpected statements %q", statements)
+	}
+}
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(path.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func hasTable(db *gorm.DB, table string) bool {
+	return db.Migrator().HasTable(table)
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	db := setupTestDB(t)
+	fsys := fstest.MapFS{
+		"0001_create_a.up.sql":   {Data: []byte("CREATE TABLE a (id int);\nCREATE TABLE b (id int);")},
+		"0001_create_a.down.sql": {Data: []byte("DROP TABLE b;\nDROP TABLE a;")},
+		"0002_add_c.up.sql":      {Data: []byte("CREATE TABLE c (id int);")},
+		"0002_add_c.down.sql":    {Data: []byte("DROP TABLE c;")},
+		"0003_broken.up.sql":     {Data: []byte("CREATE TABLE d (id int);\nCREATE TABLE a (id int);")},
+		"0003_broken.down.sql":   {Data: []byte("DROP TABLE d;")},
+	}
+	migrator, err := NewMigrator(db, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := migrator.Up(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || !hasTable(db, "a") || !hasTable(db, "b") || !hasTable(db, "c") {
+		t.Fatal("migrations until version 2 not applied")
+	}
+	current, err := migrator.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != 2 {
+		t.Fatalf("expect current version 2, got %d", current)
+	}
+
+	// a failed migration is rolled back and not recorded
+	applied, err = migrator.Up(0)
+	if err == nil || len(applied) != 0 {
+		t.Fatal("broken migration should fail")
+	}
+	if hasTable(db, "d") {
+		t.Fatal("failed migration not rolled back")
+	}
+	pending, err := migrator.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Version != 3 {
+		t.Fatal("failed migration should still be pending")
+	}
+
+	reverted, err := migrator.Down(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != 1 || reverted[0].Version != 2 || hasTable(db, "c") {
+		t.Fatal("latest migration not reverted")
+	}
+	reverted, err = migrator.Down(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != 1 || hasTable(db, "a") || hasTable(db, "b") {
+		t.Fatal("all migrations should be reverted")
+	}
+	current, err = migrator.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != 0 {
+		t.Fatalf("expect no migration applied, got %d", current)
+	}
+}
+
+func TestLogDayBackfill(t *testing.T) {
+	db := setupTestDB(t)
+	migrator, err := NewDefaultMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migrator.Up(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the day begins at 4 am, the two records of u1 are logged on the same day concurrently
+	logAt := time.Date(2022, 10, 2, 3, 30, 0, 0, time.UTC)
+	for _, r := range []map[string]interface{}{
+		{"habit_id": 1, "uid": "u1", "log_at": logAt},
+		{"habit_id": 1, "uid": "u1", "log_at": logAt.Add(time.Minute)},
+		{"habit_id": 1, "uid": "u2", "log_at": logAt.Add(time.Hour)},
+	} {
+		if err = db.Table("habit_log_records").Create(r).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = migrator.Up(0); err != nil {
+		t.Fatal(err)
+	}
+	var records []*dal.HabitLogRecord
+	if err = db.Order("id").Find(&records).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].ID != 1 || records[0].LogDay != "2022-10-01" || records[1].LogDay != "2022-10-02" {
+		t.Fatalf("unexpected records %+v", records)
+	}
+}
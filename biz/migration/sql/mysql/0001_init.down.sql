@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS `security_events`;
+DROP TABLE IF EXISTS `admin_audit_logs`;
+DROP TABLE IF EXISTS `outbox_mails`;
+DROP TABLE IF EXISTS `email_bind_requests`;
+DROP TABLE IF EXISTS `rate_limit_counters`;
+DROP TABLE IF EXISTS `recovery_codes`;
+DROP TABLE IF EXISTS `user_two_factors`;
+DROP TABLE IF EXISTS `login_codes`;
+DROP TABLE IF EXISTS `oauth_states`;
+DROP TABLE IF EXISTS `user_identities`;
+DROP TABLE IF EXISTS `sessions`;
+DROP TABLE IF EXISTS `unconfirmed_habit_log_records`;
+DROP TABLE IF EXISTS `habit_log_records`;
+DROP TABLE IF EXISTS `user_habit_configs`;
+DROP TABLE IF EXISTS `habit_groups`;
+DROP TABLE IF EXISTS `habits`;
+DROP TABLE IF EXISTS `users`;
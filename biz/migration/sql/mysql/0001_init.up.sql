@@ -0,0 +1,211 @@
+CREATE TABLE IF NOT EXISTS `users` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT 'record id',
+    `uid` varchar(32) NOT NULL COMMENT 'user id',
+    `name` varchar(32) COMMENT 'user name',
+    `email` varchar(32) COMMENT 'email',
+    `email_active` bool COMMENT 'flag indicate whether user email activated',
+    `email_bind` bool COMMENT 'flag indicate whether user email bound',
+    `password` varchar(64) COMMENT 'password',
+    `portrait` varchar(64) COMMENT 'portrait object storage key',
+    `user_register_type` varchar(16) NOT NULL COMMENT 'user register type',
+    `language` varchar(16) NOT NULL DEFAULT '' COMMENT 'locale of the emails sent to user, empty means the default one',
+    `role` varchar(16) NOT NULL DEFAULT '' COMMENT 'user role, empty for normal user or admin',
+    `disabled` bool NOT NULL DEFAULT false COMMENT 'flag indicate whether user is disabled by an admin',
+    `delete_at` datetime COMMENT 'when the account data will be erased, null if deletion not requested',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_uid` (`uid`),
+    UNIQUE KEY `uniq_name` (`name`),
+    UNIQUE KEY `uniq_email` (`email`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='all registered users table';
+
+CREATE TABLE IF NOT EXISTS `habits` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key id',
+    `name` varchar(255) NOT NULL COMMENT 'habit name',
+    `identity` varchar(255) COMMENT 'identity to form by forming this habit',
+    `owner` varchar(32) NOT NULL COMMENT 'habit owner uid',
+    `create_at` datetime NOT NULL COMMENT 'create utc time',
+    `log_days` tinyint unsigned COMMENT 'days in week need to log, bit mask',
+    PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='habit info table';
+
+CREATE TABLE IF NOT EXISTS `habit_groups` (
+   `habit_id` bigint unsigned NOT NULL COMMENT 'habit primary key id',
+   `uid` varchar(32) NOT NULL COMMENT 'user id',
+    PRIMARY KEY (`habit_id`, `uid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='user habit join relation';
+
+CREATE TABLE IF NOT EXISTS `user_habit_configs` (
+    `uid` varchar(32) NOT NULL COMMENT 'user id',
+    `habit_id` bigint unsigned NOT NULL COMMENT 'habit primary key id',
+    `current_streak` int unsigned NOT NULL COMMENT 'current consecutive record days',
+    `longest_streak` int unsigned NOT NULL COMMENT 'longest consecutive record days',
+    `streak_update_at` datetime COMMENT 'when streak info was last updated',
+    `remain_retroactive_chance` tinyint unsigned NOT NULL COMMENT 'remain retroactive change',
+    `heatmap_color` varchar(8) NOT NULL COMMENT 'heatmap hex rgb color',
+    PRIMARY KEY (`habit_id`, `uid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='user habit config info';
+
+CREATE TABLE IF NOT EXISTS `habit_log_records` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key id',
+    `habit_id` bigint unsigned NOT NULL COMMENT 'habit primary key id',
+    `uid` varchar(32) NOT NULL COMMENT 'user id',
+    `log_at` datetime NOT NULL COMMENT 'log time',
+    PRIMARY KEY (`id`),
+    index idx_habit_id(`habit_id`),
+    index idx_uid(`uid`),
+    index idx_log_time(`log_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='user habit log record';
+
+CREATE TABLE IF NOT EXISTS `unconfirmed_habit_log_records` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key id',
+    `habit_id` bigint unsigned NOT NULL COMMENT 'habit primary key id',
+    `uid` varchar(32) NOT NULL COMMENT 'user id',
+    `log_at` datetime NOT NULL COMMENT 'log time',
+    PRIMARY KEY (`id`),
+    index idx_habit_id(`habit_id`),
+    index idx_uid(`uid`),
+    index idx_log_time(`log_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='user habit temporary log record';
+
+CREATE TABLE IF NOT EXISTS `sessions` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key id',
+    `sid` varchar(32) NOT NULL COMMENT 'session id',
+    `uid` varchar(32) NOT NULL COMMENT 'user id',
+    `refresh_hash` char(64) NOT NULL COMMENT 'sha256 hash of current refresh token',
+    `device_name` varchar(64) NOT NULL COMMENT 'client device name',
+    `user_agent` varchar(255) NOT NULL COMMENT 'client user agent when session created',
+    `last_used_ip` varchar(64) NOT NULL COMMENT 'client ip when session last refreshed',
+    `last_used_at` datetime NOT NULL COMMENT 'when session last refreshed',
+    `create_at` datetime NOT NULL COMMENT 'create utc time',
+    `expire_at` datetime NOT NULL COMMENT 'expire utc time',
+    `revoke_at` datetime COMMENT 'revoke utc time, null if not revoked',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_sid` (`sid`),
+    index idx_uid(`uid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='user login sessions';
+
+CREATE TABLE IF NOT EXISTS `user_identities` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key id',
+    `uid` varchar(32) NOT NULL COMMENT 'user id',
+    `provider` varchar(32) NOT NULL COMMENT 'identity provider',
+    `subject` varchar(255) NOT NULL COMMENT 'user unique id inside the provider',
+    `create_at` datetime NOT NULL COMMENT 'create utc time',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_provider_subject` (`provider`, `subject`),
+    index idx_uid(`uid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='external identities linked to users';
+
+CREATE TABLE IF NOT EXISTS `oauth_states` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key id',
+    `state_hash` char(64) NOT NULL COMMENT 'sha256 hash of the state param',
+    `provider` varchar(32) NOT NULL COMMENT 'identity provider',
+    `code_verifier` varchar(128) NOT NULL COMMENT 'pkce code verifier',
+    `nonce` varchar(64) NOT NULL COMMENT 'id token nonce',
+    `link_uid` varchar(32) NOT NULL COMMENT 'user to link the identity to, empty for login',
+    `create_at` datetime NOT NULL COMMENT 'create utc time',
+    `expire_at` datetime NOT NULL COMMENT 'expire utc time',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_state_hash` (`state_hash`),
+    index idx_expire_at(`expire_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='pending oauth authorizations';
+
+CREATE TABLE IF NOT EXISTS `login_codes` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key id',
+    `uid` varchar(32) NOT NULL COMMENT 'user id',
+    `code_hash` char(64) NOT NULL COMMENT 'keyed hash of the one-time code',
+    `link_hash` char(64) NOT NULL COMMENT 'sha256 hash of the magic link token',
+    `attempts` int unsigned NOT NULL DEFAULT 0 COMMENT 'failed attempts to verify the one-time code',
+    `create_at` datetime NOT NULL COMMENT 'create utc time',
+    `expire_at` datetime NOT NULL COMMENT 'expire utc time',
+    `consume_at` datetime COMMENT 'used utc time, null if not used',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_link_hash` (`link_hash`),
+    index idx_uid(`uid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='passwordless login codes';
+
+CREATE TABLE IF NOT EXISTS `user_two_factors` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key id',
+    `uid` varchar(32) NOT NULL COMMENT 'user id',
+    `secret` varchar(64) NOT NULL COMMENT 'base32 encoded totp secret',
+    `confirm_at` datetime COMMENT 'enrollment confirm utc time, null if still pending',
+    `last_used_step` bigint NOT NULL DEFAULT 0 COMMENT 'time step of the last accepted code',
+    `failed_attempts` int unsigned NOT NULL DEFAULT 0 COMMENT 'consecutive failed verifications',
+    `last_fail_at` datetime COMMENT 'last failed verification utc time',
+    `create_at` datetime NOT NULL COMMENT 'create utc time',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_uid` (`uid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='user totp two-factor authentication';
+
+CREATE TABLE IF NOT EXISTS `recovery_codes` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key id',
+    `uid` varchar(32) NOT NULL COMMENT 'user id',
+    `code_hash` char(64) NOT NULL COMMENT 'sha256 hash of the recovery code',
+    `use_at` datetime COMMENT 'used utc time, null if not used',
+    `create_at` datetime NOT NULL COMMENT 'create utc time',
+    PRIMARY KEY (`id`),
+    index idx_uid(`uid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='two-factor authentication recovery codes';
+
+CREATE TABLE IF NOT EXISTS `rate_limit_counters` (
+    `limit_key` varchar(191) NOT NULL COMMENT 'rate limit key',
+    `count` bigint NOT NULL COMMENT 'count inside current window',
+    `expire_at` datetime NOT NULL COMMENT 'current window end utc time',
+    PRIMARY KEY (`limit_key`),
+    index idx_expire_at(`expire_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='shared rate limit counters';
+
+CREATE TABLE IF NOT EXISTS `email_bind_requests` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key id',
+    `uid` varchar(32) NOT NULL COMMENT 'user id',
+    `new_email` varchar(32) NOT NULL COMMENT 'the email to bind',
+    `token_hash` char(64) NOT NULL COMMENT 'sha256 hash of the confirm token sent to the new email',
+    `cancel_hash` varchar(64) NOT NULL COMMENT 'sha256 hash of the cancel token sent to the old email, empty if no old email',
+    `create_at` datetime NOT NULL COMMENT 'create utc time',
+    `expire_at` datetime NOT NULL COMMENT 'expire utc time',
+    `confirm_at` datetime COMMENT 'confirm utc time, null if not confirmed',
+    `cancel_at` datetime COMMENT 'cancel utc time, null if not canceled',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_token_hash` (`token_hash`),
+    index idx_cancel_hash(`cancel_hash`),
+    index idx_uid(`uid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='pending email changes';
+
+CREATE TABLE IF NOT EXISTS `outbox_mails` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key id',
+    `to_mail` varchar(255) NOT NULL COMMENT 'recipient email address',
+    `content` mediumblob NOT NULL COMMENT 'the whole MIME message, cleared once sent',
+    `status` varchar(16) NOT NULL COMMENT 'pending, sent or dead',
+    `attempts` int unsigned NOT NULL DEFAULT 0 COMMENT 'delivery attempts made',
+    `last_error` varchar(1024) NOT NULL DEFAULT '' COMMENT 'error of the last failed attempt',
+    `next_attempt_at` datetime NOT NULL COMMENT 'next delivery attempt utc time',
+    `create_at` datetime NOT NULL COMMENT 'create utc time',
+    `sent_at` datetime COMMENT 'delivered utc time, null if not sent',
+    PRIMARY KEY (`id`),
+    index idx_status_next_attempt_at(`status`, `next_attempt_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='outbound mail queue';
+
+CREATE TABLE IF NOT EXISTS `admin_audit_logs` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key id',
+    `admin_uid` varchar(32) NOT NULL COMMENT 'uid of the admin who took the action',
+    `action` varchar(32) NOT NULL COMMENT 'admin action',
+    `target_uid` varchar(32) NOT NULL DEFAULT '' COMMENT 'uid of the user acted on, empty if none',
+    `target_habit_id` bigint unsigned NOT NULL DEFAULT 0 COMMENT 'id of the habit acted on, 0 if none',
+    `detail` varchar(1024) NOT NULL DEFAULT '' COMMENT 'action detail, like the search text or the reason',
+    `ip` varchar(64) NOT NULL DEFAULT '' COMMENT 'client ip of the admin',
+    `create_at` datetime NOT NULL COMMENT 'create utc time',
+    PRIMARY KEY (`id`),
+    index idx_admin_uid(`admin_uid`),
+    index idx_target_uid(`target_uid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='admin action audit logs';
+
+CREATE TABLE IF NOT EXISTS `security_events` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT COMMENT 'primary key id',
+    `uid` varchar(32) NOT NULL COMMENT 'uid of the user the event belongs to',
+    `event` varchar(32) NOT NULL COMMENT 'event type',
+    `ip` varchar(64) NOT NULL DEFAULT '' COMMENT 'client ip of the request causing the event',
+    `user_agent` varchar(255) NOT NULL DEFAULT '' COMMENT 'client user agent of the request causing the event',
+    `detail` varchar(255) NOT NULL DEFAULT '' COMMENT 'event detail',
+    `create_at` datetime NOT NULL COMMENT 'create utc time',
+    PRIMARY KEY (`id`),
+    index idx_uid(`uid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='append-only security-sensitive account events';
@@ -0,0 +1,2 @@
+ALTER TABLE `habit_log_records` DROP INDEX `uniq_habit_id_uid_log_day`, DROP COLUMN `log_day`;
+ALTER TABLE `unconfirmed_habit_log_records` DROP INDEX `uniq_habit_id_uid_log_day`, DROP COLUMN `log_day`;
@@ -0,0 +1,15 @@
+-- the day a habit log counts for, a user logs a habit at most once a day, the day begins at 4 am
+-- in the zone of the client, the existing records are counted in utc as the zone is unknown
+ALTER TABLE `habit_log_records` ADD COLUMN `log_day` char(10) NOT NULL DEFAULT '' COMMENT 'the day logged for, yyyy-mm-dd';
+ALTER TABLE `unconfirmed_habit_log_records` ADD COLUMN `log_day` char(10) NOT NULL DEFAULT '' COMMENT 'the day logged for, yyyy-mm-dd';
+UPDATE `habit_log_records` SET `log_day` = DATE_FORMAT(`log_at` - INTERVAL 4 HOUR, '%Y-%m-%d');
+UPDATE `unconfirmed_habit_log_records` SET `log_day` = DATE_FORMAT(`log_at` - INTERVAL 4 HOUR, '%Y-%m-%d');
+
+-- keep the first record of the duplicated ones logged concurrently
+DELETE r1 FROM `habit_log_records` r1 JOIN `habit_log_records` r2
+    ON r1.`habit_id` = r2.`habit_id` AND r1.`uid` = r2.`uid` AND r1.`log_day` = r2.`log_day` AND r1.`id` > r2.`id`;
+DELETE r1 FROM `unconfirmed_habit_log_records` r1 JOIN `unconfirmed_habit_log_records` r2
+    ON r1.`habit_id` = r2.`habit_id` AND r1.`uid` = r2.`uid` AND r1.`log_day` = r2.`log_day` AND r1.`id` > r2.`id`;
+
+ALTER TABLE `habit_log_records` ADD UNIQUE KEY `uniq_habit_id_uid_log_day` (`habit_id`, `uid`, `log_day`);
+ALTER TABLE `unconfirmed_habit_log_records` ADD UNIQUE KEY `uniq_habit_id_uid_log_day` (`habit_id`, `uid`, `log_day`);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `idempotency_keys` (
+    `idempotency_key` varchar(191) NOT NULL COMMENT 'the idempotency key scoped by the user',
+    `request_hash` char(64) NOT NULL COMMENT 'sha256 of the method, path and body of the request',
+    `completed` bool NOT NULL DEFAULT false COMMENT 'whether the response is stored, false while the request is in progress',
+    `status_code` int NOT NULL DEFAULT 0 COMMENT 'http status of the stored response',
+    `body` mediumblob COMMENT 'the serialized response body, null while the request is in progress',
+    `expire_at` datetime NOT NULL COMMENT 'expire utc time',
+    PRIMARY KEY (`idempotency_key`),
+    index idx_expire_at(`expire_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='responses of the requests with idempotency keys';
@@ -0,0 +1,2 @@
+ALTER TABLE `habit_log_records` DROP INDEX `uniq_uid_client_id`, DROP INDEX `idx_uid_update_at`, DROP COLUMN `client_id`, DROP COLUMN `update_at`;
+ALTER TABLE `unconfirmed_habit_log_records` DROP INDEX `uniq_uid_client_id`, DROP INDEX `idx_uid_update_at`, DROP COLUMN `client_id`, DROP COLUMN `update_at`;
@@ -0,0 +1,13 @@
+-- the check-ins synced by the offline clients are identified by the ids the clients generate,
+-- and the clients pull the records changed after their last sync by the update time
+ALTER TABLE `habit_log_records`
+    ADD COLUMN `client_id` varchar(64) DEFAULT NULL COMMENT 'the id generated by the client of a synced check-in',
+    ADD COLUMN `update_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00' COMMENT 'update utc time';
+ALTER TABLE `unconfirmed_habit_log_records`
+    ADD COLUMN `client_id` varchar(64) DEFAULT NULL COMMENT 'the id generated by the client of a synced check-in',
+    ADD COLUMN `update_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00' COMMENT 'update utc time';
+UPDATE `habit_log_records` SET `update_at` = `log_at`;
+UPDATE `unconfirmed_habit_log_records` SET `update_at` = `log_at`;
+
+ALTER TABLE `habit_log_records` ADD UNIQUE KEY `uniq_uid_client_id` (`uid`, `client_id`), ADD INDEX `idx_uid_update_at` (`uid`, `update_at`);
+ALTER TABLE `unconfirmed_habit_log_records` ADD UNIQUE KEY `uniq_uid_client_id` (`uid`, `client_id`), ADD INDEX `idx_uid_update_at` (`uid`, `update_at`);
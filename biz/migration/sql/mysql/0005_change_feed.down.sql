@@ -0,0 +1,12 @@
+-- the tombstones are gone with the delete time, remove them first
+DELETE FROM `habits` WHERE `delete_at` IS NOT NULL;
+DELETE FROM `habit_groups` WHERE `delete_at` IS NOT NULL;
+DELETE FROM `user_habit_configs` WHERE `delete_at` IS NOT NULL;
+DELETE FROM `habit_log_records` WHERE `delete_at` IS NOT NULL;
+DELETE FROM `unconfirmed_habit_log_records` WHERE `delete_at` IS NOT NULL;
+
+ALTER TABLE `habits` DROP INDEX `idx_delete_at`, DROP COLUMN `update_at`, DROP COLUMN `delete_at`;
+ALTER TABLE `habit_groups` DROP INDEX `idx_uid_update_at`, DROP INDEX `idx_delete_at`, DROP COLUMN `update_at`, DROP COLUMN `delete_at`;
+ALTER TABLE `user_habit_configs` DROP INDEX `idx_uid_update_at`, DROP INDEX `idx_delete_at`, DROP COLUMN `update_at`, DROP COLUMN `delete_at`;
+ALTER TABLE `habit_log_records` DROP INDEX `idx_delete_at`, DROP COLUMN `delete_at`;
+ALTER TABLE `unconfirmed_habit_log_records` DROP INDEX `idx_delete_at`, DROP COLUMN `delete_at`;
@@ -0,0 +1,25 @@
+-- the clients pull the habits, groups, configs and log records changed after their last pull by the update time,
+-- the deleted rows are kept as tombstones with the delete time for a while, so that the clients learn the deletion
+ALTER TABLE `habits`
+    ADD COLUMN `update_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00' COMMENT 'update utc time',
+    ADD COLUMN `delete_at` datetime DEFAULT NULL COMMENT 'delete utc time, null if not deleted';
+ALTER TABLE `habit_groups`
+    ADD COLUMN `update_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00' COMMENT 'update utc time',
+    ADD COLUMN `delete_at` datetime DEFAULT NULL COMMENT 'delete utc time, null if not deleted';
+ALTER TABLE `user_habit_configs`
+    ADD COLUMN `update_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00' COMMENT 'update utc time',
+    ADD COLUMN `delete_at` datetime DEFAULT NULL COMMENT 'delete utc time, null if not deleted';
+ALTER TABLE `habit_log_records`
+    ADD COLUMN `delete_at` datetime DEFAULT NULL COMMENT 'delete utc time, null if not deleted';
+ALTER TABLE `unconfirmed_habit_log_records`
+    ADD COLUMN `delete_at` datetime DEFAULT NULL COMMENT 'delete utc time, null if not deleted';
+-- the existing groups and configs are taken as changed at the migration
+UPDATE `habits` SET `update_at` = `create_at`;
+UPDATE `habit_groups` SET `update_at` = UTC_TIMESTAMP();
+UPDATE `user_habit_configs` SET `update_at` = UTC_TIMESTAMP();
+
+ALTER TABLE `habits` ADD INDEX `idx_delete_at` (`delete_at`);
+ALTER TABLE `habit_groups` ADD INDEX `idx_uid_update_at` (`uid`, `update_at`), ADD INDEX `idx_delete_at` (`delete_at`);
+ALTER TABLE `user_habit_configs` ADD INDEX `idx_uid_update_at` (`uid`, `update_at`), ADD INDEX `idx_delete_at` (`delete_at`);
+ALTER TABLE `habit_log_records` ADD INDEX `idx_delete_at` (`delete_at`);
+ALTER TABLE `unconfirmed_habit_log_records` ADD INDEX `idx_delete_at` (`delete_at`);
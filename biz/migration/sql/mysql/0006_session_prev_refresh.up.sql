@@ -0,0 +1,3 @@
+-- the refresh token rotated last time, presenting it again means the token has leaked and revokes the session,
+-- while a refresh token matching neither the current nor the previous one is just rejected
+ALTER TABLE `sessions` ADD COLUMN `prev_refresh_hash` char(64) NOT NULL DEFAULT '' COMMENT 'sha256 hash of the refresh token rotated last time';
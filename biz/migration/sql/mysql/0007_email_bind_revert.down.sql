@@ -0,0 +1,2 @@
+ALTER TABLE `email_bind_requests` DROP COLUMN `old_email`;
+ALTER TABLE `email_bind_requests` DROP COLUMN `sid`;
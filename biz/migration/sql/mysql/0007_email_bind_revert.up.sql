@@ -0,0 +1,4 @@
+-- a confirmed email change can be reverted from the old email for a while, which restores the old email,
+-- and the sessions other than the one starting the change are revoked on confirm
+ALTER TABLE `email_bind_requests` ADD COLUMN `sid` varchar(32) NOT NULL DEFAULT '' COMMENT 'id of the session starting the change';
+ALTER TABLE `email_bind_requests` ADD COLUMN `old_email` varchar(32) COMMENT 'the email before the change, null if no old email';
@@ -0,0 +1,345 @@
+CREATE TABLE IF NOT EXISTS users (
+    id bigserial NOT NULL,
+    uid varchar(32) NOT NULL,
+    name varchar(32),
+    email varchar(32),
+    email_active boolean,
+    email_bind boolean,
+    password varchar(64),
+    portrait varchar(64),
+    user_register_type varchar(16) NOT NULL,
+    language varchar(16) NOT NULL DEFAULT '',
+    role varchar(16) NOT NULL DEFAULT '',
+    disabled boolean NOT NULL DEFAULT false,
+    delete_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_users_uid ON users (uid);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_users_name ON users (name);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_users_email ON users (email);
+COMMENT ON TABLE users IS 'all registered users table';
+COMMENT ON COLUMN users.id IS 'record id';
+COMMENT ON COLUMN users.uid IS 'user id';
+COMMENT ON COLUMN users.name IS 'user name';
+COMMENT ON COLUMN users.email IS 'email';
+COMMENT ON COLUMN users.email_active IS 'flag indicate whether user email activated';
+COMMENT ON COLUMN users.email_bind IS 'flag indicate whether user email bound';
+COMMENT ON COLUMN users.password IS 'password';
+COMMENT ON COLUMN users.portrait IS 'portrait object storage key';
+COMMENT ON COLUMN users.user_register_type IS 'user register type';
+COMMENT ON COLUMN users.language IS 'locale of the emails sent to user, empty means the default one';
+COMMENT ON COLUMN users.role IS 'user role, empty for normal user or admin';
+COMMENT ON COLUMN users.disabled IS 'flag indicate whether user is disabled by an admin';
+COMMENT ON COLUMN users.delete_at IS 'when the account data will be erased, null if deletion not requested';
+
+CREATE TABLE IF NOT EXISTS habits (
+    id bigserial NOT NULL,
+    name varchar(255) NOT NULL,
+    identity varchar(255),
+    owner varchar(32) NOT NULL,
+    create_at timestamptz NOT NULL,
+    log_days smallint,
+    PRIMARY KEY (id)
+);
+COMMENT ON TABLE habits IS 'habit info table';
+COMMENT ON COLUMN habits.id IS 'primary key id';
+COMMENT ON COLUMN habits.name IS 'habit name';
+COMMENT ON COLUMN habits.identity IS 'identity to form by forming this habit';
+COMMENT ON COLUMN habits.owner IS 'habit owner uid';
+COMMENT ON COLUMN habits.create_at IS 'create utc time';
+COMMENT ON COLUMN habits.log_days IS 'days in week need to log, bit mask';
+
+CREATE TABLE IF NOT EXISTS habit_groups (
+    habit_id bigint NOT NULL,
+    uid varchar(32) NOT NULL,
+    PRIMARY KEY (habit_id, uid)
+);
+COMMENT ON TABLE habit_groups IS 'user habit join relation';
+COMMENT ON COLUMN habit_groups.habit_id IS 'habit primary key id';
+COMMENT ON COLUMN habit_groups.uid IS 'user id';
+
+CREATE TABLE IF NOT EXISTS user_habit_configs (
+    uid varchar(32) NOT NULL,
+    habit_id bigint NOT NULL,
+    current_streak integer NOT NULL,
+    longest_streak integer NOT NULL,
+    streak_update_at timestamptz,
+    remain_retroactive_chance smallint NOT NULL,
+    heatmap_color varchar(8) NOT NULL,
+    PRIMARY KEY (habit_id, uid)
+);
+COMMENT ON TABLE user_habit_configs IS 'user habit config info';
+COMMENT ON COLUMN user_habit_configs.uid IS 'user id';
+COMMENT ON COLUMN user_habit_configs.habit_id IS 'habit primary key id';
+COMMENT ON COLUMN user_habit_configs.current_streak IS 'current consecutive record days';
+COMMENT ON COLUMN user_habit_configs.longest_streak IS 'longest consecutive record days';
+COMMENT ON COLUMN user_habit_configs.streak_update_at IS 'when streak info was last updated';
+COMMENT ON COLUMN user_habit_configs.remain_retroactive_chance IS 'remain retroactive change';
+COMMENT ON COLUMN user_habit_configs.heatmap_color IS 'heatmap hex rgb color';
+
+CREATE TABLE IF NOT EXISTS habit_log_records (
+    id bigserial NOT NULL,
+    habit_id bigint NOT NULL,
+    uid varchar(32) NOT NULL,
+    log_at timestamptz NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_habit_log_records_habit_id ON habit_log_records (habit_id);
+CREATE INDEX IF NOT EXISTS idx_habit_log_records_uid ON habit_log_records (uid);
+CREATE INDEX IF NOT EXISTS idx_habit_log_records_log_time ON habit_log_records (log_at);
+COMMENT ON TABLE habit_log_records IS 'user habit log record';
+COMMENT ON COLUMN habit_log_records.id IS 'primary key id';
+COMMENT ON COLUMN habit_log_records.habit_id IS 'habit primary key id';
+COMMENT ON COLUMN habit_log_records.uid IS 'user id';
+COMMENT ON COLUMN habit_log_records.log_at IS 'log time';
+
+CREATE TABLE IF NOT EXISTS unconfirmed_habit_log_records (
+    id bigserial NOT NULL,
+    habit_id bigint NOT NULL,
+    uid varchar(32) NOT NULL,
+    log_at timestamptz NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_unconfirmed_habit_log_records_habit_id ON unconfirmed_habit_log_records (habit_id);
+CREATE INDEX IF NOT EXISTS idx_unconfirmed_habit_log_records_uid ON unconfirmed_habit_log_records (uid);
+CREATE INDEX IF NOT EXISTS idx_unconfirmed_habit_log_records_log_time ON unconfirmed_habit_log_records (log_at);
+COMMENT ON TABLE unconfirmed_habit_log_records IS 'user habit temporary log record';
+COMMENT ON COLUMN unconfirmed_habit_log_records.id IS 'primary key id';
+COMMENT ON COLUMN unconfirmed_habit_log_records.habit_id IS 'habit primary key id';
+COMMENT ON COLUMN unconfirmed_habit_log_records.uid IS 'user id';
+COMMENT ON COLUMN unconfirmed_habit_log_records.log_at IS 'log time';
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id bigserial NOT NULL,
+    sid varchar(32) NOT NULL,
+    uid varchar(32) NOT NULL,
+    refresh_hash char(64) NOT NULL,
+    device_name varchar(64) NOT NULL,
+    user_agent varchar(255) NOT NULL,
+    last_used_ip varchar(64) NOT NULL,
+    last_used_at timestamptz NOT NULL,
+    create_at timestamptz NOT NULL,
+    expire_at timestamptz NOT NULL,
+    revoke_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_sessions_sid ON sessions (sid);
+CREATE INDEX IF NOT EXISTS idx_sessions_uid ON sessions (uid);
+COMMENT ON TABLE sessions IS 'user login sessions';
+COMMENT ON COLUMN sessions.id IS 'primary key id';
+COMMENT ON COLUMN sessions.sid IS 'session id';
+COMMENT ON COLUMN sessions.uid IS 'user id';
+COMMENT ON COLUMN sessions.refresh_hash IS 'sha256 hash of current refresh token';
+COMMENT ON COLUMN sessions.device_name IS 'client device name';
+COMMENT ON COLUMN sessions.user_agent IS 'client user agent when session created';
+COMMENT ON COLUMN sessions.last_used_ip IS 'client ip when session last refreshed';
+COMMENT ON COLUMN sessions.last_used_at IS 'when session last refreshed';
+COMMENT ON COLUMN sessions.create_at IS 'create utc time';
+COMMENT ON COLUMN sessions.expire_at IS 'expire utc time';
+COMMENT ON COLUMN sessions.revoke_at IS 'revoke utc time, null if not revoked';
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    id bigserial NOT NULL,
+    uid varchar(32) NOT NULL,
+    provider varchar(32) NOT NULL,
+    subject varchar(255) NOT NULL,
+    create_at timestamptz NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_user_identities_provider_subject ON user_identities (provider, subject);
+CREATE INDEX IF NOT EXISTS idx_user_identities_uid ON user_identities (uid);
+COMMENT ON TABLE user_identities IS 'external identities linked to users';
+COMMENT ON COLUMN user_identities.id IS 'primary key id';
+COMMENT ON COLUMN user_identities.uid IS 'user id';
+COMMENT ON COLUMN user_identities.provider IS 'identity provider';
+COMMENT ON COLUMN user_identities.subject IS 'user unique id inside the provider';
+COMMENT ON COLUMN user_identities.create_at IS 'create utc time';
+
+CREATE TABLE IF NOT EXISTS oauth_states (
+    id bigserial NOT NULL,
+    state_hash char(64) NOT NULL,
+    provider varchar(32) NOT NULL,
+    code_verifier varchar(128) NOT NULL,
+    nonce varchar(64) NOT NULL,
+    link_uid varchar(32) NOT NULL,
+    create_at timestamptz NOT NULL,
+    expire_at timestamptz NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_oauth_states_state_hash ON oauth_states (state_hash);
+CREATE INDEX IF NOT EXISTS idx_oauth_states_expire_at ON oauth_states (expire_at);
+COMMENT ON TABLE oauth_states IS 'pending oauth authorizations';
+COMMENT ON COLUMN oauth_states.id IS 'primary key id';
+COMMENT ON COLUMN oauth_states.state_hash IS 'sha256 hash of the state param';
+COMMENT ON COLUMN oauth_states.provider IS 'identity provider';
+COMMENT ON COLUMN oauth_states.code_verifier IS 'pkce code verifier';
+COMMENT ON COLUMN oauth_states.nonce IS 'id token nonce';
+COMMENT ON COLUMN oauth_states.link_uid IS 'user to link the identity to, empty for login';
+COMMENT ON COLUMN oauth_states.create_at IS 'create utc time';
+COMMENT ON COLUMN oauth_states.expire_at IS 'expire utc time';
+
+CREATE TABLE IF NOT EXISTS login_codes (
+    id bigserial NOT NULL,
+    uid varchar(32) NOT NULL,
+    code_hash char(64) NOT NULL,
+    link_hash char(64) NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    create_at timestamptz NOT NULL,
+    expire_at timestamptz NOT NULL,
+    consume_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_login_codes_link_hash ON login_codes (link_hash);
+CREATE INDEX IF NOT EXISTS idx_login_codes_uid ON login_codes (uid);
+COMMENT ON TABLE login_codes IS 'passwordless login codes';
+COMMENT ON COLUMN login_codes.id IS 'primary key id';
+COMMENT ON COLUMN login_codes.uid IS 'user id';
+COMMENT ON COLUMN login_codes.code_hash IS 'keyed hash of the one-time code';
+COMMENT ON COLUMN login_codes.link_hash IS 'sha256 hash of the magic link token';
+COMMENT ON COLUMN login_codes.attempts IS 'failed attempts to verify the one-time code';
+COMMENT ON COLUMN login_codes.create_at IS 'create utc time';
+COMMENT ON COLUMN login_codes.expire_at IS 'expire utc time';
+COMMENT ON COLUMN login_codes.consume_at IS 'used utc time, null if not used';
+
+CREATE TABLE IF NOT EXISTS user_two_factors (
+    id bigserial NOT NULL,
+    uid varchar(32) NOT NULL,
+    secret varchar(64) NOT NULL,
+    confirm_at timestamptz,
+    last_used_step bigint NOT NULL DEFAULT 0,
+    failed_attempts integer NOT NULL DEFAULT 0,
+    last_fail_at timestamptz,
+    create_at timestamptz NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_user_two_factors_uid ON user_two_factors (uid);
+COMMENT ON TABLE user_two_factors IS 'user totp two-factor authentication';
+COMMENT ON COLUMN user_two_factors.id IS 'primary key id';
+COMMENT ON COLUMN user_two_factors.uid IS 'user id';
+COMMENT ON COLUMN user_two_factors.secret IS 'base32 encoded totp secret';
+COMMENT ON COLUMN user_two_factors.confirm_at IS 'enrollment confirm utc time, null if still pending';
+COMMENT ON COLUMN user_two_factors.last_used_step IS 'time step of the last accepted code';
+COMMENT ON COLUMN user_two_factors.failed_attempts IS 'consecutive failed verifications';
+COMMENT ON COLUMN user_two_factors.last_fail_at IS 'last failed verification utc time';
+COMMENT ON COLUMN user_two_factors.create_at IS 'create utc time';
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id bigserial NOT NULL,
+    uid varchar(32) NOT NULL,
+    code_hash char(64) NOT NULL,
+    use_at timestamptz,
+    create_at timestamptz NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_uid ON recovery_codes (uid);
+COMMENT ON TABLE recovery_codes IS 'two-factor authentication recovery codes';
+COMMENT ON COLUMN recovery_codes.id IS 'primary key id';
+COMMENT ON COLUMN recovery_codes.uid IS 'user id';
+COMMENT ON COLUMN recovery_codes.code_hash IS 'sha256 hash of the recovery code';
+COMMENT ON COLUMN recovery_codes.use_at IS 'used utc time, null if not used';
+COMMENT ON COLUMN recovery_codes.create_at IS 'create utc time';
+
+CREATE TABLE IF NOT EXISTS rate_limit_counters (
+    limit_key varchar(191) NOT NULL,
+    count bigint NOT NULL,
+    expire_at timestamptz NOT NULL,
+    PRIMARY KEY (limit_key)
+);
+CREATE INDEX IF NOT EXISTS idx_rate_limit_counters_expire_at ON rate_limit_counters (expire_at);
+COMMENT ON TABLE rate_limit_counters IS 'shared rate limit counters';
+COMMENT ON COLUMN rate_limit_counters.limit_key IS 'rate limit key';
+COMMENT ON COLUMN rate_limit_counters.count IS 'count inside current window';
+COMMENT ON COLUMN rate_limit_counters.expire_at IS 'current window end utc time';
+
+CREATE TABLE IF NOT EXISTS email_bind_requests (
+    id bigserial NOT NULL,
+    uid varchar(32) NOT NULL,
+    new_email varchar(32) NOT NULL,
+    token_hash char(64) NOT NULL,
+    cancel_hash varchar(64) NOT NULL,
+    create_at timestamptz NOT NULL,
+    expire_at timestamptz NOT NULL,
+    confirm_at timestamptz,
+    cancel_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_email_bind_requests_token_hash ON email_bind_requests (token_hash);
+CREATE INDEX IF NOT EXISTS idx_email_bind_requests_cancel_hash ON email_bind_requests (cancel_hash);
+CREATE INDEX IF NOT EXISTS idx_email_bind_requests_uid ON email_bind_requests (uid);
+COMMENT ON TABLE email_bind_requests IS 'pending email changes';
+COMMENT ON COLUMN email_bind_requests.id IS 'primary key id';
+COMMENT ON COLUMN email_bind_requests.uid IS 'user id';
+COMMENT ON COLUMN email_bind_requests.new_email IS 'the email to bind';
+COMMENT ON COLUMN email_bind_requests.token_hash IS 'sha256 hash of the confirm token sent to the new email';
+COMMENT ON COLUMN email_bind_requests.cancel_hash IS 'sha256 hash of the cancel token sent to the old email, empty if no old email';
+COMMENT ON COLUMN email_bind_requests.create_at IS 'create utc time';
+COMMENT ON COLUMN email_bind_requests.expire_at IS 'expire utc time';
+COMMENT ON COLUMN email_bind_requests.confirm_at IS 'confirm utc time, null if not confirmed';
+COMMENT ON COLUMN email_bind_requests.cancel_at IS 'cancel utc time, null if not canceled';
+
+CREATE TABLE IF NOT EXISTS outbox_mails (
+    id bigserial NOT NULL,
+    to_mail varchar(255) NOT NULL,
+    content bytea NOT NULL,
+    status varchar(16) NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    last_error varchar(1024) NOT NULL DEFAULT '',
+    next_attempt_at timestamptz NOT NULL,
+    create_at timestamptz NOT NULL,
+    sent_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_mails_status_next_attempt_at ON outbox_mails (status, next_attempt_at);
+COMMENT ON TABLE outbox_mails IS 'outbound mail queue';
+COMMENT ON COLUMN outbox_mails.id IS 'primary key id';
+COMMENT ON COLUMN outbox_mails.to_mail IS 'recipient email address';
+COMMENT ON COLUMN outbox_mails.content IS 'the whole MIME message, cleared once sent';
+COMMENT ON COLUMN outbox_mails.status IS 'pending, sent or dead';
+COMMENT ON COLUMN outbox_mails.attempts IS 'delivery attempts made';
+COMMENT ON COLUMN outbox_mails.last_error IS 'error of the last failed attempt';
+COMMENT ON COLUMN outbox_mails.next_attempt_at IS 'next delivery attempt utc time';
+COMMENT ON COLUMN outbox_mails.create_at IS 'create utc time';
+COMMENT ON COLUMN outbox_mails.sent_at IS 'delivered utc time, null if not sent';
+
+CREATE TABLE IF NOT EXISTS admin_audit_logs (
+    id bigserial NOT NULL,
+    admin_uid varchar(32) NOT NULL,
+    action varchar(32) NOT NULL,
+    target_uid varchar(32) NOT NULL DEFAULT '',
+    target_habit_id bigint NOT NULL DEFAULT 0,
+    detail varchar(1024) NOT NULL DEFAULT '',
+    ip varchar(64) NOT NULL DEFAULT '',
+    create_at timestamptz NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_logs_admin_uid ON admin_audit_logs (admin_uid);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_logs_target_uid ON admin_audit_logs (target_uid);
+COMMENT ON TABLE admin_audit_logs IS 'admin action audit logs';
+COMMENT ON COLUMN admin_audit_logs.id IS 'primary key id';
+COMMENT ON COLUMN admin_audit_logs.admin_uid IS 'uid of the admin who took the action';
+COMMENT ON COLUMN admin_audit_logs.action IS 'admin action';
+COMMENT ON COLUMN admin_audit_logs.target_uid IS 'uid of the user acted on, empty if none';
+COMMENT ON COLUMN admin_audit_logs.target_habit_id IS 'id of the habit acted on, 0 if none';
+COMMENT ON COLUMN admin_audit_logs.detail IS 'action detail, like the search text or the reason';
+COMMENT ON COLUMN admin_audit_logs.ip IS 'client ip of the admin';
+COMMENT ON COLUMN admin_audit_logs.create_at IS 'create utc time';
+
+CREATE TABLE IF NOT EXISTS security_events (
+    id bigserial NOT NULL,
+    uid varchar(32) NOT NULL,
+    event varchar(32) NOT NULL,
+    ip varchar(64) NOT NULL DEFAULT '',
+    user_agent varchar(255) NOT NULL DEFAULT '',
+    detail varchar(255) NOT NULL DEFAULT '',
+    create_at timestamptz NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_security_events_uid ON security_events (uid);
+COMMENT ON TABLE security_events IS 'append-only security-sensitive account events';
+COMMENT ON COLUMN security_events.id IS 'primary key id';
+COMMENT ON COLUMN security_events.uid IS 'uid of the user the event belongs to';
+COMMENT ON COLUMN security_events.event IS 'event type';
+COMMENT ON COLUMN security_events.ip IS 'client ip of the request causing the event';
+COMMENT ON COLUMN security_events.user_agent IS 'client user agent of the request causing the event';
+COMMENT ON COLUMN security_events.detail IS 'event detail';
+COMMENT ON COLUMN security_events.create_at IS 'create utc time';
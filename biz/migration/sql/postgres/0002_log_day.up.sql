@@ -0,0 +1,17 @@
+-- the day a habit log counts for, a user logs a habit at most once a day, the day begins at 4 am
+-- in the zone of the client, the existing records are counted in utc as the zone is unknown
+ALTER TABLE habit_log_records ADD COLUMN log_day char(10) NOT NULL DEFAULT '';
+ALTER TABLE unconfirmed_habit_log_records ADD COLUMN log_day char(10) NOT NULL DEFAULT '';
+COMMENT ON COLUMN habit_log_records.log_day IS 'the day logged for, yyyy-mm-dd';
+COMMENT ON COLUMN unconfirmed_habit_log_records.log_day IS 'the day logged for, yyyy-mm-dd';
+UPDATE habit_log_records SET log_day = to_char((log_at AT TIME ZONE 'UTC') - interval '4 hours', 'YYYY-MM-DD');
+UPDATE unconfirmed_habit_log_records SET log_day = to_char((log_at AT TIME ZONE 'UTC') - interval '4 hours', 'YYYY-MM-DD');
+
+-- keep the first record of the duplicated ones logged concurrently
+DELETE FROM habit_log_records r1 USING habit_log_records r2
+    WHERE r1.habit_id = r2.habit_id AND r1.uid = r2.uid AND r1.log_day = r2.log_day AND r1.id > r2.id;
+DELETE FROM unconfirmed_habit_log_records r1 USING unconfirmed_habit_log_records r2
+    WHERE r1.habit_id = r2.habit_id AND r1.uid = r2.uid AND r1.log_day = r2.log_day AND r1.id > r2.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_habit_log_records_habit_id_uid_log_day ON habit_log_records (habit_id, uid, log_day);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_unconfirmed_habit_log_records_habit_id_uid_log_day ON unconfirmed_habit_log_records (habit_id, uid, log_day);
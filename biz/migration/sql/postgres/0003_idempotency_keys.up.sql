@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idempotency_key varchar(191) NOT NULL,
+    request_hash char(64) NOT NULL,
+    completed boolean NOT NULL DEFAULT false,
+    status_code integer NOT NULL DEFAULT 0,
+    body bytea,
+    expire_at timestamptz NOT NULL,
+    PRIMARY KEY (idempotency_key)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expire_at ON idempotency_keys (expire_at);
+COMMENT ON TABLE idempotency_keys IS 'responses of the requests with idempotency keys';
+COMMENT ON COLUMN idempotency_keys.idempotency_key IS 'the idempotency key scoped by the user';
+COMMENT ON COLUMN idempotency_keys.request_hash IS 'sha256 of the method, path and body of the request';
+COMMENT ON COLUMN idempotency_keys.completed IS 'whether the response is stored, false while the request is in progress';
+COMMENT ON COLUMN idempotency_keys.status_code IS 'http status of the stored response';
+COMMENT ON COLUMN idempotency_keys.body IS 'the serialized response body, null while the request is in progress';
+COMMENT ON COLUMN idempotency_keys.expire_at IS 'expire utc time';
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS uniq_habit_log_records_uid_client_id;
+DROP INDEX IF EXISTS uniq_unconfirmed_habit_log_records_uid_client_id;
+DROP INDEX IF EXISTS idx_habit_log_records_uid_update_at;
+DROP INDEX IF EXISTS idx_unconfirmed_habit_log_records_uid_update_at;
+ALTER TABLE habit_log_records DROP COLUMN client_id;
+ALTER TABLE habit_log_records DROP COLUMN update_at;
+ALTER TABLE unconfirmed_habit_log_records DROP COLUMN client_id;
+ALTER TABLE unconfirmed_habit_log_records DROP COLUMN update_at;
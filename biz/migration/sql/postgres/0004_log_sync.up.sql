@@ -0,0 +1,17 @@
+-- the check-ins synced by the offline clients are identified by the ids the clients generate,
+-- and the clients pull the records changed after their last sync by the update time
+ALTER TABLE habit_log_records ADD COLUMN client_id varchar(64);
+ALTER TABLE habit_log_records ADD COLUMN update_at timestamptz NOT NULL DEFAULT '1970-01-01 00:00:00+00';
+ALTER TABLE unconfirmed_habit_log_records ADD COLUMN client_id varchar(64);
+ALTER TABLE unconfirmed_habit_log_records ADD COLUMN update_at timestamptz NOT NULL DEFAULT '1970-01-01 00:00:00+00';
+COMMENT ON COLUMN habit_log_records.client_id IS 'the id generated by the client of a synced check-in';
+COMMENT ON COLUMN habit_log_records.update_at IS 'update utc time';
+COMMENT ON COLUMN unconfirmed_habit_log_records.client_id IS 'the id generated by the client of a synced check-in';
+COMMENT ON COLUMN unconfirmed_habit_log_records.update_at IS 'update utc time';
+UPDATE habit_log_records SET update_at = log_at;
+UPDATE unconfirmed_habit_log_records SET update_at = log_at;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_habit_log_records_uid_client_id ON habit_log_records (uid, client_id);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_unconfirmed_habit_log_records_uid_client_id ON unconfirmed_habit_log_records (uid, client_id);
+CREATE INDEX IF NOT EXISTS idx_habit_log_records_uid_update_at ON habit_log_records (uid, update_at);
+CREATE INDEX IF NOT EXISTS idx_unconfirmed_habit_log_records_uid_update_at ON unconfirmed_habit_log_records (uid, update_at);
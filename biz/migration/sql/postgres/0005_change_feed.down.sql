@@ -0,0 +1,22 @@
+-- the tombstones are gone with the delete time, remove them first
+DELETE FROM habits WHERE delete_at IS NOT NULL;
+DELETE FROM habit_groups WHERE delete_at IS NOT NULL;
+DELETE FROM user_habit_configs WHERE delete_at IS NOT NULL;
+DELETE FROM habit_log_records WHERE delete_at IS NOT NULL;
+DELETE FROM unconfirmed_habit_log_records WHERE delete_at IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_habits_delete_at;
+DROP INDEX IF EXISTS idx_habit_groups_uid_update_at;
+DROP INDEX IF EXISTS idx_habit_groups_delete_at;
+DROP INDEX IF EXISTS idx_user_habit_configs_uid_update_at;
+DROP INDEX IF EXISTS idx_user_habit_configs_delete_at;
+DROP INDEX IF EXISTS idx_habit_log_records_delete_at;
+DROP INDEX IF EXISTS idx_unconfirmed_habit_log_records_delete_at;
+ALTER TABLE habits DROP COLUMN update_at;
+ALTER TABLE habits DROP COLUMN delete_at;
+ALTER TABLE habit_groups DROP COLUMN update_at;
+ALTER TABLE habit_groups DROP COLUMN delete_at;
+ALTER TABLE user_habit_configs DROP COLUMN update_at;
+ALTER TABLE user_habit_configs DROP COLUMN delete_at;
+ALTER TABLE habit_log_records DROP COLUMN delete_at;
+ALTER TABLE unconfirmed_habit_log_records DROP COLUMN delete_at;
@@ -0,0 +1,30 @@
+-- the clients pull the habits, groups, configs and log records changed after their last pull by the update time,
+-- the deleted rows are kept as tombstones with the delete time for a while, so that the clients learn the deletion
+ALTER TABLE habits ADD COLUMN update_at timestamptz NOT NULL DEFAULT '1970-01-01 00:00:00+00';
+ALTER TABLE habits ADD COLUMN delete_at timestamptz;
+ALTER TABLE habit_groups ADD COLUMN update_at timestamptz NOT NULL DEFAULT '1970-01-01 00:00:00+00';
+ALTER TABLE habit_groups ADD COLUMN delete_at timestamptz;
+ALTER TABLE user_habit_configs ADD COLUMN update_at timestamptz NOT NULL DEFAULT '1970-01-01 00:00:00+00';
+ALTER TABLE user_habit_configs ADD COLUMN delete_at timestamptz;
+ALTER TABLE habit_log_records ADD COLUMN delete_at timestamptz;
+ALTER TABLE unconfirmed_habit_log_records ADD COLUMN delete_at timestamptz;
+COMMENT ON COLUMN habits.update_at IS 'update utc time';
+COMMENT ON COLUMN habits.delete_at IS 'delete utc time, null if not deleted';
+COMMENT ON COLUMN habit_groups.update_at IS 'update utc time';
+COMMENT ON COLUMN habit_groups.delete_at IS 'delete utc time, null if not deleted';
+COMMENT ON COLUMN user_habit_configs.update_at IS 'update utc time';
+COMMENT ON COLUMN user_habit_configs.delete_at IS 'delete utc time, null if not deleted';
+COMMENT ON COLUMN habit_log_records.delete_at IS 'delete utc time, null if not deleted';
+COMMENT ON COLUMN unconfirmed_habit_log_records.delete_at IS 'delete utc time, null if not deleted';
+-- the existing groups and configs are taken as changed at the migration
+UPDATE habits SET update_at = create_at;
+UPDATE habit_groups SET update_at = now();
+UPDATE user_habit_configs SET update_at = now();
+
+CREATE INDEX IF NOT EXISTS idx_habits_delete_at ON habits (delete_at);
+CREATE INDEX IF NOT EXISTS idx_habit_groups_uid_update_at ON habit_groups (uid, update_at);
+CREATE INDEX IF NOT EXISTS idx_habit_groups_delete_at ON habit_groups (delete_at);
+CREATE INDEX IF NOT EXISTS idx_user_habit_configs_uid_update_at ON user_habit_configs (uid, update_at);
+CREATE INDEX IF NOT EXISTS idx_user_habit_configs_delete_at ON user_habit_configs (delete_at);
+CREATE INDEX IF NOT EXISTS idx_habit_log_records_delete_at ON habit_log_records (delete_at);
+CREATE INDEX IF NOT EXISTS idx_unconfirmed_habit_log_records_delete_at ON unconfirmed_habit_log_records (delete_at);
@@ -0,0 +1 @@
+ALTER TABLE sessions DROP COLUMN prev_refresh_hash;
@@ -0,0 +1,197 @@
+CREATE TABLE IF NOT EXISTS `users` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `uid` varchar(32) NOT NULL,
+    `name` varchar(32),
+    `email` varchar(32),
+    `email_active` bool,
+    `email_bind` bool,
+    `password` varchar(64),
+    `portrait` varchar(64),
+    `user_register_type` varchar(16) NOT NULL,
+    `language` varchar(16) NOT NULL DEFAULT '',
+    `role` varchar(16) NOT NULL DEFAULT '',
+    `disabled` bool NOT NULL DEFAULT false,
+    `delete_at` datetime
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `uniq_users_uid` ON `users` (`uid`);
+CREATE UNIQUE INDEX IF NOT EXISTS `uniq_users_name` ON `users` (`name`);
+CREATE UNIQUE INDEX IF NOT EXISTS `uniq_users_email` ON `users` (`email`);
+
+CREATE TABLE IF NOT EXISTS `habits` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `name` varchar(255) NOT NULL,
+    `identity` varchar(255),
+    `owner` varchar(32) NOT NULL,
+    `create_at` datetime NOT NULL,
+    `log_days` tinyint
+);
+
+CREATE TABLE IF NOT EXISTS `habit_groups` (
+    `habit_id` bigint NOT NULL,
+    `uid` varchar(32) NOT NULL,
+    PRIMARY KEY (`habit_id`, `uid`)
+);
+
+CREATE TABLE IF NOT EXISTS `user_habit_configs` (
+    `uid` varchar(32) NOT NULL,
+    `habit_id` bigint NOT NULL,
+    `current_streak` int NOT NULL,
+    `longest_streak` int NOT NULL,
+    `streak_update_at` datetime,
+    `remain_retroactive_chance` tinyint NOT NULL,
+    `heatmap_color` varchar(8) NOT NULL,
+    PRIMARY KEY (`habit_id`, `uid`)
+);
+
+CREATE TABLE IF NOT EXISTS `habit_log_records` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `habit_id` bigint NOT NULL,
+    `uid` varchar(32) NOT NULL,
+    `log_at` datetime NOT NULL
+);
+CREATE INDEX IF NOT EXISTS `idx_habit_log_records_habit_id` ON `habit_log_records` (`habit_id`);
+CREATE INDEX IF NOT EXISTS `idx_habit_log_records_uid` ON `habit_log_records` (`uid`);
+CREATE INDEX IF NOT EXISTS `idx_habit_log_records_log_time` ON `habit_log_records` (`log_at`);
+
+CREATE TABLE IF NOT EXISTS `unconfirmed_habit_log_records` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `habit_id` bigint NOT NULL,
+    `uid` varchar(32) NOT NULL,
+    `log_at` datetime NOT NULL
+);
+CREATE INDEX IF NOT EXISTS `idx_unconfirmed_habit_log_records_habit_id` ON `unconfirmed_habit_log_records` (`habit_id`);
+CREATE INDEX IF NOT EXISTS `idx_unconfirmed_habit_log_records_uid` ON `unconfirmed_habit_log_records` (`uid`);
+CREATE INDEX IF NOT EXISTS `idx_unconfirmed_habit_log_records_log_time` ON `unconfirmed_habit_log_records` (`log_at`);
+
+CREATE TABLE IF NOT EXISTS `sessions` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `sid` varchar(32) NOT NULL,
+    `uid` varchar(32) NOT NULL,
+    `refresh_hash` char(64) NOT NULL,
+    `device_name` varchar(64) NOT NULL,
+    `user_agent` varchar(255) NOT NULL,
+    `last_used_ip` varchar(64) NOT NULL,
+    `last_used_at` datetime NOT NULL,
+    `create_at` datetime NOT NULL,
+    `expire_at` datetime NOT NULL,
+    `revoke_at` datetime
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `uniq_sessions_sid` ON `sessions` (`sid`);
+CREATE INDEX IF NOT EXISTS `idx_sessions_uid` ON `sessions` (`uid`);
+
+CREATE TABLE IF NOT EXISTS `user_identities` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `uid` varchar(32) NOT NULL,
+    `provider` varchar(32) NOT NULL,
+    `subject` varchar(255) NOT NULL,
+    `create_at` datetime NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `uniq_user_identities_provider_subject` ON `user_identities` (`provider`, `subject`);
+CREATE INDEX IF NOT EXISTS `idx_user_identities_uid` ON `user_identities` (`uid`);
+
+CREATE TABLE IF NOT EXISTS `oauth_states` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `state_hash` char(64) NOT NULL,
+    `provider` varchar(32) NOT NULL,
+    `code_verifier` varchar(128) NOT NULL,
+    `nonce` varchar(64) NOT NULL,
+    `link_uid` varchar(32) NOT NULL,
+    `create_at` datetime NOT NULL,
+    `expire_at` datetime NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `uniq_oauth_states_state_hash` ON `oauth_states` (`state_hash`);
+CREATE INDEX IF NOT EXISTS `idx_oauth_states_expire_at` ON `oauth_states` (`expire_at`);
+
+CREATE TABLE IF NOT EXISTS `login_codes` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `uid` varchar(32) NOT NULL,
+    `code_hash` char(64) NOT NULL,
+    `link_hash` char(64) NOT NULL,
+    `attempts` int NOT NULL DEFAULT 0,
+    `create_at` datetime NOT NULL,
+    `expire_at` datetime NOT NULL,
+    `consume_at` datetime
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `uniq_login_codes_link_hash` ON `login_codes` (`link_hash`);
+CREATE INDEX IF NOT EXISTS `idx_login_codes_uid` ON `login_codes` (`uid`);
+
+CREATE TABLE IF NOT EXISTS `user_two_factors` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `uid` varchar(32) NOT NULL,
+    `secret` varchar(64) NOT NULL,
+    `confirm_at` datetime,
+    `last_used_step` bigint NOT NULL DEFAULT 0,
+    `failed_attempts` int NOT NULL DEFAULT 0,
+    `last_fail_at` datetime,
+    `create_at` datetime NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `uniq_user_two_factors_uid` ON `user_two_factors` (`uid`);
+
+CREATE TABLE IF NOT EXISTS `recovery_codes` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `uid` varchar(32) NOT NULL,
+    `code_hash` char(64) NOT NULL,
+    `use_at` datetime,
+    `create_at` datetime NOT NULL
+);
+CREATE INDEX IF NOT EXISTS `idx_recovery_codes_uid` ON `recovery_codes` (`uid`);
+
+CREATE TABLE IF NOT EXISTS `rate_limit_counters` (
+    `limit_key` varchar(191) NOT NULL,
+    `count` bigint NOT NULL,
+    `expire_at` datetime NOT NULL,
+    PRIMARY KEY (`limit_key`)
+);
+CREATE INDEX IF NOT EXISTS `idx_rate_limit_counters_expire_at` ON `rate_limit_counters` (`expire_at`);
+
+CREATE TABLE IF NOT EXISTS `email_bind_requests` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `uid` varchar(32) NOT NULL,
+    `new_email` varchar(32) NOT NULL,
+    `token_hash` char(64) NOT NULL,
+    `cancel_hash` varchar(64) NOT NULL,
+    `create_at` datetime NOT NULL,
+    `expire_at` datetime NOT NULL,
+    `confirm_at` datetime,
+    `cancel_at` datetime
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `uniq_email_bind_requests_token_hash` ON `email_bind_requests` (`token_hash`);
+CREATE INDEX IF NOT EXISTS `idx_email_bind_requests_cancel_hash` ON `email_bind_requests` (`cancel_hash`);
+CREATE INDEX IF NOT EXISTS `idx_email_bind_requests_uid` ON `email_bind_requests` (`uid`);
+
+CREATE TABLE IF NOT EXISTS `outbox_mails` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `to_mail` varchar(255) NOT NULL,
+    `content` mediumblob NOT NULL,
+    `status` varchar(16) NOT NULL,
+    `attempts` int NOT NULL DEFAULT 0,
+    `last_error` varchar(1024) NOT NULL DEFAULT '',
+    `next_attempt_at` datetime NOT NULL,
+    `create_at` datetime NOT NULL,
+    `sent_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_outbox_mails_status_next_attempt_at` ON `outbox_mails` (`status`, `next_attempt_at`);
+
+CREATE TABLE IF NOT EXISTS `admin_audit_logs` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `admin_uid` varchar(32) NOT NULL,
+    `action` varchar(32) NOT NULL,
+    `target_uid` varchar(32) NOT NULL DEFAULT '',
+    `target_habit_id` bigint NOT NULL DEFAULT 0,
+    `detail` varchar(1024) NOT NULL DEFAULT '',
+    `ip` varchar(64) NOT NULL DEFAULT '',
+    `create_at` datetime NOT NULL
+);
+CREATE INDEX IF NOT EXISTS `idx_admin_audit_logs_admin_uid` ON `admin_audit_logs` (`admin_uid`);
+CREATE INDEX IF NOT EXISTS `idx_admin_audit_logs_target_uid` ON `admin_audit_logs` (`target_uid`);
+
+CREATE TABLE IF NOT EXISTS `security_events` (
+    `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    `uid` varchar(32) NOT NULL,
+    `event` varchar(32) NOT NULL,
+    `ip` varchar(64) NOT NULL DEFAULT '',
+    `user_agent` varchar(255) NOT NULL DEFAULT '',
+    `detail` varchar(255) NOT NULL DEFAULT '',
+    `create_at` datetime NOT NULL
+);
+CREATE INDEX IF NOT EXISTS `idx_security_events_uid` ON `security_events` (`uid`);
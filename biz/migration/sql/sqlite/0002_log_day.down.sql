@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS `uniq_habit_log_records_habit_id_uid_log_day`;
+DROP INDEX IF EXISTS `uniq_unconfirmed_habit_log_records_habit_id_uid_log_day`;
+ALTER TABLE `habit_log_records` DROP COLUMN `log_day`;
+ALTER TABLE `unconfirmed_habit_log_records` DROP COLUMN `log_day`;
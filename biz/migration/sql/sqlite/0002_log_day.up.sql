@@ -0,0 +1,15 @@
+-- the day a habit log counts for, a user logs a habit at most once a day, the day begins at 4 am
+-- in the zone of the client, the existing records are counted in utc as the zone is unknown
+ALTER TABLE `habit_log_records` ADD COLUMN `log_day` char(10) NOT NULL DEFAULT '';
+ALTER TABLE `unconfirmed_habit_log_records` ADD COLUMN `log_day` char(10) NOT NULL DEFAULT '';
+UPDATE `habit_log_records` SET `log_day` = strftime('%Y-%m-%d', `log_at`, '-4 hours');
+UPDATE `unconfirmed_habit_log_records` SET `log_day` = strftime('%Y-%m-%d', `log_at`, '-4 hours');
+
+-- keep the first record of the duplicated ones logged concurrently
+DELETE FROM `habit_log_records` WHERE `id` NOT IN
+    (SELECT MIN(`id`) FROM `habit_log_records` GROUP BY `habit_id`, `uid`, `log_day`);
+DELETE FROM `unconfirmed_habit_log_records` WHERE `id` NOT IN
+    (SELECT MIN(`id`) FROM `unconfirmed_habit_log_records` GROUP BY `habit_id`, `uid`, `log_day`);
+
+CREATE UNIQUE INDEX IF NOT EXISTS `uniq_habit_log_records_habit_id_uid_log_day` ON `habit_log_records` (`habit_id`, `uid`, `log_day`);
+CREATE UNIQUE INDEX IF NOT EXISTS `uniq_unconfirmed_habit_log_records_habit_id_uid_log_day` ON `unconfirmed_habit_log_records` (`habit_id`, `uid`, `log_day`);
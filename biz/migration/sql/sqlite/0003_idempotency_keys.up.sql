@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `idempotency_keys` (
+    `idempotency_key` varchar(191) NOT NULL,
+    `request_hash` char(64) NOT NULL,
+    `completed` bool NOT NULL DEFAULT false,
+    `status_code` integer NOT NULL DEFAULT 0,
+    `body` mediumblob,
+    `expire_at` datetime NOT NULL,
+    PRIMARY KEY (`idempotency_key`)
+);
+CREATE INDEX IF NOT EXISTS `idx_idempotency_keys_expire_at` ON `idempotency_keys` (`expire_at`);
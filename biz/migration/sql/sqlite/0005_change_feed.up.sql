@@ -0,0 +1,22 @@
+-- the clients pull the habits, groups, configs and log records changed after their last pull by the update time,
+-- the deleted rows are kept as tombstones with the delete time for a while, so that the clients learn the deletion
+ALTER TABLE `habits` ADD COLUMN `update_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00';
+ALTER TABLE `habits` ADD COLUMN `delete_at` datetime;
+ALTER TABLE `habit_groups` ADD COLUMN `update_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00';
+ALTER TABLE `habit_groups` ADD COLUMN `delete_at` datetime;
+ALTER TABLE `user_habit_configs` ADD COLUMN `update_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00';
+ALTER TABLE `user_habit_configs` ADD COLUMN `delete_at` datetime;
+ALTER TABLE `habit_log_records` ADD COLUMN `delete_at` datetime;
+ALTER TABLE `unconfirmed_habit_log_records` ADD COLUMN `delete_at` datetime;
+-- the existing groups and configs are taken as changed at the migration
+UPDATE `habits` SET `update_at` = `create_at`;
+UPDATE `habit_groups` SET `update_at` = CURRENT_TIMESTAMP;
+UPDATE `user_habit_configs` SET `update_at` = CURRENT_TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS `idx_habits_delete_at` ON `habits` (`delete_at`);
+CREATE INDEX IF NOT EXISTS `idx_habit_groups_uid_update_at` ON `habit_groups` (`uid`, `update_at`);
+CREATE INDEX IF NOT EXISTS `idx_habit_groups_delete_at` ON `habit_groups` (`delete_at`);
+CREATE INDEX IF NOT EXISTS `idx_user_habit_configs_uid_update_at` ON `user_habit_configs` (`uid`, `update_at`);
+CREATE INDEX IF NOT EXISTS `idx_user_habit_configs_delete_at` ON `user_habit_configs` (`delete_at`);
+CREATE INDEX IF NOT EXISTS `idx_habit_log_records_delete_at` ON `habit_log_records` (`delete_at`);
+CREATE INDEX IF NOT EXISTS `idx_unconfirmed_habit_log_records_delete_at` ON `unconfirmed_habit_log_records` (`delete_at`);
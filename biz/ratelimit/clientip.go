@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"fmt"
+	"github.com/cloudwego/hertz/pkg/app"
+	"net"
+	"strings"
+)
+
+// trustedProxies the networks of the proxies whose forwarded headers are honoured, none by default
+var trustedProxies []*net.IPNet
+
+// InitTrustedProxies set the proxies whose X-Forwarded-For and X-Real-IP headers are honoured, each is an ip or a cidr,
+// and make it the ClientIP of every RequestContext, the connection address is the client ip if none is set
+func InitTrustedProxies(proxies []string) error {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %s", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(p)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %s: %w", p, err)
+		}
+		networks = append(networks, network)
+	}
+	trustedProxies = networks
+	app.SetClientIPFunc(ClientIP)
+	return nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP get the ip of the client of a request, which is the connection address unless the connection comes
+// from a trusted proxy, then the forwarded headers are walked from the nearest hop and the first address
+// not of a trusted proxy is the client ip, as the farther ones can be forged by the client
+func ClientIP(rc *app.RequestContext) string {
+	host, _, err := net.SplitHostPort(rc.RemoteAddr().String())
+	if err != nil {
+		return ""
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isTrustedProxy(ip) {
+		return host
+	}
+
+	if forwardedFor := rc.Request.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+		hops := strings.Split(forwardedFor, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break // the rest is not written by a proxy
+			}
+			ip = hop
+			if !isTrustedProxy(hop) {
+				break
+			}
+		}
+		return ip.String()
+	}
+	if realIP := net.ParseIP(strings.TrimSpace(rc.Request.Header.Get("X-Real-IP"))); realIP != nil {
+		return realIP.String()
+	}
+	return ip.String()
+}
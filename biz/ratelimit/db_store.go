@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"gorm.io/gorm"
+	"time"
+)
+
+// DBStore a Store keeps the counters in the database, the limits are shared by all server instances
+type DBStore struct {
+	db *gorm.DB
+}
+
+// NewDBStore create a DBStore on a database
+func NewDBStore(db *gorm.DB) *DBStore {
+	return &DBStore{db: db}
+}
+
+func (s *DBStore) Incr(ctx context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	var count int64
+	var expireAt time.Time
+	incr := func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+		c, sErr := dal.RateLimitCounterDBHD.GetByKey(tx, key, true)
+		if sErr != nil {
+			return sErr
+		}
+		if c == nil {
+			count, expireAt = 1, now.Add(window)
+			return dal.RateLimitCounterDBHD.Add(tx, &dal.RateLimitCounter{LimitKey: key, Count: count, ExpireAt: expireAt})
+		}
+		if !now.Before(c.ExpireAt) {
+			count, expireAt = 1, now.Add(window)
+		} else {
+			count, expireAt = c.Count+1, c.ExpireAt
+		}
+		return dal.RateLimitCounterDBHD.Update(tx, key, count, expireAt)
+	}
+
+	err := s.db.WithContext(ctx).Transaction(incr)
+	if err != nil {
+		// the counter may be inserted by a concurrent request, retry once to increase the inserted one
+		err = s.db.WithContext(ctx).Transaction(incr)
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return count, expireAt, nil
+}
+
+func (s *DBStore) Get(ctx context.Context, key string) (int64, time.Time, error) {
+	c, sErr := dal.RateLimitCounterDBHD.GetByKey(s.db.WithContext(ctx), key, false)
+	if sErr != nil {
+		return 0, time.Time{}, sErr
+	}
+	if c == nil || !time.Now().Before(c.ExpireAt) {
+		return 0, time.Time{}, nil
+	}
+	return c.Count, c.ExpireAt, nil
+}
+
+func (s *DBStore) Set(ctx context.Context, key string, count int64, window time.Duration) error {
+	expireAt := time.Now().UTC().Add(window)
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		c, sErr := dal.RateLimitCounterDBHD.GetByKey(tx, key, true)
+		if sErr != nil {
+			return sErr
+		}
+		if c == nil {
+			return dal.RateLimitCounterDBHD.Add(tx, &dal.RateLimitCounter{LimitKey: key, Count: count, ExpireAt: expireAt})
+		}
+		return dal.RateLimitCounterDBHD.Update(tx, key, count, expireAt)
+	})
+}
+
+func (s *DBStore) Delete(ctx context.Context, key string) error {
+	sErr := dal.RateLimitCounterDBHD.DeleteByKey(s.db.WithContext(ctx), key)
+	if sErr != nil {
+		return sErr
+	}
+	return nil
+}
+
+// DeleteExpired remove the expired counters, should be called periodically to keep the table small
+func (s *DBStore) DeleteExpired(ctx context.Context) error {
+	sErr := dal.RateLimitCounterDBHD.DeleteExpired(s.db.WithContext(ctx), time.Now())
+	if sErr != nil {
+		return sErr
+	}
+	return nil
+}
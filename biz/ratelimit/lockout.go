@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Lockout lock an account out after consecutive failures, the lock time grows exponentially with more failures
+type Lockout struct {
+	Name          string        // distinguish the counters of different lockouts
+	Threshold     int64         // how many consecutive failures are allowed before locked
+	BaseDelay     time.Duration // the lock time after reaching the threshold, doubled by each failure after
+	MaxDelay      time.Duration
+	FailureWindow time.Duration // failures older than it are forgotten
+	Store         Store         // use DefaultStore if nil
+}
+
+func (l *Lockout) store() Store {
+	if l.Store != nil {
+		return l.Store
+	}
+	return DefaultStore()
+}
+
+func (l *Lockout) failureKey(account string) string {
+	return "lockout:" + l.Name + ":fail:" + account
+}
+
+func (l *Lockout) lockKey(account string) string {
+	return "lockout:" + l.Name + ":lock:" + account
+}
+
+// delay get the lock time after the failures
+func (l *Lockout) delay(failures int64) time.Duration {
+	d := l.BaseDelay
+	for i := l.Threshold; i < failures && d < l.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > l.MaxDelay {
+		d = l.MaxDelay
+	}
+	return d
+}
+
+// Check return how long the account is still locked, zero if not locked
+func (l *Lockout) Check(ctx context.Context, account string) (time.Duration, error) {
+	locked, expireAt, err := l.store().Get(ctx, l.lockKey(account))
+	if err != nil {
+		return 0, err
+	}
+	if locked == 0 {
+		return 0, nil
+	}
+	return time.Until(expireAt), nil
+}
+
+// Fail record a failure of the account, return how long the account is locked by it, zero if not locked
+func (l *Lockout) Fail(ctx context.Context, account string) (time.Duration, error) {
+	failures, _, err := l.store().Incr(ctx, l.failureKey(account), l.FailureWindow)
+	if err != nil {
+		return 0, err
+	}
+	if failures < l.Threshold {
+		return 0, nil
+	}
+	d := l.delay(failures)
+	err = l.store().Set(ctx, l.lockKey(account), 1, d)
+	if err != nil {
+		return 0, err
+	}
+	return d, nil
+}
+
+// Succeed clear the failures of the account
+func (l *Lockout) Succeed(ctx context.Context, account string) error {
+	err := l.store().Delete(ctx, l.failureKey(account))
+	if err != nil {
+		return err
+	}
+	return l.store().Delete(ctx, l.lockKey(account))
+}
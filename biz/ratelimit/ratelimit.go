@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/cloudwego/hertz/pkg/app"
+	"time"
+)
+
+// Result the result of a rate limit check
+type Result struct {
+	Allowed    bool
+	Remaining  int64
+	RetryAfter time.Duration // how long to wait before the next request is allowed, zero if allowed
+}
+
+// Allow count a request of key and check whether it is inside the limit of the window
+func Allow(ctx context.Context, store Store, key string, limit int64, window time.Duration) (*Result, error) {
+	count, expireAt, err := store.Incr(ctx, key, window)
+	if err != nil {
+		return nil, err
+	}
+	if count > limit {
+		return &Result{Allowed: false, RetryAfter: time.Until(expireAt)}, nil
+	}
+	return &Result{Allowed: true, Remaining: limit - count}, nil
+}
+
+// KeyFunc get the key a request is limited by, return empty string to skip limiting the request
+type KeyFunc func(ctx context.Context, rc *app.RequestContext) string
+
+// Policy a rate limit policy of a route, at most Limit requests of the same key are allowed inside Window
+type Policy struct {
+	Name   string // distinguish the counters of different policies
+	Limit  int64
+	Window time.Duration
+	Key    KeyFunc
+}
+
+// ByIP limit requests by client ip, the forwarded headers are only honoured from the trusted proxies
+func ByIP(ctx context.Context, rc *app.RequestContext) string {
+	return ClientIP(rc)
+}
+
+// ByContextValue limit requests by a string value inside the RequestContext, like the uid set by the auth middleware
+func ByContextValue(key string) KeyFunc {
+	return func(ctx context.Context, rc *app.RequestContext) string {
+		return rc.GetString(key)
+	}
+}
+
+// ByJSONField limit requests by a string field of the json body, like the target email address
+func ByJSONField(field string) KeyFunc {
+	return func(ctx context.Context, rc *app.RequestContext) string {
+		body := map[string]interface{}{}
+		if json.Unmarshal(rc.Request.Body(), &body) != nil {
+			return ""
+		}
+		v, _ := body[field].(string)
+		return v
+	}
+}
@@ -0,0 +1,205 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/test/mock"
+	"github.com/glebarez/sqlite"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"gorm.io/gorm"
+	"net"
+	"path"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	for i := int64(1); i <= 3; i++ {
+		result, err := Allow(ctx, store, "k", 3, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != 3-i {
+			t.Fatalf("request %d should be allowed, got %+v", i, result)
+		}
+	}
+	result, err := Allow(ctx, store, "k", 3, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > time.Minute {
+		t.Fatalf("request over limit should be rejected, got %+v", result)
+	}
+
+	// other keys are counted separately
+	result, err = Allow(ctx, store, "other", 3, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed {
+		t.Fatal("request of other key should be allowed")
+	}
+
+	err = store.Delete(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, _, err := store.Get(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatal("deleted counter should be zero")
+	}
+
+	err = store.Set(ctx, "s", 5, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, expireAt, err := store.Get(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 || time.Until(expireAt) <= 0 {
+		t.Fatalf("unexpected counter %d, expire at %v", count, expireAt)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+
+	now := time.Now()
+	store := NewMemoryStore()
+	store.nowFunc = func() time.Time { return now }
+	ctx := context.Background()
+	_, _, _ = store.Incr(ctx, "k", time.Minute)
+	now = now.Add(time.Minute)
+	count, _, _ := store.Incr(ctx, "k", time.Minute)
+	if count != 1 {
+		t.Fatal("counter should restart after the window ends")
+	}
+	now = now.Add(time.Hour)
+	_, _, _ = store.Incr(ctx, "other", time.Minute)
+	if _, ok := store.counters["k"]; ok {
+		t.Fatal("expired counter should be swept")
+	}
+}
+
+func TestDBStore(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(path.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&dal.RateLimitCounter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewDBStore(db)
+	testStore(t, store)
+	err = store.DeleteExpired(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	ctx := context.Background()
+	l := &Lockout{
+		Name:          "test",
+		Threshold:     3,
+		BaseDelay:     time.Minute,
+		MaxDelay:      time.Minute * 5,
+		FailureWindow: time.Hour,
+		Store:         NewMemoryStore(),
+	}
+	expects := []time.Duration{0, 0, time.Minute, time.Minute * 2, time.Minute * 4, time.Minute * 5, time.Minute * 5}
+	for i, expect := range expects {
+		d, err := l.Fail(ctx, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d != expect {
+			t.Fatalf("failure %d expect lock %v, got %v", i+1, expect, d)
+		}
+	}
+	d, err := l.Check(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d <= 0 {
+		t.Fatal("account should be locked")
+	}
+	d, err = l.Check(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != 0 {
+		t.Fatal("other account should not be locked")
+	}
+
+	err = l.Succeed(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err = l.Check(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != 0 {
+		t.Fatal("account should be unlocked after succeed")
+	}
+}
+
+// remoteAddrConn a mock connection from the address
+type remoteAddrConn struct {
+	*mock.Conn
+	addr net.Addr
+}
+
+func (c *remoteAddrConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func TestClientIP(t *testing.T) {
+	err := InitTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = InitTrustedProxies(nil) })
+
+	for _, c := range []struct {
+		remote     string
+		forwardFor string
+		realIP     string
+		expect     string
+	}{
+		{remote: "1.2.3.4", forwardFor: "5.6.7.8", realIP: "5.6.7.8", expect: "1.2.3.4"}, // not from a proxy
+		{remote: "10.0.0.1", expect: "10.0.0.1"},
+		{remote: "10.0.0.1", realIP: "5.6.7.8", expect: "5.6.7.8"},
+		{remote: "10.0.0.1", forwardFor: "5.6.7.8", realIP: "9.9.9.9", expect: "5.6.7.8"},
+		{remote: "192.168.1.1", forwardFor: "6.6.6.6, 5.6.7.8, 10.0.0.2", expect: "5.6.7.8"}, // forged hop ignored
+		{remote: "10.0.0.1", forwardFor: "10.0.0.3, 10.0.0.2", expect: "10.0.0.3"},
+		{remote: "10.0.0.1", forwardFor: "forged, 10.0.0.2", expect: "10.0.0.2"},
+		{remote: "192.168.1.2", forwardFor: "5.6.7.8", expect: "192.168.1.2"},
+	} {
+		rc := app.NewContext(0)
+		rc.SetConn(&remoteAddrConn{Conn: mock.NewConn(""), addr: &net.TCPAddr{IP: net.ParseIP(c.remote), Port: 1234}})
+		if c.forwardFor != "" {
+			rc.Request.Header.Set("X-Forwarded-For", c.forwardFor)
+		}
+		if c.realIP != "" {
+			rc.Request.Header.Set("X-Real-IP", c.realIP)
+		}
+		if ip := ClientIP(rc); ip != c.expect {
+			t.Fatalf("%+v expect client ip %s, got %s", c, c.expect, ip)
+		}
+		if ip := rc.ClientIP(); ip != c.expect {
+			t.Fatalf("%+v expect request context client ip %s, got %s", c, c.expect, ip)
+		}
+	}
+
+	if InitTrustedProxies([]string{"not an ip"}) == nil {
+		t.Fatal("invalid trusted proxy should be rejected")
+	}
+}
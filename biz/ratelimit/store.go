@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store the storage of rate limit counters, implement it with a shared storage
+// so that the limits take effect across all the server instances
+type Store interface {
+	// Incr increase the counter of key by one inside a fixed window starting from the first increase,
+	// return the count after increasing and when the window ends
+	Incr(ctx context.Context, key string, window time.Duration) (int64, time.Time, error)
+	// Get get the counter of key and when its window ends, return zero count if no counter or the window ended
+	Get(ctx context.Context, key string) (int64, time.Time, error)
+	// Set set the counter of key with a new window
+	Set(ctx context.Context, key string, count int64, window time.Duration) error
+	// Delete delete the counter of key
+	Delete(ctx context.Context, key string) error
+}
+
+var defaultStore Store = NewMemoryStore()
+
+// InitDefaultStore set the store used by the rate limit middleware and lockouts, default is a MemoryStore
+func InitDefaultStore(s Store) {
+	defaultStore = s
+}
+
+// DefaultStore get the store used by the rate limit middleware and lockouts
+func DefaultStore() Store {
+	return defaultStore
+}
+
+/*********************** Memory Store ***********************/
+
+// memorySweepInterval how often the expired counters are removed from a MemoryStore
+const memorySweepInterval = time.Minute
+
+type memoryCounter struct {
+	count    int64
+	expireAt time.Time
+}
+
+// MemoryStore a Store keeps the counters in process memory, the limits are counted per server instance
+type MemoryStore struct {
+	mutex    sync.Mutex
+	counters map[string]*memoryCounter
+	sweptAt  time.Time
+	nowFunc  func() time.Time
+}
+
+// NewMemoryStore create an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters: make(map[string]*memoryCounter),
+		nowFunc:  time.Now,
+	}
+}
+
+// sweep remove the expired counters, must be called with the mutex held
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.sweptAt) < memorySweepInterval {
+		return
+	}
+	for k, c := range s.counters {
+		if !now.Before(c.expireAt) {
+			delete(s.counters, k)
+		}
+	}
+	s.sweptAt = now
+}
+
+func (s *MemoryStore) Incr(ctx context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.nowFunc()
+	s.sweep(now)
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.expireAt) {
+		c = &memoryCounter{expireAt: now.Add(window)}
+		s.counters[key] = c
+	}
+	c.count++
+	return c.count, c.expireAt, nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (int64, time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, ok := s.counters[key]
+	if !ok || !s.nowFunc().Before(c.expireAt) {
+		return 0, time.Time{}, nil
+	}
+	return c.count, c.expireAt, nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, key string, count int64, window time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.counters[key] = &memoryCounter{count: count, expireAt: s.nowFunc().Add(window)}
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.counters, key)
+	return nil
+}
@@ -0,0 +1,55 @@
+package realtime
+
+import (
+	"context"
+	"sync"
+)
+
+// Broker the transport of the events between the server instances, a payload published on any instance is
+// handed to the handlers subscribed on every instance, including the one published it. Implement it over a
+// shared message bus for a deployment of multiple instances, so that the members connected to other instances
+// are pushed the events too
+type Broker interface {
+	// Publish hand payload to the handlers subscribed on every instance
+	Publish(ctx context.Context, payload []byte) error
+	// Subscribe call handler with every payload published until unsubscribe is called,
+	// handler must not block, it is called from the goroutine delivering the payloads
+	Subscribe(handler func(payload []byte)) (unsubscribe func())
+}
+
+/*********************** Memory Broker ***********************/
+
+// MemoryBroker a Broker delivers the payloads inside the process, only the members connected to the same
+// server instance are pushed the events
+type MemoryBroker struct {
+	mutex    sync.RWMutex
+	nextID   uint64
+	handlers map[uint64]func(payload []byte)
+}
+
+// NewMemoryBroker create a MemoryBroker without subscriber
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{handlers: make(map[uint64]func(payload []byte))}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, payload []byte) error {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for _, handler := range b.handlers {
+		handler(payload)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(handler func(payload []byte)) func() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = handler
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.handlers, id)
+	}
+}
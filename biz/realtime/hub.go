@@ -0,0 +1,182 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventCheckIn        EventType = "check_in"        // a member logged the habit, with the record
+	EventGroupCompleted EventType = "group_completed" // all the members logged the habit on the log day
+	EventMemberJoined   EventType = "member_joined"   // the members joined the habit
+	EventMemberQuit     EventType = "member_quit"     // the members quit or were removed from the habit
+	EventHabitUpdated   EventType = "habit_updated"   // the name, identity or owner of the habit changed
+)
+
+// Event a change of a habit pushed to its members, it only carries what changed in brief,
+// clients pull the change feed for the rest
+type Event struct {
+	Type    EventType `json:"type"`
+	HabitID uint64    `json:"habit_id"`
+	// UID the user made the change
+	UID    dal.UID             `json:"uid"`
+	Record *dal.HabitLogRecord `json:"record,omitempty"`
+	// LogDay the day completed by the group
+	LogDay string `json:"log_day,omitempty"`
+	// Members the members joined or quit
+	Members []dal.UID `json:"members,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// envelope an event and its recipients as published through the broker
+type envelope struct {
+	Recipients []dal.UID `json:"recipients"`
+	Event      *Event    `json:"event"`
+}
+
+// subscriberBufferSize how many events a subscriber may fall behind before it is closed
+const subscriberBufferSize = 32
+
+// Subscriber receive the events pushed to a user on this server instance
+type Subscriber struct {
+	uid    dal.UID
+	events chan *Event
+	hub    *Hub
+}
+
+// Events the events pushed to the user, closed when the subscriber is closed, or when it falls too far
+// behind, the client should pull the change feed and subscribe again then
+func (s *Subscriber) Events() <-chan *Event {
+	return s.events
+}
+
+// Close stop receiving the events
+func (s *Subscriber) Close() {
+	s.hub.mutex.Lock()
+	defer s.hub.mutex.Unlock()
+	s.hub.remove(s)
+}
+
+// Hub route the events published through a broker to the subscribers of their recipients on this server instance
+type Hub struct {
+	broker      Broker
+	unsubscribe func()
+	mutex       sync.Mutex
+	subscribers map[dal.UID]map[*Subscriber]struct{}
+}
+
+// NewHub create a Hub subscribing to broker
+func NewHub(broker Broker) *Hub {
+	h := &Hub{
+		broker:      broker,
+		subscribers: make(map[dal.UID]map[*Subscriber]struct{}),
+	}
+	h.unsubscribe = broker.Subscribe(h.deliver)
+	return h
+}
+
+// Publish push an event to the subscribers of recipients on every server instance
+func (h *Hub) Publish(ctx context.Context, recipients []dal.UID, e *Event) error {
+	recipients = dedupeUIDs(recipients)
+	if len(recipients) == 0 {
+		return nil
+	}
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	payload, err := json.Marshal(&envelope{Recipients: recipients, Event: e})
+	if err != nil {
+		return err
+	}
+	return h.broker.Publish(ctx, payload)
+}
+
+// Subscribe receive the events pushed to uid until the subscriber is closed
+func (h *Hub) Subscribe(uid dal.UID) *Subscriber {
+	s := &Subscriber{uid: uid, events: make(chan *Event, subscriberBufferSize), hub: h}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	subs, ok := h.subscribers[uid]
+	if !ok {
+		subs = make(map[*Subscriber]struct{})
+		h.subscribers[uid] = subs
+	}
+	subs[s] = struct{}{}
+	return s
+}
+
+// Close stop receiving the events from the broker and close all the subscribers
+func (h *Hub) Close() {
+	h.unsubscribe()
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, subs := range h.subscribers {
+		for s := range subs {
+			h.remove(s)
+		}
+	}
+}
+
+// remove close a subscriber if not closed yet, must be called with the mutex held
+func (h *Hub) remove(s *Subscriber) {
+	subs := h.subscribers[s.uid]
+	if _, ok := subs[s]; !ok {
+		return
+	}
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(h.subscribers, s.uid)
+	}
+	close(s.events)
+}
+
+// deliver hand an event from the broker to the subscribers of its recipients, a subscriber whose buffer is full
+// is closed rather than blocking the others
+func (h *Hub) deliver(payload []byte) {
+	var env envelope
+	if err := json.Unmarshal(payload, &env); err != nil || env.Event == nil {
+		return // not an event of this version
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, uid := range env.Recipients {
+		for s := range h.subscribers[uid] {
+			select {
+			case s.events <- env.Event:
+			default:
+				h.remove(s)
+			}
+		}
+	}
+}
+
+// dedupeUIDs remove the repeated uids, so that a recipient is pushed an event once
+func dedupeUIDs(uids []dal.UID) []dal.UID {
+	seen := make(map[dal.UID]bool, len(uids))
+	deduped := make([]dal.UID, 0, len(uids))
+	for _, uid := range uids {
+		if !seen[uid] {
+			seen[uid] = true
+			deduped = append(deduped, uid)
+		}
+	}
+	return deduped
+}
+
+var defaultHub = NewHub(NewMemoryBroker())
+
+// InitDefaultHub set the hub the habit events are published to and subscribed from, default is a hub over
+// a MemoryBroker
+func InitDefaultHub(h *Hub) {
+	defaultHub = h
+}
+
+// DefaultHub get the hub the habit events are published to and subscribed from
+func DefaultHub() *Hub {
+	return defaultHub
+}
@@ -0,0 +1,90 @@
+package realtime
+
+import (
+	"context"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"testing"
+)
+
+func receive(t *testing.T, s *Subscriber) *Event {
+	t.Helper()
+	select {
+	case e, ok := <-s.Events():
+		if !ok {
+			t.Fatal("subscriber should not be closed")
+		}
+		return e
+	default:
+		t.Fatal("expect an event")
+	}
+	return nil
+}
+
+func TestHub(t *testing.T) {
+	ctx := context.Background()
+	broker := NewMemoryBroker()
+	// the hubs of two server instances sharing the broker
+	hub1, hub2 := NewHub(broker), NewHub(broker)
+	defer hub2.Close()
+
+	alice := hub1.Subscribe("alice")
+	aliceOtherDevice := hub2.Subscribe("alice")
+	bob := hub2.Subscribe("bob")
+	carol := hub1.Subscribe("carol")
+
+	err := hub1.Publish(ctx, []dal.UID{"alice", "bob", "alice"}, &Event{Type: EventCheckIn, HabitID: 1, UID: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*Subscriber{alice, aliceOtherDevice, bob} {
+		e := receive(t, s)
+		if e.Type != EventCheckIn || e.HabitID != 1 || e.UID != "bob" || e.At.IsZero() {
+			t.Fatalf("unexpected event %+v", e)
+		}
+		if len(s.Events()) != 0 {
+			t.Fatal("recipient should receive the event once")
+		}
+	}
+	if len(carol.Events()) != 0 {
+		t.Fatal("only the recipients should receive the event")
+	}
+
+	// a closed subscriber receives nothing more
+	bob.Close()
+	bob.Close()
+	if _, ok := <-bob.Events(); ok {
+		t.Fatal("closed subscriber should not receive")
+	}
+	err = hub2.Publish(ctx, []dal.UID{"bob"}, &Event{Type: EventHabitUpdated, HabitID: 1, UID: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a subscriber too far behind is closed rather than blocking the others
+	for i := 0; i <= subscriberBufferSize; i++ {
+		err = hub1.Publish(ctx, []dal.UID{"carol"}, &Event{Type: EventCheckIn, HabitID: 1, UID: "alice"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < subscriberBufferSize; i++ {
+		receive(t, carol)
+	}
+	if _, ok := <-carol.Events(); ok {
+		t.Fatal("lagged subscriber should be closed")
+	}
+
+	// a closed hub stops receiving from the broker
+	hub1.Close()
+	if _, ok := <-alice.Events(); ok {
+		t.Fatal("subscriber should be closed with the hub")
+	}
+	err = hub2.Publish(ctx, []dal.UID{"alice"}, &Event{Type: EventMemberQuit, HabitID: 1, UID: "alice", Members: []dal.UID{"alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := receive(t, aliceOtherDevice)
+	if e.Type != EventMemberQuit || len(e.Members) != 1 {
+		t.Fatalf("unexpected event %+v", e)
+	}
+}
@@ -2,6 +2,7 @@ package response
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/cloudwego/hertz/pkg/app"
 	"github.com/cloudwego/hertz/pkg/common/hlog"
@@ -20,6 +21,9 @@ const (
 	ErrorCode_InvalidParam         ErrorCode = 1001
 	ErrorCode_UserAuthFail         ErrorCode = 1101
 	ErrorCode_UserNoPermission     ErrorCode = 1102
+	ErrorCode_TooManyRequests      ErrorCode = 1201
+	ErrorCode_RequestTimeout       ErrorCode = 1301
+	ErrorCode_RequestInProgress    ErrorCode = 1401
 	ErrroCode_InternalUnknownError ErrorCode = 9999
 )
 
@@ -169,11 +173,20 @@ func (r *HTTPResponse) SetError(err error) {
 			r.statusCode = http.StatusBadRequest
 		case ErrorCode_UserAuthFail, ErrorCode_UserNoPermission:
 			r.statusCode = http.StatusForbidden
+		case ErrorCode_TooManyRequests:
+			r.statusCode = http.StatusTooManyRequests
+		case ErrorCode_RequestInProgress:
+			r.statusCode = http.StatusConflict
 		default:
 			r.statusCode = http.StatusInternalServerError
 		}
 		r.body.Meta.Status = int(sErr.ErrorCode())
 		r.body.Meta.Message = sErr.Message()
+		if errors.Is(sErr.Cause(), context.DeadlineExceeded) { // the request runs out of its deadline
+			r.statusCode = http.StatusServiceUnavailable
+			r.body.Meta.Status = int(ErrorCode_RequestTimeout)
+			r.body.Meta.Message = "request timeout"
+		}
 	default:
 		r.statusCode = http.StatusInternalServerError
 		r.body.Meta.Status = int(ErrroCode_InternalUnknownError)
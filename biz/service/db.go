@@ -1,14 +1,36 @@
 package service
 
 import (
+	"errors"
+	"fmt"
+	gosqlite "github.com/glebarez/go-sqlite"
+	"github.com/glebarez/sqlite"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 var globalDBExecutor *gorm.DB
 
-func InitDB(dsn string) error {
-	db, err := gorm.Open(mysql.Open(dsn))
+// InitDB initialize the global db executor with the driver, mysql, postgres or sqlite, mysql is used if driver is empty
+func InitDB(driver string, dsn string) error {
+	switch driver {
+	case "", "mysql":
+		return InitDBWithDialector(mysql.Open(dsn))
+	case "postgres":
+		return InitDBWithDialector(postgres.Open(dsn))
+	case "sqlite":
+		return InitDBWithDialector(sqlite.Open(dsn))
+	default:
+		return fmt.Errorf("unknown database driver %s", driver)
+	}
+}
+
+// InitDBWithDialector initialize the global db executor with a specific gorm dialector
+func InitDBWithDialector(dialector gorm.Dialector) error {
+	db, err := gorm.Open(dialector)
 	if err != nil {
 		return err
 	}
@@ -19,3 +41,39 @@ func InitDB(dsn string) error {
 func GetDBExecutor() *gorm.DB {
 	return globalDBExecutor
 }
+
+// IsRetryableTxError whether err is from a transaction aborted by a deadlock or a lock wait timeout,
+// which may succeed if the transaction is run again
+func IsRetryableTxError(err error) bool {
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205 // ER_LOCK_DEADLOCK, ER_LOCK_WAIT_TIMEOUT
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "40P01" || pgErr.Code == "40001" // deadlock_detected, serialization_failure
+	}
+	var sqliteErr *gosqlite.Error
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code() & 0xff // the primary result code of an extended one
+		return code == 5 || code == 6   // SQLITE_BUSY, SQLITE_LOCKED
+	}
+	return false
+}
+
+// IsDuplicateKeyError whether err is from a violation of a primary key or unique constraint
+func IsDuplicateKeyError(err error) bool {
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062 // ER_DUP_ENTRY
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505" // unique_violation
+	}
+	var sqliteErr *gosqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == 1555 || sqliteErr.Code() == 2067 // SQLITE_CONSTRAINT_PRIMARYKEY, SQLITE_CONSTRAINT_UNIQUE
+	}
+	return false
+}
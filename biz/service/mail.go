@@ -1,13 +1,22 @@
 package service
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
 type MailService interface {
 	Sender() string
-	SendMail(toMail []string, content []byte) error
+	// SendMail send the mail content to the target mail list, give up when ctx is done
+	SendMail(ctx context.Context, toMail []string, content []byte) error
 }
 
 // mailService default implement of MailService
@@ -41,15 +50,146 @@ func InitMailService(identity string, fromMail string, password string, mailHost
 	return nil
 }
 
-// SendMail send email to target mail list with content
-func (m *mailService) SendMail(toMail []string, content []byte) error {
-	err := smtp.SendMail(m.mailHostPort, m.auth, m.fromMail, toMail, content)
+// InitMailServiceWithImpl initialize mail service with a custom implement, like a fake one for test
+func InitMailServiceWithImpl(m MailService) {
+	defaultMailService = m
+}
+
+// SendMail send email to target mail list with content, like smtp.SendMail but the connection is closed
+// when ctx is done
+func (m *mailService) SendMail(ctx context.Context, toMail []string, content []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.mailHostPort)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now()) // unblock the pending read or write
+		case <-done:
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, m.mailHost)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: m.mailHost})
+		if err != nil {
+			return err
+		}
+	}
+	if ok, _ := c.Extension("AUTH"); ok && m.auth != nil {
+		err = c.Auth(m.auth)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.Mail(m.fromMail)
+	if err != nil {
+		return err
+	}
+	for _, to := range toMail {
+		err = c.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	err = c.Quit()
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
 }
 
 func (m *mailService) Sender() string {
 	return m.fromMail
 }
+
+// fileMailService a MailService writes every mail as an .eml file into a dir, for local run
+type fileMailService struct {
+	fromMail string
+	dir      string
+}
+
+// InitFileMailService initialize mail service with a sink writing mails into dir instead of sending them
+func InitFileMailService(fromMail string, dir string) error {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+	defaultMailService = &fileMailService{fromMail: fromMail, dir: dir}
+	return nil
+}
+
+func (m *fileMailService) SendMail(ctx context.Context, toMail []string, content []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.Join(toMail, ","))
+	return os.WriteFile(filepath.Join(m.dir, filepath.Base(name)), content, 0o644)
+}
+
+func (m *fileMailService) Sender() string {
+	return m.fromMail
+}
+
+// SentMail a mail kept by a MemoryMailService
+type SentMail struct {
+	To      []string
+	Content []byte
+}
+
+// MemoryMailService a MailService keeps all the mails in memory instead of sending them, for test
+type MemoryMailService struct {
+	fromMail string
+	mutex    sync.Mutex
+	mails    []*SentMail
+}
+
+// NewMemoryMailService create an empty MemoryMailService
+func NewMemoryMailService(fromMail string) *MemoryMailService {
+	return &MemoryMailService{fromMail: fromMail}
+}
+
+func (m *MemoryMailService) SendMail(ctx context.Context, toMail []string, content []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.mails = append(m.mails, &SentMail{To: toMail, Content: content})
+	return nil
+}
+
+func (m *MemoryMailService) Sender() string {
+	return m.fromMail
+}
+
+// Mails get all the mails sent so far
+func (m *MemoryMailService) Mails() []*SentMail {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]*SentMail(nil), m.mails...)
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestFileMailService(t *testing.T) {
+	dir := path.Join(t.TempDir(), "mails")
+	err := InitFileMailService("noreply@test.com", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GetMailExecutor().SendMail(context.Background(), []string{"u1@test.com"}, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expect 1 mail file, got %d", len(entries))
+	}
+	data, err := os.ReadFile(path.Join(dir, entries[0].Name()))
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("unexpected mail file content %q, err=%v", data, err)
+	}
+}
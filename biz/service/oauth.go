@@ -0,0 +1,380 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OAuthProviderOption the options of an OAuth2 / OpenID Connect provider
+type OAuthProviderOption struct {
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	// JWKSURL and Issuer are used to verify the id token of an OpenID Connect provider
+	JWKSURL string
+	Issuer  string
+	// UserInfoURL is used to get the user identity from a plain OAuth2 provider which issues no id token, like GitHub
+	UserInfoURL string
+	// SubjectField the field of the user info response used as the subject, default is "sub"
+	SubjectField string
+	RedirectURI  string
+	Scopes       []string
+}
+
+// OAuthIdentity the identity of a user inside an OAuth provider
+type OAuthIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+type OAuthProvider interface {
+	// AuthCodeURL build the url to redirect the user to for the authorization code with PKCE
+	AuthCodeURL(state string, codeChallenge string, nonce string) string
+	// Exchange exchange an authorization code for the identity of the user,
+	// the id token is verified against the provider JWKS and the nonce if the provider is an OpenID Connect provider
+	Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*OAuthIdentity, error)
+}
+
+var oauthProviders = map[string]OAuthProvider{}
+
+// GetOAuthProvider get a configured OAuth provider by name
+func GetOAuthProvider(name string) (OAuthProvider, bool) {
+	p, ok := oauthProviders[name]
+	return p, ok
+}
+
+// oauthRequestTimeout the timeout of a request to an OAuth provider
+const oauthRequestTimeout = time.Second * 10
+
+// InitOAuthProviders initialize all the OAuth providers by name
+func InitOAuthProviders(options map[string]*OAuthProviderOption) error {
+	providers := make(map[string]OAuthProvider, len(options))
+	for name, option := range options {
+		if option.ClientID == "" || option.AuthURL == "" || option.TokenURL == "" {
+			return fmt.Errorf("oauth provider %s missing client id, auth url or token url", name)
+		}
+		if option.JWKSURL == "" && option.UserInfoURL == "" {
+			return fmt.Errorf("oauth provider %s needs either jwks url or user info url", name)
+		}
+		client := &http.Client{Timeout: oauthRequestTimeout}
+		providers[name] = &oauthProvider{
+			option: option,
+			client: client,
+			jwks:   &jwksCache{url: option.JWKSURL, client: client},
+		}
+	}
+	oauthProviders = providers
+	return nil
+}
+
+// oauthProvider default implement of OAuthProvider
+type oauthProvider struct {
+	option *OAuthProviderOption
+	client *http.Client
+	jwks   *jwksCache
+}
+
+func (p *oauthProvider) AuthCodeURL(state string, codeChallenge string, nonce string) string {
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.option.ClientID)
+	query.Set("redirect_uri", p.option.RedirectURI)
+	query.Set("state", state)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	if len(p.option.Scopes) > 0 {
+		query.Set("scope", strings.Join(p.option.Scopes, " "))
+	}
+	if p.option.JWKSURL != "" {
+		query.Set("nonce", nonce)
+	}
+	sep := "?"
+	if strings.Contains(p.option.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.option.AuthURL + sep + query.Encode()
+}
+
+type oauthTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (p *oauthProvider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*OAuthIdentity, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.option.RedirectURI)
+	form.Set("client_id", p.option.ClientID)
+	form.Set("client_secret", p.option.ClientSecret)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.option.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	tokenResp := &oauthTokenResponse{}
+	err = p.doJSON(req, tokenResp)
+	if err != nil {
+		return nil, err
+	}
+	if tokenResp.Error != "" {
+		return nil, fmt.Errorf("exchange code fail, error=%s, description=%s", tokenResp.Error, tokenResp.ErrorDescription)
+	}
+
+	if p.option.JWKSURL != "" {
+		if tokenResp.IDToken == "" {
+			return nil, fmt.Errorf("no id token returned")
+		}
+		return p.verifyIDToken(ctx, tokenResp.IDToken, nonce)
+	}
+	if tokenResp.AccessToken == "" {
+		return nil, fmt.Errorf("no access token returned")
+	}
+	return p.fetchUserInfo(ctx, tokenResp.AccessToken)
+}
+
+func (p *oauthProvider) doJSON(req *http.Request, v interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest { // 400 carries oauth error
+		return fmt.Errorf("%s %s http status %d", req.Method, req.URL.Host, resp.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// flexBool a bool which can be decoded from both json bool and string, Apple returns email_verified as string
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	*b = flexBool(s == "true")
+	return nil
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified flexBool `json:"email_verified"`
+}
+
+func (p *oauthProvider) verifyIDToken(ctx context.Context, idToken string, nonce string) (*OAuthIdentity, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodRSAPSS:
+		default:
+			return nil, fmt.Errorf("unexpected id token signing method %s", token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.jwks.getKey(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(p.option.Issuer, true) {
+		return nil, fmt.Errorf("unexpected id token issuer %s", claims.Issuer)
+	}
+	if !claims.VerifyAudience(p.option.ClientID, true) {
+		return nil, fmt.Errorf("unexpected id token audience %v", claims.Audience)
+	}
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("id token has no expire time")
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("id token nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("id token has no subject")
+	}
+	return &OAuthIdentity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+	}, nil
+}
+
+func (p *oauthProvider) fetchUserInfo(ctx context.Context, accessToken string) (*OAuthIdentity, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.option.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	info := map[string]interface{}{}
+	err = p.doJSON(req, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	subjectField := p.option.SubjectField
+	if subjectField == "" {
+		subjectField = "sub"
+	}
+	var subject string
+	switch v := info[subjectField].(type) {
+	case string:
+		subject = v
+	case float64: // numeric id, like GitHub
+		subject = big.NewFloat(v).Text('f', 0)
+	}
+	if subject == "" {
+		return nil, fmt.Errorf("user info has no %s field", subjectField)
+	}
+	email, _ := info["email"].(string)
+	emailVerified, _ := info["email_verified"].(bool)
+	return &OAuthIdentity{Subject: subject, Email: email, EmailVerified: emailVerified}, nil
+}
+
+/********************** JWKS Cache ***********************/
+
+// jwksRefreshInterval the min interval to refetch the JWKS, to avoid unknown kids trigger too many fetches
+const jwksRefreshInterval = time.Minute * 5
+
+// jwksCache cache the public keys of a JWKS endpoint by kid, refetch when an unknown kid is met
+type jwksCache struct {
+	url       string
+	client    *http.Client
+	mutex     sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (c *jwksCache) getKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(c.fetchedAt) < jwksRefreshInterval && c.keys != nil {
+		return nil, fmt.Errorf("unknown jwks kid %s", kid)
+	}
+	err := c.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown jwks kid %s", kid)
+}
+
+func (c *jwksCache) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch jwks http status %d", resp.StatusCode)
+	}
+	set := struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			continue // skip unsupported keys
+		}
+		keys[k.Kid] = key
+	}
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	return nil
+}
+
+func decodeBase64URLInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBase64URLInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBase64URLInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBase64URLInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBase64URLInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
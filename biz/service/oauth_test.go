@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestOAuthExchangeIDToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer jwks.Close()
+
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.PostForm.Get("code") != "good-code" || r.PostForm.Get("code_verifier") != "verifier" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss":            "https://issuer.example.com",
+			"aud":            "client",
+			"sub":            "subject-1",
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"nonce":          "nonce",
+			"email":          "a@example.com",
+			"email_verified": "true",
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "key-1"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "id_token": idToken})
+	}))
+	defer tokenEndpoint.Close()
+
+	err = InitOAuthProviders(map[string]*OAuthProviderOption{
+		"test": {
+			ClientID: "client",
+			AuthURL:  "https://issuer.example.com/authorize",
+			TokenURL: tokenEndpoint.URL,
+			JWKSURL:  jwks.URL,
+			Issuer:   "https://issuer.example.com",
+			Scopes:   []string{"openid", "email"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := GetOAuthProvider("test")
+	if !ok {
+		t.Fatal("provider not found")
+	}
+
+	authURL, err := url.Parse(p.AuthCodeURL("state", "challenge", "nonce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := authURL.Query()
+	if q.Get("state") != "state" || q.Get("code_challenge") != "challenge" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("nonce") != "nonce" || q.Get("scope") != "openid email" {
+		t.Fatalf("unexpected auth url %s", authURL)
+	}
+
+	identity, err := p.Exchange(context.Background(), "good-code", "verifier", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Subject != "subject-1" || identity.Email != "a@example.com" || !identity.EmailVerified {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+
+	_, err = p.Exchange(context.Background(), "good-code", "verifier", "other-nonce")
+	if err == nil {
+		t.Fatal("nonce mismatch should fail")
+	}
+
+	_, err = p.Exchange(context.Background(), "bad-code", "verifier", "nonce")
+	if err == nil {
+		t.Fatal("invalid code should fail")
+	}
+}
+
+func TestOAuthExchangeUserInfo(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			_, _ = w.Write([]byte(`{"access_token":"access"}`))
+		case "/user":
+			if r.Header.Get("Authorization") != "Bearer access" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"id":12345678,"email":"a@example.com"}`))
+		}
+	}))
+	defer stub.Close()
+
+	err := InitOAuthProviders(map[string]*OAuthProviderOption{
+		"test": {
+			ClientID:     "client",
+			AuthURL:      stub.URL + "/authorize",
+			TokenURL:     stub.URL + "/token",
+			UserInfoURL:  stub.URL + "/user",
+			SubjectField: "id",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := GetOAuthProvider("test")
+	identity, err := p.Exchange(context.Background(), "code", "verifier", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Subject != "12345678" || identity.EmailVerified {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+}
+
+func TestInitOAuthProvidersMissingOption(t *testing.T) {
+	err := InitOAuthProviders(map[string]*OAuthProviderOption{
+		"test": {ClientID: "client", AuthURL: "https://a", TokenURL: "https://t"},
+	})
+	if err == nil {
+		t.Fatal("provider without jwks url or user info url should fail")
+	}
+}
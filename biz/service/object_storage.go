@@ -10,6 +10,7 @@ import (
 type ObjectStorage interface {
 	GetObject(ctx context.Context, key string) ([]byte, error)
 	PutObject(ctx context.Context, key string, data []byte) error
+	DeleteObject(ctx context.Context, key string) error
 	ObjectKeyToURL(key string) string
 }
 
@@ -47,6 +48,14 @@ func (s *objectStorageImplLocalMock) PutObject(ctx context.Context, key string,
 	return nil
 }
 
+func (s *objectStorageImplLocalMock) DeleteObject(ctx context.Context, key string) error {
+	err := os.Remove(path.Join(s.localStorageRoot, key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 func (s *objectStorageImplLocalMock) ObjectKeyToURL(key string) string {
 	uri, _ := url.JoinPath(s.urlPrefix, s.localStorageRoot, key)
 	return uri
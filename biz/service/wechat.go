@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// WechatSession the result of exchanging a mini-program login code
+type WechatSession struct {
+	OpenID     string `json:"openid"`
+	SessionKey string `json:"session_key"`
+	UnionID    string `json:"unionid"`
+	ErrCode    int    `json:"errcode"`
+	ErrMsg     string `json:"errmsg"`
+}
+
+type WechatService interface {
+	// Code2Session exchange a mini-program login code for the openid and session key of the user
+	Code2Session(ctx context.Context, code string) (*WechatSession, error)
+}
+
+// wechatService default implement of WechatService
+type wechatService struct {
+	appID           string
+	appSecret       string
+	code2SessionURL string
+	client          *http.Client
+}
+
+var defaultWechatService WechatService
+
+func GetWechatExecutor() WechatService {
+	return defaultWechatService
+}
+
+// wechatRequestTimeout the timeout of a request to wechat api
+const wechatRequestTimeout = time.Second * 5
+
+// InitWechatService initialize wechat service, currently no error returned
+func InitWechatService(appID string, appSecret string, code2SessionURL string) error {
+	defaultWechatService = &wechatService{
+		appID:           appID,
+		appSecret:       appSecret,
+		code2SessionURL: code2SessionURL,
+		client:          &http.Client{Timeout: wechatRequestTimeout},
+	}
+	return nil
+}
+
+// Code2Session an implement of WechatService.Code2Session
+func (w *wechatService) Code2Session(ctx context.Context, code string) (*WechatSession, error) {
+	query := url.Values{}
+	query.Set("appid", w.appID)
+	query.Set("secret", w.appSecret)
+	query.Set("js_code", code)
+	query.Set("grant_type", "authorization_code")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.code2SessionURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("code2session http status %d", resp.StatusCode)
+	}
+
+	session := &WechatSession{}
+	err = json.NewDecoder(resp.Body).Decode(session)
+	if err != nil {
+		return nil, err
+	}
+	if session.ErrCode != 0 {
+		return nil, fmt.Errorf("code2session fail, errcode=%d, errmsg=%s", session.ErrCode, session.ErrMsg)
+	}
+	if session.OpenID == "" {
+		return nil, fmt.Errorf("code2session fail, no openid returned")
+	}
+	return session, nil
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWechatCode2Session(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("appid") != "app" || q.Get("secret") != "secret" || q.Get("grant_type") != "authorization_code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if q.Get("js_code") != "good-code" {
+			_, _ = w.Write([]byte(`{"errcode":40029,"errmsg":"invalid code"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"openid":"openid-1","session_key":"key"}`))
+	}))
+	defer stub.Close()
+
+	err := InitWechatService("app", "secret", stub.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := GetWechatExecutor().Code2Session(context.Background(), "good-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.OpenID != "openid-1" || session.SessionKey != "key" {
+		t.Fatalf("unexpected session %+v", session)
+	}
+
+	_, err = GetWechatExecutor().Code2Session(context.Background(), "bad-code")
+	if err == nil {
+		t.Fatal("invalid code should fail")
+	}
+}
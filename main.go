@@ -3,33 +3,252 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
 	"github.com/hertz-contrib/cors"
 	"github.com/swordandtea/lets-habit-server/biz/config"
+	"github.com/swordandtea/lets-habit-server/biz/controller"
+	"github.com/swordandtea/lets-habit-server/biz/dal"
+	"github.com/swordandtea/lets-habit-server/biz/emailcheck"
 	"github.com/swordandtea/lets-habit-server/biz/handler"
+	"github.com/swordandtea/lets-habit-server/biz/idempotency"
+	"github.com/swordandtea/lets-habit-server/biz/mailqueue"
+	"github.com/swordandtea/lets-habit-server/biz/mailtemplate"
+	"github.com/swordandtea/lets-habit-server/biz/ratelimit"
+	"github.com/swordandtea/lets-habit-server/biz/realtime"
 	"github.com/swordandtea/lets-habit-server/biz/service"
+	"os"
 	"time"
 )
 
-func Init() {
+// initConfigAndDB load the config and connect to the database, which are all the migrate command needs
+func initConfigAndDB() {
 	if err := config.InitConfig("conf/config.yaml"); err != nil {
 		panic(err)
 	}
 
-	mysqlConf := config.GlobalConfig.Mysql
-	if err := service.InitDB(mysqlConf.DSN); err != nil {
+	dbConf := config.GlobalConfig.Database
+	if err := service.InitDB(dbConf.Driver, dbConf.DSN); err != nil {
 		panic(err)
 	}
+}
+
+func Init() {
+	initConfigAndDB()
+
+	if config.GlobalConfig.RunMode == config.RunModeLocal && config.GlobalConfig.Database.AutoMigrate {
+		if err := autoMigrate(); err != nil {
+			panic(err)
+		}
+	}
 
 	mailServiceConf := config.GlobalConfig.EmailService
-	if err := service.InitMailService("", mailServiceConf.Sender, mailServiceConf.AuthCode, mailServiceConf.Host, mailServiceConf.Port); err != nil {
+	switch mailServiceConf.Sink {
+	case "", config.MailSinkSMTP:
+		if err := service.InitMailService("", mailServiceConf.Sender, mailServiceConf.AuthCode, mailServiceConf.Host, mailServiceConf.Port); err != nil {
+			panic(err)
+		}
+	case config.MailSinkFile:
+		if err := service.InitFileMailService(mailServiceConf.Sender, mailServiceConf.SinkDir); err != nil {
+			panic(err)
+		}
+	case config.MailSinkMemory:
+		service.InitMailServiceWithImpl(service.NewMemoryMailService(mailServiceConf.Sender))
+	default:
+		panic(fmt.Sprintf("unknown mail sink %s", mailServiceConf.Sink))
+	}
+	if err := mailtemplate.InitDefaultRenderer(mailServiceConf.TemplateDir, mailServiceConf.DefaultLanguage); err != nil {
+		panic(err)
+	}
+
+	validationConf := config.GlobalConfig.EmailValidation
+	policy := emailcheck.Policy(validationConf.Policy)
+	if policy == "" {
+		policy = emailcheck.PolicyDisposable
+	}
+	var resolver emailcheck.MXResolver
+	if policy == emailcheck.PolicyMX {
+		ttl := validationConf.MXCacheTTL
+		if ttl <= 0 {
+			ttl = time.Hour
+		}
+		resolver = emailcheck.NewCachedResolver(&emailcheck.NetResolver{}, ttl)
+	}
+	emailValidator, err := emailcheck.NewValidator(policy, resolver)
+	if err != nil {
+		panic(err)
+	}
+	emailcheck.InitDefaultValidator(emailValidator)
+
+	wechatConf := config.GlobalConfig.Wechat
+	if err := service.InitWechatService(wechatConf.AppID, wechatConf.AppSecret, wechatConf.Code2SessionURL); err != nil {
+		panic(err)
+	}
+
+	oauthProviderOptions := make(map[string]*service.OAuthProviderOption)
+	for name, c := range config.GlobalConfig.OAuthProviders {
+		if c == nil || c.ClientID == "" {
+			continue // provider not configured in this environment
+		}
+		oauthProviderOptions[name] = &service.OAuthProviderOption{
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			AuthURL:      c.AuthURL,
+			TokenURL:     c.TokenURL,
+			JWKSURL:      c.JWKSURL,
+			Issuer:       c.Issuer,
+			UserInfoURL:  c.UserInfoURL,
+			SubjectField: c.SubjectField,
+			RedirectURI:  c.RedirectURI,
+			Scopes:       c.Scopes,
+		}
+	}
+	if err := service.InitOAuthProviders(oauthProviderOptions); err != nil {
+		panic(err)
+	}
+
+	if err := ratelimit.InitTrustedProxies(config.GlobalConfig.Server.TrustedProxies); err != nil {
 		panic(err)
 	}
+	switch config.GlobalConfig.RateLimit.Store {
+	case "", config.RateLimitStoreMemory:
+		ratelimit.InitDefaultStore(ratelimit.NewMemoryStore())
+	case config.RateLimitStoreDB:
+		ratelimit.InitDefaultStore(ratelimit.NewDBStore(service.GetDBExecutor()))
+	default:
+		panic(fmt.Sprintf("unknown rate limit store %s", config.GlobalConfig.RateLimit.Store))
+	}
+
+	switch config.GlobalConfig.Idempotency.Store {
+	case "", config.IdempotencyStoreMemory:
+		idempotency.InitDefaultStore(idempotency.NewMemoryStore())
+	case config.IdempotencyStoreDB:
+		idempotency.InitDefaultStore(idempotency.NewDBStore(service.GetDBExecutor()))
+	default:
+		panic(fmt.Sprintf("unknown idempotency store %s", config.GlobalConfig.Idempotency.Store))
+	}
+
+	switch config.GlobalConfig.Realtime.Broker {
+	case "", config.RealtimeBrokerMemory:
+		realtime.InitDefaultHub(realtime.NewHub(realtime.NewMemoryBroker()))
+	default:
+		panic(fmt.Sprintf("unknown realtime broker %s", config.GlobalConfig.Realtime.Broker))
+	}
+}
+
+// rateLimitCleanInterval how often to remove the expired rate limit counters from db
+const rateLimitCleanInterval = time.Hour
+
+// runRateLimitCleaner periodically remove the expired rate limit counters from db
+func runRateLimitCleaner(store *ratelimit.DBStore) {
+	ticker := time.NewTicker(rateLimitCleanInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := store.DeleteExpired(context.Background()); err != nil {
+			hlog.Errorf("delete expired rate limit counters fail, err=%v", err)
+		}
+	}
+}
+
+// idempotencyCleanInterval how often to remove the expired idempotency keys from db
+const idempotencyCleanInterval = time.Hour
+
+// runIdempotencyCleaner periodically remove the expired idempotency keys from db
+func runIdempotencyCleaner(store *idempotency.DBStore) {
+	ticker := time.NewTicker(idempotencyCleanInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := store.DeleteExpired(context.Background()); err != nil {
+			hlog.Errorf("delete expired idempotency keys fail, err=%v", err)
+		}
+	}
+}
+
+// tombstonePurgeInterval how often to remove the tombstones older than controller.TombstoneRetention from db
+const tombstonePurgeInterval = time.Hour
+
+// runTombstonePurger periodically remove the tombstones of the deleted habits, groups, configs and log records
+// no client is expected to pull any more
+func runTombstonePurger() {
+	ticker := time.NewTicker(tombstonePurgeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sErr := dal.PurgeTombstones(service.GetDBExecutor(), time.Now().Add(-controller.TombstoneRetention))
+		if sErr != nil {
+			hlog.Errorf("purge tombstones fail, err=%v", sErr)
+		}
+	}
+}
+
+// outboxPurgeInterval how often to remove the sent and dead mails older than mailqueue.FinishedRetention from db
+const outboxPurgeInterval = time.Hour
+
+// runOutboxPurger periodically remove the sent and dead mails from the outbox
+func runOutboxPurger() {
+	ticker := time.NewTicker(outboxPurgeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		_, sErr := dal.OutboxMailDBHD.PurgeFinished(service.GetDBExecutor(), time.Now().Add(-mailqueue.FinishedRetention))
+		if sErr != nil {
+			hlog.Errorf("purge finished outbox mails fail, err=%v", sErr)
+		}
+	}
+}
+
+// accountPurgeInterval how often to erase the accounts whose delete grace period ended
+const accountPurgeInterval = time.Hour
+
+// runAccountPurger periodically erase the accounts whose delete grace period ended
+func runAccountPurger() {
+	userCtrl := controller.NewUserCtrl(controller.NewRepositories())
+	ticker := time.NewTicker(accountPurgeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		count, sErr := userCtrl.PurgeDeletedAccounts(context.Background(), time.Now().UTC())
+		if sErr != nil {
+			hlog.Errorf("purge deleted accounts fail, purged: %d, err=%v", count, sErr)
+			continue
+		}
+		if count > 0 {
+			hlog.Infof("purged %d deleted accounts", count)
+		}
+	}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		initConfigAndDB()
+		if err := runMigrateCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	Init()
 
+	if config.GlobalConfig.Account.DeleteGracePeriod > 0 {
+		go runAccountPurger()
+	}
+	go runTombstonePurger()
+	go runOutboxPurger()
+	if store, ok := ratelimit.DefaultStore().(*ratelimit.DBStore); ok {
+		go runRateLimitCleaner(store)
+	}
+	if store, ok := idempotency.DefaultStore().(*idempotency.DBStore); ok {
+		go runIdempotencyCleaner(store)
+	}
+	queueConf := config.GlobalConfig.EmailService.Queue
+	mailWorker := mailqueue.NewWorker(service.GetDBExecutor(), service.GetMailExecutor(), mailqueue.WorkerOption{
+		PollInterval: queueConf.PollInterval,
+		MaxAttempts:  queueConf.MaxAttempts,
+		BaseDelay:    queueConf.BaseDelay,
+		MaxDelay:     queueConf.MaxDelay,
+	})
+	go mailWorker.Run(context.Background())
+
 	h := server.Default()
 	var allowOrigins []string
 	switch config.GlobalConfig.RunMode {
@@ -41,12 +260,24 @@ func main() {
 	h.Use(cors.New(cors.Config{
 		AllowOrigins:     allowOrigins,
 		AllowMethods:     []string{"GET", "PUT", "POST", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type", handler.UserTokenHeader},
-		ExposeHeaders:    []string{"Content-Length", handler.UserTokenHeader},
+		AllowHeaders:     []string{"Origin", "Content-Type", handler.UserTokenHeader, handler.DeviceNameHeader, handler.IdempotencyKeyHeader},
+		ExposeHeaders:    []string{"Content-Length", "Retry-After", handler.UserTokenHeader, handler.RefreshTokenHeader, handler.IdempotentReplayedHeader},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
+	serverConf := config.GlobalConfig.Server
+	h.Use(handler.Deadline(serverConf.RequestTimeout, serverConf.RouteTimeouts))
+
 	register(h)
+	registered := make(map[string]bool)
+	for _, route := range h.Routes() {
+		registered[handler.RouteKey(route.Method, route.Path)] = true
+	}
+	for route := range serverConf.RouteTimeouts {
+		if !registered[route] {
+			panic(fmt.Sprintf("unknown route %s in route timeouts", route))
+		}
+	}
 	h.Spin()
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/swordandtea/lets-habit-server/biz/migration"
+	"github.com/swordandtea/lets-habit-server/biz/service"
+	"strconv"
+)
+
+const migrateUsage = `usage: migrate <command>
+commands:
+  up [version]   apply the pending migrations until version, all if version not given
+  down [steps]   revert the latest steps applied migrations, 1 if steps not given
+  status         list the migrations and whether they are applied`
+
+// autoMigrate apply all the pending migrations
+func autoMigrate() error {
+	migrator, err := migration.NewDefaultMigrator(service.GetDBExecutor())
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up(0)
+	for _, m := range applied {
+		hlog.Infof("applied migration %d_%s", m.Version, m.Name)
+	}
+	return err
+}
+
+// runMigrateCommand run the migrate subcommand with its args
+func runMigrateCommand(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New(migrateUsage)
+	}
+	migrator, err := migration.NewDefaultMigrator(service.GetDBExecutor())
+	if err != nil {
+		return err
+	}
+
+	var n uint64
+	if len(args) == 2 {
+		n, err = strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid argument %s\n%s", args[1], migrateUsage)
+		}
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(uint(n))
+		for _, m := range applied {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "down":
+		if len(args) == 1 {
+			n = 1
+		}
+		reverted, err := migrator.Down(int(n))
+		for _, m := range reverted {
+			fmt.Printf("reverted %d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		versions, err := migrator.Applied()
+		if err != nil {
+			return err
+		}
+		appliedAt := make(map[uint]string, len(versions))
+		for _, v := range versions {
+			appliedAt[v.Version] = v.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		for _, m := range migrator.Migrations() {
+			status, ok := appliedAt[m.Version]
+			if !ok {
+				status = "pending"
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, status)
+		}
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
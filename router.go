@@ -4,41 +4,121 @@ package main
 
 import (
 	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/swordandtea/lets-habit-server/biz/config"
+	"github.com/swordandtea/lets-habit-server/biz/controller"
 	"github.com/swordandtea/lets-habit-server/biz/handler"
 )
 
 // customizeRegister registers customize routers.
 func customizedRegister(r *server.Hertz) {
 	r.GET("/ping", handler.Ping)
+	r.GET("/debug/vars", handler.LoopbackOnly(), handler.Metrics)
 
 	// your code ...
 	apiV1 := r.Group("api/v1")
+	repos := controller.NewRepositories()
 
 	// register user related api
-	userRouter := handler.NewUserRouter()
+	userRouter := handler.NewUserRouter(repos)
 	{
-		apiV1.GET("/user", handler.UserTokenVerify(), userRouter.GetUserInfoByAuth)
-		apiV1.GET("/user/ping", handler.UserTokenVerify())
-		apiV1.POST("/user/register/email", userRouter.RegisterByEmail)
+		apiV1.GET("/user", handler.UserTokenVerify(repos), userRouter.GetUserInfoByAuth)
+		apiV1.GET("/user/ping", handler.UserTokenVerify(repos))
+		apiV1.POST("/user/register/email", handler.RateLimit(handler.RegisterIPPolicy, handler.EmailSendTargetPolicy), userRouter.RegisterByEmail)
 		//apiV1.GET("/user/register/email/activate/check", userRouter.CheckEmailActivated)
-		apiV1.POST("/user/register/email/activate/resend", handler.UserTokenVerify(), userRouter.ResendActivateEmail)
+		apiV1.POST("/user/register/email/activate/resend", handler.UserTokenVerify(repos), handler.RateLimit(handler.EmailSendIPPolicy, handler.EmailSendUserPolicy), userRouter.ResendActivateEmail)
 		apiV1.POST("/user/register/email/activate", userRouter.ActivateEmail)
-		apiV1.POST("/user/login/email", userRouter.LoginByEmail)
+		apiV1.POST("/user/login/email", handler.RateLimit(handler.LoginIPPolicy), userRouter.LoginByEmail)
+		apiV1.POST("/user/login/wechat", handler.RateLimit(handler.LoginIPPolicy), userRouter.LoginByWechat)
+		apiV1.POST("/user/login/email/code/send", handler.RateLimit(handler.EmailSendIPPolicy, handler.EmailSendTargetPolicy), userRouter.SendLoginCode)
+		apiV1.POST("/user/login/email/code", handler.RateLimit(handler.LoginIPPolicy), userRouter.LoginByEmailCode)
+		apiV1.POST("/user/login/email/link", handler.RateLimit(handler.LoginIPPolicy), userRouter.LoginByEmailLink)
+		apiV1.POST("/user/password/forgot", handler.RateLimit(handler.EmailSendIPPolicy, handler.EmailSendTargetPolicy), userRouter.ForgotPassword)
+		apiV1.POST("/user/password/reset", handler.RateLimit(handler.LoginIPPolicy), userRouter.ResetPassword)
+		apiV1.PUT("/user/password", handler.UserTokenVerify(repos), userRouter.ChangePassword)
 
-		apiV1.PUT("/user/base", handler.UserTokenVerify(), userRouter.UpdateUserBaseInfo)
-		apiV1.POST("/user/search", handler.UserTokenVerify(), userRouter.UserSearch)
+		apiV1.PUT("/user/base", handler.UserTokenVerify(repos), userRouter.UpdateUserBaseInfo)
+		apiV1.PUT("/user/language", handler.UserTokenVerify(repos), userRouter.UpdateLanguage)
+		apiV1.POST("/user/search", handler.UserTokenVerify(repos), userRouter.UserSearch)
 
-		apiV1.POST("/user/email/bind", handler.UserTokenVerify(), userRouter.SubmitBindEmail)
+		apiV1.POST("/user/email/bind", handler.UserTokenVerify(repos), handler.RateLimit(handler.EmailSendIPPolicy, handler.EmailSendUserPolicy, handler.EmailSendTargetPolicy), userRouter.SubmitBindEmail)
 		apiV1.GET("/user/email/bind/confirm", userRouter.ConfirmBindEmail)
+		apiV1.POST("/user/email/bind/cancel", userRouter.CancelBindEmail)
+
+		apiV1.DELETE("/user", handler.UserTokenVerify(repos), userRouter.DeleteAccount)
+		apiV1.POST("/user/restore/email", handler.RateLimit(handler.LoginIPPolicy), userRouter.RestoreAccount)
+
+		if config.GlobalConfig.RunMode == config.RunModeLocal {
+			apiV1.GET("/dev/email/preview/:name", userRouter.PreviewEmail)
+		}
+	}
+
+	// register session related api
+	sessionRouter := handler.NewSessionRouter(repos)
+	{
+		apiV1.POST("/user/token/refresh", handler.RateLimit(handler.LoginIPPolicy), sessionRouter.RefreshToken)
+		apiV1.POST("/user/logout", handler.UserTokenVerify(repos), sessionRouter.Logout)
+		apiV1.GET("/user/sessions", handler.UserTokenVerify(repos), sessionRouter.ListSessions)
+		apiV1.DELETE("/user/session/:sid", handler.UserTokenVerify(repos), sessionRouter.RevokeSession)
+	}
+
+	// register security event related api
+	securityEventRouter := handler.NewSecurityEventRouter(repos)
+	{
+		apiV1.GET("/user/security/events", handler.UserTokenVerify(repos), securityEventRouter.ListEvents)
+	}
+
+	// register two-factor authentication related api
+	twoFactorRouter := handler.NewTwoFactorRouter(repos)
+	{
+		apiV1.POST("/user/login/2fa", handler.RateLimit(handler.LoginIPPolicy), twoFactorRouter.Login)
+		apiV1.POST("/user/2fa/enroll", handler.UserTokenVerify(repos), twoFactorRouter.StartEnrollment)
+		apiV1.POST("/user/2fa/confirm", handler.UserTokenVerify(repos), twoFactorRouter.ConfirmEnrollment)
+		apiV1.POST("/user/2fa/recovery_codes", handler.UserTokenVerify(repos), twoFactorRouter.RegenerateRecoveryCodes)
+		apiV1.DELETE("/user/2fa", handler.UserTokenVerify(repos), twoFactorRouter.Disable)
+	}
+
+	// register oauth related api
+	oauthRouter := handler.NewOAuthRouter(repos)
+	{
+		apiV1.GET("/user/oauth/:provider/authorize", oauthRouter.Authorize)
+		apiV1.POST("/user/oauth/:provider/link", handler.UserTokenVerify(repos), oauthRouter.Link)
+		apiV1.POST("/user/oauth/:provider/callback", handler.RateLimit(handler.LoginIPPolicy), oauthRouter.Callback)
+		apiV1.POST("/user/oauth/:provider/link/callback", handler.UserTokenVerify(repos), oauthRouter.LinkCallback)
+		apiV1.GET("/user/identities", handler.UserTokenVerify(repos), oauthRouter.ListIdentities)
+		apiV1.DELETE("/user/identity/:provider", handler.UserTokenVerify(repos), oauthRouter.UnlinkIdentity)
 	}
 
 	// register habit related api
-	habitRouter := handler.NewHabitRouter()
+	habitRouter := handler.NewHabitRouter(repos)
+	idempotent := handler.Idempotent(config.GlobalConfig.Idempotency.TTL)
+	{
+		apiV1.POST("/habit", handler.UserTokenVerify(repos), idempotent, habitRouter.CreateHabit)
+		apiV1.GET("habit/:id", handler.UserTokenVerify(repos), habitRouter.GetHabit)
+		apiV1.GET("/habit/list", handler.UserTokenVerify(repos), habitRouter.ListHabits)
+		apiV1.PUT("/habit/:id", handler.UserTokenVerify(repos), idempotent, habitRouter.UpdateHabit)
+		apiV1.POST("/habit/log/:id", handler.UserTokenVerify(repos), idempotent, habitRouter.LogHabit)
+		apiV1.POST("/habit/sync", handler.UserTokenVerify(repos), idempotent, habitRouter.Sync)
+		apiV1.GET("/changes", handler.UserTokenVerify(repos), habitRouter.ListChanges)
+	}
+
+	// register real-time event related api
+	eventRouter := handler.NewEventRouter(repos)
+	{
+		apiV1.GET("/events", handler.UserTokenVerify(repos), eventRouter.Stream)
+	}
+
+	// register admin api, only for the users of admin role
+	adminRouter := handler.NewAdminRouter(repos)
 	{
-		apiV1.POST("/habit", handler.UserTokenVerify(), habitRouter.CreateHabit)
-		apiV1.GET("habit/:id", handler.UserTokenVerify(), habitRouter.GetHabit)
-		apiV1.GET("/habit/list", handler.UserTokenVerify(), habitRouter.ListHabits)
-		apiV1.PUT("/habit/:id", handler.UserTokenVerify(), habitRouter.UpdateHabit)
-		apiV1.POST("/habit/log/:id", handler.UserTokenVerify(), habitRouter.LogHabit)
+		apiAdmin := r.Group("api/admin", handler.AdminVerify(repos))
+		apiAdmin.GET("/users", adminRouter.SearchUsers)
+		apiAdmin.GET("/user/:uid/habits", adminRouter.ListUserHabits)
+		apiAdmin.POST("/user/:uid/disable", adminRouter.DisableUser)
+		apiAdmin.POST("/user/:uid/enable", adminRouter.EnableUser)
+		apiAdmin.POST("/user/:uid/email/activate", adminRouter.ActivateEmail)
+		apiAdmin.POST("/user/:uid/habit/:habit_id/streak/reset", adminRouter.ResetStreak)
+		apiAdmin.DELETE("/user/:uid/name", adminRouter.RemoveName)
+		apiAdmin.DELETE("/user/:uid/portrait", adminRouter.RemovePortrait)
+		apiAdmin.GET("/audit_logs", adminRouter.ListAuditLogs)
 	}
 }
@@ -0,0 +1,41 @@
+package util
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// RandomToken generate a url safe random token with n random bytes
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken get the hex encoded sha256 digest of a token, used to store a token without keeping its plain text
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RandomDigits generate a random numeric code with n digits, like a one-time login code
+func RandomDigits(n int) (string, error) {
+	code := make([]byte, 0, n)
+	b := make([]byte, 1)
+	for len(code) < n {
+		_, err := rand.Read(b)
+		if err != nil {
+			return "", err
+		}
+		if b[0] >= 250 { // reject to keep every digit equally likely
+			continue
+		}
+		code = append(code, '0'+b[0]%10)
+	}
+	return string(code), nil
+}
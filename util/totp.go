@@ -0,0 +1,87 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTPPeriod the time step of a TOTP code
+const TOTPPeriod = 30 * time.Second
+
+// TOTPDigits the digit count of a TOTP code
+const TOTPDigits = 6
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret generate a random base32 encoded TOTP secret with 160 bits, as RFC 4226 recommends
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPProvisioningURI build the otpauth uri of a TOTP secret, which is usually shown as a QR code to authenticator apps
+func TOTPProvisioningURI(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTPDigits))
+	query.Set("period", fmt.Sprint(int(TOTPPeriod/time.Second)))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// TOTPStep get the time step counter of a time
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod/time.Second)
+}
+
+// TOTPCode generate the TOTP code of a secret at a time step, as RFC 6238 defines with HMAC-SHA1
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod), nil
+}
+
+// VerifyTOTP verify a TOTP code at a time, allowing skew steps of clock drift before and after,
+// return the matched time step so that the caller can reject a replayed code, or -1 if the code does not match
+func VerifyTOTP(secret string, code string, t time.Time, skew int64) (int64, error) {
+	current := TOTPStep(t)
+	for step := current - skew; step <= current+skew; step++ {
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return -1, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+	return -1, nil
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// the test vectors of RFC 6238 appendix B with SHA1, truncated to 6 digits
+func TestTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, c := range cases {
+		code, err := TOTPCode(secret, TOTPStep(time.Unix(c.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != c.code {
+			t.Fatalf("time %d expect code %s, got %s", c.unix, c.code, code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	previous, err := TOTPCode(secret, TOTPStep(now)-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, err := VerifyTOTP(secret, previous, now, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step != TOTPStep(now)-1 {
+		t.Fatal("code of previous step should be accepted with skew 1")
+	}
+
+	step, err = VerifyTOTP(secret, previous, now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step != -1 {
+		t.Fatal("code of previous step should be rejected without skew")
+	}
+}